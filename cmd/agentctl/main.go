@@ -3,17 +3,28 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
 	"github.com/mateo/agentvm/internal/api"
+	"github.com/mateo/agentvm/internal/certs"
 	"github.com/mateo/agentvm/internal/config"
 	"github.com/mateo/agentvm/internal/lima"
+	"github.com/mateo/agentvm/internal/network"
+	"github.com/mateo/agentvm/internal/project"
 	"github.com/spf13/cobra"
 )
 
@@ -31,10 +42,19 @@ func main() {
 		masterCmd(),
 		dispatchCmd(),
 		statusCmd(),
+		waitCmd(),
 		poolCmd(),
+		routesCmd(),
+		certsCmd(),
 		logsCmd(),
 		shellCmd(),
+		attachCmd(),
+		execCmd(),
+		cpCmd(),
+		pullCmd(),
 		killCmd(),
+		restartCmd(),
+		exposeCmd(),
 		setupCmd(),
 	)
 
@@ -248,7 +268,10 @@ func masterSyncCmd() *cobra.Command {
 
 func dispatchCmd() *cobra.Command {
 	var req api.DispatchRequest
-	var envFlags []string
+	var envFlags, serviceFlags, tcpFlags []string
+	var health api.HealthCheck
+	var wait bool
+	var waitTimeout time.Duration
 	cmd := &cobra.Command{
 		Use:   "dispatch",
 		Short: "Dispatch a task to a new agent",
@@ -256,10 +279,6 @@ func dispatchCmd() *cobra.Command {
 			if req.Project == "" || req.RepoURL == "" || req.Prompt == "" {
 				return fmt.Errorf("--project, --repo, and --prompt are required")
 			}
-			if req.Tool == "" {
-				req.Tool = "claude-code"
-			}
-
 			// Parse --env KEY=VALUE flags
 			if len(envFlags) > 0 {
 				req.EnvVars = make(map[string]string)
@@ -271,6 +290,23 @@ func dispatchCmd() *cobra.Command {
 					req.EnvVars[k] = v
 				}
 			}
+			for _, spec := range serviceFlags {
+				svc, err := project.ParseService(spec)
+				if err != nil {
+					return err
+				}
+				req.ServeServices = append(req.ServeServices, api.ServeService{Name: svc.Name, Port: svc.Port, Path: svc.Path})
+			}
+			for _, spec := range tcpFlags {
+				svc, err := project.ParseTCPService(spec)
+				if err != nil {
+					return err
+				}
+				req.ServeTCP = append(req.ServeTCP, api.ServeTCPService{Name: svc.Name, Port: svc.Port, TLS: svc.TLS})
+			}
+			if health.Path != "" {
+				req.ServeHealth = &health
+			}
 
 			client := api.NewClient(cfg.API.Port)
 			resp, err := client.Dispatch(req)
@@ -282,27 +318,156 @@ func dispatchCmd() *cobra.Command {
 			fmt.Printf("  Agent ID:  %s\n", resp.AgentID)
 			fmt.Printf("  VM:        %s\n", resp.VMName)
 			fmt.Printf("  IP:        %s\n", resp.VMIP)
-			if req.ServeCommand != "" {
+			switch {
+			case len(resp.URLs) > 0:
+				for _, u := range resp.URLs {
+					fmt.Printf("  URL:       %s\n", u)
+				}
+			case req.ServeCommand != "":
 				fmt.Printf("  URL:       http://%s\n", resp.Subdomain)
-			} else {
+			default:
 				fmt.Printf("  URL:       https://%s\n", resp.Subdomain)
 			}
+			printAccess(resp.Access, "  ")
+
+			if wait {
+				fmt.Println()
+				code, err := waitAgents(client, []string{resp.AgentID}, api.WaitOptions{}, waitTimeout)
+				if err != nil {
+					return err
+				}
+				if code != 0 {
+					os.Exit(code)
+				}
+			}
 			return nil
 		},
 	}
 	cmd.Flags().StringVar(&req.Project, "project", "", "Project name")
 	cmd.Flags().StringVar(&req.RepoURL, "repo", "", "Git repository URL")
 	cmd.Flags().StringVar(&req.Issue, "issue", "", "Issue identifier (e.g. PROJ-123)")
-	cmd.Flags().StringVar(&req.Tool, "tool", "claude-code", "Coding tool: claude-code, opencode, amp, cline")
+	cmd.Flags().StringVar(&req.Tool, "tool", "", "Coding tool: claude-code, opencode, amp, cline (default from .agentvm.yaml, else claude-code)")
 	cmd.Flags().StringVar(&req.Prompt, "prompt", "", "Task prompt")
 	cmd.Flags().StringVar(&req.Branch, "branch", "", "Branch name (auto-generated if empty)")
-	cmd.Flags().IntVar(&req.MaxTime, "max-time", 30, "Max execution time in minutes")
+	cmd.Flags().IntVar(&req.MaxTime, "max-time", 0, "Max execution time in minutes (default from .agentvm.yaml, else 30)")
 	cmd.Flags().StringArrayVar(&envFlags, "env", nil, "Environment variables (KEY=VALUE), can be repeated")
 	cmd.Flags().StringVar(&req.ServeCommand, "serve-cmd", "", "Command to run after push to serve the app (e.g. 'docker compose up')")
 	cmd.Flags().IntVar(&req.ServePort, "serve-port", 0, "Port the serve command listens on (default 8080)")
+	cmd.Flags().StringVar(&health.Path, "health-path", "", "HTTP path probed for readiness and liveness of the served app (e.g. /healthz)")
+	cmd.Flags().IntVar(&health.Status, "health-status", 0, "Expected health check status (default any 2xx/3xx)")
+	cmd.Flags().IntVar(&health.Interval, "health-interval", 0, "Seconds between health probes (default 10)")
+	cmd.Flags().IntVar(&health.FailureThreshold, "health-threshold", 0, "Consecutive failed probes before the app is unhealthy (default 3)")
+	cmd.Flags().StringVar(&req.ServeRestart, "serve-restart", "", "Restart policy when the serve command exits: never, on-failure or always (default never)")
+	cmd.Flags().IntVar(&req.ServeMaxRestarts, "serve-max-restarts", 0, "Max automatic serve restarts (default unlimited)")
+	cmd.Flags().StringVar(&req.ServeAlias, "alias", "", "Stable preview name <alias>.<project>, moved to the newest agent using it (\"branch\" derives it from the branch)")
+	cmd.Flags().StringVar(&req.Access, "access", "", "Preview protection: none, basic or token (default from config)")
+	cmd.Flags().StringSliceVar(&serviceFlags, "serve-ports", nil, "Named ports to route, name:port[/path] (e.g. web:3000,api:8080); the first is primary")
+	cmd.Flags().StringSliceVar(&tcpFlags, "tcp-ports", nil, "Non-HTTP ports to route, name:port[/tls] (e.g. postgres:5432,redis:6379/tls)")
+	cmd.Flags().StringArrayVar(&req.SetupCommands, "setup", nil, "Setup command to run before the tool (repeatable, overrides .agentvm.yaml)")
+	cmd.Flags().IntVar(&req.SetupTimeout, "setup-timeout", 0, "Max setup time in minutes (default 10)")
+	cmd.Flags().BoolVar(&req.Devcontainer, "devcontainer", false, "Run .devcontainer/devcontainer.json lifecycle commands during setup")
+	cmd.Flags().StringArrayVar(&req.ProtectedPaths, "protect", nil, "Glob of paths the agent must not modify (repeatable, added to .agentvm.yaml)")
+	cmd.Flags().StringVar(&req.ProtectPolicy, "protect-policy", "", "What to do when a protected path is modified: revert or fail (default revert)")
+	cmd.Flags().IntVar(&req.CheckpointInterval, "checkpoint-interval", 0, "Minutes between work-in-progress checkpoints (default 10)")
+	cmd.Flags().StringVar(&req.CommitMessage, "commit-message", "", "Commit message template, e.g. 'fix({{.Issue}}): {{.Summary}}'")
+	cmd.Flags().StringVar(&req.AuthorName, "author-name", "", "Commit author name (with --author-email)")
+	cmd.Flags().StringVar(&req.AuthorEmail, "author-email", "", "Commit author email (with --author-name)")
+	cmd.Flags().StringVar(&req.SecretScan, "secret-scan", "", "Secret scan before push: block, warn or off (default block)")
+	cmd.Flags().BoolVar(&wait, "wait", false, "Wait for the task to finish and exit with its outcome, like agentctl wait")
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 0, "Give up waiting after this long (e.g. 45m, default no limit)")
+	return cmd
+}
+
+// --- wait ---
+
+// Exit codes of agentctl wait, by outcome. Errors exit 1 like failures.
+var waitExitCodes = map[string]int{
+	api.OutcomeCompleted: 0,
+	api.OutcomeFailed:    1,
+	api.OutcomeTimeout:   2,
+	api.OutcomeKilled:    3,
+}
+
+func waitCmd() *cobra.Command {
+	var opts api.WaitOptions
+	var all bool
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "wait <agent-id>...",
+		Short: "Wait for agents to finish and exit with their outcome",
+		Long: `Wait until agents finish, or reach one of --state, then print a summary.
+Finished states are completed, no_changes and serving once the app is ready
+(completed); failed and policy_violation (failed, or timeout when the agent
+hit its max time); and killed. An unhealthy app may recover or be restarted,
+so it is waited on until the harness reports it serving or failed. The exit
+code is that of the most severe outcome:
+
+  0  completed, or reached a --state
+  1  failed
+  2  timeout: an agent hit its max time, or --timeout expired
+  3  killed`,
+		Example: `  agentctl wait a1b2c3 --timeout 45m
+  agentctl wait a1b2c3 d4e5f6 --any
+  agentctl wait a1b2c3 --state serving`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewClient(cfg.API.Port)
+			code, err := waitAgents(client, args, opts, timeout)
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				os.Exit(code)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Give up after this long (e.g. 45m, default no limit)")
+	cmd.Flags().BoolVar(&opts.Any, "any", false, "Return as soon as any agent is done")
+	cmd.Flags().BoolVar(&all, "all", true, "Return once all agents are done")
+	cmd.Flags().StringSliceVar(&opts.States, "state", nil, "Also stop when an agent reaches one of these states (e.g. executing,serving)")
+	cmd.MarkFlagsMutuallyExclusive("any", "all")
 	return cmd
 }
 
+// waitAgents waits for agents, logging their state changes to stderr, prints
+// a summary and returns the exit code for their outcome.
+func waitAgents(client *api.Client, agentIDs []string, opts api.WaitOptions, timeout time.Duration) (int, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	opts.OnUpdate = func(s api.AgentState) {
+		fmt.Fprintf(os.Stderr, "%s  %-10s %s  %s\n", time.Now().Format("15:04:05"), s.AgentID, s.State, s.Message)
+	}
+	states, err := client.Wait(ctx, agentIDs, opts)
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	if err != nil && !timedOut {
+		return 0, err
+	}
+
+	code := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AGENT\tSTATE\tOUTCOME\tBRANCH\tMESSAGE")
+	for _, s := range states {
+		outcome := s.Outcome
+		switch {
+		case s.Done && slices.Contains(opts.States, s.State):
+			outcome = "reached"
+		case !s.Done && timedOut:
+			outcome = api.OutcomeTimeout
+		case !s.Done:
+			outcome = "-"
+		}
+		code = max(code, waitExitCodes[outcome])
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.AgentID, s.State, outcome, s.Branch, s.Message)
+	}
+	w.Flush()
+	return code, nil
+}
+
 // --- status ---
 
 func statusCmd() *cobra.Command {
@@ -334,6 +499,69 @@ func statusCmd() *cobra.Command {
 						a.Elapsed.Round(time.Second))
 				}
 				w.Flush()
+
+				var tcpHeader bool
+				for _, a := range status.Agents {
+					if len(a.TCP) == 0 {
+						continue
+					}
+					if !tcpHeader {
+						fmt.Println("\nTCP services:")
+						tcpHeader = true
+					}
+					fmt.Printf("  %s\n", a.AgentID)
+					for _, ep := range a.TCP {
+						tls := ""
+						if ep.TLS {
+							tls = " (TLS, SNI)"
+						}
+						fmt.Printf("    %-12s %s%s\n", ep.Name, ep.Address, tls)
+					}
+				}
+
+				var portsHeader bool
+				for _, a := range status.Agents {
+					if len(a.Listening) == 0 {
+						continue
+					}
+					if !portsHeader {
+						fmt.Println("\nDiscovered ports (agentctl expose <agent-id> <port>):")
+						portsHeader = true
+					}
+					fmt.Printf("  %s\n", a.AgentID)
+					for _, p := range a.Listening {
+						note := ""
+						if p.Loopback {
+							note = " (localhost only)"
+						}
+						fmt.Printf("    %-6d %s%s\n", p.Port, p.Process, note)
+					}
+				}
+
+				var aliasHeader bool
+				for _, a := range status.Agents {
+					if a.AliasURL == "" {
+						continue
+					}
+					if !aliasHeader {
+						fmt.Println("\nAliases:")
+						aliasHeader = true
+					}
+					fmt.Printf("  %-12s %s\n", a.AgentID, a.AliasURL)
+				}
+
+				var header bool
+				for _, a := range status.Agents {
+					if a.Access == nil {
+						continue
+					}
+					if !header {
+						fmt.Println("\nPreview access:")
+						header = true
+					}
+					fmt.Printf("  %s\n", a.AgentID)
+					printAccess(a.Access, "    ")
+				}
 			}
 			return nil
 		},
@@ -342,6 +570,19 @@ func statusCmd() *cobra.Command {
 	return cmd
 }
 
+// printAccess prints the credentials protecting an agent's previews.
+func printAccess(acc *api.PreviewAccess, indent string) {
+	if acc == nil {
+		return
+	}
+	switch acc.Mode {
+	case "basic":
+		fmt.Printf("%sAccess:    basic auth %s:%s\n", indent, acc.Username, acc.Password)
+	case "token":
+		fmt.Printf("%sAccess:    %s\n", indent, acc.URL)
+	}
+}
+
 // --- pool ---
 
 func poolCmd() *cobra.Command {
@@ -397,6 +638,158 @@ func poolCmd() *cobra.Command {
 	return cmd
 }
 
+// --- routes ---
+
+func routesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "routes",
+		Short: "Preview route management commands",
+	}
+
+	var dryRun bool
+	reconcileCmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Remove orphaned routes and rewrite drifted ones",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewClient(cfg.API.Port)
+			report, err := client.ReconcileRoutes(dryRun)
+			if err != nil {
+				return err
+			}
+			verb := "Fixed"
+			if dryRun {
+				verb = "Found"
+			}
+			drift := false
+			for _, d := range []struct {
+				label string
+				ids   []string
+			}{
+				{"orphaned routes", report.Orphaned},
+				{"stale registrations", report.Stale},
+				{"moved VMs", report.Moved},
+				{"rewritten routes", report.Rewritten},
+				{"missing routes", report.Missing},
+				{"moved aliases", report.Aliases},
+			} {
+				if len(d.ids) > 0 {
+					drift = true
+					fmt.Printf("%s %d %s: %s\n", verb, len(d.ids), d.label, strings.Join(d.ids, ", "))
+				}
+			}
+			if !drift {
+				fmt.Println("Routes are in sync")
+			}
+			return nil
+		},
+	}
+	reconcileCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only report drift")
+
+	aliasesCmd := &cobra.Command{
+		Use:   "aliases",
+		Short: "List stable preview aliases and the agents owning them",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewClient(cfg.API.Port)
+			aliases, err := client.Aliases()
+			if err != nil {
+				return err
+			}
+			if len(aliases) == 0 {
+				fmt.Println("No aliases")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "URL\tAGENT\tBRANCH\tALSO CLAIMED BY\n")
+			for _, a := range aliases {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.URL, a.AgentID, a.Branch, strings.Join(a.Claims, ","))
+			}
+			w.Flush()
+			return nil
+		},
+	}
+
+	cmd.AddCommand(reconcileCmd, aliasesCmd)
+	return cmd
+}
+
+// --- certs ---
+
+func certsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "certs",
+		Short: "Local certificate authority for HTTPS previews",
+	}
+
+	loadCA := func() (*certs.Authority, error) {
+		ca, err := certs.Load(filepath.Join(config.BaseDir(), "certs"), cfg.Network.Domain)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate authority: %w", err)
+		}
+		return ca, nil
+	}
+
+	var printOnly bool
+	trustCmd := &cobra.Command{
+		Use:   "trust",
+		Short: "Install the CA root in the system (and browser) trust stores",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ca, err := loadCA()
+			if err != nil {
+				return err
+			}
+			cmds := certs.TrustCommands(runtime.GOOS, ca.CAFile())
+			if len(cmds) == 0 {
+				return fmt.Errorf("no known trust store on %s; install %s manually (agentctl certs export)", runtime.GOOS, ca.CAFile())
+			}
+			for _, c := range cmds {
+				fmt.Printf("  %s\n", strings.Join(c, " "))
+				if printOnly {
+					continue
+				}
+				run := exec.Command(c[0], c[1:]...)
+				run.Stdin, run.Stdout, run.Stderr = os.Stdin, os.Stdout, os.Stderr
+				if err := run.Run(); err != nil {
+					return fmt.Errorf("%s: %w", c[0], err)
+				}
+			}
+			if !printOnly {
+				fmt.Printf("Trusted agentvm CA for *.%s (restart browsers to pick it up)\n", cfg.Network.Domain)
+			}
+			return nil
+		},
+	}
+	trustCmd.Flags().BoolVar(&printOnly, "print", false, "Only print the commands")
+
+	var out string
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the CA root certificate (PEM) to stdout or a file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ca, err := loadCA()
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(ca.CAFile())
+			if err != nil {
+				return err
+			}
+			if out == "" {
+				_, err = os.Stdout.Write(data)
+				return err
+			}
+			if err := os.WriteFile(out, data, 0644); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %s\n", out)
+			return nil
+		},
+	}
+	exportCmd.Flags().StringVarP(&out, "out", "o", "", "Output file (default stdout)")
+
+	cmd.AddCommand(trustCmd, exportCmd)
+	return cmd
+}
+
 // --- logs ---
 
 func logsCmd() *cobra.Command {
@@ -443,6 +836,328 @@ func execLimaShell(name string) error {
 	return runInteractive(binary, "shell", name)
 }
 
+// --- attach ---
+
+func attachCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "attach <agent-id>",
+		Short: "Open a terminal in an agent's workspace through agentd",
+		Long: `Open a terminal in an agent's workspace, relayed through agentd rather
+than limactl, so it also works against a remote agentd (set AGENTVM_API_URL).
+Sessions are recorded in the agent's history. Needs an API token with
+operator scope.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewClient(cfg.API.Port)
+			rows, cols := terminalSize()
+			term, err := client.Terminal(args[0], rows, cols)
+			if err != nil {
+				return err
+			}
+			defer term.Close()
+
+			restore, err := makeRaw()
+			if err != nil {
+				return err
+			}
+			defer restore()
+
+			winch := make(chan os.Signal, 1)
+			signal.Notify(winch, syscall.SIGWINCH)
+			defer signal.Stop(winch)
+			go func() {
+				for range winch {
+					if rows, cols := terminalSize(); rows > 0 && cols > 0 {
+						term.Resize(rows, cols)
+					}
+				}
+			}()
+
+			go io.Copy(term, os.Stdin)
+			_, err = term.Output(os.Stdout)
+			return err
+		},
+	}
+}
+
+// --- exec ---
+
+func execCmd() *cobra.Command {
+	var interactive bool
+	cmd := &cobra.Command{
+		Use:   "exec <agent-id> -- <command> [args...]",
+		Short: "Run a command in an agent's workspace",
+		Long: `Run a command in an agent's workspace through agentd, with its stdout and
+stderr streamed back separately and its exit code as agentctl's. Needs an API
+token with operator scope: the local operator token is used automatically,
+set AGENTVM_TOKEN for a remote agentd.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewClient(cfg.API.Port)
+			var stdin io.Reader
+			if interactive {
+				stdin = os.Stdin
+			}
+			code, err := client.Exec(args[0], api.ExecRequest{Command: args[1:]}, stdin, os.Stdout, os.Stderr)
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				os.Exit(code)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Pass stdin to the command")
+	return cmd
+}
+
+// --- cp ---
+
+func cpCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cp <src> <dst>",
+		Short: "Copy files between an agent's workspace and this machine",
+		Long: `Copy a file or directory between an agent's workspace and this machine.
+One side is <agent-id>:<path>, relative to the workspace root; the other is a
+local path, or - for stdin/stdout (a gzipped tar for directories). Copying
+into an existing directory, or a path ending in /, puts the source inside it.
+Writing into an agent needs an API token with operator scope.`,
+		Example: `  agentctl cp a1b2c3:dist ./dist
+  agentctl cp fixtures/users.json a1b2c3:testdata/`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewClient(cfg.API.Port)
+			srcAgent, srcPath, srcRemote := splitAgentPath(args[0])
+			dstAgent, dstPath, dstRemote := splitAgentPath(args[1])
+			switch {
+			case srcRemote && !dstRemote:
+				return copyFromAgent(client, srcAgent, srcPath, args[1])
+			case !srcRemote && dstRemote:
+				return copyToAgent(client, args[0], dstAgent, dstPath)
+			default:
+				return fmt.Errorf("exactly one of source and destination must be <agent-id>:<path>")
+			}
+		},
+	}
+}
+
+// splitAgentPath splits "<agent-id>:<path>". Local paths containing a colon
+// are recognized by a slash before it.
+func splitAgentPath(arg string) (agentID, p string, ok bool) {
+	agentID, p, ok = strings.Cut(arg, ":")
+	if !ok || agentID == "" || strings.Contains(agentID, "/") {
+		return "", "", false
+	}
+	if p == "" {
+		p = "."
+	}
+	return agentID, p, true
+}
+
+func copyFromAgent(client *api.Client, agentID, remote, local string) error {
+	info, err := client.Files(agentID, remote)
+	if err != nil {
+		return err
+	}
+
+	if info.Type == "directory" {
+		rc, err := client.Archive(agentID, remote, "tar")
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if local == "-" {
+			_, err = io.Copy(os.Stdout, rc)
+			return err
+		}
+		// Into an existing directory the entries keep the source's name,
+		// otherwise the destination takes its place
+		strip := 1
+		if st, err := os.Stat(local); (err == nil && st.IsDir()) || path.Base(info.Path) == "." {
+			strip = 0
+		}
+		if err := os.MkdirAll(local, 0755); err != nil {
+			return err
+		}
+		if err := api.ExtractTarGz(rc, local, strip); err != nil {
+			return err
+		}
+		fmt.Printf("Copied %s:%s to %s\n", agentID, info.Path, local)
+		return nil
+	}
+
+	rc, err := client.Download(agentID, remote)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if local == "-" {
+		_, err = io.Copy(os.Stdout, rc)
+		return err
+	}
+	dest := local
+	if st, err := os.Stat(local); err == nil && st.IsDir() {
+		dest = filepath.Join(local, path.Base(info.Path))
+	}
+	mode, _ := strconv.ParseUint(info.Mode, 8, 32)
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(mode).Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Copied %s:%s to %s\n", agentID, info.Path, dest)
+	return nil
+}
+
+func copyToAgent(client *api.Client, local, agentID, remote string) error {
+	if local == "-" {
+		up, err := client.Upload(agentID, remote, os.Stdin, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Copied %d bytes to %s:%s\n", up.Size, agentID, up.Path)
+		return nil
+	}
+	st, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+
+	into := strings.HasSuffix(remote, "/")
+	if info, err := client.Files(agentID, remote); err == nil && info.Type == "directory" {
+		into = true
+	}
+
+	if st.IsDir() {
+		prefix := ""
+		if into {
+			prefix = filepath.Base(local)
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(api.WriteTarGz(pw, local, prefix))
+		}()
+		if err := client.Extract(agentID, remote, pr); err != nil {
+			return err
+		}
+		fmt.Printf("Copied %s to %s:%s\n", local, agentID, path.Join(remote, prefix))
+		return nil
+	}
+
+	target := remote
+	if into {
+		target = path.Join(remote, filepath.Base(local))
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	up, err := client.Upload(agentID, target, f, st.Mode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Copied %s to %s:%s\n", local, agentID, up.Path)
+	return nil
+}
+
+// --- pull ---
+
+func pullCmd() *cobra.Command {
+	var dir, remote, branch string
+	var fromVM, uncommitted bool
+	cmd := &cobra.Command{
+		Use:   "pull <agent-id>",
+		Short: "Check out an agent's work in a new local worktree",
+		Long: `Check out an agent's work in a new git worktree next to the current
+repository, named <repo>-<agent-id>. The agent's branch is fetched from the
+remote once pushed; before that, or with --from-vm, the workspace's commits
+are bundled in the VM and downloaded through agentd. Reading from the VM and
+--uncommitted need an API token with operator scope. An argument agentd
+doesn't know as an agent is pulled as a branch of the remote.`,
+		Example: `  agentctl pull a1b2c3
+  agentctl pull a1b2c3 --from-vm --uncommitted
+  agentctl pull agent/fix-login`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewClient(cfg.API.Port)
+			agent, err := client.Agent(args[0])
+			if err != nil {
+				// Not an agent agentd knows about: take it as a branch name
+				if fromVM || uncommitted {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "%v, pulling branch %s\n", err, args[0])
+				agent = &api.AgentStatus{Branch: args[0]}
+			}
+			repo, err := git("", nil, "rev-parse", "--show-toplevel")
+			if err != nil {
+				return fmt.Errorf("not in a git repository: %w", err)
+			}
+			if dir == "" {
+				dir = worktreeDir(repo, agent)
+			}
+			if _, err := os.Stat(dir); err == nil {
+				return fmt.Errorf("%s already exists", dir)
+			}
+
+			var commit, source string
+			if !fromVM && agent.Branch != "" {
+				if commit, err = fetchBranch(repo, remote, agent.Branch); err == nil {
+					source = remote + "/" + agent.Branch
+				} else if agent.AgentID == "" {
+					return err
+				} else {
+					fmt.Fprintf(os.Stderr, "%s is not on %s yet, fetching from the VM\n", agent.Branch, remote)
+				}
+			}
+			if commit == "" {
+				if commit, err = fetchFromVM(client, agent.AgentID, repo, remote); err != nil {
+					return err
+				}
+				source = "the VM"
+			}
+
+			if branch == "" {
+				branch = agent.Branch
+			}
+			if branch == "" {
+				branch = "agentvm/" + agent.AgentID
+			}
+			if err := addWorktree(repo, dir, branch, commit); err != nil {
+				return err
+			}
+			fmt.Printf("Checked out %s at %.12s from %s in %s\n", branch, commit, source, dir)
+
+			if uncommitted {
+				applied, err := applyUncommitted(client, agent.AgentID, commit, dir)
+				if err != nil {
+					return err
+				}
+				if applied {
+					fmt.Println("Applied uncommitted changes from the VM")
+				} else {
+					fmt.Println("No uncommitted changes in the VM")
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "", "Worktree directory (default ../<repo>-<agent-id>)")
+	cmd.Flags().StringVar(&remote, "remote", "origin", "Remote the agent pushes to")
+	cmd.Flags().StringVar(&branch, "branch", "", "Local branch to create (default the agent's branch)")
+	cmd.Flags().BoolVar(&fromVM, "from-vm", false, "Fetch from the VM even if the branch was pushed, e.g. for a running agent")
+	cmd.Flags().BoolVar(&uncommitted, "uncommitted", false, "Also apply the workspace's uncommitted changes")
+	return cmd
+}
+
 // --- kill ---
 
 func killCmd() *cobra.Command {
@@ -461,10 +1176,58 @@ func killCmd() *cobra.Command {
 	}
 }
 
+// --- restart ---
+
+func restartCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restart <agent-id>",
+		Short: "Restart an agent's serve process",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := api.NewClient(cfg.API.Port)
+			if err := client.RestartServe(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("Restart requested for agent %s\n", args[0])
+			return nil
+		},
+	}
+}
+
+// --- expose ---
+
+func exposeCmd() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "expose <agent-id> <port>",
+		Short: "Route a port an agent listens on at a preview URL",
+		Long: `Route a port an agent's VM listens on (see "Discovered ports" in
+agentctl status) at <name>.<agent host>, without restarting the agent.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid port %q", args[1])
+			}
+			client := api.NewClient(cfg.API.Port)
+			resp, err := client.Expose(args[0], api.ExposeRequest{Port: port, Name: name})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Port %d of agent %s exposed as %s: %s\n", port, args[0], resp.Name, resp.URL)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Subdomain label (default port-<port>)")
+	return cmd
+}
+
 // --- setup ---
 
 func setupCmd() *cobra.Command {
-	return &cobra.Command{
+	var dnsProvider string
+	var applyDNS bool
+	cmd := &cobra.Command{
 		Use:   "setup",
 		Short: "First-time setup: create directories, generate config, install dependencies",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -485,7 +1248,10 @@ func setupCmd() *cobra.Command {
 			}
 
 			// Check dependencies
-			deps := []string{"limactl", "docker", "traefik", "mkcert"}
+			deps := []string{"limactl", "docker"}
+			if cfg.Network.Router != network.RouterBuiltin {
+				deps = append(deps, "traefik")
+			}
 			for _, d := range deps {
 				if _, err := findBinary(d); err != nil {
 					fmt.Printf("  Warning: %s not found in PATH\n", d)
@@ -494,13 +1260,67 @@ func setupCmd() *cobra.Command {
 				}
 			}
 
+			if err := setupDNS(dnsProvider, applyDNS); err != nil {
+				return err
+			}
+
 			fmt.Println("\nSetup complete. Next steps:")
 			fmt.Println("  1. make build-harness && make install-harness")
 			fmt.Println("  2. agentctl master create")
-			fmt.Println("  3. Start agentd daemon")
+			fmt.Println("  3. agentctl certs trust (for HTTPS previews)")
+			fmt.Println("  4. Start agentd daemon")
 			return nil
 		},
 	}
+	cmd.Flags().StringVar(&dnsProvider, "dns", "", "DNS provider: auto, "+strings.Join(network.DNSProviders(), ", ")+" (default from config)")
+	cmd.Flags().BoolVar(&applyDNS, "apply-dns", false, "Apply the DNS configuration with sudo instead of printing it")
+	return cmd
+}
+
+// setupDNS configures the host resolver so *.<domain> reaches agentvm.
+// systemd-resolved can only forward, so choosing it turns on agentd's
+// built-in responder.
+func setupDNS(name string, apply bool) error {
+	dns := cfg.Network.DNS
+	if name == "" {
+		name = dns.Provider
+	}
+	provider, err := network.DNSProviderByName(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  DNS provider: %s\n", provider.Name())
+	if provider.Name() == network.DNSNone {
+		fmt.Printf("  Skipping DNS setup; make *.%s resolve to %s yourself\n", cfg.Network.Domain, dns.Address)
+		return nil
+	}
+
+	if provider.Name() == network.DNSSystemdResolved && !dns.Responder {
+		cfg.Network.DNS.Responder = true
+		if err := config.Save(cfg); err != nil {
+			return fmt.Errorf("enabling DNS responder: %w", err)
+		}
+		dns = cfg.Network.DNS
+		fmt.Printf("  Enabled agentd's DNS responder on %s (restart agentd)\n", dns.Listen)
+	}
+
+	target := network.DNSTarget{Address: dns.Address}
+	if dns.Responder {
+		target.Server = dns.Listen
+	}
+	setup, err := provider.Setup(cfg.Network.Domain, target)
+	if err != nil {
+		return err
+	}
+	if !apply {
+		fmt.Printf("\nTo resolve *.%s, run:\n\n%s", cfg.Network.Domain, setup.Instructions())
+		return nil
+	}
+	if err := setup.Apply(); err != nil {
+		return fmt.Errorf("applying DNS setup: %w", err)
+	}
+	fmt.Printf("  Configured %s for *.%s\n", provider.Name(), cfg.Network.Domain)
+	return nil
 }
 
 func findBinary(name string) (string, error) {
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mateo/agentvm/internal/api"
+)
+
+// pullBundle is where the VM workspace is bundled for download, inside .git
+// so it never shows up as a change.
+const pullBundle = ".git/agentvm-pull.bundle"
+
+// worktreeDir is the default worktree for agent: <repo>-<agent-id> next to
+// repo, or <repo>-<branch> when pulling a bare branch.
+func worktreeDir(repo string, agent *api.AgentStatus) string {
+	name := agent.AgentID
+	if name == "" {
+		name = strings.ReplaceAll(agent.Branch, "/", "-")
+	}
+	return filepath.Join(filepath.Dir(repo), filepath.Base(repo)+"-"+name)
+}
+
+// addWorktree checks out commit on a new branch in a worktree of repo at dir.
+func addWorktree(repo, dir, branch, commit string) error {
+	_, err := git(repo, nil, "worktree", "add", "--quiet", "-b", branch, dir, commit)
+	return err
+}
+
+// fetchBranch fetches branch from remote into repo and returns its commit.
+func fetchBranch(repo, remote, branch string) (string, error) {
+	if _, err := git(repo, nil, "fetch", "--quiet", remote, "refs/heads/"+branch); err != nil {
+		return "", err
+	}
+	return git(repo, nil, "rev-parse", "FETCH_HEAD")
+}
+
+// fetchFromVM bundles the commits of the agent's workspace in its VM,
+// downloads the bundle and fetches it into repo, returning the commit.
+func fetchFromVM(client *api.Client, agentID, repo, remote string) (string, error) {
+	// Leave out what the remote already has when the bundle isn't empty
+	// without it; the remote is fetched below to provide those commits
+	script := `git bundle create "$1" HEAD --not --remotes 2>/dev/null || git bundle create "$1" HEAD`
+	if err := execQuiet(client, agentID, "sh", "-c", script, "sh", pullBundle); err != nil {
+		return "", fmt.Errorf("bundling workspace: %w", err)
+	}
+	defer execQuiet(client, agentID, "rm", "-f", pullBundle)
+
+	rc, err := client.Download(agentID, pullBundle)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	f, err := os.CreateTemp("", "agentvm-*.bundle")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, rc)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("downloading bundle: %w", err)
+	}
+
+	if _, err := git(repo, nil, "fetch", "--quiet", remote); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: fetching %s: %v\n", remote, err)
+	}
+	if _, err := git(repo, nil, "fetch", "--quiet", f.Name(), "HEAD"); err != nil {
+		return "", err
+	}
+	return git(repo, nil, "rev-parse", "FETCH_HEAD")
+}
+
+// uncommittedScript prints the workspace's changes against the commit in
+// $1, untracked files included, as a binary diff. Staging goes to a
+// throwaway index so the workspace's own index is untouched.
+const uncommittedScript = `git cat-file -e "$1^{commit}" 2>/dev/null || { echo "commit $1 is not in the workspace, pull with --from-vm" >&2; exit 1; }
+tmp=$(mktemp -u) && trap 'rm -f "$tmp"' EXIT && export GIT_INDEX_FILE="$tmp" &&
+git read-tree HEAD && git add -A && git diff --cached --binary "$1"`
+
+// applyUncommitted applies the difference between the agent's workspace and
+// commit, the commit the worktree at dir was created from, so the worktree
+// ends up matching the workspace. It reports whether there were any changes.
+func applyUncommitted(client *api.Client, agentID, commit, dir string) (bool, error) {
+	var diff, stderr bytes.Buffer
+	req := api.ExecRequest{Command: []string{"sh", "-c", uncommittedScript, "sh", commit}}
+	code, err := client.Exec(agentID, req, nil, &diff, &stderr)
+	if err != nil {
+		return false, err
+	}
+	if code != 0 {
+		return false, fmt.Errorf("diffing workspace: %s", strings.TrimSpace(stderr.String()))
+	}
+	return applyPatch(dir, &diff)
+}
+
+// applyPatch applies a binary diff to the worktree at dir, reporting
+// whether it had any changes.
+func applyPatch(dir string, diff *bytes.Buffer) (bool, error) {
+	if diff.Len() == 0 {
+		return false, nil
+	}
+	if _, err := git(dir, diff, "apply", "--whitespace=nowarn"); err != nil {
+		return false, fmt.Errorf("applying uncommitted changes: %w", err)
+	}
+	return true, nil
+}
+
+// execQuiet runs a command in the agent's workspace, returning its stderr
+// as the error if it fails.
+func execQuiet(client *api.Client, agentID string, command ...string) error {
+	var stderr bytes.Buffer
+	code, err := client.Exec(agentID, api.ExecRequest{Command: command}, nil, io.Discard, &stderr)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("exit status %d: %s", code, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// git runs git in dir and returns its trimmed output, or its stderr as the
+// error.
+func git(dir string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mateo/agentvm/internal/api"
+)
+
+func gitT(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	out, err := git(dir, nil, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func writeT(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readT(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// setupPullRepos creates a remote with the agent's branch pushed, a local
+// clone to pull into and the agent's workspace, returning the pushed commit.
+func setupPullRepos(t *testing.T) (remote, repo, workspace, pushed string) {
+	root := t.TempDir()
+	remote = filepath.Join(root, "remote.git")
+	workspace = filepath.Join(root, "workspace")
+	repo = filepath.Join(root, "repo")
+
+	gitT(t, root, "init", "-q", "--bare", remote)
+	gitT(t, root, "clone", "-q", remote, workspace)
+	writeT(t, workspace, "main.go", "package main\n")
+	gitT(t, workspace, "add", "-A")
+	gitT(t, workspace, "commit", "-q", "-m", "init")
+	gitT(t, workspace, "push", "-q", "origin", "HEAD:refs/heads/main")
+	gitT(t, root, "clone", "-q", remote, repo)
+
+	gitT(t, workspace, "checkout", "-q", "-b", "agent/fix")
+	writeT(t, workspace, "main.go", "package main // fixed\n")
+	gitT(t, workspace, "commit", "-q", "-am", "fix")
+	gitT(t, workspace, "push", "-q", "origin", "agent/fix")
+	return remote, repo, workspace, gitT(t, workspace, "rev-parse", "HEAD")
+}
+
+func TestPull_WorktreeFromBranch(t *testing.T) {
+	_, repo, _, pushed := setupPullRepos(t)
+
+	commit, err := fetchBranch(repo, "origin", "agent/fix")
+	if err != nil {
+		t.Fatalf("fetchBranch failed: %v", err)
+	}
+	if commit != pushed {
+		t.Errorf("fetched %s, want %s", commit, pushed)
+	}
+
+	dir := worktreeDir(repo, &api.AgentStatus{AgentID: "agent-1", Branch: "agent/fix"})
+	if filepath.Base(dir) != "repo-agent-1" || filepath.Dir(dir) != filepath.Dir(repo) {
+		t.Errorf("unexpected worktree dir %s", dir)
+	}
+	if err := addWorktree(repo, dir, "agent/fix", commit); err != nil {
+		t.Fatalf("addWorktree failed: %v", err)
+	}
+	if got := readT(t, dir, "main.go"); got != "package main // fixed\n" {
+		t.Errorf("worktree has %q", got)
+	}
+	if branch := gitT(t, dir, "rev-parse", "--abbrev-ref", "HEAD"); branch != "agent/fix" {
+		t.Errorf("worktree on %s, want agent/fix", branch)
+	}
+
+	// A second pull into the same branch name is refused
+	if err := addWorktree(repo, dir+"-2", "agent/fix", commit); err == nil {
+		t.Error("expected an error for an existing branch")
+	}
+}
+
+func TestPull_WorktreeDirForBranch(t *testing.T) {
+	dir := worktreeDir("/src/shop", &api.AgentStatus{Branch: "agent/fix-login"})
+	if dir != "/src/shop-agent-fix-login" {
+		t.Errorf("got %s", dir)
+	}
+}
+
+func TestPull_UncommittedAgainstFetchedCommit(t *testing.T) {
+	_, repo, workspace, pushed := setupPullRepos(t)
+
+	// The workspace moved on after pushing: a local commit, an edit and an
+	// untracked file
+	writeT(t, workspace, "util.go", "package main // util\n")
+	gitT(t, workspace, "add", "util.go")
+	gitT(t, workspace, "commit", "-q", "-m", "local")
+	writeT(t, workspace, "main.go", "package main // wip\n")
+	writeT(t, workspace, "notes.txt", "todo\n")
+
+	commit, err := fetchBranch(repo, "origin", "agent/fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "wt")
+	if err := addWorktree(repo, dir, "agent/fix", commit); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sh", "-c", uncommittedScript, "sh", pushed)
+	cmd.Dir = workspace
+	var diff bytes.Buffer
+	cmd.Stdout = &diff
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("diff script failed: %v", err)
+	}
+	applied, err := applyPatch(dir, &diff)
+	if err != nil || !applied {
+		t.Fatalf("applyPatch = %v, %v", applied, err)
+	}
+	for name, want := range map[string]string{
+		"main.go":   "package main // wip\n",
+		"util.go":   "package main // util\n",
+		"notes.txt": "todo\n",
+	} {
+		if got := readT(t, dir, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// The workspace's own index is untouched
+	if staged := gitT(t, workspace, "diff", "--cached", "--name-only"); staged != "" {
+		t.Errorf("workspace index changed: %q", staged)
+	}
+
+	// Nothing to apply when the workspace matches the commit
+	applied, err = applyPatch(dir, &bytes.Buffer{})
+	if err != nil || applied {
+		t.Errorf("expected no changes, got %v, %v", applied, err)
+	}
+}
+
+func TestPull_UncommittedUnknownCommit(t *testing.T) {
+	_, _, workspace, _ := setupPullRepos(t)
+
+	cmd := exec.Command("sh", "-c", uncommittedScript, "sh", strings.Repeat("1", 40))
+	cmd.Dir = workspace
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "--from-vm") {
+		t.Errorf("expected a --from-vm hint, got %q (%v)", out, err)
+	}
+}
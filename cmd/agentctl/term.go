@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// makeRaw puts the local terminal in raw mode so keystrokes reach the remote
+// shell unprocessed. The returned func restores the previous mode.
+func makeRaw() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stdin is not a terminal")
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("setting raw mode: %w", err)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { stty(strings.TrimSpace(saved)) })
+	}, nil
+}
+
+// terminalSize returns the local terminal's size, or zeros if unknown.
+func terminalSize() (rows, cols int) {
+	out, err := stty("size")
+	if err != nil {
+		return 0, 0
+	}
+	fmt.Sscan(out, &rows, &cols)
+	return rows, cols
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mateo/agentvm/internal/api"
+	"github.com/mateo/agentvm/internal/auth"
+	"github.com/mateo/agentvm/internal/lima"
+	"github.com/mateo/agentvm/internal/pool"
+)
+
+// previewLimit is how much of a file GET /agents/{id}/files returns inline.
+const previewLimit = 100 * 1024
+
+// findFormat prints a path's name, type, size, mode, mtime and link target,
+// each NUL-terminated.
+const findFormat = `%f\0%y\0%s\0%m\0%T@\0%l\0`
+
+// Exit statuses of the workspace scripts below.
+const (
+	exitNoWorkspace = 3 // see runInWorkspace
+	exitNotFound    = 4
+	exitIsDir       = 5
+)
+
+func setupFileRoutes(mux *http.ServeMux, poolMgr *pool.Manager, limaClient lima.Client, authz *auth.Authorizer) {
+	// agentSlot resolves the request's agent and workspace path, writing the
+	// error response itself when it fails.
+	agentSlot := func(w http.ResponseWriter, r *http.Request) (*pool.VMSlot, string, bool) {
+		slot, ok := poolMgr.GetSlot(r.PathValue("id"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "agent not found"})
+			return nil, "", false
+		}
+		p, err := workspacePath(r.URL.Query().Get("path"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: err.Error()})
+			return nil, "", false
+		}
+		return slot, p, true
+	}
+
+	// GET /agents/{id}/files - describe a workspace path: a directory's
+	// entries, or a file with a preview of its content
+	mux.HandleFunc("GET /agents/{id}/files", func(w http.ResponseWriter, r *http.Request) {
+		slot, reqPath, ok := agentSlot(w, r)
+		if !ok {
+			return
+		}
+
+		// One round trip: the path's own entry, then its entries or content
+		var out bytes.Buffer
+		err := runInWorkspace(r.Context(), limaClient, slot, &out, nil, `
+[ -e "$2" ] || exit 4
+find -H "$2" -maxdepth 0 -printf "$3"
+if [ -d "$2" ]; then
+	find "$2" -mindepth 1 -maxdepth 1 -printf "$3"
+else
+	head -c "$4" "$2"
+fi`, reqPath, findFormat, strconv.Itoa(previewLimit+1))
+		if writeScriptError(w, err, nil) {
+			return
+		}
+
+		self, rest, ok := parseFileEntry(out.Bytes())
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: "unexpected listing output"})
+			return
+		}
+		info := api.FileInfo{FileEntry: self, Path: reqPath}
+		if self.Type == "directory" {
+			info.Entries = []api.FileEntry{}
+			for len(rest) > 0 {
+				var entry api.FileEntry
+				if entry, rest, ok = parseFileEntry(rest); !ok {
+					break
+				}
+				info.Entries = append(info.Entries, entry)
+			}
+			sort.Slice(info.Entries, func(i, j int) bool { return info.Entries[i].Name < info.Entries[j].Name })
+		} else {
+			if len(rest) > previewLimit {
+				rest, info.Truncated = rest[:previewLimit], true
+			}
+			info.Content = string(rest)
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	// GET /agents/{id}/files/content - download a file, with range support
+	mux.HandleFunc("GET /agents/{id}/files/content", func(w http.ResponseWriter, r *http.Request) {
+		slot, reqPath, ok := agentSlot(w, r)
+		if !ok {
+			return
+		}
+
+		var out bytes.Buffer
+		err := runInWorkspace(r.Context(), limaClient, slot, &out, nil, `
+[ -e "$2" ] || exit 4
+[ -d "$2" ] && exit 5
+stat -L -c '%s %Y' "$2"`, reqPath)
+		if writeScriptError(w, err, nil) {
+			return
+		}
+		var size, mtime int64
+		if _, err := fmt.Sscan(out.String(), &size, &mtime); err != nil {
+			writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: "unexpected stat output"})
+			return
+		}
+
+		start, length := int64(0), size
+		status := http.StatusOK
+		if header := r.Header.Get("Range"); header != "" {
+			s, l, ok, err := parseRange(header, size)
+			if err != nil {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+				writeJSON(w, http.StatusRequestedRangeNotSatisfiable, api.ErrorResponse{Error: err.Error()})
+				return
+			}
+			if ok {
+				start, length, status = s, l, http.StatusPartialContent
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(reqPath)))
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Last-Modified", time.Unix(mtime, 0).UTC().Format(http.TimeFormat))
+		w.WriteHeader(status)
+		if r.Method == http.MethodHead || length == 0 {
+			return
+		}
+
+		err = streamInWorkspace(r.Context(), limaClient, slot, nil, w, nil,
+			`tail -c +"$3" "$2" | head -c "$4"`, reqPath, strconv.FormatInt(start+1, 10), strconv.FormatInt(length, 10))
+		if err != nil && r.Context().Err() == nil {
+			log.Printf("Download of %s from %s failed: %v", reqPath, slot.AgentID, err)
+		}
+	})
+
+	// PUT /agents/{id}/files/content - write a file, creating missing
+	// directories (operator scope)
+	mux.HandleFunc("PUT /agents/{id}/files/content", authz.Require(auth.ScopeOperator, func(w http.ResponseWriter, r *http.Request) {
+		slot, reqPath, ok := agentSlot(w, r)
+		if !ok {
+			return
+		}
+		if reqPath == "." {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "path required"})
+			return
+		}
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = "0644"
+		}
+		if m, err := strconv.ParseUint(mode, 8, 32); err != nil || m > 0777 {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: fmt.Sprintf("invalid mode %q", mode)})
+			return
+		}
+		http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+		// Write to a temporary file first so readers never see a partial one
+		body := &countingReader{r: r.Body}
+		var stderr bytes.Buffer
+		err := streamInWorkspace(r.Context(), limaClient, slot, body, nil, &stderr, `
+mkdir -p "$(dirname "$2")" || exit
+[ -d "$2" ] && exit 5
+t=$(mktemp "$2.XXXXXX") || exit
+cat > "$t" && chmod "$3" "$t" && mv -f "$t" "$2" || { rm -f "$t"; exit 1; }`, reqPath, mode)
+		if writeScriptError(w, err, &stderr) {
+			return
+		}
+		log.Printf("Uploaded %s (%d bytes) to %s", reqPath, body.n, slot.AgentID)
+		writeJSON(w, http.StatusOK, api.UploadResponse{Path: reqPath, Size: body.n})
+	}))
+
+	// GET /agents/{id}/archive - a subtree as a gzipped tar or a zip
+	mux.HandleFunc("GET /agents/{id}/archive", func(w http.ResponseWriter, r *http.Request) {
+		slot, reqPath, ok := agentSlot(w, r)
+		if !ok {
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "tar"
+		}
+		if format != "tar" && format != "zip" {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: fmt.Sprintf("invalid format %q (valid: tar, zip)", format)})
+			return
+		}
+
+		name := path.Base(reqPath)
+		if name == "." {
+			name = slot.Project
+		}
+		out := &lazyResponse{w: w, header: func(h http.Header) {
+			if format == "zip" {
+				h.Set("Content-Type", "application/zip")
+				h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+			} else {
+				h.Set("Content-Type", "application/gzip")
+				h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".tar.gz"))
+			}
+		}}
+
+		script := `
+[ -e "$2" ] || exit 4
+cd "$(dirname "$2")" && tar -c $3 -f - "$(basename "$2")"`
+		var stderr bytes.Buffer
+		var err error
+		if format == "tar" {
+			err = streamInWorkspace(r.Context(), limaClient, slot, nil, out, &stderr, script, reqPath, "-z")
+		} else {
+			// Zip on the host so the VM needs nothing beyond tar
+			pr, pw := io.Pipe()
+			done := make(chan error, 1)
+			go func() {
+				err := streamInWorkspace(r.Context(), limaClient, slot, nil, pw, &stderr, script, reqPath, "")
+				pw.CloseWithError(err)
+				done <- err
+			}()
+			err = tarToZip(pr, out)
+			pr.Close()
+			if execErr := <-done; execErr != nil {
+				err = execErr
+			}
+		}
+		if !out.started {
+			writeScriptError(w, err, &stderr)
+		} else if err != nil && r.Context().Err() == nil {
+			log.Printf("Archive of %s from %s failed: %v", reqPath, slot.AgentID, err)
+		}
+	})
+
+	// PUT /agents/{id}/archive - unpack a tar (gzipped if sent as
+	// application/gzip) into a directory, creating it (operator scope)
+	mux.HandleFunc("PUT /agents/{id}/archive", authz.Require(auth.ScopeOperator, func(w http.ResponseWriter, r *http.Request) {
+		slot, reqPath, ok := agentSlot(w, r)
+		if !ok {
+			return
+		}
+		compress := ""
+		if r.Header.Get("Content-Type") == "application/gzip" {
+			compress = "-z"
+		}
+		http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+		var stderr bytes.Buffer
+		err := streamInWorkspace(r.Context(), limaClient, slot, r.Body, nil, &stderr, `
+mkdir -p "$2" && tar -x $3 -f - -C "$2" --no-same-owner`, reqPath, compress)
+		if writeScriptError(w, err, &stderr) {
+			return
+		}
+		log.Printf("Extracted archive into %s on %s", reqPath, slot.AgentID)
+		writeJSON(w, http.StatusOK, map[string]string{"ok": "true"})
+	}))
+}
+
+// workspacePath cleans a path relative to the workspace root, rejecting
+// paths that would leave it. Empty means the root.
+func workspacePath(raw string) (string, error) {
+	if raw == "" {
+		return ".", nil
+	}
+	p := path.Clean(raw)
+	if p == ".." || strings.HasPrefix(p, "../") || path.IsAbs(p) {
+		return "", fmt.Errorf("invalid path %q", raw)
+	}
+	return p, nil
+}
+
+// writeScriptError writes the response for a failed workspace script and
+// reports whether it did.
+func writeScriptError(w http.ResponseWriter, err error, stderr *bytes.Buffer) bool {
+	if err == nil {
+		return false
+	}
+	var exitErr *lima.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.Code {
+		case exitNoWorkspace:
+			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "workspace not found"})
+			return true
+		case exitNotFound:
+			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "path not found"})
+			return true
+		case exitIsDir:
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "path is a directory"})
+			return true
+		}
+		if stderr != nil && stderr.Len() > 0 {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: err.Error()})
+	return true
+}
+
+// parseFileEntry parses one findFormat record off the front of data.
+func parseFileEntry(data []byte) (api.FileEntry, []byte, bool) {
+	var fields [6]string
+	for i := range fields {
+		field, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok {
+			return api.FileEntry{}, nil, false
+		}
+		fields[i], data = string(field), rest
+	}
+
+	entry := api.FileEntry{Name: fields[0], Target: fields[5]}
+	switch fields[1] {
+	case "f":
+		entry.Type = "file"
+	case "d":
+		entry.Type = "directory"
+	case "l":
+		entry.Type = "symlink"
+	default:
+		entry.Type = "other"
+	}
+	entry.Size, _ = strconv.ParseInt(fields[2], 10, 64)
+	if mode, err := strconv.ParseUint(fields[3], 8, 32); err == nil {
+		entry.Mode = fmt.Sprintf("%04o", mode)
+	}
+	if secs, err := strconv.ParseFloat(fields[4], 64); err == nil {
+		entry.ModTime = time.Unix(0, int64(secs*1e9)).UTC()
+	}
+	return entry, data, true
+}
+
+// parseRange parses a single-range "bytes=" Range header against a file of
+// size bytes. ok is false for headers to ignore, like multiple ranges, in
+// which case the whole file is served.
+func parseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, fmt.Errorf("invalid range %q", header)
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, fmt.Errorf("invalid range %q", header)
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, fmt.Errorf("range %q not satisfiable for %d bytes", header, size)
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, false, fmt.Errorf("invalid range %q", header)
+		}
+		end = min(e, size-1)
+	}
+	return start, end - start + 1, true, nil
+}
+
+// tarToZip converts a tar stream into a zip archive.
+func tarToZip(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	zw := zip.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fh, err := zip.FileInfoHeader(hdr.FileInfo())
+		if err != nil {
+			return err
+		}
+		fh.Name = hdr.Name
+		if hdr.Typeflag == tar.TypeDir {
+			fh.Name = strings.TrimSuffix(fh.Name, "/") + "/"
+		} else {
+			fh.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if _, err := io.Copy(fw, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			io.WriteString(fw, hdr.Linkname)
+		}
+	}
+	return zw.Close()
+}
+
+// lazyResponse sends the status and headers with the first byte of the
+// body, so a stream that fails before producing anything can still get an
+// error response.
+type lazyResponse struct {
+	w       http.ResponseWriter
+	header  func(http.Header)
+	started bool
+}
+
+func (l *lazyResponse) Write(p []byte) (int, error) {
+	if !l.started {
+		l.started = true
+		l.header(l.w.Header())
+		l.w.WriteHeader(http.StatusOK)
+	}
+	return l.w.Write(p)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,25 +1,36 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/mateo/agentvm/internal/api"
+	"github.com/mateo/agentvm/internal/auth"
+	"github.com/mateo/agentvm/internal/certs"
 	"github.com/mateo/agentvm/internal/config"
+	"github.com/mateo/agentvm/internal/history"
 	"github.com/mateo/agentvm/internal/lima"
 	"github.com/mateo/agentvm/internal/network"
 	"github.com/mateo/agentvm/internal/orchestrator"
 	"github.com/mateo/agentvm/internal/pool"
+	"github.com/mateo/agentvm/internal/project"
 	"github.com/mateo/agentvm/internal/registry"
+	"github.com/mateo/agentvm/internal/repocache"
 	"github.com/mateo/agentvm/internal/ws"
 )
 
@@ -61,21 +72,164 @@ func main() {
 		log.Fatalf("Failed to create registry store: %v", err)
 	}
 
-	// Traefik writer
-	traefikWriter := network.NewTraefikWriterHTTPOnly(config.BaseDir(), cfg.Network.Domain, cfg.Network.HTTPOnly)
+	// Preview access control (credentials are revoked on deregistration)
+	accessStore, err := network.NewAccessStore(config.BaseDir(), cfg.Network.Domain)
+	if err != nil {
+		log.Fatalf("Failed to load preview access store: %v", err)
+	}
+	go accessStore.Watch(ctx, store)
+
+	// Built-in DNS responder for *.<domain>
+	if cfg.Network.DNS.Responder {
+		responder, err := network.NewDNSResponder(cfg.Network.Domain, cfg.Network.DNS.Address)
+		if err != nil {
+			log.Fatalf("Failed to create DNS responder: %v", err)
+		}
+		go func() {
+			if err := responder.ListenAndServe(ctx, cfg.Network.DNS.Listen); err != nil {
+				log.Printf("DNS responder error: %v", err)
+			}
+		}()
+		log.Printf("DNS responder answering *.%s on %s", cfg.Network.Domain, cfg.Network.DNS.Listen)
+	}
+
+	// Local CA issuing wildcard certificates for preview hosts
+	var ca *certs.Authority
+	if !cfg.Network.HTTPOnly {
+		ca, err = certs.Load(filepath.Join(config.BaseDir(), "certs"), cfg.Network.Domain)
+		if err != nil {
+			log.Fatalf("Failed to load certificate authority: %v", err)
+		}
+		go network.WatchCerts(ctx, ca, store, cfg.Network.Domain)
+	}
+
+	// Preview router: Traefik file provider or the built-in reverse proxy
+	var router network.Router
+	var reconciler *network.Reconciler
+	switch cfg.Network.Router {
+	case "", network.RouterTraefik:
+		traefikWriter := network.NewTraefikWriterHTTPOnly(config.BaseDir(), cfg.Network.Domain, cfg.Network.HTTPOnly)
+		traefikWriter.SetAccess(accessStore, fmt.Sprintf("http://127.0.0.1:%d/auth/preview", cfg.API.Port))
+		if ca != nil {
+			traefikWriter.SetCA(ca)
+			// Renewed certificates get new file names; rewriting the routes
+			// using them makes Traefik load them.
+			ca.Start(ctx, 12*time.Hour, func(hosts []string) {
+				if err := traefikWriter.CertsRenewed(store.List(), hosts); err != nil {
+					log.Printf("Failed to rewrite routes for renewed certificates: %v", err)
+				}
+			})
+		}
+		// Clean up routes left behind by crashes, dead VMs and released slots
+		reconciler = network.NewReconciler(traefikWriter, store,
+			func() map[string]string {
+				agents := make(map[string]string)
+				for _, slot := range poolMgr.ActiveSlots() {
+					agents[slot.AgentID] = slot.Name
+				}
+				return agents
+			},
+			func(ctx context.Context, vmName string) (string, error) {
+				return lima.GetVMIP(ctx, limaClient, vmName)
+			},
+			time.Minute,
+		)
+		reconciler.Start(ctx)
+		go traefikWriter.WatchAliases(ctx, store)
+		router = traefikWriter
+	case network.RouterBuiltin:
+		proxy := network.NewProxy(cfg.Network.Domain, cfg.Network.HTTPOnly)
+		proxy.SetAccess(accessStore)
+		go proxy.Watch(ctx, store)
+		var tlsConfig *tls.Config
+		if ca != nil {
+			// Certificates are issued on demand during the handshake, for
+			// the hosts of agents serving previews only
+			ca.SetHostPolicy(func(host string) bool {
+				return network.CertHosts(cfg.Network.Domain, store.List())[host]
+			})
+			tlsConfig = &tls.Config{GetCertificate: ca.GetCertificate}
+			ca.Start(ctx, 12*time.Hour, nil)
+		}
+		go func() {
+			if err := proxy.Serve(ctx, cfg.Network.TraefikHTTP, cfg.Network.TraefikHTTPS, tlsConfig); err != nil {
+				log.Printf("Preview proxy failed: %v", err)
+			}
+		}()
+		router = proxy
+	default:
+		log.Fatalf("Unknown network.router %q (valid: traefik, builtin)", cfg.Network.Router)
+	}
+
+	// Host ports for agents' plain TCP services; TLS ones are routed by SNI
+	// when Traefik serves HTTPS
+	sni := cfg.Network.Router != network.RouterBuiltin && !cfg.Network.HTTPOnly
+	tcpFwd := network.NewTCPForwarder(store, cfg.Network.TCP.Bind, cfg.Network.TCP.PortMin, cfg.Network.TCP.PortMax, sni)
+	go tcpFwd.Watch(ctx, store)
 
 	// Registration server (port 8090 — VMs call this)
 	regServer := registry.NewServer(store, func(reg *registry.AgentRegistration) {
-		if err := traefikWriter.WriteRoute(reg); err != nil {
-			log.Printf("Failed to write Traefik route for %s: %v", reg.AgentID, err)
+		if err := router.WriteRoute(reg); err != nil {
+			log.Printf("Failed to write route for %s: %v", reg.AgentID, err)
 		} else {
-			log.Printf("Traefik route written for %s -> %s", reg.AgentID, reg.VMIP)
+			log.Printf("Route written for %s -> %s", reg.AgentID, reg.VMIP)
 		}
 	})
 
 	// Orchestrator
 	hostAddr := fmt.Sprintf("host.lima.internal:%d", cfg.Network.RegistryPort)
 	orch := orchestrator.New(poolMgr, limaClient, config.BaseDir(), hostAddr)
+	orch.SetRegistry(store)
+
+	// Repository mirror cache (shared/repos is mounted into VMs at /mnt/host-shared/repos)
+	var repoCache *repocache.Cache
+	if cfg.RepoCache.Enabled {
+		repoCache = repocache.New(
+			filepath.Join(config.BaseDir(), "shared", "repos"),
+			"/mnt/host-shared/repos",
+			time.Duration(cfg.RepoCache.RefreshMinutes)*time.Minute,
+		)
+		repoCache.Start(ctx)
+	}
+	cloneSettings := make(map[string]orchestrator.CloneConfig)
+	for project, cc := range cfg.RepoCache.Projects {
+		cloneSettings[project] = orchestrator.CloneConfig{
+			Depth:      cc.Depth,
+			Filter:     cc.Filter,
+			FromMirror: cc.FromMirror,
+		}
+	}
+	orch.SetRepoCache(repoCache, cloneSettings)
+
+	// Git credentials per host, optionally remapped per project
+	credSource := func(c config.GitCredential) orchestrator.CredentialSource {
+		return orchestrator.CredentialSource{
+			Type:       c.Type,
+			Username:   c.Username,
+			TokenEnv:   c.TokenEnv,
+			TokenFile:  c.TokenFile,
+			KeyFile:    c.KeyFile,
+			KnownHosts: c.KnownHosts,
+			Helper:     c.Helper,
+		}
+	}
+	credHosts := make(map[string]orchestrator.CredentialSource)
+	for host, c := range cfg.Credentials.Hosts {
+		credHosts[host] = credSource(c)
+	}
+	credProjects := make(map[string]map[string]orchestrator.CredentialSource)
+	for project, hosts := range cfg.Credentials.Projects {
+		credProjects[project] = make(map[string]orchestrator.CredentialSource)
+		for host, c := range hosts {
+			credProjects[project][host] = credSource(c)
+		}
+	}
+	orch.SetCredentials(credHosts, credProjects)
+
+	// Task history (what actually ran, kept after VMs are recycled)
+	hist := history.NewStore(config.BaseDir())
+	orch.SetHistory(hist)
+	regServer.SetHistory(hist)
 
 	// Monitor
 	monitor := orchestrator.NewMonitor(poolMgr, limaClient, 15*time.Second)
@@ -85,17 +239,30 @@ func main() {
 	sshfsMgr := ws.NewSSHFSManager(config.BaseDir())
 
 	// WebSocket command handler
-	cmdHandler := ws.NewCommandHandler(orch, poolMgr, store, traefikWriter, sshfsMgr)
+	cmdHandler := ws.NewCommandHandler(orch, poolMgr, store, router, sshfsMgr)
 
 	// WebSocket hub
-	hub := ws.NewHub(store, poolMgr, cmdHandler, traefikWriter.SubdomainFor)
+	hub := ws.NewHub(store, poolMgr, limaClient, cmdHandler, router.SubdomainFor, router.URLsFor)
+	hub.SetHistory(hist)
 	go hub.Run()
 
+	// API tokens: the local operator's plus any configured ones
+	tokens, err := apiTokens(cfg.API.Tokens)
+	if err != nil {
+		log.Fatalf("API tokens: %v", err)
+	}
+	authz, err := auth.New(config.OperatorTokenPath(), tokens)
+	if err != nil {
+		log.Fatalf("API tokens: %v", err)
+	}
+	hub.SetAuthorizer(authz)
+
 	// API server (port 8091 — agentctl + TUI call this)
 	apiMux := http.NewServeMux()
-	setupAPIRoutes(apiMux, orch, poolMgr, store, traefikWriter, cfg, limaClient, sshfsMgr)
+	setupAPIRoutes(apiMux, orch, poolMgr, store, router, reconciler, accessStore, tcpFwd, cfg, limaClient, sshfsMgr, authz)
 
-	// WebSocket endpoint
+	// WebSocket endpoint: status and logs are open to any client; the hub
+	// checks for operator scope before terminals and commands
 	apiMux.HandleFunc("GET /ws", hub.ServeWS)
 
 	// Start servers
@@ -128,11 +295,17 @@ func main() {
 	log.Println("Shutting down...")
 	hub.Stop()
 	monitor.Stop()
+	if reconciler != nil {
+		reconciler.Stop()
+	}
+	if repoCache != nil {
+		repoCache.Stop()
+	}
 	poolMgr.Stop()
 	cancel()
 }
 
-func setupAPIRoutes(mux *http.ServeMux, orch *orchestrator.Orchestrator, poolMgr *pool.Manager, store *registry.Store, tw *network.TraefikWriter, cfg config.Config, limaClient lima.Client, sshfsMgr *ws.SSHFSManager) {
+func setupAPIRoutes(mux *http.ServeMux, orch *orchestrator.Orchestrator, poolMgr *pool.Manager, store *registry.Store, tw network.Router, reconciler *network.Reconciler, access *network.AccessStore, tcpFwd *network.TCPForwarder, cfg config.Config, limaClient lima.Client, sshfsMgr *ws.SSHFSManager, authz *auth.Authorizer) {
 	// POST /dispatch
 	mux.HandleFunc("POST /dispatch", func(w http.ResponseWriter, r *http.Request) {
 		var req api.DispatchRequest
@@ -140,58 +313,125 @@ func setupAPIRoutes(mux *http.ServeMux, orch *orchestrator.Orchestrator, poolMgr
 			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: err.Error()})
 			return
 		}
+		accessMode := req.Access
+		if accessMode == "" {
+			accessMode = cfg.Network.Access.ModeFor(req.Project)
+		}
+		if !network.ValidAccessMode(accessMode) {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: fmt.Sprintf("invalid access mode %q (valid: none, basic, token)", accessMode)})
+			return
+		}
+
+		// Protect the previews before the agent can serve anything
+		agentID := orchestrator.NewAgentID()
+		acc, err := access.Grant(agentID, req.Project, accessMode)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: fmt.Sprintf("granting preview access: %v", err)})
+			return
+		}
 
 		result, err := orch.Dispatch(r.Context(), orchestrator.DispatchRequest{
-			Project:      req.Project,
-			RepoURL:      req.RepoURL,
-			Issue:        req.Issue,
-			Tool:         req.Tool,
-			Prompt:       req.Prompt,
-			Branch:       req.Branch,
-			MaxTime:      req.MaxTime,
-			MaxTokens:    req.MaxTokens,
-			EnvVars:      req.EnvVars,
-			ServeCommand: req.ServeCommand,
-			ServePort:    req.ServePort,
+			AgentID:            agentID,
+			Project:            req.Project,
+			RepoURL:            req.RepoURL,
+			Issue:              req.Issue,
+			Tool:               req.Tool,
+			Prompt:             req.Prompt,
+			Branch:             req.Branch,
+			MaxTime:            req.MaxTime,
+			MaxTokens:          req.MaxTokens,
+			EnvVars:            req.EnvVars,
+			ServeCommand:       req.ServeCommand,
+			ServePort:          req.ServePort,
+			ServeServices:      serveServices(req.ServeServices),
+			ServeTCP:           serveTCP(req.ServeTCP),
+			ServeHealth:        serveHealth(req.ServeHealth),
+			ServeRestart:       req.ServeRestart,
+			ServeMaxRestarts:   req.ServeMaxRestarts,
+			ServeAlias:         req.ServeAlias,
+			SetupCommands:      req.SetupCommands,
+			SetupTimeout:       req.SetupTimeout,
+			Devcontainer:       req.Devcontainer,
+			ProtectedPaths:     req.ProtectedPaths,
+			ProtectPolicy:      req.ProtectPolicy,
+			SecretScan:         req.SecretScan,
+			CheckpointInterval: req.CheckpointInterval,
+			CommitMessage:      req.CommitMessage,
+			AuthorName:         req.AuthorName,
+			AuthorEmail:        req.AuthorEmail,
 		})
 		if err != nil {
+			access.Revoke(agentID)
 			writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: err.Error()})
 			return
 		}
 
-		writeJSON(w, http.StatusOK, api.DispatchResponse{
+		resp := api.DispatchResponse{
 			AgentID:   result.AgentID,
 			VMName:    result.VMName,
 			VMIP:      result.VMIP,
 			Subdomain: tw.SubdomainFor(result.AgentID, req.Project),
-		})
+		}
+		if result.ServeCommand != "" {
+			var services []registry.Service
+			for _, svc := range result.ServeServices {
+				services = append(services, registry.Service{Name: svc.Name, Port: svc.Port, Path: svc.Path})
+			}
+			resp.URLs = tw.URLsFor(result.AgentID, req.Project, services)
+		}
+		resp.Access = previewAccess(acc, tw.URLsFor(result.AgentID, req.Project, nil)[0])
+		writeJSON(w, http.StatusOK, resp)
 	})
 
+	// slotStatus describes an active agent, enriched with registry data if
+	// available
+	slotStatus := func(slot pool.VMSlot, owners map[string]*registry.AgentRegistration) api.AgentStatus {
+		state := string(slot.State)
+		var urls []string
+		var restarts int
+		var tcp []api.TCPEndpoint
+		var aliasURL string
+		if reg, ok := store.Get(slot.AgentID); ok {
+			state = reg.State
+			restarts = reg.Restarts
+			if reg.Serves() {
+				urls = tw.URLsFor(slot.AgentID, slot.Project, reg.RoutedServices())
+			}
+			tcp = tcpEndpoints(reg, tcpFwd, tw, cfg.Network.TraefikHTTPS)
+			if owner, ok := owners[reg.Alias+"."+reg.Project]; ok && owner.AgentID == reg.AgentID {
+				aliasURL = tw.AliasURL(reg.Alias, reg.Project)
+			}
+		}
+		return api.AgentStatus{
+			AgentID:   slot.AgentID,
+			VMName:    slot.Name,
+			VMIP:      slot.VMIP,
+			Project:   slot.Project,
+			Tool:      slot.Tool,
+			Branch:    slot.Branch,
+			Issue:     slot.Issue,
+			State:     state,
+			StartedAt: slot.ClaimedAt,
+			Elapsed:   time.Since(slot.ClaimedAt),
+			Subdomain: tw.SubdomainFor(slot.AgentID, slot.Project),
+			URLs:      urls,
+			Restarts:  restarts,
+			Access:    previewAccess(access.Get(slot.AgentID), tw.URLsFor(slot.AgentID, slot.Project, nil)[0]),
+			TCP:       tcp,
+			Listening: listeningPorts(store.Listening(slot.AgentID)),
+			AliasURL:  aliasURL,
+		}
+	}
+
 	// GET /status
 	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
 		warm, active, cold := poolMgr.Status()
 		agents := poolMgr.ActiveSlots()
+		owners := network.AliasOwners(store.List())
 
 		statusAgents := make([]api.AgentStatus, 0, len(agents))
 		for _, slot := range agents {
-			// Enrich with registry data if available
-			state := string(slot.State)
-			if reg, ok := store.Get(slot.AgentID); ok {
-				state = reg.State
-			}
-			statusAgents = append(statusAgents, api.AgentStatus{
-				AgentID:   slot.AgentID,
-				VMName:    slot.Name,
-				VMIP:      slot.VMIP,
-				Project:   slot.Project,
-				Tool:      slot.Tool,
-				Branch:    slot.Branch,
-				Issue:     slot.Issue,
-				State:     state,
-				StartedAt: slot.ClaimedAt,
-				Elapsed:   time.Since(slot.ClaimedAt),
-				Subdomain: tw.SubdomainFor(slot.AgentID, slot.Project),
-			})
+			statusAgents = append(statusAgents, slotStatus(slot, owners))
 		}
 
 		writeJSON(w, http.StatusOK, api.PoolStatus{
@@ -202,6 +442,36 @@ func setupAPIRoutes(mux *http.ServeMux, orch *orchestrator.Orchestrator, poolMgr
 		})
 	})
 
+	// GET /agents/{id} describes one agent. Agents whose VM slot was already
+	// released are described from the registry while they remain in it.
+	mux.HandleFunc("GET /agents/{id}", func(w http.ResponseWriter, r *http.Request) {
+		agentID := r.PathValue("id")
+		for _, slot := range poolMgr.ActiveSlots() {
+			if slot.AgentID == agentID {
+				writeJSON(w, http.StatusOK, slotStatus(slot, network.AliasOwners(store.List())))
+				return
+			}
+		}
+		reg, ok := store.Get(agentID)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("agent %s not found", agentID)})
+			return
+		}
+		writeJSON(w, http.StatusOK, api.AgentStatus{
+			AgentID:   reg.AgentID,
+			VMName:    reg.VMName,
+			VMIP:      reg.VMIP,
+			Project:   reg.Project,
+			Tool:      reg.Tool,
+			Branch:    reg.Branch,
+			State:     reg.State,
+			StartedAt: reg.RegisteredAt,
+			Elapsed:   time.Since(reg.RegisteredAt),
+			Subdomain: tw.SubdomainFor(reg.AgentID, reg.Project),
+			Restarts:  reg.Restarts,
+		})
+	})
+
 	// POST /agents/{id}/kill
 	mux.HandleFunc("POST /agents/{id}/kill", func(w http.ResponseWriter, r *http.Request) {
 		agentID := r.PathValue("id")
@@ -221,111 +491,141 @@ func setupAPIRoutes(mux *http.ServeMux, orch *orchestrator.Orchestrator, poolMgr
 		writeJSON(w, http.StatusOK, map[string]string{"ok": "true"})
 	})
 
-	// GET /agents/{id}/logs
-	mux.HandleFunc("GET /agents/{id}/logs", func(w http.ResponseWriter, r *http.Request) {
+	// POST /agents/{id}/serve/restart - bounce the serve process via SIGHUP
+	mux.HandleFunc("POST /agents/{id}/serve/restart", func(w http.ResponseWriter, r *http.Request) {
 		agentID := r.PathValue("id")
 		slot, ok := poolMgr.GetSlot(agentID)
 		if !ok {
 			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "agent not found"})
 			return
 		}
+		reg, ok := store.Get(agentID)
+		if !ok || !servingStates[reg.State] {
+			writeJSON(w, http.StatusConflict, api.ErrorResponse{Error: "agent is not serving"})
+			return
+		}
 
-		output, err := limaClient.Shell(r.Context(), lima.ShellOptions{
+		_, err := limaClient.Shell(r.Context(), lima.ShellOptions{
 			Instance: slot.Name,
 			Command:  "sudo",
-			Args:     []string{"journalctl", "-u", "agent-harness.service", "--no-pager", "-n", "200"},
+			Args:     []string{"systemctl", "kill", "--kill-who=main", "--signal=SIGHUP", "agent-harness.service"},
 			Timeout:  15 * time.Second,
 		})
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: err.Error()})
 			return
 		}
-
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte(output))
+		writeJSON(w, http.StatusOK, map[string]string{"ok": "true"})
 	})
 
-	// GET /agents/{id}/files - browse files in agent's workspace
-	mux.HandleFunc("GET /agents/{id}/files", func(w http.ResponseWriter, r *http.Request) {
+	// POST /agents/{id}/expose - route a discovered port without restarting the agent
+	mux.HandleFunc("POST /agents/{id}/expose", func(w http.ResponseWriter, r *http.Request) {
 		agentID := r.PathValue("id")
 		slot, ok := poolMgr.GetSlot(agentID)
 		if !ok {
 			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "agent not found"})
 			return
 		}
-
-		reqPath := r.URL.Query().Get("path")
-		if reqPath == "" {
-			reqPath = "."
+		var req api.ExposeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: err.Error()})
+			return
 		}
-		// Sanitize path to prevent traversal
-		reqPath = filepath.Clean(reqPath)
-		if strings.HasPrefix(reqPath, "..") || strings.HasPrefix(reqPath, "/") {
-			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "invalid path"})
+
+		reg, err := network.Expose(store, tw, slot, req.Port, req.Name)
+		if err != nil {
+			status := http.StatusBadRequest
+			if reg != nil {
+				status = http.StatusInternalServerError
+			}
+			writeJSON(w, status, api.ErrorResponse{Error: err.Error()})
 			return
 		}
+		urls := tw.URLsFor(agentID, slot.Project, reg.RoutedServices())
+		writeJSON(w, http.StatusOK, api.ExposeResponse{
+			Name: reg.Exposed[len(reg.Exposed)-1].Name,
+			URL:  urls[len(urls)-1],
+		})
+	})
 
-		wsBase := fmt.Sprintf("~/workspace/%s", slot.Project)
-		fullPath := fmt.Sprintf("%s/%s", wsBase, reqPath)
+	// POST /agents/{id}/exec - run a command in the agent's workspace (operator scope)
+	mux.HandleFunc("POST /agents/{id}/exec", authz.Require(auth.ScopeOperator, func(w http.ResponseWriter, r *http.Request) {
+		agentID := r.PathValue("id")
+		slot, ok := poolMgr.GetSlot(agentID)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "agent not found"})
+			return
+		}
+		// The body is the request line, then stdin
+		body := bufio.NewReader(r.Body)
+		line, err := body.ReadBytes('\n')
+		var req api.ExecRequest
+		if err != nil || json.Unmarshal(line, &req) != nil || len(req.Command) == 0 {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "invalid exec request"})
+			return
+		}
+		var stdin io.Reader
+		if req.Stdin {
+			stdin = body
+		}
 
-		// Check if it's a directory or file
-		checkCmd := fmt.Sprintf("if [ -d %q ]; then echo DIR; elif [ -f %q ]; then echo FILE; else echo NONE; fi", fullPath, fullPath)
-		typeCheck, err := limaClient.Shell(r.Context(), lima.ShellOptions{
+		// Keep reading stdin while streaming output, for as long as it runs
+		rc := http.NewResponseController(w)
+		rc.EnableFullDuplex()
+		rc.SetReadDeadline(time.Time{})
+		caller, _ := authz.Caller(r)
+		log.Printf("Exec on %s by %s: %q", agentID, caller.Name, req.Command)
+
+		w.Header().Set("Content-Type", api.ExecContentType)
+		w.WriteHeader(http.StatusOK)
+		out := api.NewExecWriter(w, func() { rc.Flush() })
+		err = limaClient.Exec(r.Context(), lima.ExecOptions{
 			Instance: slot.Name,
 			Command:  "bash",
-			Args:     []string{"-c", checkCmd},
-			Timeout:  10 * time.Second,
+			Args:     append([]string{"-c", `cd ~/workspace/"$1" || exit; shift; exec "$@"`, "bash", slot.Project}, req.Command...),
+			Stdin:    stdin,
+			Stdout:   out.Stream(api.ExecStdout),
+			Stderr:   out.Stream(api.ExecStderr),
+		})
+
+		var exit api.ExecExit
+		var exitErr *lima.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			exit.Code = exitErr.Code
+		case err != nil:
+			exit.Code, exit.Error = -1, err.Error()
+		}
+		out.Exit(exit)
+	}))
+
+	// GET /agents/{id}/logs
+	mux.HandleFunc("GET /agents/{id}/logs", func(w http.ResponseWriter, r *http.Request) {
+		agentID := r.PathValue("id")
+		slot, ok := poolMgr.GetSlot(agentID)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "agent not found"})
+			return
+		}
+
+		output, err := limaClient.Shell(r.Context(), lima.ShellOptions{
+			Instance: slot.Name,
+			Command:  "sudo",
+			Args:     []string{"journalctl", "-u", "agent-harness.service", "--no-pager", "-n", "200"},
+			Timeout:  15 * time.Second,
 		})
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: err.Error()})
 			return
 		}
 
-		typeCheck = strings.TrimSpace(typeCheck)
-		switch typeCheck {
-		case "DIR":
-			// List directory contents
-			listCmd := fmt.Sprintf("ls -la --time-style=long-iso %q 2>/dev/null || ls -la %q", fullPath, fullPath)
-			output, err := limaClient.Shell(r.Context(), lima.ShellOptions{
-				Instance: slot.Name,
-				Command:  "bash",
-				Args:     []string{"-c", listCmd},
-				Timeout:  10 * time.Second,
-			})
-			if err != nil {
-				writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: err.Error()})
-				return
-			}
-			writeJSON(w, http.StatusOK, map[string]interface{}{
-				"type":    "directory",
-				"path":    reqPath,
-				"content": output,
-			})
-
-		case "FILE":
-			// Read file contents (limited to 100KB)
-			readCmd := fmt.Sprintf("head -c 102400 %q", fullPath)
-			output, err := limaClient.Shell(r.Context(), lima.ShellOptions{
-				Instance: slot.Name,
-				Command:  "bash",
-				Args:     []string{"-c", readCmd},
-				Timeout:  10 * time.Second,
-			})
-			if err != nil {
-				writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: err.Error()})
-				return
-			}
-			writeJSON(w, http.StatusOK, map[string]interface{}{
-				"type":    "file",
-				"path":    reqPath,
-				"content": output,
-			})
-
-		default:
-			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "path not found"})
-		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(output))
 	})
 
+	// Workspace files: listings, downloads, archives and uploads
+	setupFileRoutes(mux, poolMgr, limaClient, authz)
+
 	// GET /agents/{id}/diff - git diff for agent's workspace
 	mux.HandleFunc("GET /agents/{id}/diff", func(w http.ResponseWriter, r *http.Request) {
 		agentID := r.PathValue("id")
@@ -335,32 +635,80 @@ func setupAPIRoutes(mux *http.ServeMux, orch *orchestrator.Orchestrator, poolMgr
 			return
 		}
 
-		wsBase := fmt.Sprintf("~/workspace/%s", slot.Project)
-		// Get diff against origin (shows all changes made by the agent)
-		diffCmd := fmt.Sprintf("cd %s && git diff HEAD 2>/dev/null || git diff 2>/dev/null || echo 'no changes'", wsBase)
-		output, err := limaClient.Shell(r.Context(), lima.ShellOptions{
-			Instance: slot.Name,
-			Command:  "bash",
-			Args:     []string{"-c", diffCmd},
-			Timeout:  15 * time.Second,
-		})
+		// Diff against HEAD (all changes made by the agent) on stdout, the
+		// stat summary on stderr
+		var diff, stat bytes.Buffer
+		err := runInWorkspace(r.Context(), limaClient, slot, &diff, &stat, `
+git diff --stat HEAD >&2 2>/dev/null
+git diff HEAD 2>/dev/null || git diff 2>/dev/null || echo 'no changes'`)
+		var exitErr *lima.ExitError
+		if errors.As(err, &exitErr) && exitErr.Code == 3 {
+			writeJSON(w, http.StatusNotFound, api.ErrorResponse{Error: "workspace not found"})
+			return
+		}
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: err.Error()})
 			return
 		}
 
-		// Also get the stat summary
-		statCmd := fmt.Sprintf("cd %s && git diff --stat HEAD 2>/dev/null || echo ''", wsBase)
-		stat, _ := limaClient.Shell(r.Context(), lima.ShellOptions{
-			Instance: slot.Name,
-			Command:  "bash",
-			Args:     []string{"-c", statCmd},
-			Timeout:  10 * time.Second,
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"diff": diff.String(),
+			"stat": strings.TrimSpace(stat.String()),
 		})
+	})
 
-		writeJSON(w, http.StatusOK, map[string]interface{}{
-			"diff": output,
-			"stat": strings.TrimSpace(stat),
+	// GET /aliases - stable preview aliases and which agent owns each
+	mux.HandleFunc("GET /aliases", func(w http.ResponseWriter, r *http.Request) {
+		regs := store.List()
+		claims := network.AliasClaims(regs)
+		owners := network.AliasOwners(regs)
+		keys := make([]string, 0, len(owners))
+		for key := range owners {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		aliases := make([]api.Alias, 0, len(keys))
+		for _, key := range keys {
+			owner := owners[key]
+			a := api.Alias{
+				Alias:   owner.Alias,
+				Project: owner.Project,
+				URL:     tw.AliasURL(owner.Alias, owner.Project),
+				AgentID: owner.AgentID,
+				Branch:  owner.Branch,
+			}
+			for _, reg := range claims[key] {
+				if reg != owner {
+					a.Claims = append(a.Claims, reg.AgentID)
+				}
+			}
+			aliases = append(aliases, a)
+		}
+		writeJSON(w, http.StatusOK, aliases)
+	})
+
+	// /auth/preview - Traefik forwardAuth check for token-protected previews
+	mux.HandleFunc("/auth/preview", access.ForwardAuth)
+
+	// POST /routes/reconcile - fix route drift now; ?dryRun=true only reports it
+	mux.HandleFunc("POST /routes/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		if reconciler == nil {
+			writeJSON(w, http.StatusBadRequest, api.ErrorResponse{Error: "route reconciliation requires the traefik router"})
+			return
+		}
+		report, err := reconciler.Reconcile(r.Context(), r.URL.Query().Get("dryRun") == "true")
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, api.ErrorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, api.RouteReport{
+			Orphaned:  report.Orphaned,
+			Stale:     report.Stale,
+			Rewritten: report.Rewritten,
+			Moved:     report.Moved,
+			Missing:   report.Missing,
+			Aliases:   report.Aliases,
 		})
 	})
 
@@ -396,8 +744,136 @@ func setupAPIRoutes(mux *http.ServeMux, orch *orchestrator.Orchestrator, poolMgr
 	})
 }
 
+// apiTokens reads the configured API tokens from their env vars or files.
+func apiTokens(configured []config.APIToken) ([]auth.Token, error) {
+	tokens := make([]auth.Token, 0, len(configured))
+	for _, t := range configured {
+		value := ""
+		switch {
+		case t.TokenEnv != "":
+			value = os.Getenv(t.TokenEnv)
+		case t.TokenFile != "":
+			data, err := os.ReadFile(t.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("token %q: %w", t.Name, err)
+			}
+			value = strings.TrimSpace(string(data))
+		}
+		tokens = append(tokens, auth.Token{Name: t.Name, Value: value, Scopes: t.Scopes})
+	}
+	return tokens, nil
+}
+
+// runInWorkspace runs a bash script from the root of the agent's workspace,
+// with the project as $1 and args from $2 on so paths never need quoting.
+// It exits 3 when the workspace does not exist.
+func runInWorkspace(ctx context.Context, client lima.Client, slot *pool.VMSlot, stdout, stderr io.Writer, script string, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	return streamInWorkspace(ctx, client, slot, nil, stdout, stderr, script, args...)
+}
+
+// streamInWorkspace is runInWorkspace with stdin and without a timeout, for
+// transfers that take as long as they take.
+func streamInWorkspace(ctx context.Context, client lima.Client, slot *pool.VMSlot, stdin io.Reader, stdout, stderr io.Writer, script string, args ...string) error {
+	return client.Exec(ctx, lima.ExecOptions{
+		Instance: slot.Name,
+		Command:  "bash",
+		Args:     append([]string{"-c", `cd ~/workspace/"$1" || exit 3` + script, "bash", slot.Project}, args...),
+		Stdin:    stdin,
+		Stdout:   stdout,
+		Stderr:   stderr,
+	})
+}
+
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(v)
 }
+
+// previewAccess converts an agent's access grant for API responses; url is
+// its primary preview URL.
+func previewAccess(acc *network.Access, url string) *api.PreviewAccess {
+	if acc == nil {
+		return nil
+	}
+	pa := &api.PreviewAccess{
+		Mode:     acc.Mode,
+		Username: acc.Username,
+		Password: acc.Password,
+		Token:    acc.Token,
+	}
+	if acc.Token != "" {
+		pa.URL = network.TokenURL(url, acc.Token)
+	}
+	return pa
+}
+
+// servingStates are the registry states in which the harness is running the
+// serve loop and handles SIGHUP.
+var servingStates = map[string]bool{"serving": true, "unhealthy": true, "restarting": true}
+
+func listeningPorts(in []registry.ListeningPort) []api.ListeningPort {
+	var out []api.ListeningPort
+	for _, p := range in {
+		out = append(out, api.ListeningPort{Port: p.Port, Process: p.Process, Loopback: p.Loopback})
+	}
+	return out
+}
+
+func serveServices(in []api.ServeService) []project.Service {
+	var out []project.Service
+	for _, svc := range in {
+		out = append(out, project.Service{Name: svc.Name, Port: svc.Port, Path: svc.Path})
+	}
+	return out
+}
+
+func serveTCP(in []api.ServeTCPService) []project.TCPService {
+	var out []project.TCPService
+	for _, svc := range in {
+		out = append(out, project.TCPService{Name: svc.Name, Port: svc.Port, TLS: svc.TLS})
+	}
+	return out
+}
+
+// tcpEndpoints lists where an agent's TCP services are reachable: a
+// forwarded host port, or <name>.<agent host> on the HTTPS port for SNI
+// routes.
+func tcpEndpoints(reg *registry.AgentRegistration, tcpFwd *network.TCPForwarder, tw network.Router, httpsPort int) []api.TCPEndpoint {
+	hostPorts := make(map[string]int)
+	for _, r := range reg.TCPRoutes {
+		hostPorts[r.Name] = r.HostPort
+	}
+	var out []api.TCPEndpoint
+	for _, svc := range reg.TCP {
+		ep := api.TCPEndpoint{Name: svc.Name}
+		if tcpFwd.Forwarded(svc) {
+			port, ok := hostPorts[svc.Name]
+			if !ok {
+				continue // not allocated yet
+			}
+			ep.Address = tcpFwd.Addr(port)
+		} else {
+			ep.Address = fmt.Sprintf("%s.%s:%d", svc.Name, tw.SubdomainFor(reg.AgentID, reg.Project), httpsPort)
+			ep.TLS = true
+		}
+		out = append(out, ep)
+	}
+	return out
+}
+
+func serveHealth(in *api.HealthCheck) *project.HealthCheck {
+	if in == nil {
+		return nil
+	}
+	return &project.HealthCheck{
+		Path:             in.Path,
+		Status:           in.Status,
+		Interval:         in.Interval,
+		Timeout:          in.Timeout,
+		FailureThreshold: in.FailureThreshold,
+		StartTimeout:     in.StartTimeout,
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarGzRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "sub", "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644)
+	os.Symlink("sub/run.sh", filepath.Join(src, "link"))
+
+	var buf bytes.Buffer
+	if err := WriteTarGz(&buf, src, "fixtures"); err != nil {
+		t.Fatalf("WriteTarGz failed: %v", err)
+	}
+
+	// Stripping the prefix extracts the contents directly into dest
+	dest := t.TempDir()
+	if err := ExtractTarGz(bytes.NewReader(buf.Bytes()), dest, 1); err != nil {
+		t.Fatalf("ExtractTarGz failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "a.txt")); string(data) != "hello" {
+		t.Errorf("a.txt = %q", data)
+	}
+	info, err := os.Stat(filepath.Join(dest, "sub", "run.sh"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("sub/run.sh: %v %v", info, err)
+	}
+	if target, _ := os.Readlink(filepath.Join(dest, "link")); target != "sub/run.sh" {
+		t.Errorf("link -> %q", target)
+	}
+}
+
+func TestExtractTarGz_RefusesEscapes(t *testing.T) {
+	tests := []struct {
+		name string
+		hdr  tar.Header
+	}{
+		{"parent entry", tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}},
+		{"nested parent entry", tar.Header{Name: "a/../../evil", Typeflag: tar.TypeReg, Mode: 0644}},
+		{"absolute symlink", tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		{"escaping symlink", tar.Header{Name: "a/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			tw := tar.NewWriter(gz)
+			tw.WriteHeader(&tt.hdr)
+			tw.Close()
+			gz.Close()
+
+			dest := t.TempDir()
+			if err := ExtractTarGz(&buf, filepath.Join(dest, "out"), 0); err == nil {
+				t.Error("expected entry to be refused")
+			}
+			if _, err := os.Lstat(filepath.Join(dest, "evil")); err == nil {
+				t.Error("entry was written outside the destination")
+			}
+		})
+	}
+}
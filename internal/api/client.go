@@ -6,17 +6,36 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 type Client struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	Token      string // API bearer token, needed for operator endpoints
 }
 
+// NewClient talks to agentd on the local port, or at $AGENTVM_API_URL when
+// set, e.g. a remote agentd reached through an SSH tunnel. The API token is
+// $AGENTVM_TOKEN, or else the local operator token agentd keeps in
+// ~/.agentvm/operator-token.
 func NewClient(port int) *Client {
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+	if url := os.Getenv("AGENTVM_API_URL"); url != "" {
+		baseURL = strings.TrimSuffix(url, "/")
+	}
+	token := os.Getenv("AGENTVM_TOKEN")
+	if token == "" {
+		home, _ := os.UserHomeDir()
+		data, _ := os.ReadFile(filepath.Join(home, ".agentvm", "operator-token"))
+		token = strings.TrimSpace(string(data))
+	}
 	return &Client{
-		BaseURL: fmt.Sprintf("http://127.0.0.1:%d", port),
+		BaseURL: baseURL,
+		Token:   token,
 		HTTPClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -39,10 +58,33 @@ func (c *Client) Status() (*PoolStatus, error) {
 	return &resp, nil
 }
 
+// Agent returns the status of one agent, active or still in the registry.
+func (c *Client) Agent(agentID string) (*AgentStatus, error) {
+	var resp AgentStatus
+	if err := c.get("/agents/"+agentID, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func (c *Client) Kill(agentID string) error {
 	return c.post(fmt.Sprintf("/agents/%s/kill", agentID), nil, nil)
 }
 
+// RestartServe restarts an agent's serve process.
+func (c *Client) RestartServe(agentID string) error {
+	return c.post(fmt.Sprintf("/agents/%s/serve/restart", agentID), nil, nil)
+}
+
+// Expose routes a port of a running agent at a preview URL.
+func (c *Client) Expose(agentID string, req ExposeRequest) (*ExposeResponse, error) {
+	var resp ExposeResponse
+	if err := c.post(fmt.Sprintf("/agents/%s/expose", agentID), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func (c *Client) Logs(agentID string, follow bool, execution bool) (io.ReadCloser, error) {
 	url := fmt.Sprintf("%s/agents/%s/logs?follow=%v&execution=%v", c.BaseURL, agentID, follow, execution)
 	resp, err := c.HTTPClient.Get(url)
@@ -57,6 +99,29 @@ func (c *Client) Logs(agentID string, follow bool, execution bool) (io.ReadClose
 	return resp.Body, nil
 }
 
+// Aliases lists the stable preview aliases and the agents owning them.
+func (c *Client) Aliases() ([]Alias, error) {
+	var resp []Alias
+	if err := c.get("/aliases", &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ReconcileRoutes fixes routing drift on the host, or only reports it when
+// dryRun is set.
+func (c *Client) ReconcileRoutes(dryRun bool) (*RouteReport, error) {
+	var report RouteReport
+	path := "/routes/reconcile"
+	if dryRun {
+		path += "?dryRun=true"
+	}
+	if err := c.post(path, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
 func (c *Client) PoolReplenish() error {
 	return c.post("/pool/replenish", nil, nil)
 }
@@ -129,3 +194,20 @@ func (c *Client) post(path string, payload, result interface{}) error {
 	}
 	return nil
 }
+
+// authorize adds the client's API token to req.
+func (c *Client) authorize(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// responseError turns a non-OK response into an error.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	var errResp ErrorResponse
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+		return fmt.Errorf("%s", errResp.Error)
+	}
+	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, body)
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ExecRequest is the first line of a POST /agents/{id}/exec body. When Stdin
+// is set, the rest of the body is the command's stdin.
+type ExecRequest struct {
+	Command []string `json:"command"`
+	Stdin   bool     `json:"stdin,omitempty"`
+}
+
+// ExecExit ends an exec stream.
+type ExecExit struct {
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"` // the command could not be run
+}
+
+// ExecContentType is the media type of an exec response: a sequence of
+// frames, each a stream byte, a big-endian uint32 length and the data.
+const ExecContentType = "application/vnd.agentvm.exec-stream"
+
+// Exec stream identifiers.
+const (
+	ExecStdout byte = 1
+	ExecStderr byte = 2
+	ExecEnd    byte = 3 // data is an ExecExit
+)
+
+// ExecWriter multiplexes a command's output into an exec response.
+type ExecWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	flush func()
+}
+
+// NewExecWriter writes frames to w, calling flush after each one.
+func NewExecWriter(w io.Writer, flush func()) *ExecWriter {
+	return &ExecWriter{w: w, flush: flush}
+}
+
+// Stream returns a writer framing its writes as the given stream.
+func (e *ExecWriter) Stream(stream byte) io.Writer {
+	return execStream{e, stream}
+}
+
+// Exit writes the final frame.
+func (e *ExecWriter) Exit(exit ExecExit) error {
+	data, err := json.Marshal(exit)
+	if err != nil {
+		return err
+	}
+	return e.frame(ExecEnd, data)
+}
+
+func (e *ExecWriter) frame(stream byte, data []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var header [5]byte
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := e.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(data); err != nil {
+		return err
+	}
+	if e.flush != nil {
+		e.flush()
+	}
+	return nil
+}
+
+type execStream struct {
+	e      *ExecWriter
+	stream byte
+}
+
+func (s execStream) Write(p []byte) (int, error) {
+	if err := s.e.frame(s.stream, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadExecStream demultiplexes an exec response into stdout and stderr and
+// returns its final frame.
+func ReadExecStream(r io.Reader, stdout, stderr io.Writer) (ExecExit, error) {
+	br := bufio.NewReader(r)
+	var header [5]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return ExecExit{}, fmt.Errorf("exec stream ended without an exit status: %w", err)
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(br, data); err != nil {
+			return ExecExit{}, fmt.Errorf("reading exec stream: %w", err)
+		}
+		switch header[0] {
+		case ExecStdout:
+			stdout.Write(data)
+		case ExecStderr:
+			stderr.Write(data)
+		case ExecEnd:
+			var exit ExecExit
+			err := json.Unmarshal(data, &exit)
+			return exit, err
+		}
+	}
+}
+
+// Exec runs a command in an agent's workspace, streaming its output to
+// stdout and stderr, and returns its exit code. stdin may be nil. It needs
+// an API token with operator scope.
+func (c *Client) Exec(agentID string, req ExecRequest, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	req.Stdin = stdin != nil
+	head, err := json.Marshal(req)
+	if err != nil {
+		return -1, fmt.Errorf("marshaling request: %w", err)
+	}
+	body := io.Reader(bytes.NewReader(append(head, '\n')))
+	if stdin != nil {
+		body = io.MultiReader(body, stdin)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/agents/%s/exec", c.BaseURL, agentID), body)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := c.stream(httpReq)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	exit, err := ReadExecStream(resp.Body, stdout, stderr)
+	if err != nil {
+		return -1, err
+	}
+	if exit.Error != "" {
+		return exit.Code, errors.New(exit.Error)
+	}
+	return exit.Code, nil
+}
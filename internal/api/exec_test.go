@@ -0,0 +1,48 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClient_Exec(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "operator scope required"})
+			return
+		}
+		body := bufio.NewReader(r.Body)
+		line, _ := body.ReadBytes('\n')
+		var req ExecRequest
+		json.Unmarshal(line, &req)
+		stdin, _ := io.ReadAll(body)
+
+		out := NewExecWriter(w, nil)
+		io.WriteString(out.Stream(ExecStdout), strings.Join(req.Command, " ")+"\n")
+		out.Stream(ExecStderr).Write(stdin)
+		out.Exit(ExecExit{Code: 4})
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, HTTPClient: srv.Client(), Token: "secret"}
+	var stdout, stderr bytes.Buffer
+	code, err := c.Exec("a1", ExecRequest{Command: []string{"go", "test", "./..."}}, strings.NewReader("input"), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if code != 4 || stdout.String() != "go test ./...\n" || stderr.String() != "input" {
+		t.Errorf("got code %d, stdout %q, stderr %q", code, stdout.String(), stderr.String())
+	}
+
+	c.Token = ""
+	if _, err := c.Exec("a1", ExecRequest{Command: []string{"true"}}, nil, &stdout, &stderr); err == nil || !strings.Contains(err.Error(), "operator scope") {
+		t.Errorf("expected scope error, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Files describes a path in an agent's workspace.
+func (c *Client) Files(agentID, path string) (*FileInfo, error) {
+	var resp FileInfo
+	if err := c.get(fmt.Sprintf("/agents/%s/files?path=%s", agentID, url.QueryEscape(path)), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Download streams a file from an agent's workspace.
+func (c *Client) Download(agentID, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/agents/%s/files/content?path=%s", c.BaseURL, agentID, url.QueryEscape(path)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.stream(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Upload writes r to a file in an agent's workspace, creating missing
+// directories. It needs an API token with operator scope.
+func (c *Client) Upload(agentID, path string, r io.Reader, mode os.FileMode) (*UploadResponse, error) {
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/agents/%s/files/content?path=%s&mode=%04o", c.BaseURL, agentID, url.QueryEscape(path), mode.Perm()), r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.stream(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var up UploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&up); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return &up, nil
+}
+
+// Archive streams a subtree of an agent's workspace as a gzipped tar
+// ("tar") or a zip ("zip"), with entries under the subtree's name.
+func (c *Client) Archive(agentID, path, format string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/agents/%s/archive?path=%s&format=%s", c.BaseURL, agentID, url.QueryEscape(path), url.QueryEscape(format)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.stream(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Extract unpacks a gzipped tar into a directory of an agent's workspace,
+// creating it if missing. It needs an API token with operator scope.
+func (c *Client) Extract(agentID, path string, tarGz io.Reader) error {
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/agents/%s/archive?path=%s", c.BaseURL, agentID, url.QueryEscape(path)), tarGz)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err := c.stream(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// stream sends req with the client's token and no overall timeout, for
+// transfers that take as long as they take. Non-OK responses are errors.
+func (c *Client) stream(req *http.Request) (*http.Response, error) {
+	c.authorize(req)
+	resp, err := (&http.Client{Transport: c.HTTPClient.Transport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Terminal is an interactive shell in an agent's workspace, relayed through
+// agentd's WebSocket hub so it works wherever agentd is reachable.
+type Terminal struct {
+	conn    *websocket.Conn
+	agentID string
+	mu      sync.Mutex // serializes writes
+}
+
+// wsEnvelope mirrors the hub's message envelope.
+type wsEnvelope struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type termSubscribe struct {
+	Channel string `json:"channel"`
+	Rows    int    `json:"rows,omitempty"`
+	Cols    int    `json:"cols,omitempty"`
+}
+
+type termResize struct {
+	AgentID string `json:"agentID"`
+	Rows    int    `json:"rows"`
+	Cols    int    `json:"cols"`
+}
+
+type termExit struct {
+	AgentID string `json:"agentID"`
+	Code    int    `json:"code"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Terminal opens a shell in an agent's workspace with the given initial size.
+func (c *Client) Terminal(agentID string, rows, cols int) (*Terminal, error) {
+	conn, err := c.dialWS()
+	if err != nil {
+		return nil, err
+	}
+	t := &Terminal{conn: conn, agentID: agentID}
+	sub := termSubscribe{Channel: "term:" + agentID, Rows: rows, Cols: cols}
+	if err := t.send("subscribe", sub); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Write sends input to the shell.
+func (t *Terminal) Write(p []byte) (int, error) {
+	frame := append(append([]byte(t.agentID), 0), p...)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize changes the terminal size.
+func (t *Terminal) Resize(rows, cols int) error {
+	return t.send("term.resize", termResize{AgentID: t.agentID, Rows: rows, Cols: cols})
+}
+
+// Output copies the shell's output to w until the session ends and returns
+// the shell's exit code.
+func (t *Terminal) Output(w io.Writer) (int, error) {
+	for {
+		msgType, msg, err := t.conn.ReadMessage()
+		if err != nil {
+			return -1, fmt.Errorf("terminal connection: %w", err)
+		}
+		if msgType == websocket.BinaryMessage {
+			agentID, data, ok := bytes.Cut(msg, []byte{0})
+			if ok && string(agentID) == t.agentID {
+				w.Write(data)
+			}
+			continue
+		}
+
+		// Text messages may be batched, one envelope per line
+		for _, line := range bytes.Split(msg, []byte("\n")) {
+			var env wsEnvelope
+			var exit termExit
+			if json.Unmarshal(line, &env) != nil || env.Type != "term.exit" {
+				continue
+			}
+			if json.Unmarshal(env.Payload, &exit) != nil || exit.AgentID != t.agentID {
+				continue
+			}
+			if exit.Error != "" {
+				return exit.Code, errors.New(exit.Error)
+			}
+			return exit.Code, nil
+		}
+	}
+}
+
+// Close ends the session.
+func (t *Terminal) Close() error {
+	t.mu.Lock()
+	t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	t.mu.Unlock()
+	return t.conn.Close()
+}
+
+// dialWS connects to agentd's WebSocket hub, sending the API token that
+// terminals and commands need.
+func (c *Client) dialWS() (*websocket.Conn, error) {
+	url := "ws" + strings.TrimPrefix(c.BaseURL, "http") + "/ws"
+	header := http.Header{}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			defer resp.Body.Close()
+			return nil, responseError(resp)
+		}
+		return nil, fmt.Errorf("connecting to %s: %w", url, err)
+	}
+	return conn, nil
+}
+
+func (t *Terminal) send(msgType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn.WriteJSON(wsEnvelope{Type: msgType, Payload: data})
+}
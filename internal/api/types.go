@@ -4,40 +4,153 @@ import "time"
 
 // DispatchRequest is sent from agentctl to agentd to start a new agent task.
 type DispatchRequest struct {
-	Project      string            `json:"project"`
-	RepoURL      string            `json:"repoURL"`
-	Issue        string            `json:"issue,omitempty"`
-	Tool         string            `json:"tool"`       // claude-code, opencode, amp, cline
-	Prompt       string            `json:"prompt"`
-	Branch       string            `json:"branch,omitempty"`
-	MaxTime      int               `json:"maxTime,omitempty"`    // minutes
-	MaxTokens    int               `json:"maxTokens,omitempty"`
-	EnvVars      map[string]string `json:"envVars,omitempty"`
-	ServeCommand string            `json:"serveCommand,omitempty"`
-	ServePort    int               `json:"servePort,omitempty"`
+	Project            string            `json:"project"`
+	RepoURL            string            `json:"repoURL"`
+	Issue              string            `json:"issue,omitempty"`
+	Tool               string            `json:"tool,omitempty"` // claude-code, opencode, amp, cline (default from .agentvm.yaml)
+	Prompt             string            `json:"prompt"`
+	Branch             string            `json:"branch,omitempty"`
+	MaxTime            int               `json:"maxTime,omitempty"` // minutes
+	MaxTokens          int               `json:"maxTokens,omitempty"`
+	EnvVars            map[string]string `json:"envVars,omitempty"`
+	ServeCommand       string            `json:"serveCommand,omitempty"`
+	ServePort          int               `json:"servePort,omitempty"`
+	ServeServices      []ServeService    `json:"serveServices,omitempty"`
+	ServeTCP           []ServeTCPService `json:"serveTCP,omitempty"`
+	ServeHealth        *HealthCheck      `json:"serveHealth,omitempty"`
+	ServeRestart       string            `json:"serveRestart,omitempty"` // never (default), on-failure or always
+	ServeMaxRestarts   int               `json:"serveMaxRestarts,omitempty"`
+	ServeAlias         string            `json:"serveAlias,omitempty"` // stable preview name, or "branch"
+	SetupCommands      []string          `json:"setupCommands,omitempty"`
+	SetupTimeout       int               `json:"setupTimeout,omitempty"` // minutes
+	Devcontainer       bool              `json:"devcontainer,omitempty"`
+	ProtectedPaths     []string          `json:"protectedPaths,omitempty"`
+	ProtectPolicy      string            `json:"protectPolicy,omitempty"`      // revert (default) or fail
+	SecretScan         string            `json:"secretScan,omitempty"`         // block (default), warn or off
+	CheckpointInterval int               `json:"checkpointInterval,omitempty"` // minutes
+	CommitMessage      string            `json:"commitMessage,omitempty"`      // text/template
+	AuthorName         string            `json:"authorName,omitempty"`
+	AuthorEmail        string            `json:"authorEmail,omitempty"`
+	Access             string            `json:"access,omitempty"` // preview protection: none, basic or token (default from config)
+}
+
+// PreviewAccess holds the credentials protecting an agent's previews.
+type PreviewAccess struct {
+	Mode     string `json:"mode"` // basic or token
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+	URL      string `json:"url,omitempty"` // primary preview URL that logs a browser in (token mode)
+}
+
+// ServeService is one named port exposed by the serve command.
+type ServeService struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	Path string `json:"path,omitempty"`
+}
+
+// ServeTCPService is a non-HTTP port routed by SNI (TLS) or a host port.
+type ServeTCPService struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	TLS  bool   `json:"tls,omitempty"`
+}
+
+// TCPEndpoint is where a TCP service of an agent is reachable from the host.
+type TCPEndpoint struct {
+	Name    string `json:"name"`
+	Address string `json:"address"` // host:port
+	TLS     bool   `json:"tls,omitempty"`
+}
+
+// HealthCheck configures HTTP readiness and liveness probes for the served
+// app. Durations are in seconds.
+type HealthCheck struct {
+	Path             string `json:"path"`
+	Status           int    `json:"status,omitempty"` // expected status; 0 accepts any 2xx or 3xx
+	Interval         int    `json:"interval,omitempty"`
+	Timeout          int    `json:"timeout,omitempty"`
+	FailureThreshold int    `json:"failureThreshold,omitempty"`
+	StartTimeout     int    `json:"startTimeout,omitempty"`
 }
 
 // DispatchResponse is returned after a successful dispatch.
 type DispatchResponse struct {
-	AgentID  string `json:"agentID"`
-	VMName   string `json:"vmName"`
-	VMIP     string `json:"vmIP"`
-	Subdomain string `json:"subdomain"`
+	AgentID   string         `json:"agentID"`
+	VMName    string         `json:"vmName"`
+	VMIP      string         `json:"vmIP"`
+	Subdomain string         `json:"subdomain"`
+	URLs      []string       `json:"urls,omitempty"` // preview URLs, one per serve service
+	Access    *PreviewAccess `json:"access,omitempty"`
 }
 
 // AgentStatus represents the current state of an agent.
 type AgentStatus struct {
-	AgentID   string        `json:"agentID"`
-	VMName    string        `json:"vmName"`
-	VMIP      string        `json:"vmIP"`
-	Project   string        `json:"project"`
-	Tool      string        `json:"tool"`
-	Branch    string        `json:"branch,omitempty"`
-	Issue     string        `json:"issue,omitempty"`
-	State     string        `json:"state"` // running, completed, failed, killed
-	StartedAt time.Time     `json:"startedAt"`
-	Elapsed   time.Duration `json:"elapsed"`
-	Subdomain string        `json:"subdomain"`
+	AgentID   string          `json:"agentID"`
+	VMName    string          `json:"vmName"`
+	VMIP      string          `json:"vmIP"`
+	Project   string          `json:"project"`
+	Tool      string          `json:"tool"`
+	Branch    string          `json:"branch,omitempty"`
+	Issue     string          `json:"issue,omitempty"`
+	State     string          `json:"state"` // running, completed, failed, killed
+	StartedAt time.Time       `json:"startedAt"`
+	Elapsed   time.Duration   `json:"elapsed"`
+	Subdomain string          `json:"subdomain"`
+	URLs      []string        `json:"urls,omitempty"`
+	Restarts  int             `json:"restarts,omitempty"` // serve process restarts
+	Access    *PreviewAccess  `json:"access,omitempty"`
+	TCP       []TCPEndpoint   `json:"tcp,omitempty"`
+	Listening []ListeningPort `json:"listening,omitempty"` // discovered ports not yet routed
+	AliasURL  string          `json:"aliasURL,omitempty"`  // stable alias this agent currently owns
+}
+
+// ListeningPort is a TCP port discovered in an agent's VM that can be
+// exposed with POST /agents/{id}/expose.
+type ListeningPort struct {
+	Port     int    `json:"port"`
+	Process  string `json:"process,omitempty"`
+	Loopback bool   `json:"loopback,omitempty"` // bound to localhost in the VM, so not reachable when exposed
+}
+
+// ExposeRequest routes a port of a running agent.
+type ExposeRequest struct {
+	Port int    `json:"port"`
+	Name string `json:"name,omitempty"` // subdomain label, default port-<port>
+}
+
+// ExposeResponse is the exposed port's preview URL.
+type ExposeResponse struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// FileEntry is one entry of a workspace directory listing.
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"` // file, directory, symlink or other
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"` // permission bits in octal, e.g. "0644"
+	ModTime time.Time `json:"modTime"`
+	Target  string    `json:"target,omitempty"` // symlink target
+}
+
+// FileInfo describes a workspace path: a directory with its entries, or a
+// file with a preview of its content. Full contents are downloaded from
+// /agents/{id}/files/content.
+type FileInfo struct {
+	FileEntry
+	Path      string      `json:"path"`
+	Entries   []FileEntry `json:"entries,omitempty"`
+	Content   string      `json:"content,omitempty"`   // first 100KB of a file
+	Truncated bool        `json:"truncated,omitempty"` // the file is larger than Content
+}
+
+// UploadResponse reports a file written into a workspace.
+type UploadResponse struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
 }
 
 // PoolStatus reports pool state.
@@ -52,13 +165,34 @@ type PoolStatus struct {
 type HarnessStatusReport struct {
 	AgentID    string `json:"agentID"`
 	VMName     string `json:"vmName"`
-	State      string `json:"state"` // starting, cloning, executing, pushing, completed, failed
+	State      string `json:"state"` // starting, cloning, setup, executing, pushing, serving, unhealthy, restarting, completed, no_changes, failed, policy_violation
 	Message    string `json:"message,omitempty"`
 	Branch     string `json:"branch,omitempty"`
 	ExitCode   int    `json:"exitCode,omitempty"`
 	Error      string `json:"error,omitempty"`
 }
 
+// RouteReport lists the routing drift found (and, unless a dry run, fixed)
+// by a reconciliation pass.
+type RouteReport struct {
+	Orphaned  []string `json:"orphaned,omitempty"`  // routes without a live agent
+	Stale     []string `json:"stale,omitempty"`     // registrations whose VM was released
+	Rewritten []string `json:"rewritten,omitempty"` // routes that differed from the registry
+	Moved     []string `json:"moved,omitempty"`     // agents whose VM IP changed
+	Missing   []string `json:"missing,omitempty"`   // registered agents without a route
+	Aliases   []string `json:"aliases,omitempty"`   // alias hosts moved to their owner or removed
+}
+
+// Alias is a stable preview name and the agent currently owning it.
+type Alias struct {
+	Alias   string   `json:"alias"`
+	Project string   `json:"project"`
+	URL     string   `json:"url"`
+	AgentID string   `json:"agentID"`
+	Branch  string   `json:"branch,omitempty"`
+	Claims  []string `json:"claims,omitempty"` // other agents claiming it, newest first
+}
+
 // ErrorResponse is a standard error response.
 type ErrorResponse struct {
 	Error string `json:"error"`
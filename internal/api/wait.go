@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Outcomes of a finished agent task.
+const (
+	OutcomeCompleted = "completed"
+	OutcomeFailed    = "failed"
+	OutcomeTimeout   = "timeout"
+	OutcomeKilled    = "killed"
+)
+
+// Outcome maps an agent state and message to the task's outcome, or "" while
+// the task is still in progress. A served app counts as completed once it is
+// ready ("serving"); an unhealthy one may still recover or be restarted, and
+// is reported failed if it can't be. Agents that hit their max time fail
+// with a "Timed out" message.
+func Outcome(state, message string) string {
+	switch state {
+	case "completed", "no_changes", "serving":
+		return OutcomeCompleted
+	case "failed":
+		if strings.HasPrefix(message, "Timed out") {
+			return OutcomeTimeout
+		}
+		return OutcomeFailed
+	case "policy_violation":
+		return OutcomeFailed
+	case "killed":
+		return OutcomeKilled
+	}
+	return ""
+}
+
+// WaitOptions controls Wait.
+type WaitOptions struct {
+	States   []string         // also stop at these states, besides finished ones
+	Any      bool             // return once any agent is done, not all of them
+	OnUpdate func(AgentState) // called for every state change
+}
+
+// AgentState is an agent's state as last seen by Wait.
+type AgentState struct {
+	AgentID string
+	State   string
+	Message string
+	Branch  string
+	Done    bool   // reached one of WaitOptions.States or finished
+	Outcome string // set once finished
+}
+
+// Wait follows agentd's status channel until the agents are done, or ctx
+// ends, and returns their last states in order. Agents that disappear are
+// reported as killed. Dropped connections are retried until ctx ends.
+func (c *Client) Wait(ctx context.Context, agentIDs []string, opts WaitOptions) ([]AgentState, error) {
+	w := &waiter{opts: opts, agents: make(map[string]*AgentState)}
+	for _, id := range agentIDs {
+		if _, ok := w.agents[id]; !ok {
+			w.ids = append(w.ids, id)
+			w.agents[id] = &AgentState{AgentID: id}
+		}
+	}
+
+	for {
+		err := c.watchStatus(ctx, w.handle)
+		if err == nil {
+			return w.states(), nil
+		}
+		if ctx.Err() != nil {
+			return w.states(), ctx.Err()
+		}
+		if !w.synced {
+			return nil, err
+		}
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return w.states(), ctx.Err()
+		}
+	}
+}
+
+// watchStatus subscribes to the status channel and passes each message to
+// handle until it reports being finished.
+func (c *Client) watchStatus(ctx context.Context, handle func(wsEnvelope) (bool, error)) error {
+	conn, err := c.dialWS()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sub, _ := json.Marshal(map[string]string{"channel": "status"})
+	if err := conn.WriteJSON(wsEnvelope{Type: "subscribe", Payload: sub}); err != nil {
+		return err
+	}
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("status connection: %w", err)
+		}
+		// Messages may be batched, one envelope per line
+		for _, line := range bytes.Split(msg, []byte("\n")) {
+			var env wsEnvelope
+			if json.Unmarshal(line, &env) != nil {
+				continue
+			}
+			done, err := handle(env)
+			if err != nil || done {
+				return err
+			}
+		}
+	}
+}
+
+// statusAgent holds the fields Wait needs from the hub's agent snapshots and
+// status updates.
+type statusAgent struct {
+	AgentID string `json:"agentID"`
+	State   string `json:"state"`
+	Message string `json:"message,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+}
+
+type waiter struct {
+	opts   WaitOptions
+	ids    []string
+	agents map[string]*AgentState
+	synced bool // a status snapshot has been seen
+}
+
+func (w *waiter) handle(env wsEnvelope) (bool, error) {
+	switch env.Type {
+	case "status.snapshot":
+		var snap struct {
+			Agents []statusAgent `json:"agents"`
+		}
+		if json.Unmarshal(env.Payload, &snap) != nil {
+			return false, nil
+		}
+		seen := make(map[string]statusAgent)
+		for _, a := range snap.Agents {
+			seen[a.AgentID] = a
+		}
+		for _, id := range w.ids {
+			a, ok := seen[id]
+			switch {
+			case ok:
+				w.update(a)
+			case !w.synced:
+				return false, fmt.Errorf("agent %s not found", id)
+			default:
+				w.update(statusAgent{AgentID: id, State: "killed", Message: "agent is gone"})
+			}
+		}
+		w.synced = true
+
+	case "status.update":
+		var a statusAgent
+		if json.Unmarshal(env.Payload, &a) == nil {
+			w.update(a)
+		}
+
+	case "agent.registered":
+		var ev struct {
+			Agent *statusAgent `json:"agent"`
+		}
+		if json.Unmarshal(env.Payload, &ev) == nil && ev.Agent != nil {
+			w.update(*ev.Agent)
+		}
+
+	case "agent.deregistered":
+		var ev struct {
+			AgentID string `json:"agentID"`
+		}
+		if json.Unmarshal(env.Payload, &ev) == nil {
+			w.update(statusAgent{AgentID: ev.AgentID, State: "killed", Message: "agent was deregistered"})
+		}
+	}
+	return w.synced && w.finished(), nil
+}
+
+// update records a state change for a watched agent that isn't done yet.
+func (w *waiter) update(a statusAgent) {
+	s, ok := w.agents[a.AgentID]
+	if !ok || s.Done || (s.State == a.State && s.Message == a.Message) {
+		return
+	}
+	s.State, s.Message = a.State, a.Message
+	if a.Branch != "" {
+		s.Branch = a.Branch
+	}
+	s.Outcome = Outcome(a.State, a.Message)
+	s.Done = s.Outcome != "" || slices.Contains(w.opts.States, a.State)
+	if w.opts.OnUpdate != nil {
+		w.opts.OnUpdate(*s)
+	}
+}
+
+func (w *waiter) finished() bool {
+	for _, id := range w.ids {
+		done := w.agents[id].Done
+		if w.opts.Any && done {
+			return true
+		}
+		if !w.opts.Any && !done {
+			return false
+		}
+	}
+	return !w.opts.Any
+}
+
+func (w *waiter) states() []AgentState {
+	states := make([]AgentState, 0, len(w.ids))
+	for _, id := range w.ids {
+		states = append(states, *w.agents[id])
+	}
+	return states
+}
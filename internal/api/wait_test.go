@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/harness"
+	"github.com/mateo/agentvm/internal/lima"
+	"github.com/mateo/agentvm/internal/pool"
+	"github.com/mateo/agentvm/internal/registry"
+	"github.com/mateo/agentvm/internal/ws"
+)
+
+// waitEnv runs the registry server harnesses report to and agentd's WebSocket
+// hub for agents dispatched to active slots.
+type waitEnv struct {
+	store    *registry.Store
+	reporter *harness.Reporter
+	client   *Client
+}
+
+func newWaitEnv(t *testing.T, agentIDs ...string) *waitEnv {
+	baseDir := t.TempDir()
+	var state pool.PersistentState
+	for _, id := range agentIDs {
+		state.Slots = append(state.Slots, pool.VMSlot{Name: "vm-" + id, State: pool.SlotActive, AgentID: id, Project: "shop"})
+	}
+	data, _ := json.Marshal(state)
+	os.WriteFile(filepath.Join(baseDir, "pool-state.json"), data, 0644)
+	mock := lima.NewMockClient()
+	poolMgr, err := pool.NewManager(pool.PoolConfig{}, mock, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := registry.NewStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As the orchestrator does at dispatch
+	for _, id := range agentIDs {
+		store.Register(&registry.AgentRegistration{AgentID: id, VMName: "vm-" + id, Project: "shop", State: "dispatched"})
+	}
+
+	regSrv := httptest.NewServer(registry.NewServer(store, nil).Handler())
+	t.Cleanup(regSrv.Close)
+
+	hub := ws.NewHub(store, poolMgr, mock, nil, nil, nil)
+	go hub.Run()
+	t.Cleanup(hub.Stop)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", hub.ServeWS)
+	apiSrv := httptest.NewServer(mux)
+	t.Cleanup(apiSrv.Close)
+
+	return &waitEnv{
+		store:    store,
+		reporter: harness.NewReporter(regSrv.URL),
+		client:   &Client{BaseURL: apiSrv.URL, HTTPClient: apiSrv.Client()},
+	}
+}
+
+// wait runs Client.Wait in the background, returning once it has seen the
+// agents' current states, and a func collecting its result.
+func (e *waitEnv) wait(t *testing.T, ctx context.Context, agentIDs []string, opts WaitOptions) func() ([]AgentState, error) {
+	synced := make(chan struct{}, len(agentIDs))
+	opts.OnUpdate = func(AgentState) {
+		select {
+		case synced <- struct{}{}:
+		default:
+		}
+	}
+	type result struct {
+		states []AgentState
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		states, err := e.client.Wait(ctx, agentIDs, opts)
+		done <- result{states, err}
+	}()
+	for range agentIDs {
+		select {
+		case <-synced:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Wait never saw the agents")
+		}
+	}
+	return func() ([]AgentState, error) {
+		select {
+		case r := <-done:
+			return r.states, r.err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Wait did not return")
+			return nil, nil
+		}
+	}
+}
+
+func TestClient_Wait(t *testing.T) {
+	env := newWaitEnv(t, "a1", "a2", "a3")
+	result := env.wait(t, context.Background(), []string{"a1", "a2", "a3"}, WaitOptions{})
+
+	// a1 doesn't serve anything, a2 hits its max time, a3 is killed
+	env.reporter.Report("a1", "executing", "Running claude-code", "agent/a1")
+	env.reporter.Report("a2", "failed", "Timed out after 30 minutes", "agent/a2")
+	env.reporter.Report("a1", "completed", "Exit code: 0, Duration: 1m0s", "agent/a1")
+	env.store.Deregister("a3")
+
+	states, err := result()
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	got := make([]string, 0, len(states))
+	for _, s := range states {
+		got = append(got, s.AgentID+":"+s.State+":"+s.Outcome)
+	}
+	want := "a1:completed:completed a2:failed:timeout a3:killed:killed"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+	if states[0].Branch != "agent/a1" {
+		t.Errorf("branch = %q", states[0].Branch)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		state, message, want string
+	}{
+		{"starting", "Starting serve: npm start", ""},
+		{"serving", "Serving on port 3000", OutcomeCompleted},
+		{"unhealthy", "Health check failing", ""},
+		{"restarting", "Serve process exit status 1, restarting in 1s", ""},
+		{"failed", "App never became healthy", OutcomeFailed},
+		{"failed", "Timed out after 30 minutes", OutcomeTimeout},
+		{"policy_violation", "Push blocked", OutcomeFailed},
+	}
+	for _, tt := range tests {
+		if got := Outcome(tt.state, tt.message); got != tt.want {
+			t.Errorf("Outcome(%q, %q) = %q, want %q", tt.state, tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestClient_WaitServe(t *testing.T) {
+	env := newWaitEnv(t, "a1")
+	result := env.wait(t, context.Background(), []string{"a1"}, WaitOptions{})
+
+	// Starting doesn't end the wait; readiness does
+	env.reporter.Report("a1", "starting", "Starting serve: npm start", "")
+	env.reporter.Report("a1", "serving", "Serving on port 3000", "")
+
+	states, err := result()
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if states[0].State != "serving" || states[0].Outcome != OutcomeCompleted {
+		t.Errorf("unexpected state %+v", states[0])
+	}
+
+	// Nor does an unhealthy spell, until the harness gives up
+	env = newWaitEnv(t, "a2")
+	result = env.wait(t, context.Background(), []string{"a2"}, WaitOptions{})
+	env.reporter.Report("a2", "unhealthy", "Health check failing", "")
+	env.reporter.Report("a2", "restarting", "Restarting", "")
+	env.reporter.Report("a2", "failed", "App never became healthy", "")
+
+	states, err = result()
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if states[0].State != "failed" || states[0].Outcome != OutcomeFailed {
+		t.Errorf("expected to wait past unhealthy until failed, got %+v", states[0])
+	}
+}
+
+func TestClient_WaitAnyAndStates(t *testing.T) {
+	env := newWaitEnv(t, "a1", "a2")
+	result := env.wait(t, context.Background(), []string{"a1", "a2"}, WaitOptions{Any: true, States: []string{"executing"}})
+
+	env.reporter.Report("a2", "cloning", "Cloning repo", "")
+	env.reporter.Report("a2", "executing", "Running claude-code", "")
+
+	states, err := result()
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if states[0].Done || !states[1].Done || states[1].State != "executing" || states[1].Outcome != "" {
+		t.Errorf("unexpected states %+v", states)
+	}
+}
+
+func TestClient_WaitErrors(t *testing.T) {
+	env := newWaitEnv(t, "a1")
+
+	_, err := env.client.Wait(context.Background(), []string{"a9"}, WaitOptions{})
+	if err == nil || !strings.Contains(err.Error(), "a9 not found") {
+		t.Errorf("expected unknown agent error, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	states, err := env.wait(t, ctx, []string{"a1"}, WaitOptions{})()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline, got %v", err)
+	}
+	if len(states) != 1 || states[0].Done || states[0].State != "dispatched" {
+		t.Errorf("unexpected states %+v", states)
+	}
+}
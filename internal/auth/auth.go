@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// API scopes. Most of the API is open to anyone who can reach agentd;
+// endpoints that run arbitrary commands in agents' VMs need ScopeOperator.
+const (
+	ScopeOperator = "operator"
+)
+
+// ValidScope reports whether s is a known scope.
+func ValidScope(s string) bool {
+	return s == ScopeOperator
+}
+
+// Token is an API bearer token and the scopes it grants.
+type Token struct {
+	Name   string
+	Value  string
+	Scopes []string
+}
+
+// HasScope reports whether the token grants scope.
+func (t Token) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// Authorizer checks bearer tokens on agentd API requests.
+type Authorizer struct {
+	tokens []Token
+}
+
+// New returns an authorizer accepting tokens plus the local operator token
+// stored at operatorFile, which is generated (readable only by the owner)
+// if missing so local agentctl invocations hold operator scope.
+func New(operatorFile string, tokens []Token) (*Authorizer, error) {
+	for _, t := range tokens {
+		if t.Value == "" {
+			return nil, fmt.Errorf("API token %q is empty", t.Name)
+		}
+		for _, s := range t.Scopes {
+			if !ValidScope(s) {
+				return nil, fmt.Errorf("API token %q: unknown scope %q", t.Name, s)
+			}
+		}
+	}
+	operator, err := loadOrCreate(operatorFile)
+	if err != nil {
+		return nil, err
+	}
+	all := append([]Token{{Name: "local operator", Value: operator, Scopes: []string{ScopeOperator}}}, tokens...)
+	return &Authorizer{tokens: all}, nil
+}
+
+// Caller returns the token presented by r, if any is valid.
+func (a *Authorizer) Caller(r *http.Request) (Token, bool) {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || bearer == "" {
+		return Token{}, false
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(t.Value)) == 1 {
+			return t, true
+		}
+	}
+	return Token{}, false
+}
+
+// Require wraps h to refuse callers without scope: 401 without a valid
+// token, 403 when the token lacks the scope.
+func (a *Authorizer) Require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := a.Caller(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="agentd"`)
+			writeError(w, http.StatusUnauthorized, scope+" scope required: no valid API token")
+			return
+		}
+		if !t.HasScope(scope) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("API token %q lacks %s scope", t.Name, scope))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func loadOrCreate(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("reading operator token: %w", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating operator token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("writing operator token: %w", err)
+	}
+	return token, nil
+}
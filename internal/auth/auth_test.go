@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew_OperatorToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operator-token")
+	if _, err := New(path, nil); err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("operator token not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+	first, _ := os.ReadFile(path)
+
+	// Reused across restarts
+	if _, err := New(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := os.ReadFile(path)
+	if string(first) != string(second) {
+		t.Error("operator token changed on restart")
+	}
+}
+
+func TestNew_RejectsBadTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operator-token")
+	if _, err := New(path, []Token{{Name: "ci", Scopes: []string{ScopeOperator}}}); err == nil {
+		t.Error("expected error for empty token")
+	}
+	if _, err := New(path, []Token{{Name: "ci", Value: "x", Scopes: []string{"admin"}}}); err == nil {
+		t.Error("expected error for unknown scope")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operator-token")
+	a, err := New(path, []Token{
+		{Name: "remote", Value: "remote-secret", Scopes: []string{ScopeOperator}},
+		{Name: "viewer", Value: "viewer-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	operator, _ := os.ReadFile(path)
+	h := a.Require(ScopeOperator, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"viewer-secret", http.StatusForbidden},
+		{"remote-secret", http.StatusOK},
+		{strings.TrimSpace(string(operator)), http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/agents/a1/exec", nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("token %q: got %d, want %d", tc.token, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+// Package certs is agentvm's local certificate authority. It issues wildcard
+// certificates for preview hostnames so browsers accept every
+// <agent>.<project>.<domain> once the CA root is trusted.
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 90 * 24 * time.Hour
+	// RenewBefore is how long before expiry a certificate is reissued.
+	RenewBefore = 30 * 24 * time.Hour
+)
+
+// Authority holds the CA key and the certificates it issued, one wildcard
+// certificate per host (covering host and *.host).
+type Authority struct {
+	dir    string
+	domain string
+
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	issued map[string]*leaf
+	policy func(host string) bool // hosts GetCertificate may issue for
+}
+
+type leaf struct {
+	certFile string
+	keyFile  string
+	notAfter time.Time
+	tls      *tls.Certificate // loaded on first TLS handshake
+}
+
+// Load opens the CA under dir, creating it on first use. The CA is name
+// constrained to domain, so trusting it cannot vouch for other sites.
+func Load(dir, domain string) (*Authority, error) {
+	a := &Authority{
+		dir:    dir,
+		domain: strings.ToLower(strings.TrimSuffix(domain, ".")),
+		issued: make(map[string]*leaf),
+	}
+	if err := os.MkdirAll(a.issuedDir(), 0755); err != nil {
+		return nil, fmt.Errorf("creating certs dir: %w", err)
+	}
+
+	if _, err := os.Stat(a.CAFile()); os.IsNotExist(err) {
+		if err := a.createCA(); err != nil {
+			return nil, fmt.Errorf("creating CA: %w", err)
+		}
+	} else if err := a.loadCA(); err != nil {
+		return nil, err
+	}
+	if err := a.loadIssued(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// CAFile is the PEM root to install in trust stores.
+func (a *Authority) CAFile() string { return filepath.Join(a.dir, "ca.pem") }
+
+func (a *Authority) caKeyFile() string { return filepath.Join(a.dir, "ca-key.pem") }
+
+func (a *Authority) issuedDir() string { return filepath.Join(a.dir, "issued") }
+
+func (a *Authority) createCA() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{Organization: []string{"agentvm"}, CommonName: "agentvm local CA " + host},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		PermittedDNSDomains:   []string{a.domain},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	if err := writeKey(a.caKeyFile(), key); err != nil {
+		return err
+	}
+	if err := writeCert(a.CAFile(), der); err != nil {
+		return err
+	}
+	a.caCert, err = x509.ParseCertificate(der)
+	a.caKey = key
+	return err
+}
+
+func (a *Authority) loadCA() error {
+	cert, err := readCert(a.CAFile())
+	if err != nil {
+		return fmt.Errorf("reading CA: %w", err)
+	}
+	key, err := readKey(a.caKeyFile())
+	if err != nil {
+		return fmt.Errorf("reading CA key: %w", err)
+	}
+	if !permits(cert, a.domain) {
+		return fmt.Errorf("CA %s is constrained to %v, not %s; remove %s to create a new one", a.CAFile(), cert.PermittedDNSDomains, a.domain, a.dir)
+	}
+	a.caCert, a.caKey = cert, key
+	return nil
+}
+
+func permits(ca *x509.Certificate, domain string) bool {
+	if len(ca.PermittedDNSDomains) == 0 {
+		return true
+	}
+	for _, d := range ca.PermittedDNSDomains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadIssued indexes issued certificates, keeping the newest per host and
+// removing superseded ones.
+func (a *Authority) loadIssued() error {
+	certs, err := filepath.Glob(filepath.Join(a.issuedDir(), "*-cert.pem"))
+	if err != nil {
+		return err
+	}
+	for _, certFile := range certs {
+		cert, err := readCert(certFile)
+		if err != nil {
+			log.Printf("certs: skipping %s: %v", certFile, err)
+			continue
+		}
+		host := cert.Subject.CommonName
+		l := &leaf{
+			certFile: certFile,
+			keyFile:  strings.TrimSuffix(certFile, "-cert.pem") + "-key.pem",
+			notAfter: cert.NotAfter,
+		}
+		if old, ok := a.issued[host]; ok {
+			if old.notAfter.After(l.notAfter) {
+				removeLeaf(l)
+				continue
+			}
+			removeLeaf(old)
+		}
+		a.issued[host] = l
+	}
+	return nil
+}
+
+// Ensure makes sure host has a valid certificate for host and *.host,
+// issuing or renewing it as needed. It reports whether a new certificate
+// was written.
+func (a *Authority) Ensure(host string) (bool, error) {
+	host = strings.ToLower(host)
+	if host != a.domain && !strings.HasSuffix(host, "."+a.domain) {
+		return false, fmt.Errorf("%s is outside %s", host, a.domain)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if l, ok := a.issued[host]; ok && time.Until(l.notAfter) > RenewBefore {
+		return false, nil
+	}
+	return true, a.issueLocked(host)
+}
+
+func (a *Authority) issueLocked(host string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{Organization: []string{"agentvm"}, CommonName: host},
+		DNSNames:     []string{host, "*." + host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.caCert, &key.PublicKey, a.caKey)
+	if err != nil {
+		return fmt.Errorf("issuing certificate for %s: %w", host, err)
+	}
+
+	// A new file name per certificate makes routes referencing it change,
+	// which is what gets Traefik to load the renewed certificate.
+	base := filepath.Join(a.issuedDir(), host+"-"+tmpl.SerialNumber.Text(36))
+	l := &leaf{certFile: base + "-cert.pem", keyFile: base + "-key.pem", notAfter: tmpl.NotAfter}
+	if err := writeKey(l.keyFile, key); err != nil {
+		return err
+	}
+	if err := writeCert(l.certFile, der); err != nil {
+		return err
+	}
+	if old, ok := a.issued[host]; ok && old.certFile != l.certFile {
+		removeLeaf(old)
+	}
+	a.issued[host] = l
+	log.Printf("certs: issued *.%s (expires %s)", host, tmpl.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+// Files returns the certificate and key paths for host, if issued.
+func (a *Authority) Files(host string) (certFile, keyFile string, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l, ok := a.issued[strings.ToLower(host)]
+	if !ok {
+		return "", "", false
+	}
+	return l.certFile, l.keyFile, true
+}
+
+// Hosts lists the hosts with issued certificates.
+func (a *Authority) Hosts() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	hosts := make([]string, 0, len(a.issued))
+	for h := range a.issued {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// SetHostPolicy limits on-demand issuance by GetCertificate to the hosts
+// policy accepts, so arbitrary names under the domain can't fill the disk.
+func (a *Authority) SetHostPolicy(policy func(host string) bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy = policy
+}
+
+// GetCertificate serves TLS handshakes for the built-in proxy, issuing the
+// wildcard certificate of the requested name's parent on demand.
+func (a *Authority) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	_, host, ok := strings.Cut(name, ".")
+	if !ok || (host != a.domain && !strings.HasSuffix(host, "."+a.domain)) {
+		return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+	}
+	a.mu.Lock()
+	policy := a.policy
+	a.mu.Unlock()
+	if policy != nil && !policy(host) {
+		return nil, fmt.Errorf("no certificate for %q: unknown host", hello.ServerName)
+	}
+	if _, err := a.Ensure(host); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l := a.issued[host]
+	if l.tls == nil {
+		cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		l.tls = &cert
+	}
+	return l.tls, nil
+}
+
+// Prune removes the certificates of hosts keep rejects and returns those
+// hosts.
+func (a *Authority) Prune(keep func(host string) bool) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var pruned []string
+	for host, l := range a.issued {
+		if keep(host) {
+			continue
+		}
+		removeLeaf(l)
+		delete(a.issued, host)
+		pruned = append(pruned, host)
+	}
+	sort.Strings(pruned)
+	return pruned
+}
+
+// Renew reissues every certificate close to expiry and returns their hosts.
+func (a *Authority) Renew() ([]string, error) {
+	var renewed []string
+	for _, host := range a.Hosts() {
+		changed, err := a.Ensure(host)
+		if err != nil {
+			return renewed, err
+		}
+		if changed {
+			renewed = append(renewed, host)
+		}
+	}
+	return renewed, nil
+}
+
+// Start checks for expiring certificates every interval, calling onRenew
+// with the hosts that got new certificates.
+func (a *Authority) Start(ctx context.Context, interval time.Duration, onRenew func(hosts []string)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			renewed, err := a.Renew()
+			if err != nil {
+				log.Printf("certs: renewal failed: %v", err)
+			}
+			if len(renewed) > 0 && onRenew != nil {
+				onRenew(renewed)
+			}
+		}
+	}()
+}
+
+func removeLeaf(l *leaf) {
+	os.Remove(l.certFile)
+	os.Remove(l.keyFile)
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+func writeCert(path string, der []byte) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func readCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func readKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no private key", path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
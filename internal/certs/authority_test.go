@@ -0,0 +1,156 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func loadTestCA(t *testing.T, dir string) *Authority {
+	t.Helper()
+	a, err := Load(dir, "agents.test")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return a
+}
+
+func verify(t *testing.T, a *Authority, certFile, name string) error {
+	t.Helper()
+	caPEM, err := os.ReadFile(a.CAFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	cert, err := readCert(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+	return err
+}
+
+func TestAuthority_Ensure(t *testing.T) {
+	dir := t.TempDir()
+	a := loadTestCA(t, dir)
+
+	changed, err := a.Ensure("myproject.agents.test")
+	if err != nil || !changed {
+		t.Fatalf("expected new certificate, got %v %v", changed, err)
+	}
+	certFile, keyFile, ok := a.Files("myproject.agents.test")
+	if !ok {
+		t.Fatal("no files for issued host")
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("issued pair invalid: %v", err)
+	}
+	for _, name := range []string{"agent-1.myproject.agents.test", "myproject.agents.test"} {
+		if err := verify(t, a, certFile, name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if err := verify(t, a, certFile, "web.agent-1.myproject.agents.test"); err == nil {
+		t.Error("project wildcard must not cover two levels")
+	}
+
+	if changed, _ := a.Ensure("myproject.agents.test"); changed {
+		t.Error("valid certificate should not be reissued")
+	}
+	if _, err := a.Ensure("example.com"); err == nil {
+		t.Error("expected error for host outside the domain")
+	}
+
+	// Reloading keeps the CA and issued certificates.
+	b := loadTestCA(t, dir)
+	if got, _, _ := b.Files("myproject.agents.test"); got != certFile {
+		t.Errorf("issued certificate not reloaded: %q", got)
+	}
+	if err := verify(t, b, certFile, "agent-1.myproject.agents.test"); err != nil {
+		t.Errorf("CA not reloaded: %v", err)
+	}
+
+	if _, err := Load(dir, "other.test"); err == nil {
+		t.Error("expected error loading a CA constrained to another domain")
+	}
+}
+
+func TestAuthority_Renew(t *testing.T) {
+	a := loadTestCA(t, t.TempDir())
+	a.Ensure("p.agents.test")
+	a.Ensure("q.agents.test")
+	old, _, _ := a.Files("p.agents.test")
+
+	a.issued["p.agents.test"].notAfter = time.Now().Add(RenewBefore / 2)
+	renewed, err := a.Renew()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renewed) != 1 || renewed[0] != "p.agents.test" {
+		t.Fatalf("expected p.agents.test renewed, got %v", renewed)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("superseded certificate should be removed")
+	}
+	files, _ := filepath.Glob(filepath.Join(a.issuedDir(), "*-cert.pem"))
+	if len(files) != 2 {
+		t.Errorf("expected 2 issued certificates on disk, got %v", files)
+	}
+}
+
+func TestAuthority_GetCertificate(t *testing.T) {
+	a := loadTestCA(t, t.TempDir())
+
+	cert, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "web.agent-1.p.agents.test"})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("web.agent-1.p.agents.test"); err != nil {
+		t.Error(err)
+	}
+	if hosts := a.Hosts(); len(hosts) != 1 || hosts[0] != "agent-1.p.agents.test" {
+		t.Errorf("expected on-demand certificate for the parent host, got %v", hosts)
+	}
+
+	for _, name := range []string{"", "agents.test", "example.com"} {
+		if _, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err == nil {
+			t.Errorf("%q: expected error", name)
+		}
+	}
+}
+
+func TestAuthority_HostPolicyAndPrune(t *testing.T) {
+	a := loadTestCA(t, t.TempDir())
+	a.SetHostPolicy(func(host string) bool { return host == "agent-1.p.agents.test" })
+
+	if _, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "web.agent-1.p.agents.test"}); err != nil {
+		t.Fatalf("GetCertificate for a known host failed: %v", err)
+	}
+	if _, err := a.GetCertificate(&tls.ClientHelloInfo{ServerName: "web.random.p.agents.test"}); err == nil {
+		t.Error("expected no certificate for an unknown host")
+	}
+	a.Ensure("agent-2.p.agents.test")
+	if hosts := a.Hosts(); len(hosts) != 2 {
+		t.Fatalf("expected 2 issued hosts, got %v", hosts)
+	}
+	certFile, _, _ := a.Files("agent-2.p.agents.test")
+
+	pruned := a.Prune(func(host string) bool { return host == "agent-1.p.agents.test" })
+	if len(pruned) != 1 || pruned[0] != "agent-2.p.agents.test" {
+		t.Errorf("unexpected pruned hosts %v", pruned)
+	}
+	if hosts := a.Hosts(); len(hosts) != 1 || hosts[0] != "agent-1.p.agents.test" {
+		t.Errorf("unexpected remaining hosts %v", hosts)
+	}
+	if _, err := os.Stat(certFile); !os.IsNotExist(err) {
+		t.Error("pruned certificate still on disk")
+	}
+}
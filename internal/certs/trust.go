@@ -0,0 +1,51 @@
+package certs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// trustStore is a system trust store: where the root is copied and the
+// command that refreshes the store afterwards.
+type trustStore struct {
+	dir    string
+	file   string
+	update []string
+}
+
+// Debian/Ubuntu, then Fedora/RHEL/Arch.
+var linuxTrustStores = []trustStore{
+	{dir: "/usr/local/share/ca-certificates", file: "agentvm.crt", update: []string{"update-ca-certificates"}},
+	{dir: "/etc/pki/ca-trust/source/anchors", file: "agentvm.pem", update: []string{"update-ca-trust", "extract"}},
+	{dir: "/etc/ca-certificates/trust-source/anchors", file: "agentvm.pem", update: []string{"trust", "extract-compat"}},
+}
+
+// TrustCommands returns the commands installing caFile as a trusted root on
+// goos, system store first. The system commands need root; the NSS one
+// (Chrome and Firefox on Linux) runs as the user and only if nssdb exists.
+func TrustCommands(goos, caFile string) [][]string {
+	switch goos {
+	case "darwin":
+		return [][]string{{"sudo", "security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", "/Library/Keychains/System.keychain", caFile}}
+	case "linux":
+		var cmds [][]string
+		for _, s := range linuxTrustStores {
+			if _, err := os.Stat(s.dir); err != nil {
+				continue
+			}
+			cmds = append(cmds,
+				[]string{"sudo", "cp", caFile, filepath.Join(s.dir, s.file)},
+				append([]string{"sudo"}, s.update...),
+			)
+			break
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			nssdb := filepath.Join(home, ".pki", "nssdb")
+			if _, err := os.Stat(nssdb); err == nil {
+				cmds = append(cmds, []string{"certutil", "-d", "sql:" + nssdb, "-A", "-t", "C,,", "-n", "agentvm local CA", "-i", caFile})
+			}
+		}
+		return cmds
+	}
+	return nil
+}
@@ -9,10 +9,12 @@ import (
 )
 
 type Config struct {
-	Pool    PoolConfig    `yaml:"pool"`
-	VM      VMConfig      `yaml:"vm"`
-	Network NetworkConfig `yaml:"network"`
-	API     APIConfig     `yaml:"api"`
+	Pool        PoolConfig        `yaml:"pool"`
+	VM          VMConfig          `yaml:"vm"`
+	Network     NetworkConfig     `yaml:"network"`
+	API         APIConfig         `yaml:"api"`
+	RepoCache   RepoCacheConfig   `yaml:"repoCache"`
+	Credentials CredentialsConfig `yaml:"credentials,omitempty"`
 }
 
 type PoolConfig struct {
@@ -33,10 +35,94 @@ type NetworkConfig struct {
 	TraefikHTTP  int    `yaml:"traefikHTTP"`
 	TraefikHTTPS int    `yaml:"traefikHTTPS"`
 	HTTPOnly     bool   `yaml:"httpOnly,omitempty"`
+	// Router selects how previews are routed: "traefik" (default) writes
+	// file-provider config for an external Traefik, "builtin" serves them
+	// from agentd's own reverse proxy on the TraefikHTTP/TraefikHTTPS ports.
+	Router string       `yaml:"router,omitempty"`
+	Access AccessConfig `yaml:"access,omitempty"`
+	DNS    DNSConfig    `yaml:"dns"`
+	TCP    TCPConfig    `yaml:"tcp"`
+}
+
+// TCPConfig is the host port range agentd forwards to agents' plain TCP
+// services (TLS ones are routed by SNI on the HTTPS port with Traefik).
+type TCPConfig struct {
+	Bind    string `yaml:"bind"`
+	PortMin int    `yaml:"portMin"`
+	PortMax int    `yaml:"portMax"`
+}
+
+// DNSConfig controls how *.<domain> resolves on the host. Provider is set up
+// by `agentctl setup`: "auto" (default), "networkmanager", "systemd-resolved",
+// "dnsmasq", "macos" or "none". With Responder set, agentd answers the domain
+// itself on Listen and the provider forwards queries there.
+type DNSConfig struct {
+	Provider  string `yaml:"provider,omitempty"`
+	Responder bool   `yaml:"responder,omitempty"`
+	Listen    string `yaml:"listen"`  // UDP address of the built-in responder
+	Address   string `yaml:"address"` // IP every preview name resolves to
+}
+
+// AccessConfig protects preview URLs: "none" (default), "basic" for HTTP
+// basic auth with generated credentials, or "token" for a generated token
+// checked by agentd. Dispatch requests may override it per agent.
+type AccessConfig struct {
+	Mode     string            `yaml:"mode,omitempty"`
+	Projects map[string]string `yaml:"projects,omitempty"` // per-project mode
+}
+
+// ModeFor returns the access mode for a project's previews.
+func (a AccessConfig) ModeFor(project string) string {
+	if m, ok := a.Projects[project]; ok {
+		return m
+	}
+	return a.Mode
 }
 
 type APIConfig struct {
-	Port int `yaml:"port"`
+	Port   int        `yaml:"port"`
+	Tokens []APIToken `yaml:"tokens,omitempty"` // besides the local operator token
+}
+
+// APIToken grants scopes to callers presenting a bearer token, e.g. a
+// remote operator. Secrets stay out of the config file.
+type APIToken struct {
+	Name      string   `yaml:"name"`
+	TokenEnv  string   `yaml:"tokenEnv,omitempty"`  // agentd env var holding the token
+	TokenFile string   `yaml:"tokenFile,omitempty"` // file holding the token
+	Scopes    []string `yaml:"scopes"`              // e.g. operator
+}
+
+// RepoCacheConfig controls the bare mirror caches kept under shared/repos.
+type RepoCacheConfig struct {
+	Enabled        bool                   `yaml:"enabled"`
+	RefreshMinutes int                    `yaml:"refreshMinutes"`
+	Projects       map[string]CloneConfig `yaml:"projects,omitempty"` // per-project clone settings
+}
+
+// CloneConfig tunes how the harness clones a project's repository.
+type CloneConfig struct {
+	Depth      int    `yaml:"depth,omitempty"`      // shallow clone depth (0 = full history)
+	Filter     string `yaml:"filter,omitempty"`     // partial clone filter, e.g. "blob:none"
+	FromMirror bool   `yaml:"fromMirror,omitempty"` // clone from the mirror instead of the remote
+}
+
+// CredentialsConfig maps git hosts to the credentials the harness uses to
+// clone and push. Secrets stay on the host and are read at dispatch.
+type CredentialsConfig struct {
+	Hosts    map[string]GitCredential            `yaml:"hosts,omitempty"`    // default per host, e.g. "github.com"
+	Projects map[string]map[string]GitCredential `yaml:"projects,omitempty"` // per-project host mapping, replaces hosts
+}
+
+// GitCredential describes one credential source.
+type GitCredential struct {
+	Type       string `yaml:"type,omitempty"`       // token (default), ssh or helper
+	Username   string `yaml:"username,omitempty"`   // HTTPS username (default "git")
+	TokenEnv   string `yaml:"tokenEnv,omitempty"`   // agentd env var holding the token
+	TokenFile  string `yaml:"tokenFile,omitempty"`  // file holding the token
+	KeyFile    string `yaml:"keyFile,omitempty"`    // SSH deploy key
+	KnownHosts string `yaml:"knownHosts,omitempty"` // known_hosts lines; empty accepts the key on first use
+	Helper     string `yaml:"helper,omitempty"`     // git credential helper, run inside the VM
 }
 
 func Default() Config {
@@ -56,10 +142,25 @@ func Default() Config {
 			RegistryPort: 8090,
 			TraefikHTTP:  80,
 			TraefikHTTPS: 443,
+			Router:       "traefik",
+			DNS: DNSConfig{
+				Provider: "auto",
+				Listen:   "127.0.0.1:5353",
+				Address:  "127.0.0.1",
+			},
+			TCP: TCPConfig{
+				Bind:    "127.0.0.1",
+				PortMin: 15000,
+				PortMax: 15999,
+			},
 		},
 		API: APIConfig{
 			Port: 8091,
 		},
+		RepoCache: RepoCacheConfig{
+			Enabled:        true,
+			RefreshMinutes: 15,
+		},
 	}
 }
 
@@ -68,6 +169,11 @@ func BaseDir() string {
 	return filepath.Join(home, ".agentvm")
 }
 
+// OperatorTokenPath is where agentd keeps the local operator's API token.
+func OperatorTokenPath() string {
+	return filepath.Join(BaseDir(), "operator-token")
+}
+
 func ConfigPath() string {
 	return filepath.Join(BaseDir(), "config.yaml")
 }
@@ -105,6 +211,7 @@ func EnsureDirs() error {
 	dirs := []string{
 		BaseDir(),
 		filepath.Join(BaseDir(), "shared", "bin"),
+		filepath.Join(BaseDir(), "shared", "repos"),
 		filepath.Join(BaseDir(), "traefik", "dynamic"),
 		filepath.Join(BaseDir(), "certs"),
 		filepath.Join(BaseDir(), "logs"),
@@ -28,9 +28,24 @@ func TestDefault(t *testing.T) {
 	if cfg.Network.RegistryPort != 8090 {
 		t.Errorf("expected port 8090, got %d", cfg.Network.RegistryPort)
 	}
+	if cfg.Network.Router != "traefik" {
+		t.Errorf("expected traefik router, got %s", cfg.Network.Router)
+	}
+	if cfg.Network.DNS.Listen != "127.0.0.1:5353" || cfg.Network.DNS.Address != "127.0.0.1" {
+		t.Errorf("expected DNS responder on 127.0.0.1:5353 answering 127.0.0.1, got %+v", cfg.Network.DNS)
+	}
+	if cfg.Network.TCP.PortMin != 15000 || cfg.Network.TCP.PortMax != 15999 {
+		t.Errorf("expected TCP ports 15000-15999, got %d-%d", cfg.Network.TCP.PortMin, cfg.Network.TCP.PortMax)
+	}
 	if cfg.API.Port != 8091 {
 		t.Errorf("expected port 8091, got %d", cfg.API.Port)
 	}
+	if !cfg.RepoCache.Enabled {
+		t.Error("expected repo cache enabled by default")
+	}
+	if cfg.RepoCache.RefreshMinutes != 15 {
+		t.Errorf("expected repo cache refresh 15, got %d", cfg.RepoCache.RefreshMinutes)
+	}
 }
 
 func TestBaseDir(t *testing.T) {
@@ -39,3 +54,13 @@ func TestBaseDir(t *testing.T) {
 		t.Error("expected non-empty base dir")
 	}
 }
+
+func TestAccessConfig_ModeFor(t *testing.T) {
+	a := AccessConfig{Mode: "token", Projects: map[string]string{"public-site": "none"}}
+	if got := a.ModeFor("public-site"); got != "none" {
+		t.Errorf("expected project override none, got %s", got)
+	}
+	if got := a.ModeFor("other"); got != "token" {
+		t.Errorf("expected default token, got %s", got)
+	}
+}
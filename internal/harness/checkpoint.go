@@ -0,0 +1,97 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// checkpointRef is the shadow ref holding work-in-progress snapshots. It is
+// never pushed itself: if the tool fails and leaves no changes, the last
+// checkpoint is restored into the workspace and pushed from there.
+const checkpointRef = "refs/agentvm/checkpoint"
+
+const defaultCheckpointInterval = 10 * time.Minute
+
+// Checkpointer periodically snapshots the workspace to checkpointRef while
+// the coding tool runs, so partial work survives a timeout or a tool that
+// resets its own changes.
+type Checkpointer struct {
+	git      *Git
+	interval time.Duration
+
+	mu    sync.Mutex
+	count int
+	last  string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCheckpointer creates a checkpointer for the repository in git. A zero
+// interval uses the default of 10 minutes.
+func NewCheckpointer(git *Git, intervalMinutes int) *Checkpointer {
+	interval := time.Duration(intervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	return &Checkpointer{git: git, interval: interval}
+}
+
+// Start begins taking checkpoints in the background until Stop is called or
+// ctx is cancelled.
+func (c *Checkpointer) Start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.Checkpoint()
+			}
+		}
+	}()
+}
+
+// Stop halts the background loop and waits for an in-flight checkpoint.
+func (c *Checkpointer) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// Checkpoint snapshots the workspace now. Failures are logged, not returned:
+// a missed checkpoint must never interrupt the tool.
+func (c *Checkpointer) Checkpoint() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg := fmt.Sprintf("WIP checkpoint %d (%s)", c.count+1, time.Now().UTC().Format(time.RFC3339))
+	commit, err := c.git.Snapshot(checkpointRef, msg)
+	if err != nil {
+		log.Printf("Warning: checkpoint failed: %v", err)
+		return
+	}
+	if commit == "" {
+		return
+	}
+	c.count++
+	c.last = commit
+	log.Printf("Checkpoint %d: %s", c.count, commit)
+}
+
+// Count returns the number of checkpoints taken and the latest commit.
+func (c *Checkpointer) Count() (int, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count, c.last
+}
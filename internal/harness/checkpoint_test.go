@@ -0,0 +1,149 @@
+package harness
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/orchestrator"
+)
+
+func TestGit_Snapshot(t *testing.T) {
+	git, base := initTestRepo(t, map[string]string{"main.go": "package main"})
+
+	writeTestFile(t, git.dir, "main.go", "package main // wip")
+	writeTestFile(t, git.dir, "new.go", "package main")
+
+	first, err := git.Snapshot(checkpointRef, "WIP 1")
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if first == "" {
+		t.Fatal("expected a checkpoint commit")
+	}
+
+	// HEAD and the index are untouched
+	if head, _ := git.HeadCommit(); head != base {
+		t.Errorf("HEAD moved to %s", head)
+	}
+	if staged, _ := git.output("diff", "--cached", "--name-only"); staged != "" {
+		t.Errorf("expected nothing staged, got %q", staged)
+	}
+
+	files, err := git.output("ls-tree", "-r", "--name-only", checkpointRef)
+	if err != nil || !strings.Contains(files, "new.go") {
+		t.Errorf("expected untracked file in checkpoint, got %q (%v)", files, err)
+	}
+
+	// No changes since the last checkpoint: nothing recorded
+	again, err := git.Snapshot(checkpointRef, "WIP 2")
+	if err != nil || again != "" {
+		t.Errorf("expected no new checkpoint, got %q (%v)", again, err)
+	}
+
+	writeTestFile(t, git.dir, "new.go", "package main // more")
+	second, err := git.Snapshot(checkpointRef, "WIP 3")
+	if err != nil || second == "" {
+		t.Fatalf("expected second checkpoint, got %q (%v)", second, err)
+	}
+	parent, _ := git.output("rev-parse", second+"^")
+	if strings.TrimSpace(parent) != first {
+		t.Errorf("expected checkpoint chain, parent %s != %s", parent, first)
+	}
+}
+
+func TestCheckpointer_Count(t *testing.T) {
+	git, _ := initTestRepo(t, map[string]string{"a.txt": "a"})
+	cp := NewCheckpointer(git, 0)
+	if cp.interval != defaultCheckpointInterval {
+		t.Errorf("expected default interval, got %s", cp.interval)
+	}
+
+	cp.Checkpoint()
+	if n, _ := cp.Count(); n != 0 {
+		t.Errorf("expected no checkpoint for a clean tree, got %d", n)
+	}
+	writeTestFile(t, git.dir, "a.txt", "b")
+	cp.Checkpoint()
+	if n, last := cp.Count(); n != 1 || last == "" {
+		t.Errorf("expected one checkpoint, got %d (%q)", n, last)
+	}
+}
+
+func TestDaemon_FailurePushesRecoveredCheckpoint(t *testing.T) {
+	git, base := initTestRepo(t, map[string]string{"main.go": "package main"})
+	origin := addTestOrigin(t, git)
+
+	// The tool's work is checkpointed, then the tool throws it away and fails
+	cp := NewCheckpointer(git, 0)
+	writeTestFile(t, git.dir, "main.go", "package main // wip")
+	cp.Checkpoint()
+	writeTestFile(t, git.dir, "main.go", "package main")
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer host.Close()
+	d := &Daemon{
+		task:     &orchestrator.TaskConfig{AgentID: "agent-1", Branch: "agent/fix", Prompt: "Fix main"},
+		reporter: NewReporter(host.URL),
+		auth:     &GitAuth{},
+	}
+	d.recoverCheckpoint(git, base, cp)
+	report := &Report{}
+	if err := d.pushPartial(git, base, report, nil, errors.New("tool crashed")); err == nil {
+		t.Fatal("expected the execution error back")
+	}
+
+	if report.PartialBranch != "agent/fix-partial" {
+		t.Fatalf("expected partial branch, got %q", report.PartialBranch)
+	}
+	out, err := exec.Command("git", "-C", origin, "show", "agent/fix-partial:main.go").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "package main // wip" {
+		t.Errorf("checkpointed work not pushed: %q (%v)", out, err)
+	}
+}
+
+func TestDaemon_NonzeroExitPushesPartial(t *testing.T) {
+	git, base := initTestRepo(t, map[string]string{"main.go": "package main"})
+	origin := addTestOrigin(t, git)
+	writeTestFile(t, git.dir, "main.go", "package main // half done")
+
+	var states []string
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			return
+		}
+		var req struct{ State string }
+		json.NewDecoder(r.Body).Decode(&req)
+		states = append(states, req.State)
+	}))
+	defer host.Close()
+	d := &Daemon{
+		task:     &orchestrator.TaskConfig{AgentID: "agent-1", Branch: "agent/fix", Prompt: "Fix main"},
+		reporter: NewReporter(host.URL),
+		auth:     &GitAuth{},
+	}
+	report := &Report{}
+	result := &ExecuteResult{ExitCode: 2, Duration: time.Minute}
+	if err := d.pushPartial(git, base, report, result, nil); err != nil {
+		t.Fatalf("a nonzero exit is not a harness error: %v", err)
+	}
+
+	if report.PartialBranch != "agent/fix-partial" {
+		t.Fatalf("expected partial branch, got %q", report.PartialBranch)
+	}
+	if err := exec.Command("git", "-C", origin, "rev-parse", "--verify", "--quiet", "agent/fix").Run(); err == nil {
+		t.Error("failed work pushed to the task branch")
+	}
+	out, err := exec.Command("git", "-C", origin, "show", "agent/fix-partial:main.go").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "package main // half done" {
+		t.Errorf("partial work not pushed: %q (%v)", out, err)
+	}
+	if len(states) == 0 || states[len(states)-1] != "failed" {
+		t.Errorf("expected failed reported last, got %v", states)
+	}
+}
@@ -0,0 +1,52 @@
+package harness
+
+import (
+	"os"
+	"strings"
+
+	"github.com/mateo/agentvm/internal/project"
+)
+
+// modelEnvVars maps tools to the env var that selects their model, used for
+// the Agent-Model commit trailer.
+var modelEnvVars = map[string]string{
+	"claude-code": "ANTHROPIC_MODEL",
+	"opencode":    "OPENCODE_MODEL",
+	"amp":         "AMP_MODEL",
+	"cline":       "CLINE_MODEL",
+}
+
+// commitMessage renders the task's commit message template. Partial results
+// get a "WIP (incomplete): " prefix on the subject.
+func (d *Daemon) commitMessage(partial bool) (string, error) {
+	summary, _, _ := strings.Cut(strings.TrimSpace(d.task.Prompt), "\n")
+	msg, err := project.RenderCommitMessage(d.task.CommitMessage, project.CommitData{
+		AgentID: d.task.AgentID,
+		Project: d.task.Project,
+		Issue:   d.task.Issue,
+		Tool:    d.task.Tool,
+		Model:   d.toolModel(),
+		Branch:  d.task.Branch,
+		Prompt:  d.task.Prompt,
+		Summary: truncate(summary, 50),
+	})
+	if err != nil {
+		return "", err
+	}
+	if partial {
+		msg = "WIP (incomplete): " + msg
+	}
+	return msg, nil
+}
+
+// toolModel returns the model the tool was configured with, if known.
+func (d *Daemon) toolModel() string {
+	name, ok := modelEnvVars[d.task.Tool]
+	if !ok {
+		return ""
+	}
+	if v := d.task.EnvVars[name]; v != "" {
+		return v
+	}
+	return os.Getenv(name)
+}
@@ -0,0 +1,57 @@
+package harness
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mateo/agentvm/internal/orchestrator"
+)
+
+func TestDaemon_CommitMessage(t *testing.T) {
+	d := &Daemon{task: &orchestrator.TaskConfig{
+		AgentID:       "agent-1",
+		Issue:         "PROJ-7",
+		Tool:          "claude-code",
+		Prompt:        "Fix the flaky login test\n\nIt fails on CI about once a day.",
+		EnvVars:       map[string]string{"ANTHROPIC_MODEL": "claude-sonnet-4"},
+		CommitMessage: "fix({{.Issue}}): {{.Summary}}\n\nAgent-Model: {{.Model}}",
+	}}
+
+	msg, err := d.commitMessage(false)
+	if err != nil {
+		t.Fatalf("commitMessage failed: %v", err)
+	}
+	if msg != "fix(PROJ-7): Fix the flaky login test\n\nAgent-Model: claude-sonnet-4\n" {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	msg, _ = d.commitMessage(true)
+	if !strings.HasPrefix(msg, "WIP (incomplete): fix(PROJ-7)") {
+		t.Errorf("expected WIP prefix, got %q", msg)
+	}
+}
+
+func TestGit_CommitsAndStagedChanges(t *testing.T) {
+	git, base := initTestRepo(t, map[string]string{"a.txt": "a"})
+	git.SetIdentity("Test", "test@example.com")
+
+	if staged, err := git.HasStagedChanges(); err != nil || staged {
+		t.Errorf("expected clean index, got %v (%v)", staged, err)
+	}
+
+	writeTestFile(t, git.dir, "a.txt", "b")
+	git.AddAll()
+	if staged, err := git.HasStagedChanges(); err != nil || !staged {
+		t.Errorf("expected staged changes, got %v (%v)", staged, err)
+	}
+
+	if err := git.Commit("tool commit"); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if n, err := git.CommitsSince(base); err != nil || n != 1 {
+		t.Errorf("expected 1 commit since base, got %d (%v)", n, err)
+	}
+	if err := git.Commit("empty"); err == nil {
+		t.Error("expected empty commit to fail")
+	}
+}
@@ -16,9 +16,10 @@ func NewConstrainer(maxMinutes int) *Constrainer {
 	return &Constrainer{maxMinutes: maxMinutes}
 }
 
-func (c *Constrainer) WithContext(parent context.Context) context.Context {
-	ctx, _ := context.WithTimeout(parent, time.Duration(c.maxMinutes)*time.Minute)
-	return ctx
+// WithContext returns a context that expires after the max execution time.
+// The caller must call cancel once execution finishes.
+func (c *Constrainer) WithContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, time.Duration(c.maxMinutes)*time.Minute)
 }
 
 func (c *Constrainer) Deadline() time.Duration {
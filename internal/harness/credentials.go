@@ -0,0 +1,167 @@
+package harness
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mateo/agentvm/internal/orchestrator"
+)
+
+const credentialsPath = "/etc/agent-config/credentials.json"
+
+// GitAuth holds the task's git credentials in memory and installs them into
+// the user's git config only while the harness itself talks to the remote
+// (clone and push), so the coding tool never sees them on disk.
+type GitAuth struct {
+	home  string
+	creds []orchestrator.GitCredential
+	keys  []string // global git config keys set by Install
+}
+
+// LoadGitAuth reads the credentials injected by agentd and deletes the file.
+// GITHUB_TOKEN (with optional GITHUB_USER) is still honored for github.com
+// when no injected credential covers it.
+func LoadGitAuth(path, home string) (*GitAuth, error) {
+	a := &GitAuth{home: home}
+	if _, err := os.Stat(path); err == nil {
+		creds, err := orchestrator.ReadCredentials(path)
+		if err != nil {
+			return nil, err
+		}
+		// The tool must never be able to read the file
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing %s: %w", path, err)
+		}
+		a.creds = creds
+	}
+
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && !a.covers("github.com") {
+		user := os.Getenv("GITHUB_USER")
+		if user == "" {
+			user = "git"
+		}
+		a.creds = append(a.creds, orchestrator.GitCredential{
+			Host:     "github.com",
+			Type:     orchestrator.CredentialToken,
+			Username: user,
+			Token:    token,
+		})
+	}
+	return a, nil
+}
+
+func (a *GitAuth) covers(host string) bool {
+	for _, c := range a.creds {
+		if c.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *GitAuth) dir() string {
+	return filepath.Join(a.home, ".config", "agentvm", "git-auth")
+}
+
+// Install writes the credentials and points git at them: a credential store
+// file per HTTPS token, an ssh config for deploy keys (via core.sshCommand),
+// and credential helpers scoped to their host.
+func (a *GitAuth) Install() error {
+	if len(a.creds) == 0 {
+		return nil
+	}
+	dir := a.dir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	var store, sshConfig, knownHosts strings.Builder
+	for _, c := range a.creds {
+		switch c.Type {
+		case orchestrator.CredentialToken:
+			u := url.URL{Scheme: "https", User: url.UserPassword(c.Username, c.Token), Host: c.Host}
+			store.WriteString(u.String() + "\n")
+		case orchestrator.CredentialSSH:
+			keyPath := filepath.Join(dir, c.Host+".key")
+			key := c.SSHKey
+			if !strings.HasSuffix(key, "\n") {
+				key += "\n"
+			}
+			if err := os.WriteFile(keyPath, []byte(key), 0600); err != nil {
+				return fmt.Errorf("writing SSH key for %s: %w", c.Host, err)
+			}
+			strict := "accept-new"
+			if c.KnownHosts != "" {
+				knownHosts.WriteString(strings.TrimSpace(c.KnownHosts) + "\n")
+				strict = "yes"
+			}
+			fmt.Fprintf(&sshConfig, "Host %s\n  IdentityFile %s\n  IdentitiesOnly yes\n  UserKnownHostsFile %s\n  StrictHostKeyChecking %s\n",
+				c.Host, keyPath, filepath.Join(dir, "known_hosts"), strict)
+		case orchestrator.CredentialHelper:
+			if err := a.setConfig("credential.https://"+c.Host+".helper", c.Helper); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown credential type %q for %s", c.Type, c.Host)
+		}
+	}
+
+	if store.Len() > 0 {
+		storePath := filepath.Join(dir, "credentials")
+		if err := os.WriteFile(storePath, []byte(store.String()), 0600); err != nil {
+			return fmt.Errorf("writing credential store: %w", err)
+		}
+		for _, c := range a.creds {
+			if c.Type == orchestrator.CredentialToken {
+				if err := a.setConfig("credential.https://"+c.Host+".helper", "store --file="+storePath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if sshConfig.Len() > 0 {
+		configPath := filepath.Join(dir, "ssh_config")
+		if err := os.WriteFile(configPath, []byte(sshConfig.String()), 0600); err != nil {
+			return fmt.Errorf("writing ssh config: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "known_hosts"), []byte(knownHosts.String()), 0600); err != nil {
+			return fmt.Errorf("writing known_hosts: %w", err)
+		}
+		if err := a.setConfig("core.sshCommand", "ssh -F "+configPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes installed credential files and unsets the git config keys
+// Install set. It is safe to call repeatedly.
+func (a *GitAuth) Remove() {
+	git := NewGit("")
+	for _, key := range a.keys {
+		if err := git.run("config", "--global", "--unset-all", key); err != nil {
+			log.Printf("Warning: unsetting %s: %v", key, err)
+		}
+	}
+	a.keys = nil
+	if err := os.RemoveAll(a.dir()); err != nil {
+		log.Printf("Warning: removing git credentials: %v", err)
+	}
+}
+
+func (a *GitAuth) setConfig(key, value string) error {
+	if err := NewGit("").run("config", "--global", "--replace-all", key, value); err != nil {
+		return err
+	}
+	for _, k := range a.keys {
+		if k == key {
+			return nil
+		}
+	}
+	a.keys = append(a.keys, key)
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/mateo/agentvm/internal/orchestrator"
+	"github.com/mateo/agentvm/internal/project"
 )
 
 const (
@@ -32,6 +33,7 @@ type Daemon struct {
 	task     *orchestrator.TaskConfig
 	reporter *Reporter
 	executor *Executor
+	auth     *GitAuth
 }
 
 func NewDaemon() (*Daemon, error) {
@@ -68,169 +70,261 @@ func (d *Daemon) Run() error {
 	// Step 1: Register with host
 	d.reporter.Report(d.task.AgentID, "starting", "Harness initializing", d.task.Branch)
 
-	// Configure git credentials if GITHUB_TOKEN is set
-	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
-		home, _ := os.UserHomeDir()
-		credFile := filepath.Join(home, ".git-credentials")
-		user := os.Getenv("GITHUB_USER")
-		if user == "" {
-			user = "git"
-		}
-		os.WriteFile(credFile, []byte(fmt.Sprintf("https://%s:%s@github.com\n", user, token)), 0600)
-		git := NewGit("")
-		git.run("config", "--global", "credential.helper", "store")
+	// Report listening ports for the whole run so they can be exposed
+	go d.watchPorts(ctx)
+
+	// Load git credentials; they are only installed while cloning and pushing
+	home, _ := os.UserHomeDir()
+	auth, err := LoadGitAuth(credentialsPath, home)
+	if err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Loading git credentials failed: %v", err), d.task.Branch)
+		return err
 	}
+	d.auth = auth
+	defer auth.Remove()
 
 	// Step 2: Setup workspace
+	if err := auth.Install(); err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Installing git credentials failed: %v", err), d.task.Branch)
+		return err
+	}
 	repoDir, err := d.setupWorkspace(ctx)
+	auth.Remove()
 	if err != nil {
 		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Workspace setup failed: %v", err), d.task.Branch)
 		return err
 	}
 
+	// Merge .agentvm.yaml defaults under the task and record what will run
+	if err := d.loadProjectConfig(repoDir); err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Invalid project config: %v", err), d.task.Branch)
+		return err
+	}
+
 	// Step 3: Create branch
 	git := NewGit(repoDir)
+	if d.task.AuthorName != "" {
+		if err := git.SetIdentity(d.task.AuthorName, d.task.AuthorEmail); err != nil {
+			d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Setting commit author failed: %v", err), d.task.Branch)
+			return err
+		}
+	}
 	if err := git.CreateBranch(d.task.Branch); err != nil {
 		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Branch creation failed: %v", err), d.task.Branch)
 		return err
 	}
+	base, err := git.HeadCommit()
+	if err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Resolving base commit failed: %v", err), d.task.Branch)
+		return err
+	}
+
+	// Step 4: Run project setup (dependencies, env files, services)
+	if err := d.runSetup(ctx, repoDir); err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Setup failed: %v", err), d.task.Branch)
+		return err
+	}
 
 	d.reporter.Report(d.task.AgentID, "executing", fmt.Sprintf("Running %s", d.task.Tool), d.task.Branch)
 
-	// Step 4: Execute coding tool with constraints
+	// Step 5: Execute coding tool with constraints, checkpointing work in
+	// progress so a timeout doesn't lose it
+	checkpointer := NewCheckpointer(git, d.task.CheckpointInterval)
+	checkpointer.Start(ctx)
 	constrainer := NewConstrainer(d.task.MaxTime)
 	result, err := d.executor.Execute(ctx, constrainer, ExecuteConfig{
-		Tool:      d.task.Tool,
-		Prompt:    d.task.Prompt,
-		WorkDir:   repoDir,
-		EnvVars:   d.task.EnvVars,
+		Tool:    d.task.Tool,
+		Prompt:  d.task.Prompt,
+		WorkDir: repoDir,
+		EnvVars: d.task.EnvVars,
 	})
-	if err != nil {
-		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Execution failed: %v", err), d.task.Branch)
-		return err
-	}
+	checkpointer.Stop()
 
-	// Step 5: Push results
-	d.reporter.Report(d.task.AgentID, "pushing", "Pushing branch", d.task.Branch)
-	if err := git.AddAll(); err != nil {
-		log.Printf("Warning: git add failed: %v", err)
+	report := &Report{
+		AgentID: d.task.AgentID,
+		Project: d.task.Project,
+		Tool:    d.task.Tool,
+		Branch:  d.task.Branch,
 	}
-	if err := git.Commit(fmt.Sprintf("agent/%s: %s", d.task.AgentID, truncate(d.task.Prompt, 50))); err != nil {
-		log.Printf("Warning: git commit failed: %v", err)
+	if result != nil {
+		report.ExitCode = result.ExitCode
+		report.Duration = result.Duration.String()
+		report.TimedOut = result.TimedOut
 	}
-	if err := git.Push(d.task.Branch); err != nil {
-		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Push failed: %v", err), d.task.Branch)
+	if err != nil || result.TimedOut || result.ExitCode != 0 {
+		d.recoverCheckpoint(git, base, checkpointer)
+		checkpointer.Checkpoint()
+		report.Checkpoints, _ = checkpointer.Count()
+		return d.pushPartial(git, base, report, result, err)
+	}
+	report.Checkpoints, _ = checkpointer.Count()
+
+	// Step 6: Check, commit and push results
+	if err := d.publish(git, base, report, d.task.Branch, false); err != nil {
 		return err
 	}
 
-	// Write result report locally
-	d.writeReport(result)
+	// Write result report locally and to the host task history
+	d.writeReport(report)
 
-	// Step 6: Serve if configured
+	// Step 7: Serve if configured
 	if d.task.ServeCommand != "" {
 		return d.serve(ctx, repoDir)
 	}
 
-	// Step 7: Report completion (non-serve mode)
+	// Step 8: Report completion (non-serve mode)
 	state := "completed"
-	if result.ExitCode != 0 {
-		state = "failed"
+	message := fmt.Sprintf("Exit code: %d, Duration: %s", result.ExitCode, result.Duration)
+	if report.NoChanges {
+		state = "no_changes"
+		message = fmt.Sprintf("No changes to push, Duration: %s", result.Duration)
+	} else if len(report.ProtectedViolations) > 0 {
+		message += fmt.Sprintf(", reverted %d protected file(s)", len(report.ProtectedViolations))
 	}
-	d.reporter.Report(d.task.AgentID, state,
-		fmt.Sprintf("Exit code: %d, Duration: %s", result.ExitCode, result.Duration), d.task.Branch)
+	d.reporter.Report(d.task.AgentID, state, message, d.task.Branch)
 
 	log.Printf("Agent harness finished: state=%s exit=%d duration=%s",
 		state, result.ExitCode, result.Duration)
 	return nil
 }
 
-func (d *Daemon) serve(ctx context.Context, repoDir string) error {
-	port := d.task.ServePort
-	if port <= 0 {
-		port = 8080
+// publish enforces protected paths, stages and scans the changes, then
+// commits and pushes HEAD to branch. Failures are reported before returning.
+//
+// Commits the tool made itself are kept as they are, unless reverting
+// protected paths squashed them; the harness only adds a commit for
+// uncommitted changes. If there is nothing at all to push,
+// report.NoChanges is set and nothing is pushed.
+func (d *Daemon) publish(git *Git, base string, report *Report, branch string, partial bool) error {
+	if err := d.enforceProtectedPaths(git, base, report); err != nil {
+		return err
 	}
 
-	log.Printf("Starting serve command: %s (port %d)", d.task.ServeCommand, port)
-	d.reporter.Report(d.task.AgentID, "serving", fmt.Sprintf("Starting serve: %s", d.task.ServeCommand), d.task.Branch)
-
-	// Launch serve command via bash -c (supports pipes, &&, etc.)
-	cmd := exec.CommandContext(ctx, "bash", "-c", d.task.ServeCommand)
-	cmd.Dir = repoDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	// Pass through environment
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, fmt.Sprintf("AGENT_ID=%s", d.task.AgentID))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("AGENT_PROJECT=%s", d.task.Project))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PORT=%d", port))
-	for k, v := range d.task.EnvVars {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	if err := git.AddAll(); err != nil {
+		log.Printf("Warning: git add failed: %v", err)
+	}
+	if err := d.scanSecrets(git, base, report); err != nil {
+		return err
 	}
 
-	if err := cmd.Start(); err != nil {
-		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Serve command failed to start: %v", err), d.task.Branch)
-		return fmt.Errorf("starting serve command: %w", err)
+	toolCommits, err := git.CommitsSince(base)
+	if err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Inspecting commits failed: %v", err), d.task.Branch)
+		return err
+	}
+	report.ToolCommits = toolCommits
+	staged, err := git.HasStagedChanges()
+	if err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Inspecting changes failed: %v", err), d.task.Branch)
+		return err
+	}
+	if !staged && toolCommits == 0 {
+		log.Println("No changes to push")
+		report.NoChanges = true
+		return nil
 	}
 
-	// Wait for port to become ready (up to 5 minutes for docker builds)
-	if err := d.waitForPort(ctx, port, 5*time.Minute); err != nil {
-		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Port %d never became ready: %v", port, err), d.task.Branch)
-		cmd.Process.Kill()
+	d.reporter.Report(d.task.AgentID, "pushing", fmt.Sprintf("Pushing %s", branch), branch)
+	if err := d.auth.Install(); err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Installing git credentials failed: %v", err), branch)
+		return err
+	}
+	defer d.auth.Remove()
+	if staged {
+		msg, err := d.commitMessage(partial)
+		if err != nil {
+			d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Commit message: %v", err), branch)
+			return err
+		}
+		if err := git.Commit(msg); err != nil {
+			d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Commit failed: %v", err), branch)
+			return err
+		}
+	}
+	if err := git.PushHeadTo(branch); err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Push failed: %v", err), branch)
 		return err
 	}
+	return nil
+}
 
-	log.Printf("Serving on port %d", port)
+// recoverCheckpoint restores the last checkpoint into the working tree when
+// the tool failed and left no changes behind, e.g. because it reset its own
+// work. The restored work is then pushed with the same checks as any other.
+func (d *Daemon) recoverCheckpoint(git *Git, base string, checkpointer *Checkpointer) {
+	n, last := checkpointer.Count()
+	if n == 0 {
+		return
+	}
+	changed, err := git.ChangedFiles(base)
+	if err != nil || len(changed) > 0 {
+		return
+	}
+	if err := git.RestoreTree(last); err != nil {
+		log.Printf("Warning: restoring checkpoint %s failed: %v", last, err)
+		return
+	}
+	log.Printf("Tool left no changes, restored checkpoint %d (%s)", n, last)
+}
 
-	// Get VM IP and register with host
-	vmIP, err := d.getVMIP()
-	if err != nil {
-		log.Printf("Warning: could not determine VM IP: %v", err)
-		vmIP = "unknown"
-	}
-
-	hostname, _ := os.Hostname()
-	if err := d.reporter.Register(
-		d.task.AgentID,
-		hostname,
-		vmIP,
-		d.task.Project,
-		d.task.Tool,
-		[]int{port},
-	); err != nil {
-		log.Printf("Warning: registration failed: %v", err)
-	} else {
-		log.Printf("Registered with host: %s -> %s:%d", d.task.AgentID, vmIP, port)
+// pushPartial salvages work after the tool timed out, failed to run or
+// exited nonzero by pushing it to a "-partial" branch, with the same checks
+// as a normal push. A nonzero exit is reported as failed but, as before
+// checkpointing, is not a harness error.
+func (d *Daemon) pushPartial(git *Git, base string, report *Report, result *ExecuteResult, execErr error) error {
+	var cause string
+	var fail error
+	switch {
+	case execErr != nil:
+		cause = fmt.Sprintf("Execution failed: %v", execErr)
+		fail = execErr
+	case result.TimedOut:
+		cause = fmt.Sprintf("Timed out after %d minutes", d.task.MaxTime)
+		fail = fmt.Errorf("timed out after %d minutes", d.task.MaxTime)
+	default:
+		cause = fmt.Sprintf("Exit code: %d, Duration: %s", result.ExitCode, result.Duration)
 	}
 
-	d.reporter.Report(d.task.AgentID, "serving",
-		fmt.Sprintf("Serving on port %d, registered at %s:%d", port, vmIP, port), d.task.Branch)
+	changed, err := git.ChangedFiles(base)
+	if err != nil || len(changed) == 0 {
+		d.writeReport(report)
+		d.reporter.Report(d.task.AgentID, "failed", cause, d.task.Branch)
+		return fail
+	}
 
-	// Block until context is cancelled (systemd stop) or serve process exits
-	doneCh := make(chan error, 1)
-	go func() {
-		doneCh <- cmd.Wait()
-	}()
+	branch := d.task.Branch + "-partial"
+	if err := d.publish(git, base, report, branch, true); err != nil {
+		return err
+	}
+	if report.NoChanges {
+		d.writeReport(report)
+		d.reporter.Report(d.task.AgentID, "failed", cause, d.task.Branch)
+		return fail
+	}
+	report.PartialBranch = branch
 
-	select {
-	case <-ctx.Done():
-		log.Println("Context cancelled, stopping serve process")
-		cmd.Process.Signal(syscall.SIGTERM)
-		// Give it 10 seconds to shut down
-		select {
-		case <-doneCh:
-		case <-time.After(10 * time.Second):
-			cmd.Process.Kill()
-		}
-		return nil
-	case err := <-doneCh:
-		if err != nil {
-			d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Serve process exited: %v", err), d.task.Branch)
-			return fmt.Errorf("serve process exited: %w", err)
-		}
-		d.reporter.Report(d.task.AgentID, "completed", "Serve process exited cleanly", d.task.Branch)
-		return nil
+	d.writeReport(report)
+	d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("%s; partial work pushed to %s", cause, branch), branch)
+	return fail
+}
+
+// taskEnv returns the process environment plus agent metadata, any extra
+// KEY=VALUE pairs, and the task's env vars (which take precedence).
+func (d *Daemon) taskEnv(extra ...string) []string {
+	env := os.Environ()
+	env = append(env, fmt.Sprintf("AGENT_ID=%s", d.task.AgentID))
+	env = append(env, fmt.Sprintf("AGENT_PROJECT=%s", d.task.Project))
+	env = append(env, extra...)
+	for k, v := range d.task.EnvVars {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
+	return env
+}
+
+// serviceEnvName turns a service name into its PORT_ suffix, e.g. "web-ui"
+// becomes "WEB_UI".
+func serviceEnvName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
 }
 
 func (d *Daemon) waitForPort(ctx context.Context, port int, timeout time.Duration) error {
@@ -305,24 +399,110 @@ func (d *Daemon) setupWorkspace(ctx context.Context) (string, error) {
 	}
 
 	git := NewGit(wsBase)
-	if err := git.Clone(d.task.RepoURL, repoDir); err != nil {
-		return "", fmt.Errorf("cloning repo: %w", err)
+	cc := d.task.Clone
+	if cc == nil {
+		cc = &orchestrator.CloneConfig{}
+	}
+	opts := CloneOptions{Depth: cc.Depth, Filter: cc.Filter}
+
+	mirror := ""
+	if cc.Mirror != "" {
+		if _, err := os.Stat(cc.Mirror); err == nil {
+			mirror = cc.Mirror
+		} else {
+			log.Printf("Warning: repo mirror %s not available, cloning from remote", cc.Mirror)
+		}
+	}
+
+	if mirror != "" && cc.FromMirror {
+		// Clone entirely from the host mirror, then point origin back at the
+		// real remote so the push goes to the right place. file:// keeps
+		// --depth/--filter effective for a local source.
+		log.Printf("Cloning from mirror %s", mirror)
+		if err := git.Clone("file://"+mirror, repoDir, opts); err != nil {
+			return "", fmt.Errorf("cloning from mirror: %w", err)
+		}
+		if err := NewGit(repoDir).SetRemoteURL("origin", d.task.RepoURL); err != nil {
+			return "", fmt.Errorf("setting origin: %w", err)
+		}
+	} else {
+		if mirror != "" {
+			log.Printf("Cloning with reference mirror %s", mirror)
+			opts.Reference = mirror
+		}
+		if err := git.Clone(d.task.RepoURL, repoDir, opts); err != nil {
+			return "", fmt.Errorf("cloning repo: %w", err)
+		}
 	}
 
+	// Start from the commit agentd read .agentvm.yaml from
+	if cc.Revision != "" {
+		if err := NewGit(repoDir).Checkout(cc.Revision); err != nil {
+			log.Printf("Warning: checking out %s failed, staying on the default branch: %v", cc.Revision, err)
+		}
+	}
 	return repoDir, nil
 }
 
-func (d *Daemon) writeReport(result *ExecuteResult) {
-	report := map[string]interface{}{
-		"agentID":  d.task.AgentID,
-		"project":  d.task.Project,
-		"tool":     d.task.Tool,
-		"exitCode": result.ExitCode,
-		"duration": result.Duration.String(),
-		"branch":   d.task.Branch,
-	}
+// Report is the result summary written to report.json and recorded in the
+// host task history.
+type Report struct {
+	AgentID  string `json:"agentID"`
+	Project  string `json:"project"`
+	Tool     string `json:"tool"`
+	ExitCode int    `json:"exitCode"`
+	Duration string `json:"duration"`
+	Branch   string `json:"branch"`
+	// ProtectedViolations lists protected files the tool modified; they were
+	// reverted unless the task failed with policy_violation.
+	ProtectedViolations []string `json:"protectedViolations,omitempty"`
+	// SecretFindings lists potential secrets found in the diff, redacted.
+	SecretFindings []SecretFinding `json:"secretFindings,omitempty"`
+	TimedOut       bool            `json:"timedOut,omitempty"`
+	// ToolCommits counts commits the tool made itself; they are pushed as-is
+	// unless they touched protected paths that were reverted.
+	ToolCommits int  `json:"toolCommits,omitempty"`
+	NoChanges   bool `json:"noChanges,omitempty"`
+	Checkpoints int  `json:"checkpoints,omitempty"`
+	// PartialBranch is where incomplete work was pushed after a timeout, an
+	// execution failure or a nonzero exit.
+	PartialBranch string `json:"partialBranch,omitempty"`
+}
+
+func (d *Daemon) writeReport(report *Report) {
 	data, _ := json.MarshalIndent(report, "", "  ")
 	os.WriteFile("/etc/agent-config/report.json", data, 0644)
+	if err := d.reporter.RecordHistory(d.task.AgentID, "report", report); err != nil {
+		log.Printf("Warning: recording report in history failed: %v", err)
+	}
+}
+
+// loadProjectConfig reads .agentvm.yaml from the cloned repo, merges it under
+// the task and records the effective configuration in the host history.
+func (d *Daemon) loadProjectConfig(repoDir string) error {
+	pc, err := project.Load(repoDir)
+	if err != nil {
+		return err
+	}
+	if err := orchestrator.ValidateProjectConfig(pc); err != nil {
+		return err
+	}
+	orchestrator.ApplyProjectConfig(d.task, pc)
+
+	effective := map[string]interface{}{
+		"task":    d.task.Redacted(),
+		"project": pc,
+	}
+	if data, err := json.MarshalIndent(effective, "", "  "); err == nil {
+		os.WriteFile("/etc/agent-config/effective.json", data, 0644)
+	}
+	if err := d.reporter.RecordHistory(d.task.AgentID, "effective", effective); err != nil {
+		log.Printf("Warning: recording effective config failed: %v", err)
+	}
+	log.Printf("Effective config: tool=%s maxTime=%d serve=%q setup=%d protected=%d",
+		d.task.Tool, d.task.MaxTime, d.task.ServeCommand,
+		len(d.task.SetupCommands), len(d.task.ProtectedPaths))
+	return nil
 }
 
 func truncate(s string, n int) string {
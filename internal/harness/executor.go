@@ -2,6 +2,7 @@ package harness
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -20,6 +21,7 @@ type ExecuteResult struct {
 	ExitCode int
 	Duration time.Duration
 	Output   string
+	TimedOut bool // killed after exceeding the max execution time
 }
 
 type Executor struct{}
@@ -37,7 +39,8 @@ func (e *Executor) Execute(ctx context.Context, c *Constrainer, cfg ExecuteConfi
 	log.Printf("Executing: %v in %s", args, cfg.WorkDir)
 
 	// Apply time constraint
-	execCtx := c.WithContext(ctx)
+	execCtx, cancel := c.WithContext(ctx)
+	defer cancel()
 
 	cmd := exec.CommandContext(execCtx, args[0], args[1:]...)
 	cmd.Dir = cfg.WorkDir
@@ -66,6 +69,7 @@ func (e *Executor) Execute(ctx context.Context, c *Constrainer, cfg ExecuteConfi
 	return &ExecuteResult{
 		ExitCode: exitCode,
 		Duration: duration,
+		TimedOut: errors.Is(execCtx.Err(), context.DeadlineExceeded),
 	}, nil
 }
 
@@ -2,7 +2,13 @@ package harness
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type Git struct {
@@ -23,8 +29,33 @@ func (g *Git) run(args ...string) error {
 	return nil
 }
 
-func (g *Git) Clone(url, dest string) error {
-	cmd := exec.Command("git", "clone", url, dest)
+func (g *Git) output(args ...string) (string, error) {
+	return g.outputEnv(nil, args...)
+}
+
+// outputEnv runs git with extra KEY=VALUE environment variables.
+func (g *Git) outputEnv(env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %v: %w", args, err)
+	}
+	return string(output), nil
+}
+
+// CloneOptions configures Git.Clone.
+type CloneOptions struct {
+	Reference string // local mirror to borrow objects from (--reference-if-able)
+	Depth     int    // shallow clone depth, 0 for full history
+	Filter    string // partial clone filter, e.g. "blob:none"
+}
+
+func (g *Git) Clone(url, dest string, opts CloneOptions) error {
+	cmd := exec.Command("git", cloneArgs(url, dest, opts)...)
 	cmd.Dir = g.dir
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -33,6 +64,31 @@ func (g *Git) Clone(url, dest string) error {
 	return nil
 }
 
+func cloneArgs(url, dest string, opts CloneOptions) []string {
+	args := []string{"clone"}
+	if opts.Reference != "" {
+		// --dissociate copies borrowed objects so the workspace never depends
+		// on the read-only mirror after the clone finishes
+		args = append(args, "--reference-if-able", opts.Reference, "--dissociate")
+	}
+	if opts.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.Filter != "" {
+		args = append(args, "--filter", opts.Filter)
+	}
+	return append(args, url, dest)
+}
+
+func (g *Git) SetRemoteURL(remote, url string) error {
+	return g.run("remote", "set-url", remote, url)
+}
+
+// Checkout detaches HEAD at rev.
+func (g *Git) Checkout(rev string) error {
+	return g.run("checkout", "--quiet", "--detach", rev)
+}
+
 func (g *Git) CreateBranch(name string) error {
 	return g.run("checkout", "-b", name)
 }
@@ -42,13 +98,203 @@ func (g *Git) AddAll() error {
 }
 
 func (g *Git) Commit(message string) error {
-	return g.run("commit", "-m", message, "--allow-empty")
+	return g.run("commit", "-m", message)
+}
+
+// SetIdentity sets the commit author for this repository only.
+func (g *Git) SetIdentity(name, email string) error {
+	if err := g.run("config", "user.name", name); err != nil {
+		return err
+	}
+	return g.run("config", "user.email", email)
+}
+
+// HasStagedChanges reports whether the index differs from HEAD.
+func (g *Git) HasStagedChanges() (bool, error) {
+	cmd := exec.Command("git", "diff", "--cached", "--quiet")
+	cmd.Dir = g.dir
+	err := cmd.Run()
+	if err == nil {
+		return false, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return true, nil
+	}
+	return false, fmt.Errorf("git diff --cached: %w", err)
+}
+
+// CommitsSince returns the number of commits reachable from HEAD but not
+// from rev.
+func (g *Git) CommitsSince(rev string) (int, error) {
+	out, err := g.output("rev-list", "--count", rev+"..HEAD")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// LogPatch returns the patches of every commit since rev, for scanning
+// history that a net diff would hide.
+func (g *Git) LogPatch(rev string) (string, error) {
+	return g.output("log", "-p", "--no-color", "--no-ext-diff", "-U0", "--format=", rev+"..HEAD")
 }
 
 func (g *Git) Push(branch string) error {
 	return g.run("push", "origin", branch)
 }
 
+// PushHeadTo pushes HEAD to branch on origin, regardless of the local
+// branch name.
+func (g *Git) PushHeadTo(branch string) error {
+	return g.run("push", "origin", "HEAD:refs/heads/"+branch)
+}
+
+// Snapshot records the working tree, including untracked files, as a commit
+// on ref without touching HEAD, the index or the working tree. The commit's
+// parent is the previous snapshot on ref, or HEAD for the first one. It
+// returns "" if nothing changed since the parent.
+func (g *Git) Snapshot(ref, message string) (string, error) {
+	// Stage into a copy of the real index so unchanged files aren't rehashed
+	indexPath, err := g.output("rev-parse", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	indexPath = strings.TrimSpace(indexPath)
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(g.dir, indexPath)
+	}
+	tmp, err := os.CreateTemp("", "agentvm-index-*")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := copyFile(indexPath, tmp.Name()); err != nil {
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("copying index: %w", err)
+		}
+		os.Remove(tmp.Name()) // no index yet, let git start an empty one
+	}
+	env := []string{"GIT_INDEX_FILE=" + tmp.Name()}
+
+	if _, err := g.outputEnv(env, "add", "-A"); err != nil {
+		return "", err
+	}
+	tree, err := g.outputEnv(env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	tree = strings.TrimSpace(tree)
+
+	parent, err := g.output("rev-parse", "--verify", "--quiet", ref)
+	if err != nil {
+		parent, err = g.output("rev-parse", "HEAD")
+		if err != nil {
+			return "", err
+		}
+	}
+	parent = strings.TrimSpace(parent)
+	if parentTree, err := g.output("rev-parse", parent+"^{tree}"); err == nil && strings.TrimSpace(parentTree) == tree {
+		return "", nil
+	}
+
+	// Checkpoints stay inside the VM: use the template's identity without
+	// depending on git config being present
+	identity := []string{
+		"GIT_AUTHOR_NAME=AgentVM", "GIT_AUTHOR_EMAIL=agentvm@localhost",
+		"GIT_COMMITTER_NAME=AgentVM", "GIT_COMMITTER_EMAIL=agentvm@localhost",
+	}
+	commit, err := g.outputEnv(identity, "commit-tree", tree, "-p", parent, "-m", message)
+	if err != nil {
+		return "", err
+	}
+	commit = strings.TrimSpace(commit)
+	if err := g.run("update-ref", ref, commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// HeadCommit returns the commit hash HEAD points to.
+func (g *Git) HeadCommit() (string, error) {
+	out, err := g.output("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// ChangedFiles lists paths that differ between rev and the working tree,
+// including commits made since rev and untracked files. Renames are reported
+// as a deletion plus an addition so both paths are visible.
+func (g *Git) ChangedFiles(rev string) ([]string, error) {
+	tracked, err := g.output("diff", "--name-only", "--no-renames", "-z", rev)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := g.output("ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, p := range strings.Split(tracked+untracked, "\x00") {
+		if p != "" {
+			files = append(files, p)
+		}
+	}
+	return files, nil
+}
+
+// CommittedFiles lists paths touched by any commit since rev, including
+// changes a later commit undid.
+func (g *Git) CommittedFiles(rev string) ([]string, error) {
+	out, err := g.output("log", "--name-only", "--no-renames", "-z", "--format=", rev+"..HEAD")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, p := range strings.Split(out, "\x00") {
+		if p = strings.TrimSpace(p); p != "" && !slices.Contains(files, p) {
+			files = append(files, p)
+		}
+	}
+	return files, nil
+}
+
+// ResetSoft moves HEAD back to rev, keeping the index and working tree, so
+// the commits since rev become staged changes.
+func (g *Git) ResetSoft(rev string) error {
+	return g.run("reset", "--soft", "--quiet", rev)
+}
+
+// StagedDiff returns the diff between rev and the index, i.e. everything
+// that would be pushed after the next commit.
+func (g *Git) StagedDiff(rev string) (string, error) {
+	return g.output("diff", "--cached", "--no-color", "--no-ext-diff", "-U0", rev)
+}
+
+// RestoreFile resets path in the index and working tree to its content at
+// rev, removing it if it did not exist there.
+func (g *Git) RestoreFile(rev, path string) error {
+	if g.run("cat-file", "-e", rev+":"+path) == nil {
+		return g.run("checkout", rev, "--", path)
+	}
+	if err := g.run("rm", "--cached", "--quiet", "--ignore-unmatch", "--", path); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(g.dir, path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RestoreTree checks out every file of rev into the index and working tree
+// without moving HEAD.
+func (g *Git) RestoreTree(rev string) error {
+	return g.run("checkout", rev, "--", ".")
+}
+
 func (g *Git) CurrentBranch() (string, error) {
 	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
 	cmd.Dir = g.dir
@@ -58,3 +304,24 @@ func (g *Git) CurrentBranch() (string, error) {
 	}
 	return string(output), nil
 }
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,34 @@
+package harness
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCloneArgs(t *testing.T) {
+	args := cloneArgs("https://github.com/user/repo", "/ws/repo", CloneOptions{})
+	want := []string{"clone", "https://github.com/user/repo", "/ws/repo"}
+	if strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %v, got %v", want, args)
+	}
+
+	args = cloneArgs("https://github.com/user/repo", "/ws/repo", CloneOptions{
+		Reference: "/mnt/host-shared/repos/repo-abc.git",
+		Depth:     1,
+		Filter:    "blob:none",
+	})
+	joined := strings.Join(args, " ")
+	for _, part := range []string{
+		"--reference-if-able /mnt/host-shared/repos/repo-abc.git",
+		"--dissociate",
+		"--depth 1",
+		"--filter blob:none",
+	} {
+		if !strings.Contains(joined, part) {
+			t.Errorf("expected %q in %v", part, args)
+		}
+	}
+	if args[len(args)-2] != "https://github.com/user/repo" || args[len(args)-1] != "/ws/repo" {
+		t.Errorf("expected url and dest last, got %v", args)
+	}
+}
@@ -0,0 +1,117 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mateo/agentvm/internal/project"
+)
+
+// healthProber runs HTTP probes against the served app.
+type healthProber struct {
+	url    string
+	check  project.HealthCheck
+	client *http.Client
+}
+
+func newHealthProber(port int, hc project.HealthCheck) *healthProber {
+	hc = hc.WithDefaults()
+	return &healthProber{
+		url:   fmt.Sprintf("http://127.0.0.1:%d%s", port, hc.Path),
+		check: hc,
+		client: &http.Client{
+			Timeout: time.Duration(hc.Timeout) * time.Second,
+			// A redirect is an answer; don't chase it off the app.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// probe performs a single check, returning nil when the app is healthy.
+func (p *healthProber) probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if !p.check.Healthy(resp.StatusCode) {
+		return fmt.Errorf("%s returned HTTP %d", p.check.Path, resp.StatusCode)
+	}
+	return nil
+}
+
+// waitReady polls until the first successful probe or StartTimeout expires.
+func (p *healthProber) waitReady(ctx context.Context) error {
+	deadline := time.Now().Add(time.Duration(p.check.StartTimeout) * time.Second)
+	log.Printf("Waiting for %s to become healthy...", p.url)
+
+	var lastErr error
+	for time.Now().Before(deadline) {
+		if lastErr = p.probe(ctx); lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+	return fmt.Errorf("not healthy after %ds: %w", p.check.StartTimeout, lastErr)
+}
+
+// watch probes every Interval until ctx is done, calling onChange with false
+// once FailureThreshold consecutive probes fail and with true when a probe
+// succeeds again.
+func (p *healthProber) watch(ctx context.Context, onChange func(healthy bool, err error)) {
+	ticker := time.NewTicker(time.Duration(p.check.Interval) * time.Second)
+	defer ticker.Stop()
+
+	failures := 0
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := p.probe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			if !healthy {
+				healthy = true
+				onChange(true, nil)
+			}
+			continue
+		}
+		failures++
+		if healthy && failures >= p.check.FailureThreshold {
+			healthy = false
+			onChange(false, err)
+		}
+	}
+}
+
+// watchHealth reports unhealthy/serving transitions of the served app.
+func (d *Daemon) watchHealth(ctx context.Context, p *healthProber) {
+	p.watch(ctx, func(healthy bool, err error) {
+		if healthy {
+			log.Printf("Health check recovered")
+			d.reporter.Report(d.task.AgentID, "serving", "Health check recovered", d.task.Branch)
+			return
+		}
+		log.Printf("Health check failing: %v", err)
+		d.reporter.Report(d.task.AgentID, "unhealthy",
+			fmt.Sprintf("Health check failed %d times: %v", p.check.FailureThreshold, err), d.task.Branch)
+	})
+}
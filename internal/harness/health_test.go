@@ -0,0 +1,78 @@
+package harness
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/project"
+)
+
+func testProber(t *testing.T, status *atomic.Int32, hc project.HealthCheck) *healthProber {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+
+	port, _ := strconv.Atoi(srv.URL[strings.LastIndex(srv.URL, ":")+1:])
+	return newHealthProber(port, hc)
+}
+
+func TestHealthProber_Probe(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	p := testProber(t, &status, project.HealthCheck{Path: "/healthz"})
+
+	if err := p.probe(context.Background()); err != nil {
+		t.Fatalf("expected healthy, got %v", err)
+	}
+	status.Store(http.StatusFound)
+	if err := p.probe(context.Background()); err != nil {
+		t.Errorf("expected redirect to count as healthy, got %v", err)
+	}
+	status.Store(http.StatusInternalServerError)
+	if err := p.probe(context.Background()); err == nil {
+		t.Error("expected 500 to be unhealthy")
+	}
+
+	p.check.Status = http.StatusNoContent
+	status.Store(http.StatusOK)
+	if err := p.probe(context.Background()); err == nil {
+		t.Error("expected 200 to fail when 204 is required")
+	}
+}
+
+func TestHealthProber_Watch(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	p := testProber(t, &status, project.HealthCheck{Path: "/healthz", Interval: 1, FailureThreshold: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	changes := make(chan bool, 4)
+	go p.watch(ctx, func(healthy bool, err error) { changes <- healthy })
+
+	if healthy := <-changes; healthy {
+		t.Fatal("expected unhealthy transition first")
+	}
+	status.Store(http.StatusOK)
+	select {
+	case healthy := <-changes:
+		if !healthy {
+			t.Error("expected recovery transition")
+		}
+	case <-ctx.Done():
+		t.Fatal("no recovery reported")
+	}
+}
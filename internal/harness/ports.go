@@ -0,0 +1,222 @@
+package harness
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const portScanInterval = 15 * time.Second
+
+// systemPorts are well-known VM services never offered for exposure.
+var systemPorts = map[int]bool{
+	22:   true, // sshd
+	53:   true, // systemd-resolved stub
+	111:  true, // rpcbind
+	323:  true, // chronyd
+	631:  true, // cups
+	5355: true, // LLMNR
+}
+
+// systemProcesses are daemons whose sockets are never app ports.
+var systemProcesses = map[string]bool{
+	"sshd":              true,
+	"systemd":           true,
+	"systemd-resolve":   true,
+	"systemd-network":   true,
+	"rpcbind":           true,
+	"chronyd":           true,
+	"cupsd":             true,
+	"lima-guestagent":   true,
+	"containerd":        true,
+	"buildkitd":         true,
+	"agent-harness":     true,
+	"avahi-daemon":      true,
+	"dnsmasq":           true,
+	"systemd-timesyncd": true,
+}
+
+// ListeningPort is a TCP port the VM listens on, as reported to the host.
+type ListeningPort struct {
+	Port     int    `json:"port"`
+	Process  string `json:"process,omitempty"`
+	Loopback bool   `json:"loopback,omitempty"` // bound to localhost only
+}
+
+// listenSocket is one LISTEN entry of /proc/net/tcp{,6}.
+type listenSocket struct {
+	port     int
+	loopback bool
+	inode    string
+}
+
+// parseProcNetTCP returns the listening sockets in a /proc/net/tcp or
+// /proc/net/tcp6 table.
+func parseProcNetTCP(data []byte) []listenSocket {
+	var sockets []listenSocket
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Scan() // header
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 || fields[3] != "0A" { // TCP_LISTEN
+			continue
+		}
+		addr, portHex, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		port, err := strconv.ParseUint(portHex, 16, 16)
+		if err != nil {
+			continue
+		}
+		sockets = append(sockets, listenSocket{
+			port:     int(port),
+			loopback: loopbackHex(addr),
+			inode:    fields[9],
+		})
+	}
+	return sockets
+}
+
+// loopbackHex reports whether a /proc/net address (32-bit words in host
+// byte order) is 127.0.0.0/8, ::1 or an IPv4-mapped loopback address.
+func loopbackHex(addr string) bool {
+	raw, err := hex.DecodeString(addr)
+	if err != nil || len(raw)%4 != 0 {
+		return false
+	}
+	// Undo the little-endian word order
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	switch len(raw) {
+	case 4:
+		return raw[0] == 127
+	case 16:
+		if bytes.Equal(raw[:15], make([]byte, 15)) && raw[15] == 1 {
+			return true
+		}
+		mapped := append(make([]byte, 10), 0xff, 0xff)
+		return bytes.Equal(raw[:12], mapped) && raw[12] == 127
+	}
+	return false
+}
+
+// socketProcesses maps socket inodes to the names of the processes holding
+// them. Sockets of other users' processes are missing unless running as root.
+func socketProcesses(procRoot string) map[string]string {
+	owners := make(map[string]string)
+	fds, _ := filepath.Glob(filepath.Join(procRoot, "[0-9]*", "fd", "*"))
+	for _, fd := range fds {
+		target, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(target, "socket:[") {
+			continue
+		}
+		inode := strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")
+		if _, ok := owners[inode]; ok {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(filepath.Dir(filepath.Dir(fd)), "comm"))
+		if err == nil {
+			owners[inode] = strings.TrimSpace(string(comm))
+		}
+	}
+	return owners
+}
+
+// listeningPorts enumerates the VM's listening TCP ports under procRoot,
+// leaving out system services and the declared serve ports, which are
+// already routed.
+func listeningPorts(procRoot string, declared map[int]bool) []ListeningPort {
+	var sockets []listenSocket
+	for _, table := range []string{"tcp", "tcp6"} {
+		data, err := os.ReadFile(filepath.Join(procRoot, "net", table))
+		if err != nil {
+			continue
+		}
+		sockets = append(sockets, parseProcNetTCP(data)...)
+	}
+	owners := socketProcesses(procRoot)
+
+	byPort := make(map[int]*ListeningPort)
+	for _, s := range sockets {
+		process := owners[s.inode]
+		if declared[s.port] || systemPorts[s.port] || systemProcesses[process] {
+			continue
+		}
+		if s.port < 1024 && s.port != 80 && s.port != 443 {
+			continue
+		}
+		p, ok := byPort[s.port]
+		if !ok {
+			p = &ListeningPort{Port: s.port, Process: process, Loopback: true}
+			byPort[s.port] = p
+		}
+		// Reachable if any of its sockets (v4 or v6) is
+		p.Loopback = p.Loopback && s.loopback
+		if p.Process == "" {
+			p.Process = process
+		}
+	}
+
+	ports := make([]ListeningPort, 0, len(byPort))
+	for _, p := range byPort {
+		ports = append(ports, *p)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Port < ports[j].Port })
+	return ports
+}
+
+// declaredPorts are the task's serve ports, routed through registration.
+func (d *Daemon) declaredPorts() map[int]bool {
+	declared := make(map[int]bool)
+	if d.task.ServeCommand != "" {
+		port := d.task.ServePort
+		if port <= 0 && len(d.task.ServeServices) == 0 {
+			port = 8080
+		}
+		declared[port] = true
+	}
+	for _, svc := range d.task.ServeServices {
+		declared[svc.Port] = true
+	}
+	for _, svc := range d.task.ServeTCP {
+		declared[svc.Port] = true
+	}
+	return declared
+}
+
+// watchPorts reports the VM's listening ports to the host whenever they
+// change, so any of them can be exposed without restarting the agent.
+func (d *Daemon) watchPorts(ctx context.Context) {
+	declared := d.declaredPorts()
+	var last []ListeningPort
+	reported := false
+
+	ticker := time.NewTicker(portScanInterval)
+	defer ticker.Stop()
+	for {
+		ports := listeningPorts("/proc", declared)
+		if !reported || !reflect.DeepEqual(ports, last) {
+			if err := d.reporter.ReportPorts(d.task.AgentID, ports); err != nil {
+				log.Printf("Warning: reporting listening ports: %v", err)
+			} else {
+				last, reported = ports, true
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
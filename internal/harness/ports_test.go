@@ -0,0 +1,72 @@
+package harness
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1003 1 0000000000000000 100 0 0 10 0
+   3: 0F02000A:1F90 0202000A:C350 01 00000000:00000000 00:00000000 00000000  1000        0 1004 1 0000000000000000 100 0 0 10 0
+   4: 00000000:0BB8 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1005 1 0000000000000000 100 0 0 10 0
+`
+
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:1538 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 2001 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000000000000:1770 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 2002 1 0000000000000000 100 0 0 10 0
+   2: 0000000000000000FFFF00000100007F:2328 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 2003 1 0000000000000000 100 0 0 10 0
+`
+
+func TestParseProcNetTCP(t *testing.T) {
+	got := parseProcNetTCP([]byte(procNetTCP))
+	want := []listenSocket{
+		{port: 22, inode: "1001"},
+		{port: 5432, loopback: true, inode: "1002"},
+		{port: 8080, inode: "1003"},
+		{port: 3000, inode: "1005"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tcp: got %+v, want %+v", got, want)
+	}
+
+	got = parseProcNetTCP([]byte(procNetTCP6))
+	want = []listenSocket{
+		{port: 5432, loopback: true, inode: "2001"},
+		{port: 6000, inode: "2002"},
+		{port: 9000, loopback: true, inode: "2003"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tcp6: got %+v, want %+v", got, want)
+	}
+}
+
+func TestListeningPorts(t *testing.T) {
+	proc := t.TempDir()
+	os.MkdirAll(filepath.Join(proc, "net"), 0755)
+	os.WriteFile(filepath.Join(proc, "net", "tcp"), []byte(procNetTCP), 0644)
+	os.WriteFile(filepath.Join(proc, "net", "tcp6"), []byte(procNetTCP6), 0644)
+
+	// Process 42 is a vite dev server holding the port 3000 socket
+	fdDir := filepath.Join(proc, "42", "fd")
+	os.MkdirAll(fdDir, 0755)
+	os.WriteFile(filepath.Join(proc, "42", "comm"), []byte("node\n"), 0644)
+	if err := os.Symlink("socket:[1005]", filepath.Join(fdDir, "3")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 22 is a system port and 8080 is the declared serve port
+	got := listeningPorts(proc, map[int]bool{8080: true})
+	want := []ListeningPort{
+		{Port: 3000, Process: "node"},
+		{Port: 5432, Loopback: true},
+		{Port: 6000},
+		{Port: 9000, Loopback: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
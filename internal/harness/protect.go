@@ -0,0 +1,88 @@
+package harness
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/mateo/agentvm/internal/project"
+)
+
+// protectedChanges returns the files changed since base, or touched by a
+// commit since base, that match any of the protected path globs. It also
+// reports whether any of them are in the tool's commits.
+func protectedChanges(git *Git, base string, globs []string) ([]string, bool, error) {
+	if len(globs) == 0 {
+		return nil, false, nil
+	}
+	changed, err := git.ChangedFiles(base)
+	if err != nil {
+		return nil, false, fmt.Errorf("listing changed files: %w", err)
+	}
+	committed, err := git.CommittedFiles(base)
+	if err != nil {
+		return nil, false, fmt.Errorf("listing committed files: %w", err)
+	}
+	for _, f := range committed {
+		if !slices.Contains(changed, f) {
+			changed = append(changed, f)
+		}
+	}
+
+	var violations []string
+	inCommits := false
+	for _, f := range changed {
+		for _, g := range globs {
+			if project.MatchGlob(g, f) {
+				violations = append(violations, f)
+				inCommits = inCommits || slices.Contains(committed, f)
+				break
+			}
+		}
+	}
+	return violations, inCommits, nil
+}
+
+// enforceProtectedPaths checks the workspace against the task's protected
+// paths before anything is staged. Depending on the policy, offending files
+// are restored to base or the task fails with a policy_violation state.
+// Violations are recorded in the report either way.
+//
+// Restoring a file only fixes the working tree, so when the tool committed
+// protected changes its commits are squashed into the harness's commit
+// first; otherwise they would still be pushed.
+func (d *Daemon) enforceProtectedPaths(git *Git, base string, report *Report) error {
+	violations, inCommits, err := protectedChanges(git, base, d.task.ProtectedPaths)
+	if err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Protected path check failed: %v", err), d.task.Branch)
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	report.ProtectedViolations = violations
+
+	if d.task.ProtectPolicy == project.PolicyFail {
+		msg := fmt.Sprintf("Protected paths modified: %s", strings.Join(violations, ", "))
+		d.writeReport(report)
+		d.reporter.Report(d.task.AgentID, "policy_violation", msg, d.task.Branch)
+		return fmt.Errorf("protected paths modified: %s", strings.Join(violations, ", "))
+	}
+
+	if inCommits {
+		if err := git.ResetSoft(base); err != nil {
+			d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Squashing tool commits failed: %v", err), d.task.Branch)
+			return err
+		}
+		log.Println("Squashed tool commits touching protected paths")
+	}
+	for _, f := range violations {
+		if err := git.RestoreFile(base, f); err != nil {
+			d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Reverting protected path %s failed: %v", f, err), d.task.Branch)
+			return err
+		}
+	}
+	log.Printf("Reverted changes to protected paths: %s", strings.Join(violations, ", "))
+	return nil
+}
@@ -0,0 +1,175 @@
+package harness
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mateo/agentvm/internal/orchestrator"
+	"github.com/mateo/agentvm/internal/project"
+)
+
+func initTestRepo(t *testing.T, files map[string]string) (*Git, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		writeTestFile(t, dir, name, content)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git := NewGit(dir)
+	base, err := git.HeadCommit()
+	if err != nil {
+		t.Fatalf("HeadCommit failed: %v", err)
+	}
+	return git, base
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	os.MkdirAll(filepath.Dir(p), 0755)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addTestOrigin gives git a bare origin to push to and returns its path.
+func addTestOrigin(t *testing.T, git *Git) string {
+	t.Helper()
+	origin := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "--bare", origin},
+		{"-C", git.dir, "remote", "add", "origin", origin},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git.SetIdentity("test", "test@example.com")
+	return origin
+}
+
+func TestProtectedChanges(t *testing.T) {
+	git, base := initTestRepo(t, map[string]string{
+		".github/workflows/ci.yml": "on: push",
+		"package-lock.json":        "{}",
+		"src/main.go":              "package main",
+	})
+
+	writeTestFile(t, git.dir, ".github/workflows/ci.yml", "on: pull_request")
+	writeTestFile(t, git.dir, ".github/workflows/deploy.yml", "on: push")
+	writeTestFile(t, git.dir, "src/main.go", "package main // changed")
+	os.Remove(filepath.Join(git.dir, "package-lock.json"))
+
+	got, _, err := protectedChanges(git, base, []string{".github/workflows/**", "package-lock.json"})
+	if err != nil {
+		t.Fatalf("protectedChanges failed: %v", err)
+	}
+	sort.Strings(got)
+	want := []string{".github/workflows/ci.yml", ".github/workflows/deploy.yml", "package-lock.json"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	none, _, err := protectedChanges(git, base, nil)
+	if err != nil || len(none) != 0 {
+		t.Errorf("expected no violations without globs, got %v (%v)", none, err)
+	}
+}
+
+func TestGit_RestoreFile(t *testing.T) {
+	git, base := initTestRepo(t, map[string]string{
+		"migrations/001.sql": "create table a;",
+	})
+
+	writeTestFile(t, git.dir, "migrations/001.sql", "drop table a;")
+	writeTestFile(t, git.dir, "migrations/002.sql", "create table b;")
+	git.AddAll()
+
+	for _, f := range []string{"migrations/001.sql", "migrations/002.sql"} {
+		if err := git.RestoreFile(base, f); err != nil {
+			t.Fatalf("RestoreFile(%s) failed: %v", f, err)
+		}
+	}
+
+	data, _ := os.ReadFile(filepath.Join(git.dir, "migrations", "001.sql"))
+	if string(data) != "create table a;" {
+		t.Errorf("expected original content, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(git.dir, "migrations", "002.sql")); !os.IsNotExist(err) {
+		t.Error("expected added file to be removed")
+	}
+	changed, err := git.ChangedFiles(base)
+	if err != nil || len(changed) != 0 {
+		t.Errorf("expected clean tree after restore, got %v (%v)", changed, err)
+	}
+}
+
+func TestDaemon_PublishRevertsCommittedProtectedPaths(t *testing.T) {
+	git, base := initTestRepo(t, map[string]string{
+		".github/workflows/ci.yml": "on: push",
+		"src/main.go":              "package main",
+	})
+	origin := addTestOrigin(t, git)
+
+	// The tool commits a protected file along with its real change, then
+	// leaves another protected change uncommitted
+	writeTestFile(t, git.dir, ".github/workflows/ci.yml", "on: pull_request")
+	writeTestFile(t, git.dir, "src/main.go", "package main // changed")
+	git.AddAll()
+	git.Commit("tool commit")
+	writeTestFile(t, git.dir, ".github/workflows/deploy.yml", "on: push")
+
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer host.Close()
+	d := &Daemon{
+		task: &orchestrator.TaskConfig{
+			AgentID:        "agent-1",
+			Branch:         "agent/fix",
+			Prompt:         "Fix main",
+			ProtectedPaths: []string{".github/workflows/**"},
+			ProtectPolicy:  project.PolicyRevert,
+		},
+		reporter: NewReporter(host.URL),
+		auth:     &GitAuth{},
+	}
+	report := &Report{}
+	if err := d.publish(git, base, report, "agent/fix", false); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+
+	if len(report.ProtectedViolations) != 2 {
+		t.Errorf("expected 2 violations, got %v", report.ProtectedViolations)
+	}
+	pushed := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", origin}, args...)...).Output()
+		if err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if got := pushed("show", "agent/fix:.github/workflows/ci.yml"); got != "on: push" {
+		t.Errorf("protected change was pushed: %q", got)
+	}
+	if got := pushed("show", "agent/fix:src/main.go"); got != "package main // changed" {
+		t.Errorf("tool change was lost: %q", got)
+	}
+	if files := pushed("log", "--name-only", "--format=", base+"..agent/fix"); strings.Contains(files, ".github") {
+		t.Errorf("protected paths remain in the pushed history: %s", files)
+	}
+}
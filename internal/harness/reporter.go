@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/mateo/agentvm/internal/project"
 )
 
 type Reporter struct {
@@ -33,7 +35,22 @@ func (r *Reporter) Report(agentID, state, message, branch string) {
 	if branch != "" {
 		payload["branch"] = branch
 	}
+	r.sendStatus(payload)
+}
 
+// ReportRestart reports that the serve process is restarting, along with the
+// number of restarts so far.
+func (r *Reporter) ReportRestart(agentID, message, branch string, restarts int) {
+	r.sendStatus(map[string]interface{}{
+		"agentID":  agentID,
+		"state":    "restarting",
+		"message":  message,
+		"branch":   branch,
+		"restarts": restarts,
+	})
+}
+
+func (r *Reporter) sendStatus(payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("Failed to marshal status report: %v", err)
@@ -53,14 +70,26 @@ func (r *Reporter) Report(agentID, state, message, branch string) {
 	}
 }
 
-func (r *Reporter) Register(agentID, vmName, vmIP, project, tool string, ports []int) error {
+func (r *Reporter) Register(agentID, vmName, vmIP, projectName, tool string, ports []int, services []project.Service, tcp []project.TCPService, health *project.HealthCheck, alias string) error {
 	payload := map[string]interface{}{
-		"agentID": agentID,
-		"vmName":  vmName,
-		"vmIP":    vmIP,
-		"project": project,
-		"tool":    tool,
-		"ports":   ports,
+		"agentID":  agentID,
+		"vmName":   vmName,
+		"vmIP":     vmIP,
+		"project":  projectName,
+		"tool":     tool,
+		"ports":    ports,
+		"services": services,
+		"tcp":      tcp,
+		"alias":    alias,
+	}
+	if health != nil {
+		hc := health.WithDefaults()
+		payload["health"] = map[string]interface{}{
+			"path":     hc.Path,
+			"status":   hc.Status,
+			"interval": hc.Interval,
+			"timeout":  hc.Timeout,
+		}
 	}
 
 	data, err := json.Marshal(payload)
@@ -80,3 +109,54 @@ func (r *Reporter) Register(agentID, vmName, vmIP, project, tool string, ports [
 	}
 	return nil
 }
+
+// ReportPorts sends the ports the VM currently listens on.
+func (r *Reporter) ReportPorts(agentID string, ports []ListeningPort) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"agentID": agentID,
+		"ports":   ports,
+	})
+	if err != nil {
+		return fmt.Errorf("marshaling ports report: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/ports", r.baseURL)
+	resp, err := r.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("ports report failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ports report returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RecordHistory stores a named JSON document in the host-side task history.
+func (r *Reporter) RecordHistory(agentID, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling %s: %w", name, err)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"agentID": agentID,
+		"name":    name,
+		"data":    json.RawMessage(data),
+	})
+	if err != nil {
+		return fmt.Errorf("marshaling history request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/history", r.baseURL)
+	resp, err := r.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("history request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("history returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
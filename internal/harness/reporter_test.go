@@ -0,0 +1,33 @@
+package harness
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mateo/agentvm/internal/project"
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+func TestReporter_RegisterHealth(t *testing.T) {
+	var got registry.RegisterRequest
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding register request: %v", err)
+		}
+	}))
+	defer host.Close()
+
+	health := &project.HealthCheck{Path: "/healthz", Status: 204}
+	err := NewReporter(host.URL).Register("agent-1", "vm-1", "10.0.0.5", "shop", "claude-code",
+		[]int{3000}, nil, nil, health, "")
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	want := registry.HealthCheck{Path: "/healthz", Status: 204, Interval: 10, Timeout: 3}
+	if got.Health == nil || *got.Health != want {
+		t.Errorf("registry received health %+v, want %+v", got.Health, want)
+	}
+}
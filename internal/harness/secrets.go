@@ -0,0 +1,355 @@
+package harness
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mateo/agentvm/internal/orchestrator"
+	"github.com/mateo/agentvm/internal/project"
+)
+
+// SecretFinding is a potential secret in the agent's diff. Snippet never
+// contains the matched value in full.
+type SecretFinding struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Rule    string `json:"rule"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+func (f SecretFinding) String() string {
+	loc := f.File
+	if f.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", f.File, f.Line)
+	}
+	if f.Snippet == "" {
+		return fmt.Sprintf("%s %s", loc, f.Rule)
+	}
+	return fmt.Sprintf("%s %s (%s)", loc, f.Rule, f.Snippet)
+}
+
+var secretPatterns = []struct {
+	rule string
+	re   *regexp.Regexp
+}{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"anthropic-key", regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{20,}`)},
+	{"openai-key", regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_-]{32,}`)},
+	{"slack-token", regexp.MustCompile(`\bxox[baprs]-[A-Za-z0-9-]{10,}`)},
+	{"stripe-key", regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{20,}`)},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+}
+
+// highEntropyToken matches candidate strings for the entropy check.
+var highEntropyToken = regexp.MustCompile(`[A-Za-z0-9+/=_-]{32,}`)
+
+const entropyThreshold = 4.5
+
+// Files whose content is expected to be full of hashes; only pattern and
+// literal matches are reported for them.
+var lockfiles = map[string]bool{
+	"package-lock.json": true,
+	"yarn.lock":         true,
+	"pnpm-lock.yaml":    true,
+	"go.sum":            true,
+	"Cargo.lock":        true,
+	"poetry.lock":       true,
+	"Gemfile.lock":      true,
+	"composer.lock":     true,
+}
+
+// secretScanner finds secrets in added lines of a unified diff.
+type secretScanner struct {
+	literals map[string]string // secret value -> rule, e.g. "env:API_TOKEN"
+}
+
+// harnessSecretEnv names the credentials the harness environment may hold.
+// Only these are taken from it: other variables named like credentials,
+// such as SSH_AUTH_SOCK or *_TOKEN_URL, hold paths and URLs a repo can
+// legitimately contain.
+var harnessSecretEnv = []string{"GITHUB_TOKEN", "GH_TOKEN", "GITLAB_TOKEN", "ANTHROPIC_API_KEY", "OPENAI_API_KEY"}
+
+// newSecretScanner builds a scanner that also flags the literal values of
+// the task's env vars that look like credentials, and of the
+// harnessSecretEnv variables in environ (KEY=VALUE form, as os.Environ).
+func newSecretScanner(taskEnv map[string]string, environ []string) *secretScanner {
+	s := &secretScanner{literals: make(map[string]string)}
+	for k, v := range taskEnv {
+		if len(v) >= 8 && (secretName(k) || len(v) >= 16 && shannonEntropy(v) >= 3.5) {
+			s.literals[v] = "env:" + k
+		}
+	}
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if len(v) >= 8 && slices.Contains(harnessSecretEnv, k) {
+			s.literals[v] = "env:" + k
+		}
+	}
+	return s
+}
+
+// addGitAuth also flags the tokens of the task's git credentials, which
+// agentd injects through a file rather than the environment.
+func (s *secretScanner) addGitAuth(auth *GitAuth) {
+	if auth == nil {
+		return
+	}
+	for _, c := range auth.creds {
+		if c.Type == orchestrator.CredentialToken && len(c.Token) >= 8 {
+			s.literals[c.Token] = "git-credential:" + c.Host
+		}
+	}
+}
+
+// secretName reports whether an env var is named like a credential.
+func secretName(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, hint := range []string{"TOKEN", "SECRET", "KEY", "PASSWORD", "PASSWD", "CREDENTIAL", "AUTH"} {
+		if strings.Contains(upper, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan parses the output of `git diff` and returns findings for added lines
+// and newly added sensitive files.
+func (s *secretScanner) Scan(diff string) []SecretFinding {
+	var findings []SecretFinding
+	file := ""
+	line := 0
+
+	sc := bufio.NewScanner(strings.NewReader(diff))
+	sc.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for sc.Scan() {
+		text := sc.Text()
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = ""
+			} else if sensitiveFile(file) {
+				findings = append(findings, SecretFinding{File: file, Rule: "sensitive-file"})
+			}
+		case strings.HasPrefix(text, "@@ "):
+			line = hunkStart(text)
+		case strings.HasPrefix(text, "+"):
+			if file != "" {
+				findings = append(findings, s.scanLine(file, line, text[1:])...)
+			}
+			line++
+		case strings.HasPrefix(text, " "):
+			line++
+		}
+	}
+	return findings
+}
+
+func (s *secretScanner) scanLine(file string, line int, text string) []SecretFinding {
+	var findings []SecretFinding
+	found := func(rule, match, mask string) {
+		findings = append(findings, SecretFinding{
+			File:    file,
+			Line:    line,
+			Rule:    rule,
+			Snippet: redactLine(text, match, mask),
+		})
+	}
+
+	// Literal values first: an echoed env var is the most certain finding.
+	// Sorted for deterministic output.
+	values := make([]string, 0, len(s.literals))
+	for v := range s.literals {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		if strings.Contains(text, v) {
+			found(s.literals[v], v, "[redacted]")
+			return findings
+		}
+	}
+
+	for _, p := range secretPatterns {
+		if m := p.re.FindString(text); m != "" {
+			found(p.rule, m, redact(m))
+			return findings
+		}
+	}
+
+	if lockfiles[path.Base(file)] {
+		return nil
+	}
+	for _, m := range highEntropyToken.FindAllString(text, -1) {
+		if mixedCharClasses(m) && shannonEntropy(m) >= entropyThreshold {
+			found("high-entropy", m, redact(m))
+			return findings
+		}
+	}
+	return nil
+}
+
+// sensitiveFile reports whether a newly added file is a credential file by
+// name, such as a .env file or a private key.
+func sensitiveFile(file string) bool {
+	base := path.Base(file)
+	switch {
+	case base == ".env":
+		return true
+	case strings.HasPrefix(base, ".env."):
+		switch strings.TrimPrefix(base, ".env.") {
+		case "example", "sample", "template", "dist":
+			return false
+		}
+		return true
+	case base == "id_rsa" || base == "id_ecdsa" || base == "id_ed25519":
+		return true
+	case strings.HasSuffix(base, ".pem") || strings.HasSuffix(base, ".key") || strings.HasSuffix(base, ".p12"):
+		return true
+	}
+	return false
+}
+
+// hunkStart returns the first new-file line number of a hunk header such as
+// "@@ -10,2 +12,3 @@".
+func hunkStart(header string) int {
+	fields := strings.Fields(header)
+	if len(fields) < 3 {
+		return 0
+	}
+	start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+	n, _ := strconv.Atoi(start)
+	return n
+}
+
+// redactLine replaces secret in line with mask and truncates long lines.
+func redactLine(line, secret, mask string) string {
+	line = strings.TrimSpace(strings.ReplaceAll(line, secret, mask))
+	if len(line) > 120 {
+		line = line[:117] + "..."
+	}
+	return line
+}
+
+// redact masks a pattern match, keeping a short prefix so the kind of
+// credential stays identifiable.
+func redact(secret string) string {
+	if len(secret) < 12 {
+		return "********"
+	}
+	return secret[:4] + "********"
+}
+
+func mixedCharClasses(s string) bool {
+	var upper, lower, digit bool
+	for _, r := range s {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= '0' && r <= '9':
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
+
+// shannonEntropy returns the entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(len(s))
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// scanSecrets checks the staged diff against base before commit and push.
+// Findings block the push unless the task's secret scan mode is warn; they
+// are recorded in the report either way.
+func (d *Daemon) scanSecrets(git *Git, base string, report *Report) error {
+	if d.task.SecretScan == project.SecretScanOff {
+		return nil
+	}
+
+	// The net diff covers what the harness will commit; commits the tool
+	// made itself are scanned one by one so a secret added and later removed
+	// doesn't slip into the pushed history.
+	diff, err := git.StagedDiff(base)
+	if err == nil {
+		var history string
+		history, err = git.LogPatch(base)
+		diff += history
+	}
+	if err != nil {
+		d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Secret scan failed: %v", err), d.task.Branch)
+		return err
+	}
+	scanner := newSecretScanner(d.task.EnvVars, os.Environ())
+	scanner.addGitAuth(d.auth)
+	findings := dedupeFindings(scanner.Scan(diff))
+	if len(findings) == 0 {
+		return nil
+	}
+	report.SecretFindings = findings
+
+	summary := summarizeFindings(findings, 5)
+	if d.task.SecretScan == project.SecretScanWarn {
+		log.Printf("Warning: potential secrets in diff: %s", summary)
+		return nil
+	}
+
+	d.writeReport(report)
+	d.reporter.Report(d.task.AgentID, "policy_violation",
+		fmt.Sprintf("Push blocked, %d potential secret(s): %s", len(findings), summary), d.task.Branch)
+	return fmt.Errorf("push blocked: %d potential secret(s) in diff", len(findings))
+}
+
+// dedupeFindings drops repeats of the same secret in the same file, which
+// occur when a tool commit and the net diff both contain it.
+func dedupeFindings(findings []SecretFinding) []SecretFinding {
+	seen := make(map[string]bool)
+	var out []SecretFinding
+	for _, f := range findings {
+		key := f.File + "\x00" + f.Rule + "\x00" + f.Snippet
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, f)
+	}
+	return out
+}
+
+// summarizeFindings joins up to max findings for a status message.
+func summarizeFindings(findings []SecretFinding, max int) string {
+	parts := make([]string, 0, max+1)
+	for i, f := range findings {
+		if i == max {
+			parts = append(parts, fmt.Sprintf("and %d more", len(findings)-max))
+			break
+		}
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, "; ")
+}
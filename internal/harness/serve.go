@@ -0,0 +1,281 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mateo/agentvm/internal/project"
+)
+
+// maxRestartBackoff caps the delay between automatic serve restarts.
+const maxRestartBackoff = time.Minute
+
+// serveProcess is a running serve command.
+type serveProcess struct {
+	cmd  *exec.Cmd
+	done chan error
+}
+
+// serve runs the serve command, registers the app with the host and keeps it
+// running according to the task's restart policy. SIGHUP restarts the
+// process on request (POST /agents/{id}/serve/restart on the host).
+func (d *Daemon) serve(ctx context.Context, repoDir string) error {
+	services := d.task.ServeServices
+	port := d.task.ServePort
+	if len(services) > 0 {
+		port = services[0].Port
+	} else if port <= 0 {
+		port = 8080
+	}
+	ports := []int{port}
+	env := []string{fmt.Sprintf("PORT=%d", port)}
+	for i, svc := range services {
+		if i > 0 {
+			ports = append(ports, svc.Port)
+		}
+		env = append(env, fmt.Sprintf("PORT_%s=%d", serviceEnvName(svc.Name), svc.Port))
+	}
+
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+
+	log.Printf("Starting serve command: %s (ports %v)", d.task.ServeCommand, ports)
+	// "serving" waits until the app is ready
+	d.reporter.Report(d.task.AgentID, "starting", fmt.Sprintf("Starting serve: %s", d.task.ServeCommand), d.task.Branch)
+
+	restarts, autoRestarts := 0, 0
+	registered := false
+	for {
+		// A restart requested while the last one was under way is
+		// satisfied by this one
+		drainSignals(hupCh)
+
+		proc, err := d.startServe(ctx, repoDir, env)
+		if err != nil {
+			d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Serve command failed to start: %v", err), d.task.Branch)
+			return fmt.Errorf("starting serve command: %w", err)
+		}
+
+		var prober *healthProber
+		if d.task.ServeHealth != nil {
+			prober = newHealthProber(port, *d.task.ServeHealth)
+		}
+		if cause, err := d.waitServeReady(ctx, ports, prober); err != nil {
+			proc.stop()
+			// A first start that never gets ready fails the task; after a
+			// restart the restart policy decides
+			if restarts == 0 || !d.restartAllowed(err, autoRestarts) {
+				d.reporter.Report(d.task.AgentID, "failed", cause, d.task.Branch)
+				return err
+			}
+			restarts++
+			autoRestarts++
+			if !d.waitRestart(ctx, cause, restarts, autoRestarts) {
+				return nil
+			}
+			continue
+		}
+
+		log.Printf("Serving on ports %v", ports)
+
+		if !registered {
+			d.registerServe(port, ports, services)
+			registered = true
+		}
+		d.reporter.Report(d.task.AgentID, "serving", fmt.Sprintf("Serving on port %d", port), d.task.Branch)
+
+		healthCtx, stopHealth := context.WithCancel(ctx)
+		if prober != nil {
+			go d.watchHealth(healthCtx, prober)
+		}
+
+		// Block until context is cancelled (systemd stop), a restart is
+		// requested, or the serve process exits
+		select {
+		case <-ctx.Done():
+			stopHealth()
+			log.Println("Context cancelled, stopping serve process")
+			proc.stop()
+			return nil
+
+		case <-hupCh:
+			stopHealth()
+			restarts++
+			log.Printf("Restart requested, restarting serve process (restart %d)", restarts)
+			d.reporter.ReportRestart(d.task.AgentID, "Restart requested", d.task.Branch, restarts)
+			proc.stop()
+
+		case err := <-proc.done:
+			stopHealth()
+			if !d.restartAllowed(err, autoRestarts) {
+				if err != nil {
+					d.reporter.Report(d.task.AgentID, "failed", fmt.Sprintf("Serve process exited: %v", err), d.task.Branch)
+					return fmt.Errorf("serve process exited: %w", err)
+				}
+				d.reporter.Report(d.task.AgentID, "completed", "Serve process exited cleanly", d.task.Branch)
+				return nil
+			}
+
+			restarts++
+			autoRestarts++
+			cause := "Serve process exited cleanly"
+			if err != nil {
+				cause = fmt.Sprintf("Serve process %v", err)
+			}
+			if !d.waitRestart(ctx, cause, restarts, autoRestarts) {
+				return nil
+			}
+		}
+	}
+}
+
+// waitServeReady waits for every port to accept connections (up to 5
+// minutes for docker builds), then for the health check to pass. On failure
+// it also returns a message for the status report.
+func (d *Daemon) waitServeReady(ctx context.Context, ports []int, prober *healthProber) (string, error) {
+	deadline := time.Now().Add(5 * time.Minute)
+	for _, p := range ports {
+		if err := d.waitForPort(ctx, p, time.Until(deadline)); err != nil {
+			return fmt.Sprintf("Port %d never became ready: %v", p, err), err
+		}
+	}
+	if prober != nil {
+		if err := prober.waitReady(ctx); err != nil {
+			return fmt.Sprintf("App never became healthy: %v", err), err
+		}
+	}
+	return "", nil
+}
+
+// restartAllowed reports whether the restart policy and ServeMaxRestarts
+// allow another automatic restart after the serve process failed with err.
+func (d *Daemon) restartAllowed(err error, autoRestarts int) bool {
+	return shouldRestart(d.task.ServeRestart, err) &&
+		(d.task.ServeMaxRestarts <= 0 || autoRestarts < d.task.ServeMaxRestarts)
+}
+
+// waitRestart reports an automatic restart and waits out its backoff. It
+// returns false if ctx ends first.
+func (d *Daemon) waitRestart(ctx context.Context, cause string, restarts, autoRestarts int) bool {
+	delay := restartBackoff(autoRestarts)
+	log.Printf("%s, restarting in %s (restart %d)", cause, delay, restarts)
+	d.reporter.ReportRestart(d.task.AgentID,
+		fmt.Sprintf("%s, restarting in %s", cause, delay), d.task.Branch, restarts)
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// drainSignals discards pending signals on ch.
+func drainSignals(ch <-chan os.Signal) {
+	for {
+		select {
+		case <-ch:
+		default:
+			return
+		}
+	}
+}
+
+// startServe launches the serve command via bash -c (supports pipes, &&,
+// etc.) in its own process group so a restart stops the whole tree.
+func (d *Daemon) startServe(ctx context.Context, repoDir string, env []string) (*serveProcess, error) {
+	cmd := exec.CommandContext(ctx, "bash", "-c", d.task.ServeCommand)
+	cmd.Dir = repoDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = d.taskEnv(env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	proc := &serveProcess{cmd: cmd, done: make(chan error, 1)}
+	go func() {
+		proc.done <- cmd.Wait()
+	}()
+	return proc, nil
+}
+
+// stop sends SIGTERM to the process group and kills it if it hasn't exited
+// after 10 seconds.
+func (p *serveProcess) stop() {
+	pgid := -p.cmd.Process.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(10 * time.Second):
+		syscall.Kill(pgid, syscall.SIGKILL)
+		<-p.done
+	}
+}
+
+// registerServe registers the served app with the host so it gets routed.
+func (d *Daemon) registerServe(port int, ports []int, services []project.Service) {
+	vmIP, err := d.getVMIP()
+	if err != nil {
+		log.Printf("Warning: could not determine VM IP: %v", err)
+		vmIP = "unknown"
+	}
+
+	alias := ""
+	if d.task.ServeAlias != "" {
+		if alias, err = project.ResolveAlias(d.task.ServeAlias, d.task.Branch); err != nil {
+			log.Printf("Warning: not claiming preview alias: %v", err)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	if err := d.reporter.Register(
+		d.task.AgentID,
+		hostname,
+		vmIP,
+		d.task.Project,
+		d.task.Tool,
+		ports,
+		services,
+		d.task.ServeTCP,
+		d.task.ServeHealth,
+		alias,
+	); err != nil {
+		log.Printf("Warning: registration failed: %v", err)
+	} else {
+		log.Printf("Registered with host: %s -> %s:%d", d.task.AgentID, vmIP, port)
+	}
+}
+
+// shouldRestart reports whether the restart policy restarts a serve process
+// that exited with err.
+func shouldRestart(policy string, err error) bool {
+	switch policy {
+	case project.RestartAlways:
+		return true
+	case project.RestartOnFailure:
+		return err != nil
+	default:
+		return false
+	}
+}
+
+// restartBackoff returns the delay before the nth automatic restart: 1s,
+// doubling up to maxRestartBackoff.
+func restartBackoff(n int) time.Duration {
+	if n < 1 {
+		n = 1
+	}
+	if n > 7 {
+		return maxRestartBackoff
+	}
+	return min(time.Second<<(n-1), maxRestartBackoff)
+}
@@ -0,0 +1,127 @@
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/orchestrator"
+	"github.com/mateo/agentvm/internal/project"
+)
+
+func TestShouldRestart(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	tests := []struct {
+		policy string
+		err    error
+		want   bool
+	}{
+		{"", exitErr, false},
+		{project.RestartNever, exitErr, false},
+		{project.RestartOnFailure, exitErr, true},
+		{project.RestartOnFailure, nil, false},
+		{project.RestartAlways, nil, true},
+		{project.RestartAlways, exitErr, true},
+	}
+	for _, tt := range tests {
+		if got := shouldRestart(tt.policy, tt.err); got != tt.want {
+			t.Errorf("shouldRestart(%q, %v) = %v, want %v", tt.policy, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i, w := range want {
+		if got := restartBackoff(i + 1); got != w {
+			t.Errorf("restartBackoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+	if got := restartBackoff(50); got != maxRestartBackoff {
+		t.Errorf("expected backoff capped at %s, got %s", maxRestartBackoff, got)
+	}
+}
+
+func TestServeProcess_StopKillsGroup(t *testing.T) {
+	d := &Daemon{task: &orchestrator.TaskConfig{ServeCommand: "sleep 60 & sleep 60; wait"}}
+	proc, err := d.startServe(context.Background(), t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	proc.stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stop took %s; process group not terminated", elapsed)
+	}
+}
+
+func TestServe_ReadinessFailureAfterRestartUsesPolicy(t *testing.T) {
+	// The app is healthy for the first start only.
+	var probes atomic.Int32
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probes.Add(1) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})}
+	go app.Serve(ln)
+	defer app.Close()
+
+	var mu sync.Mutex
+	var states []string
+	host := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/status" {
+			var report struct{ State string }
+			json.NewDecoder(r.Body).Decode(&report)
+			mu.Lock()
+			states = append(states, report.State)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer host.Close()
+
+	d := &Daemon{
+		task: &orchestrator.TaskConfig{
+			AgentID:          "agent-1",
+			ServeCommand:     "sleep 1; exit 1",
+			ServePort:        ln.Addr().(*net.TCPAddr).Port,
+			ServeRestart:     project.RestartOnFailure,
+			ServeMaxRestarts: 2,
+			ServeHealth:      &project.HealthCheck{Path: "/", Interval: 60, StartTimeout: 1},
+		},
+		reporter: NewReporter(host.URL),
+	}
+	if err := d.serve(context.Background(), t.TempDir()); err == nil {
+		t.Fatal("expected serve to fail once restarts are exhausted")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	restarting := 0
+	for _, s := range states {
+		if s == "restarting" {
+			restarting++
+		}
+	}
+	if restarting != 2 {
+		t.Errorf("expected 2 restarts before giving up, got states %v", states)
+	}
+	if len(states) < 2 || states[0] != "starting" || states[1] != "serving" {
+		t.Errorf("expected starting, then serving once ready, got states %v", states)
+	}
+	if last := states[len(states)-1]; last != "failed" {
+		t.Errorf("expected final state failed, got %s", last)
+	}
+}
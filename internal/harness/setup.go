@@ -0,0 +1,98 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/mateo/agentvm/internal/orchestrator"
+	"github.com/mateo/agentvm/internal/project"
+)
+
+const (
+	setupLogPath        = "/etc/agent-config/setup.log"
+	defaultSetupTimeout = 10 * time.Minute
+)
+
+// commandPlan is a resolved list of shell commands for a harness phase.
+type commandPlan struct {
+	Commands []string
+	Timeout  time.Duration
+}
+
+// planSetup resolves setup commands for the task (already merged with
+// .agentvm.yaml). Devcontainer lifecycle commands, when enabled, run first.
+func planSetup(task *orchestrator.TaskConfig, dc *project.Devcontainer) commandPlan {
+	plan := commandPlan{Timeout: defaultSetupTimeout}
+	if task.SetupTimeout > 0 {
+		plan.Timeout = time.Duration(task.SetupTimeout) * time.Minute
+	}
+	if task.Devcontainer && dc != nil {
+		plan.Commands = append(plan.Commands, dc.Commands()...)
+	}
+	plan.Commands = append(plan.Commands, task.SetupCommands...)
+	return plan
+}
+
+// runSetup executes the setup phase in repoDir.
+func (d *Daemon) runSetup(ctx context.Context, repoDir string) error {
+	var dc *project.Devcontainer
+	if d.task.Devcontainer {
+		var err error
+		dc, err = project.LoadDevcontainer(repoDir)
+		if err != nil {
+			return err
+		}
+		if dc == nil {
+			log.Println("Warning: devcontainer requested but no devcontainer.json found")
+		} else if len(dc.Features) > 0 {
+			log.Printf("Warning: devcontainer features are not installed by the harness, bake them into the master image: %v", dc.Features)
+		}
+	}
+
+	plan := planSetup(d.task, dc)
+	if len(plan.Commands) == 0 {
+		return nil
+	}
+
+	d.reporter.Report(d.task.AgentID, "setup",
+		fmt.Sprintf("Running %d setup command(s)", len(plan.Commands)), d.task.Branch)
+	return d.runCommands(ctx, "setup", repoDir, plan, setupLogPath)
+}
+
+// runCommands runs each command with bash in repoDir under a shared timeout,
+// teeing output to the journal and to logPath.
+func (d *Daemon) runCommands(ctx context.Context, phase, repoDir string, plan commandPlan, logPath string) error {
+	var out io.Writer = os.Stdout
+	if f, err := os.Create(logPath); err == nil {
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, f)
+	} else {
+		log.Printf("Warning: cannot write %s log: %v", phase, err)
+	}
+
+	phaseCtx, cancel := context.WithTimeout(ctx, plan.Timeout)
+	defer cancel()
+
+	for i, c := range plan.Commands {
+		fmt.Fprintf(out, "[%s %d/%d] $ %s\n", phase, i+1, len(plan.Commands), c)
+
+		cmd := exec.CommandContext(phaseCtx, "bash", "-c", c)
+		cmd.Dir = repoDir
+		cmd.Stdout = out
+		cmd.Stderr = out
+		cmd.Env = d.taskEnv()
+
+		if err := cmd.Run(); err != nil {
+			if phaseCtx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("%s timed out after %s running %q", phase, plan.Timeout, c)
+			}
+			return fmt.Errorf("%s command %q: %w", phase, c, err)
+		}
+	}
+	return nil
+}
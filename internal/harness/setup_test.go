@@ -0,0 +1,53 @@
+package harness
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/orchestrator"
+	"github.com/mateo/agentvm/internal/project"
+)
+
+func TestPlanSetup(t *testing.T) {
+	task := &orchestrator.TaskConfig{
+		SetupCommands: []string{"make deps"},
+		SetupTimeout:  20,
+	}
+
+	plan := planSetup(task, nil)
+	if len(plan.Commands) != 1 || plan.Commands[0] != "make deps" {
+		t.Errorf("unexpected commands: %v", plan.Commands)
+	}
+	if plan.Timeout != 20*time.Minute {
+		t.Errorf("expected timeout 20m, got %s", plan.Timeout)
+	}
+}
+
+func TestPlanSetup_Devcontainer(t *testing.T) {
+	dc := &project.Devcontainer{
+		OnCreateCommand:   []string{"make tools"},
+		PostCreateCommand: []string{"npm ci"},
+	}
+	task := &orchestrator.TaskConfig{SetupCommands: []string{"cp .env.example .env"}}
+
+	// Not enabled: devcontainer commands are ignored
+	plan := planSetup(task, dc)
+	if len(plan.Commands) != 1 {
+		t.Errorf("expected devcontainer commands to be skipped, got %v", plan.Commands)
+	}
+
+	task.Devcontainer = true
+	plan = planSetup(task, dc)
+	want := []string{"make tools", "npm ci", "cp .env.example .env"}
+	if len(plan.Commands) != len(want) {
+		t.Fatalf("expected %v, got %v", want, plan.Commands)
+	}
+	for i := range want {
+		if plan.Commands[i] != want[i] {
+			t.Errorf("command[%d]: expected %q, got %q", i, want[i], plan.Commands[i])
+		}
+	}
+	if plan.Timeout != defaultSetupTimeout {
+		t.Errorf("expected default timeout, got %s", plan.Timeout)
+	}
+}
@@ -0,0 +1,66 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store keeps per-task records on the host under ~/.agentvm/history so it is
+// possible to see what actually ran after the VM has been recycled. Each
+// agent gets a directory with one JSON file per record name (e.g. "task",
+// "effective", "report").
+type Store struct {
+	dir string
+}
+
+func NewStore(baseDir string) *Store {
+	return &Store{dir: filepath.Join(baseDir, "history")}
+}
+
+// Write stores v as the named record for agentID, replacing any previous one.
+func (s *Store) Write(agentID, name string, v interface{}) error {
+	if err := validName(agentID); err != nil {
+		return err
+	}
+	if err := validName(name); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling %s record: %w", name, err)
+	}
+
+	dir := filepath.Join(s.dir, agentID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+".json"), data, 0644)
+}
+
+// Create creates a free-form file, such as a session transcript, among
+// agentID's records.
+func (s *Store) Create(agentID, name string) (*os.File, error) {
+	if err := validName(agentID); err != nil {
+		return nil, err
+	}
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.dir, agentID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+// validName rejects identifiers that could escape the history directory.
+func validName(s string) error {
+	if s == "" || s == "." || s == ".." || strings.ContainsAny(s, `/\`) {
+		return fmt.Errorf("invalid history name %q", s)
+	}
+	return nil
+}
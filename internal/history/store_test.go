@@ -0,0 +1,61 @@
+package history
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_Write(t *testing.T) {
+	base := t.TempDir()
+	s := NewStore(base)
+
+	if err := s.Write("agent-1", "task", map[string]string{"tool": "amp"}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := s.Write("agent-1", "task", map[string]string{"tool": "claude"}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(base, "history", "agent-1", "task.json"))
+	if err != nil {
+		t.Fatalf("reading record: %v", err)
+	}
+	var task map[string]string
+	json.Unmarshal(data, &task)
+	if task["tool"] != "claude" {
+		t.Errorf("expected the record replaced, got %v", task)
+	}
+}
+
+func TestStore_RejectsTraversal(t *testing.T) {
+	s := NewStore(t.TempDir())
+	for _, id := range []string{"", "..", "../etc", "a/b"} {
+		if err := s.Write(id, "task", nil); err == nil {
+			t.Errorf("expected error for agent ID %q", id)
+		}
+	}
+	if err := s.Write("agent-1", "../x", nil); err == nil {
+		t.Error("expected error for record name with slash")
+	}
+}
+
+func TestStore_Create(t *testing.T) {
+	s := NewStore(t.TempDir())
+	s.Write("agent-1", "task", map[string]string{"tool": "amp"})
+
+	f, err := s.Create("agent-1", "terminal-1.log")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.WriteString("$ ls\n")
+	f.Close()
+
+	if _, err := s.Create("agent-1", "terminal-1.log"); err == nil {
+		t.Error("expected error creating an existing file")
+	}
+	if _, err := s.Create("agent-1", "../x.log"); err == nil {
+		t.Error("expected error for name with slash")
+	}
+}
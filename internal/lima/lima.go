@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 	"time"
@@ -19,6 +21,7 @@ type Client interface {
 	List(ctx context.Context) ([]Instance, error)
 	Get(ctx context.Context, name string) (*Instance, error)
 	Shell(ctx context.Context, opts ShellOptions) (string, error)
+	Exec(ctx context.Context, opts ExecOptions) error
 	Copy(ctx context.Context, opts CopyOptions) error
 }
 
@@ -205,6 +208,76 @@ func (c *client) Shell(ctx context.Context, opts ShellOptions) (string, error) {
 	return c.run(ctx, args...)
 }
 
+// Exec streams a command in an instance, returning an *ExitError when it
+// exits non-zero and the context's error when cancelled.
+func (c *client) Exec(ctx context.Context, opts ExecOptions) error {
+	if opts.Command == "" {
+		return fmt.Errorf("no command to run in %s", opts.Instance)
+	}
+	cmd := exec.CommandContext(ctx, c.limactlPath, execArgs(opts)...)
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	// Don't let output held open by background processes hold up Wait
+	cmd.WaitDelay = 5 * time.Second
+
+	// Copy stdin ourselves: os/exec would wait for a reader that may never
+	// return, like an idle terminal, before reporting the exit
+	var stdin io.WriteCloser
+	if opts.Stdin != nil {
+		var err error
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("limactl shell %s: %w", opts.Instance, err)
+	}
+	if stdin != nil {
+		go func() {
+			io.Copy(stdin, opts.Stdin)
+			stdin.Close()
+		}()
+	}
+
+	err := cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitCode()}
+	}
+	if err != nil {
+		return fmt.Errorf("limactl shell %s: %w", opts.Instance, err)
+	}
+	return nil
+}
+
+// execArgs builds the limactl arguments for Exec. ssh only allocates a
+// terminal when limactl itself runs on one, so TTY commands are wrapped in
+// script(1) to get a pseudo-terminal in the VM regardless.
+func execArgs(opts ExecOptions) []string {
+	args := []string{"shell", opts.Instance, "--"}
+	command := append([]string{opts.Command}, opts.Args...)
+	if !opts.TTY {
+		return append(args, command...)
+	}
+	line := "exec " + shellJoin(command)
+	if opts.Rows > 0 && opts.Cols > 0 {
+		line = fmt.Sprintf("stty rows %d cols %d; %s", opts.Rows, opts.Cols, line)
+	}
+	return append(args, "script", "-qfec", line, "/dev/null")
+}
+
+// shellJoin quotes args for a POSIX shell.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
 func (c *client) Copy(ctx context.Context, opts CopyOptions) error {
 	var src, dst string
 	switch opts.Direction {
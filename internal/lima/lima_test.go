@@ -1,7 +1,14 @@
 package lima
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -147,3 +154,52 @@ func TestMockClient_StartStop(t *testing.T) {
 		t.Errorf("expected Stopped after stop, got %s", inst.Status)
 	}
 }
+
+func TestExecArgs(t *testing.T) {
+	got := execArgs(ExecOptions{Instance: "vm-1", Command: "git", Args: []string{"status", "--short"}})
+	want := []string{"shell", "vm-1", "--", "git", "status", "--short"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got = execArgs(ExecOptions{Instance: "vm-1", Command: "bash", Args: []string{"-c", "echo 'hi'"}, TTY: true, Rows: 40, Cols: 120})
+	want = []string{"shell", "vm-1", "--", "script", "-qfec", `stty rows 40 cols 120; exec 'bash' '-c' 'echo '\''hi'\'''`, "/dev/null"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestClientExec(t *testing.T) {
+	// A limactl stand-in running the command after "shell <vm> --" locally
+	limactl := filepath.Join(t.TempDir(), "limactl")
+	os.WriteFile(limactl, []byte("#!/bin/sh\nshift 3\nexec \"$@\"\n"), 0755)
+	c := &client{limactlPath: limactl}
+	ctx := context.Background()
+
+	var stdout, stderr bytes.Buffer
+	err := c.Exec(ctx, ExecOptions{
+		Instance: "vm-1",
+		Command:  "sh",
+		Args:     []string{"-c", "cat; echo oops >&2; exit 3"},
+		Stdin:    strings.NewReader("hello"),
+		Stdout:   &stdout,
+		Stderr:   &stderr,
+	})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+	if stdout.String() != "hello" || stderr.String() != "oops\n" {
+		t.Errorf("got stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+
+	// An idle stdin doesn't hold up the exit
+	idle, _ := io.Pipe()
+	start := time.Now()
+	if err := c.Exec(ctx, ExecOptions{Instance: "vm-1", Command: "true", Stdin: idle}); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Exec waited %v for stdin", time.Since(start))
+	}
+}
@@ -9,6 +9,7 @@ import (
 type MockClient struct {
 	Instances map[string]*Instance
 	ShellFn   func(ctx context.Context, opts ShellOptions) (string, error)
+	ExecFn    func(ctx context.Context, opts ExecOptions) error
 	CreateErr error
 	CloneErr  error
 	StartErr  error
@@ -116,6 +117,13 @@ func (m *MockClient) Shell(ctx context.Context, opts ShellOptions) (string, erro
 	return "", nil
 }
 
+func (m *MockClient) Exec(ctx context.Context, opts ExecOptions) error {
+	if m.ExecFn != nil {
+		return m.ExecFn(ctx, opts)
+	}
+	return nil
+}
+
 func (m *MockClient) Copy(ctx context.Context, opts CopyOptions) error {
 	return m.CopyErr
 }
@@ -1,6 +1,10 @@
 package lima
 
-import "time"
+import (
+	"fmt"
+	"io"
+	"time"
+)
 
 type InstanceStatus string
 
@@ -66,3 +70,27 @@ type ShellOptions struct {
 	Args     []string
 	Timeout  time.Duration
 }
+
+// ExecOptions configures a command streamed to and from an instance. Unlike
+// Shell, output is written as it is produced and there is no timeout: the
+// command runs until it exits or the context is cancelled.
+type ExecOptions struct {
+	Instance string
+	Command  string
+	Args     []string
+	Stdin    io.Reader
+	Stdout   io.Writer
+	Stderr   io.Writer
+	TTY      bool // run the command on a pseudo-terminal in the VM
+	Rows     int  // initial terminal size when TTY is set
+	Cols     int
+}
+
+// ExitError is returned by Exec when the command ran but exited non-zero.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
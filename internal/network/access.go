@@ -0,0 +1,351 @@
+package network
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// Preview access modes.
+const (
+	AccessNone  = "none"  // anyone who can reach the router
+	AccessBasic = "basic" // HTTP basic auth with generated credentials
+	AccessToken = "token" // a generated token, exchanged for a signed cookie
+)
+
+// ValidAccessMode reports whether m is a known access mode. An empty mode
+// means AccessNone.
+func ValidAccessMode(m string) bool {
+	return m == "" || m == AccessNone || m == AccessBasic || m == AccessToken
+}
+
+const (
+	// TokenParam is the query parameter carrying a preview token.
+	TokenParam = "agentvm_token"
+	// accessCookie holds the signed proof that a browser presented the token.
+	accessCookie = "agentvm_preview"
+	cookieTTL    = 7 * 24 * time.Hour
+)
+
+// Access holds the generated credentials protecting one agent's previews.
+type Access struct {
+	Mode     string `json:"mode"`
+	Project  string `json:"project"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// AccessStore issues and checks per-agent preview credentials. It is
+// persisted so previews stay protected across agentd restarts.
+type AccessStore struct {
+	hosts
+	aliases aliasClaims // so alias hosts resolve to their owner
+
+	mu     sync.RWMutex
+	path   string
+	secret []byte
+	agents map[string]*Access
+}
+
+type accessFile struct {
+	Secret string             `json:"secret"`
+	Agents map[string]*Access `json:"agents"`
+}
+
+func NewAccessStore(baseDir, domain string) (*AccessStore, error) {
+	a := &AccessStore{
+		hosts:  hosts{domain: domain},
+		path:   filepath.Join(baseDir, "access.json"),
+		agents: make(map[string]*Access),
+	}
+
+	data, err := os.ReadFile(a.path)
+	switch {
+	case err == nil:
+		var f accessFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", a.path, err)
+		}
+		if a.secret, err = hex.DecodeString(f.Secret); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", a.path, err)
+		}
+		if f.Agents != nil {
+			a.agents = f.Agents
+		}
+	case os.IsNotExist(err):
+	default:
+		return nil, fmt.Errorf("reading %s: %w", a.path, err)
+	}
+
+	if len(a.secret) == 0 {
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			return nil, err
+		}
+		if err := a.persistLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Grant generates credentials for an agent. AccessNone (or "") revokes any
+// existing grant and returns nil.
+func (a *AccessStore) Grant(agentID, project, mode string) (*Access, error) {
+	if !ValidAccessMode(mode) {
+		return nil, fmt.Errorf("invalid access mode %q (valid: none, basic, token)", mode)
+	}
+	if mode == "" || mode == AccessNone {
+		return nil, a.Revoke(agentID)
+	}
+
+	acc := &Access{Mode: mode, Project: project}
+	if mode == AccessBasic {
+		acc.Username = "preview"
+		acc.Password = randomString(12)
+	} else {
+		acc.Token = randomString(24)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.agents[agentID] = acc
+	return acc, a.persistLocked()
+}
+
+// Get returns the agent's credentials, or nil if its previews are public.
+func (a *AccessStore) Get(agentID string) *Access {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.agents[agentID]
+}
+
+func (a *AccessStore) Revoke(agentID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.agents[agentID]; !ok {
+		return nil
+	}
+	delete(a.agents, agentID)
+	return a.persistLocked()
+}
+
+// Watch revokes grants when their agent is deregistered and follows the
+// aliases claimed by routed agents. It blocks until ctx is done.
+func (a *AccessStore) Watch(ctx context.Context, store *registry.Store) {
+	ch := store.Subscribe()
+	defer store.Unsubscribe(ch)
+	for _, reg := range store.List() {
+		a.claimAlias(reg)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			switch ev.Type {
+			case registry.EventAgentRegistered, registry.EventAgentUpdated:
+				if ev.Agent != nil {
+					a.claimAlias(ev.Agent)
+				}
+			case registry.EventAgentDeregistered:
+				a.aliases.remove(ev.AgentID)
+				a.Revoke(ev.AgentID)
+			}
+		}
+	}
+}
+
+// claimAlias records reg's alias claim once it is routed, like the routers do.
+func (a *AccessStore) claimAlias(reg *registry.AgentRegistration) {
+	if !reg.Serves() {
+		a.aliases.remove(reg.AgentID)
+		return
+	}
+	a.aliases.set(reg)
+}
+
+// TokenURL appends the agent's token to a preview URL so opening it logs the
+// browser in.
+func TokenURL(previewURL, token string) string {
+	sep := "?"
+	if strings.Contains(previewURL, "?") {
+		sep = "&"
+	}
+	return previewURL + sep + TokenParam + "=" + url.QueryEscape(token)
+}
+
+// Authorize checks a request routed to agentID. When it returns false it
+// has already written the response: a 401 challenge, or for a valid token
+// in the URL a redirect that drops the token and sets the access cookie.
+func (a *AccessStore) Authorize(w http.ResponseWriter, r *http.Request, agentID string) bool {
+	secure := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+	return a.authorize(w, r, agentID, r.Host, r.URL, secure)
+}
+
+// ForwardAuth is the handler for Traefik's forwardAuth middleware: it finds
+// the agent from X-Forwarded-Host and answers 2xx to let the request through.
+func (a *AccessStore) ForwardAuth(w http.ResponseWriter, r *http.Request) {
+	host := r.Header.Get("X-Forwarded-Host")
+	agentID, ok := a.agentForHost(host)
+	if !ok {
+		http.Error(w, "unknown preview host", http.StatusForbidden)
+		return
+	}
+	u, err := url.ParseRequestURI(r.Header.Get("X-Forwarded-Uri"))
+	if err != nil {
+		u = &url.URL{Path: "/"}
+	}
+	if a.authorize(w, r, agentID, host, u, r.Header.Get("X-Forwarded-Proto") == "https") {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// authorize checks a request for u on host. The access cookie is scoped to
+// host, which may be an alias rather than the agent's own host.
+func (a *AccessStore) authorize(w http.ResponseWriter, r *http.Request, agentID, host string, u *url.URL, secure bool) bool {
+	acc := a.Get(agentID)
+	if acc == nil {
+		return true
+	}
+
+	switch acc.Mode {
+	case AccessBasic:
+		user, pass, ok := r.BasicAuth()
+		if ok && equal(user, acc.Username) && equal(pass, acc.Password) {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="agentvm preview %s"`, agentID))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+
+	case AccessToken:
+		if c, err := r.Cookie(accessCookie); err == nil && a.validCookie(c.Value, agentID) {
+			return true
+		}
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && equal(bearer, acc.Token) {
+			return true
+		}
+		q := u.Query()
+		if tok := q.Get(TokenParam); tok != "" && equal(tok, acc.Token) {
+			q.Del(TokenParam)
+			clean := *u
+			clean.RawQuery = q.Encode()
+			http.SetCookie(w, &http.Cookie{
+				Name:     accessCookie,
+				Value:    a.signCookie(agentID, time.Now().Add(cookieTTL)),
+				Domain:   hostname(host),
+				Path:     "/",
+				MaxAge:   int(cookieTTL.Seconds()),
+				HttpOnly: true,
+				Secure:   secure,
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, clean.RequestURI(), http.StatusFound)
+			return false
+		}
+		http.Error(w, "this preview requires its access token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// agentForHost maps a preview hostname (the agent host or a service
+// subdomain of it, or an alias host) back to the agent that owns it.
+func (a *AccessStore) agentForHost(host string) (string, bool) {
+	host = hostname(host)
+	for _, owner := range a.aliases.owners() {
+		if host == a.AliasHost(owner.Alias, owner.Project) {
+			return owner.AgentID, true
+		}
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for agentID, acc := range a.agents {
+		h := a.SubdomainFor(agentID, acc.Project)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return agentID, true
+		}
+	}
+	return "", false
+}
+
+// hostname returns host without its port, lowercased.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func (a *AccessStore) signCookie(agentID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + a.mac(agentID, exp)
+}
+
+func (a *AccessStore) validCookie(value, agentID string) bool {
+	exp, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(a.mac(agentID, exp)))
+}
+
+func (a *AccessStore) mac(agentID, exp string) string {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(agentID + "|" + exp))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (a *AccessStore) persistLocked() error {
+	data, err := json.MarshalIndent(accessFile{Secret: hex.EncodeToString(a.secret), Agents: a.agents}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(a.path, data, 0600)
+}
+
+// htpasswdSHA formats a user for Traefik's basicAuth middleware.
+func htpasswdSHA(user, pass string) string {
+	sum := sha1.Sum([]byte(pass))
+	return user + ":{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)[:n]
+}
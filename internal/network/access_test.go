@@ -0,0 +1,210 @@
+package network
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+func newTestAccess(t *testing.T) *AccessStore {
+	t.Helper()
+	a, err := NewAccessStore(t.TempDir(), "agents.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func authorizeReq(a *AccessStore, req *http.Request, agentID string) (bool, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	return a.Authorize(w, req, agentID), w
+}
+
+func TestAccessStore_GrantPersists(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewAccessStore(dir, "agents.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := a.Grant("agent-1", "p", AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acc.Token) != 24 {
+		t.Errorf("expected 24-char token, got %q", acc.Token)
+	}
+	if _, err := a.Grant("agent-2", "p", "open"); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+
+	reloaded, err := NewAccessStore(dir, "agents.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Get("agent-1"); got == nil || got.Token != acc.Token {
+		t.Errorf("grant not persisted: %+v", got)
+	}
+	cookie := a.signCookie("agent-1", time.Now().Add(time.Hour))
+	if !reloaded.validCookie(cookie, "agent-1") {
+		t.Error("cookie signing secret not persisted")
+	}
+
+	if acc, err := reloaded.Grant("agent-1", "p", AccessNone); acc != nil || err != nil {
+		t.Errorf("expected none to revoke, got %+v %v", acc, err)
+	}
+	if reloaded.Get("agent-1") != nil {
+		t.Error("expected grant revoked")
+	}
+}
+
+func TestAccessStore_Basic(t *testing.T) {
+	a := newTestAccess(t)
+	acc, _ := a.Grant("agent-1", "p", AccessBasic)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if ok, w := authorizeReq(a, req, "agent-1"); ok || w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected 401 challenge, got %v %d", ok, w.Code)
+	}
+
+	req.SetBasicAuth(acc.Username, "wrong")
+	if ok, _ := authorizeReq(a, req, "agent-1"); ok {
+		t.Error("wrong password accepted")
+	}
+	req.SetBasicAuth(acc.Username, acc.Password)
+	if ok, _ := authorizeReq(a, req, "agent-1"); !ok {
+		t.Error("valid credentials rejected")
+	}
+
+	if ok, _ := authorizeReq(a, httptest.NewRequest("GET", "/", nil), "public"); !ok {
+		t.Error("agent without a grant should be public")
+	}
+}
+
+func TestAccessStore_Token(t *testing.T) {
+	a := newTestAccess(t)
+	acc, _ := a.Grant("agent-1", "p", AccessToken)
+
+	if ok, w := authorizeReq(a, httptest.NewRequest("GET", "/", nil), "agent-1"); ok || w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %v %d", ok, w.Code)
+	}
+
+	ok, w := authorizeReq(a, httptest.NewRequest("GET", TokenURL("http://agent-1.p.agents.test/app?x=1", acc.Token), nil), "agent-1")
+	if ok || w.Code != http.StatusFound {
+		t.Fatalf("expected redirect for token URL, got %v %d", ok, w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "/app?x=1" {
+		t.Errorf("expected token dropped from redirect, got %q", loc)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Domain != "agent-1.p.agents.test" {
+		t.Fatalf("expected access cookie for agent host, got %v", cookies)
+	}
+
+	req := httptest.NewRequest("GET", "/app", nil)
+	req.AddCookie(cookies[0])
+	if ok, _ := authorizeReq(a, req, "agent-1"); !ok {
+		t.Error("access cookie rejected")
+	}
+	a.Grant("agent-2", "p", AccessToken)
+	if ok, _ := authorizeReq(a, req, "agent-2"); ok {
+		t.Error("cookie for agent-1 accepted by agent-2")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+acc.Token)
+	if ok, _ := authorizeReq(a, req, "agent-1"); !ok {
+		t.Error("bearer token rejected")
+	}
+}
+
+func TestAccessStore_ForwardAuth(t *testing.T) {
+	a := newTestAccess(t)
+	acc, _ := a.Grant("agent-1", "p", AccessToken)
+
+	forward := func(host, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth/preview", nil)
+		req.Header.Set("X-Forwarded-Host", host)
+		req.Header.Set("X-Forwarded-Uri", uri)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		a.ForwardAuth(w, req)
+		return w
+	}
+
+	if w := forward("agent-1.p.agents.test", "/"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+	w := forward("web.agent-1.p.agents.test", "/?"+TokenParam+"="+acc.Token)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected redirect on service subdomain, got %d", w.Code)
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || !c[0].Secure {
+		t.Errorf("expected secure cookie behind https, got %v", c)
+	}
+	if w := forward("other.p.agents.test", "/"); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for unknown host, got %d", w.Code)
+	}
+
+	a.Revoke("agent-1")
+	if w := forward("agent-1.p.agents.test", "/"); w.Code != http.StatusForbidden {
+		t.Errorf("expected revoked agent host to be unknown, got %d", w.Code)
+	}
+}
+
+func TestAccessStore_AliasHost(t *testing.T) {
+	a := newTestAccess(t)
+	acc, _ := a.Grant("agent-1", "p", AccessToken)
+	a.claimAlias(&registry.AgentRegistration{AgentID: "agent-1", Project: "p", Alias: "main", Ports: []int{3000}})
+
+	forward := func(host, uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth/preview", nil)
+		req.Header.Set("X-Forwarded-Host", host)
+		req.Header.Set("X-Forwarded-Uri", uri)
+		w := httptest.NewRecorder()
+		a.ForwardAuth(w, req)
+		return w
+	}
+
+	if w := forward("main.p.agents.test", "/"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 on alias host, got %d", w.Code)
+	}
+	w := forward("main.p.agents.test", "/?"+TokenParam+"="+acc.Token)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected redirect on alias host, got %d", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Domain != "main.p.agents.test" {
+		t.Fatalf("expected access cookie for the alias host, got %v", cookies)
+	}
+	req := httptest.NewRequest("GET", "/auth/preview", nil)
+	req.Header.Set("X-Forwarded-Host", "main.p.agents.test")
+	req.AddCookie(cookies[0])
+	rec := httptest.NewRecorder()
+	if a.ForwardAuth(rec, req); rec.Code != http.StatusOK {
+		t.Errorf("alias cookie rejected: %d", rec.Code)
+	}
+
+	// Through the built-in proxy, which resolves the alias itself
+	ok, pw := authorizeReq(a, httptest.NewRequest("GET", TokenURL("http://main.p.agents.test/", acc.Token), nil), "agent-1")
+	if c := pw.Result().Cookies(); ok || len(c) != 1 || c[0].Domain != "main.p.agents.test" {
+		t.Errorf("expected alias-scoped cookie from the proxy, got %v", c)
+	}
+
+	// The alias moves to a newer agent, which the old cookie doesn't open
+	a.Grant("agent-2", "p", AccessToken)
+	a.claimAlias(&registry.AgentRegistration{AgentID: "agent-2", Project: "p", Alias: "main", Ports: []int{3000}, RegisteredAt: time.Now()})
+	rec = httptest.NewRecorder()
+	if a.ForwardAuth(rec, req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected the new owner's token to be required, got %d", rec.Code)
+	}
+
+	// Unclaimed aliases are unknown
+	a.aliases.remove("agent-1")
+	a.aliases.remove("agent-2")
+	if w := forward("main.p.agents.test", "/"); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an unclaimed alias, got %d", w.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// AliasClaims groups the registrations claiming each alias, keyed
+// "<alias>.<project>", newest first.
+func AliasClaims(regs []*registry.AgentRegistration) map[string][]*registry.AgentRegistration {
+	claims := make(map[string][]*registry.AgentRegistration)
+	for _, reg := range regs {
+		if reg.Alias != "" {
+			claims[aliasKey(reg)] = append(claims[aliasKey(reg)], reg)
+		}
+	}
+	for _, c := range claims {
+		sort.Slice(c, func(i, j int) bool {
+			if !c[i].RegisteredAt.Equal(c[j].RegisteredAt) {
+				return c[i].RegisteredAt.After(c[j].RegisteredAt)
+			}
+			return c[i].AgentID > c[j].AgentID
+		})
+	}
+	return claims
+}
+
+// stoppedStates are the harness states after which an agent's app no longer
+// runs, so the agent gives up its alias.
+var stoppedStates = map[string]bool{
+	"completed":        true,
+	"no_changes":       true,
+	"failed":           true,
+	"policy_violation": true,
+	"killed":           true,
+}
+
+// AliasOwners maps every claimed alias to the registration owning it: the
+// newest one claiming it that is still serving, so re-running a task moves
+// the alias to the new agent, and killing that agent or its app stopping
+// hands it back. An alias whose claimants have all stopped has no owner.
+func AliasOwners(regs []*registry.AgentRegistration) map[string]*registry.AgentRegistration {
+	owners := make(map[string]*registry.AgentRegistration)
+	for key, c := range AliasClaims(regs) {
+		for _, reg := range c {
+			if !stoppedStates[reg.State] {
+				owners[key] = reg
+				break
+			}
+		}
+	}
+	return owners
+}
+
+func aliasKey(reg *registry.AgentRegistration) string {
+	return reg.Alias + "." + reg.Project
+}
+
+// AliasHost returns the hostname of a project's alias.
+func (h hosts) AliasHost(alias, project string) string {
+	return fmt.Sprintf("%s.%s.%s", alias, project, h.domain)
+}
+
+// AliasURL returns the URL of a project's alias.
+func (h hosts) AliasURL(alias, project string) string {
+	scheme := "https"
+	if h.httpOnly {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, h.AliasHost(alias, project))
+}
+
+// aliasClaims tracks the routed registrations claiming an alias so a router
+// can move aliases as agents come and go.
+type aliasClaims struct {
+	mu   sync.Mutex
+	regs map[string]*registry.AgentRegistration // agent ID -> registration
+}
+
+// set records reg's claim, or drops the agent's claim when it has no alias.
+func (c *aliasClaims) set(reg *registry.AgentRegistration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.regs == nil {
+		c.regs = make(map[string]*registry.AgentRegistration)
+	}
+	if reg.Alias == "" {
+		delete(c.regs, reg.AgentID)
+		return
+	}
+	c.regs[reg.AgentID] = reg
+}
+
+func (c *aliasClaims) remove(agentID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.regs, agentID)
+}
+
+// replace resets the claims to those of regs.
+func (c *aliasClaims) replace(regs []*registry.AgentRegistration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.regs = make(map[string]*registry.AgentRegistration)
+	for _, reg := range regs {
+		if reg.Alias != "" {
+			c.regs[reg.AgentID] = reg
+		}
+	}
+}
+
+func (c *aliasClaims) owners() map[string]*registry.AgentRegistration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	regs := make([]*registry.AgentRegistration, 0, len(c.regs))
+	for _, reg := range c.regs {
+		regs = append(regs, reg)
+	}
+	return AliasOwners(regs)
+}
+
+// sortedKeys returns the alias keys of owners in order.
+func sortedKeys(owners map[string]*registry.AgentRegistration) []string {
+	keys := make([]string, 0, len(owners))
+	for k := range owners {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
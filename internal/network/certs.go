@@ -0,0 +1,59 @@
+package network
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/mateo/agentvm/internal/certs"
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// CertHosts returns the hosts certificates may be issued for: the project,
+// agent and alias hosts of the agents serving previews. Each certificate
+// also covers the host's subdomains.
+func CertHosts(domain string, regs []*registry.AgentRegistration) map[string]bool {
+	h := hosts{domain: domain}
+	known := make(map[string]bool)
+	for _, reg := range regs {
+		if !reg.Serves() {
+			continue
+		}
+		agentHost := strings.ToLower(h.SubdomainFor(reg.AgentID, reg.Project))
+		_, projectHost, _ := strings.Cut(agentHost, ".")
+		known[agentHost] = true
+		known[projectHost] = true
+		if reg.Alias != "" {
+			known[strings.ToLower(h.AliasHost(reg.Alias, reg.Project))] = true
+		}
+	}
+	return known
+}
+
+// WatchCerts removes the certificates of hosts no agent serves anymore,
+// once at start and whenever an agent is deregistered. It blocks until ctx
+// is done.
+func WatchCerts(ctx context.Context, ca *certs.Authority, store *registry.Store, domain string) {
+	ch := store.Subscribe()
+	defer store.Unsubscribe(ch)
+	prune := func() {
+		known := CertHosts(domain, store.List())
+		if pruned := ca.Prune(func(host string) bool { return known[host] }); len(pruned) > 0 {
+			log.Printf("certs: removed certificates of %s", strings.Join(pruned, ", "))
+		}
+	}
+	prune()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if ev.Type == registry.EventAgentDeregistered {
+				prune()
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mateo/agentvm/internal/certs"
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+func TestCertHosts(t *testing.T) {
+	known := CertHosts("agents.test", []*registry.AgentRegistration{
+		{AgentID: "agent-1", Project: "shop", Ports: []int{3000}, Alias: "main"},
+		{AgentID: "agent-2", Project: "blog", State: "executing"}, // not serving
+	})
+	var got []string
+	for host := range known {
+		got = append(got, host)
+	}
+	sort.Strings(got)
+	want := "agent-1.shop.agents.test main.shop.agents.test shop.agents.test"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestTraefikWriter_CertsRenewed(t *testing.T) {
+	baseDir := t.TempDir()
+	ca, err := certs.Load(filepath.Join(baseDir, "certs"), "agents.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := NewTraefikWriter(baseDir, "agents.test")
+	tw.SetCA(ca)
+	regs := []*registry.AgentRegistration{
+		{AgentID: "agent-1", Project: "shop", VMIP: "192.168.64.5", Ports: []int{3000}},
+		{AgentID: "agent-2", Project: "blog", VMIP: "192.168.64.6", Ports: []int{3000}},
+	}
+	for _, reg := range regs {
+		if err := tw.WriteRoute(reg); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(tw.routeFile(reg.AgentID))
+	}
+
+	if err := tw.CertsRenewed(regs, []string{"shop.agents.test"}); err != nil {
+		t.Fatalf("CertsRenewed failed: %v", err)
+	}
+	if _, err := os.Stat(tw.routeFile("agent-1")); err != nil {
+		t.Error("route using the renewed certificate not rewritten")
+	}
+	if _, err := os.Stat(tw.routeFile("agent-2")); !os.IsNotExist(err) {
+		t.Error("route using another certificate rewritten")
+	}
+}
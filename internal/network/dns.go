@@ -1,32 +1,320 @@
 package network
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 )
 
-func WriteDnsmasqConfig(domain string) error {
-	configDir := "/opt/homebrew/etc/dnsmasq.d"
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return fmt.Errorf("creating dnsmasq config dir: %w", err)
+// DNS providers, in detection order on Linux.
+const (
+	DNSNetworkManager  = "networkmanager"   // NetworkManager's dnsmasq plugin
+	DNSSystemdResolved = "systemd-resolved" // per-link routing domain to the built-in responder
+	DNSDnsmasq         = "dnsmasq"          // a system dnsmasq reading /etc/dnsmasq.d
+	DNSMacOS           = "macos"            // Homebrew dnsmasq plus /etc/resolver
+	DNSNone            = "none"             // leave host DNS alone
+)
+
+const (
+	dnsLinkName     = "agentvm0"
+	dnsLinkAddr     = "169.254.53.53/32"
+	dnsmasqConfName = "agentvm.conf"
+)
+
+// DNSTarget is what names under the agents domain should resolve through.
+type DNSTarget struct {
+	Address string // IP every name under the domain resolves to
+	Server  string // host:port of agentd's DNS responder; when set, queries are forwarded to it
+}
+
+// DNSFile is a configuration file a provider installs.
+type DNSFile struct {
+	Path    string
+	Content string
+}
+
+// DNSSetup is the host change a provider needs: files to write, then
+// commands to run. Both usually need root.
+type DNSSetup struct {
+	Provider string
+	Files    []DNSFile
+	Commands []string
+}
+
+// DNSProvider configures the host resolver to send the agents domain to
+// agentvm.
+type DNSProvider interface {
+	Name() string
+	// Setup returns the changes routing domain to target.
+	Setup(domain string, target DNSTarget) (*DNSSetup, error)
+}
+
+// dnsEnv is the view of the host used for detection, replaced in tests.
+type dnsEnv struct {
+	goos     string
+	lookPath func(string) (string, error)
+	active   func(unit string) bool
+	exists   func(path string) bool
+	readFile func(path string) ([]byte, error)
+}
+
+var hostDNSEnv = dnsEnv{
+	goos:     runtime.GOOS,
+	lookPath: exec.LookPath,
+	active: func(unit string) bool {
+		return exec.Command("systemctl", "is-active", "--quiet", unit).Run() == nil
+	},
+	exists: func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	},
+	readFile: os.ReadFile,
+}
+
+// DNSProviders lists the provider names accepted by DNSProviderByName.
+func DNSProviders() []string {
+	return []string{DNSNetworkManager, DNSSystemdResolved, DNSDnsmasq, DNSMacOS, DNSNone}
+}
+
+// DNSProviderByName returns the named provider; "" or "auto" detects one.
+func DNSProviderByName(name string) (DNSProvider, error) {
+	switch name {
+	case "", "auto":
+		return DetectDNSProvider(), nil
+	case DNSNetworkManager:
+		return networkManagerDNS{}, nil
+	case DNSSystemdResolved:
+		return resolvedDNS{}, nil
+	case DNSDnsmasq:
+		return dnsmasqDNS{}, nil
+	case DNSMacOS:
+		return macDNS{}, nil
+	case DNSNone:
+		return noDNS{}, nil
+	}
+	return nil, fmt.Errorf("unknown DNS provider %q (valid: auto, %s)", name, strings.Join(DNSProviders(), ", "))
+}
+
+// DetectDNSProvider picks the provider matching the host's resolver.
+func DetectDNSProvider() DNSProvider {
+	return hostDNSEnv.detect()
+}
+
+func (e dnsEnv) detect() DNSProvider {
+	if e.goos == "darwin" {
+		return macDNS{}
+	}
+	if e.active("NetworkManager") && e.nmUsesDnsmasq() {
+		return networkManagerDNS{}
+	}
+	if e.active("systemd-resolved") {
+		return resolvedDNS{}
+	}
+	if _, err := e.lookPath("dnsmasq"); err == nil && e.exists("/etc/dnsmasq.d") {
+		return dnsmasqDNS{}
+	}
+	return noDNS{}
+}
+
+// nmUsesDnsmasq reports whether NetworkManager is configured with dns=dnsmasq.
+func (e dnsEnv) nmUsesDnsmasq() bool {
+	paths := []string{"/etc/NetworkManager/NetworkManager.conf"}
+	if conf, err := filepath.Glob("/etc/NetworkManager/conf.d/*.conf"); err == nil {
+		paths = append(paths, conf...)
+	}
+	for _, p := range paths {
+		data, err := e.readFile(p)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.ReplaceAll(strings.TrimSpace(line), " ", "") == "dns=dnsmasq" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dnsmasqLine answers the domain directly, or forwards it to the responder.
+func dnsmasqLine(domain string, target DNSTarget) (string, error) {
+	if target.Server != "" {
+		host, port, ok := strings.Cut(target.Server, ":")
+		if !ok {
+			return "", fmt.Errorf("invalid DNS server %q, expected host:port", target.Server)
+		}
+		return fmt.Sprintf("server=/%s/%s#%s\n", domain, host, port), nil
+	}
+	if target.Address == "" {
+		return "", fmt.Errorf("no DNS address or server for %s", domain)
 	}
+	return fmt.Sprintf("address=/%s/%s\n", domain, target.Address), nil
+}
+
+type networkManagerDNS struct{}
+
+func (networkManagerDNS) Name() string { return DNSNetworkManager }
+
+func (networkManagerDNS) Setup(domain string, target DNSTarget) (*DNSSetup, error) {
+	line, err := dnsmasqLine(domain, target)
+	if err != nil {
+		return nil, err
+	}
+	return &DNSSetup{
+		Provider: DNSNetworkManager,
+		Files:    []DNSFile{{Path: "/etc/NetworkManager/dnsmasq.d/" + dnsmasqConfName, Content: line}},
+		Commands: []string{"systemctl reload NetworkManager"},
+	}, nil
+}
+
+// resolvedDNS routes the domain to the built-in responder through a dummy
+// link managed by systemd-networkd, so only that domain leaves the normal
+// resolvers (DNSDefaultRoute=false).
+type resolvedDNS struct{}
+
+func (resolvedDNS) Name() string { return DNSSystemdResolved }
+
+func (resolvedDNS) Setup(domain string, target DNSTarget) (*DNSSetup, error) {
+	if target.Server == "" {
+		return nil, fmt.Errorf("systemd-resolved cannot answer *.%s itself; enable the built-in DNS responder (network.dns.responder)", domain)
+	}
+	netdev := fmt.Sprintf("[NetDev]\nName=%s\nKind=dummy\n", dnsLinkName)
+	network := fmt.Sprintf(`[Match]
+Name=%s
+
+[Link]
+RequiredForOnline=no
+
+[Network]
+Address=%s
+ConfigureWithoutCarrier=yes
+DNS=%s
+Domains=~%s
+DNSDefaultRoute=false
+`, dnsLinkName, dnsLinkAddr, target.Server, domain)
+	return &DNSSetup{
+		Provider: DNSSystemdResolved,
+		Files: []DNSFile{
+			{Path: "/etc/systemd/network/50-agentvm.netdev", Content: netdev},
+			{Path: "/etc/systemd/network/50-agentvm.network", Content: network},
+		},
+		Commands: []string{
+			"systemctl enable --now systemd-networkd",
+			"networkctl reload",
+			"resolvectl flush-caches",
+		},
+	}, nil
+}
+
+type dnsmasqDNS struct{}
+
+func (dnsmasqDNS) Name() string { return DNSDnsmasq }
+
+func (dnsmasqDNS) Setup(domain string, target DNSTarget) (*DNSSetup, error) {
+	line, err := dnsmasqLine(domain, target)
+	if err != nil {
+		return nil, err
+	}
+	return &DNSSetup{
+		Provider: DNSDnsmasq,
+		Files:    []DNSFile{{Path: "/etc/dnsmasq.d/" + dnsmasqConfName, Content: line}},
+		Commands: []string{"systemctl restart dnsmasq"},
+	}, nil
+}
+
+// macDNS is the original macOS setup: Homebrew dnsmasq answers the domain
+// and /etc/resolver sends its queries there.
+type macDNS struct{}
+
+func (macDNS) Name() string { return DNSMacOS }
 
-	config := fmt.Sprintf("address=/%s/127.0.0.1\n", domain)
-	configPath := filepath.Join(configDir, "agentvm.conf")
-	return os.WriteFile(configPath, []byte(config), 0644)
+func (macDNS) Setup(domain string, target DNSTarget) (*DNSSetup, error) {
+	if target.Server != "" {
+		host, port, ok := strings.Cut(target.Server, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid DNS server %q, expected host:port", target.Server)
+		}
+		return &DNSSetup{
+			Provider: DNSMacOS,
+			Files:    []DNSFile{{Path: "/etc/resolver/" + domain, Content: fmt.Sprintf("nameserver %s\nport %s\n", host, port)}},
+		}, nil
+	}
+	line, err := dnsmasqLine(domain, target)
+	if err != nil {
+		return nil, err
+	}
+	return &DNSSetup{
+		Provider: DNSMacOS,
+		Files: []DNSFile{
+			{Path: "/opt/homebrew/etc/dnsmasq.d/" + dnsmasqConfName, Content: line},
+			{Path: "/etc/resolver/" + domain, Content: "nameserver 127.0.0.1\nport 53\n"},
+		},
+		Commands: []string{"brew services restart dnsmasq"},
+	}, nil
 }
 
-func WriteResolverConfig(domain string) error {
-	resolverDir := "/etc/resolver"
-	config := fmt.Sprintf("nameserver 127.0.0.1\nport 53\n")
-	configPath := filepath.Join(resolverDir, domain)
+type noDNS struct{}
 
-	// This requires sudo, so we just generate the content
-	// The setup script handles the actual file creation
-	fmt.Printf("To enable DNS resolution, run:\n")
-	fmt.Printf("  sudo mkdir -p %s\n", resolverDir)
-	fmt.Printf("  echo '%s' | sudo tee %s\n", config, configPath)
+func (noDNS) Name() string { return DNSNone }
 
+func (noDNS) Setup(string, DNSTarget) (*DNSSetup, error) {
+	return &DNSSetup{Provider: DNSNone}, nil
+}
+
+// Instructions renders the setup as shell commands for the operator.
+func (s *DNSSetup) Instructions() string {
+	var b strings.Builder
+	dirs := make(map[string]bool)
+	for _, f := range s.Files {
+		dirs[filepath.Dir(f.Path)] = true
+	}
+	sorted := make([]string, 0, len(dirs))
+	for d := range dirs {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+	for _, d := range sorted {
+		fmt.Fprintf(&b, "sudo mkdir -p %s\n", d)
+	}
+	for _, f := range s.Files {
+		fmt.Fprintf(&b, "sudo tee %s >/dev/null <<'EOF'\n%sEOF\n", f.Path, f.Content)
+	}
+	for _, c := range s.Commands {
+		fmt.Fprintf(&b, "sudo %s\n", c)
+	}
+	return b.String()
+}
+
+// Apply writes the files and runs the commands, through sudo unless already
+// root.
+func (s *DNSSetup) Apply() error {
+	sudo := func(name string, args ...string) *exec.Cmd {
+		if os.Geteuid() == 0 {
+			return exec.Command(name, args...)
+		}
+		return exec.Command("sudo", append([]string{name}, args...)...)
+	}
+	for _, f := range s.Files {
+		if out, err := sudo("mkdir", "-p", filepath.Dir(f.Path)).CombinedOutput(); err != nil {
+			return fmt.Errorf("creating %s: %s: %w", filepath.Dir(f.Path), strings.TrimSpace(string(out)), err)
+		}
+		cmd := sudo("tee", f.Path)
+		cmd.Stdin = strings.NewReader(f.Content)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("writing %s: %s: %w", f.Path, strings.TrimSpace(stderr.String()), err)
+		}
+	}
+	for _, c := range s.Commands {
+		if out, err := sudo("sh", "-c", c).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %s: %w", c, strings.TrimSpace(string(out)), err)
+		}
+	}
 	return nil
 }
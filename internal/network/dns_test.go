@@ -0,0 +1,110 @@
+package network
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func fakeDNSEnv(goos string, active []string, binaries []string, files map[string]string) dnsEnv {
+	has := func(list []string, s string) bool {
+		for _, v := range list {
+			if v == s {
+				return true
+			}
+		}
+		return false
+	}
+	return dnsEnv{
+		goos: goos,
+		lookPath: func(name string) (string, error) {
+			if has(binaries, name) {
+				return "/usr/sbin/" + name, nil
+			}
+			return "", errors.New("not found")
+		},
+		active: func(unit string) bool { return has(active, unit) },
+		exists: func(path string) bool {
+			_, ok := files[path]
+			return ok
+		},
+		readFile: func(path string) ([]byte, error) {
+			if c, ok := files[path]; ok {
+				return []byte(c), nil
+			}
+			return nil, os.ErrNotExist
+		},
+	}
+}
+
+func TestDNSEnv_Detect(t *testing.T) {
+	nmConf := map[string]string{"/etc/NetworkManager/NetworkManager.conf": "[main]\ndns = dnsmasq\n"}
+	tests := []struct {
+		name string
+		env  dnsEnv
+		want string
+	}{
+		{"macos", fakeDNSEnv("darwin", nil, nil, nil), DNSMacOS},
+		{"nm dnsmasq plugin", fakeDNSEnv("linux", []string{"NetworkManager", "systemd-resolved"}, nil, nmConf), DNSNetworkManager},
+		{"nm without plugin", fakeDNSEnv("linux", []string{"NetworkManager", "systemd-resolved"}, nil, nil), DNSSystemdResolved},
+		{"plain dnsmasq", fakeDNSEnv("linux", nil, []string{"dnsmasq"}, map[string]string{"/etc/dnsmasq.d": ""}), DNSDnsmasq},
+		{"nothing", fakeDNSEnv("linux", nil, nil, nil), DNSNone},
+	}
+	for _, tt := range tests {
+		if got := tt.env.detect().Name(); got != tt.want {
+			t.Errorf("%s: detected %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDNSProvider_Setup(t *testing.T) {
+	direct := DNSTarget{Address: "127.0.0.1"}
+	responder := DNSTarget{Address: "127.0.0.1", Server: "127.0.0.1:5353"}
+
+	tests := []struct {
+		provider string
+		target   DNSTarget
+		path     string
+		want     string
+	}{
+		{DNSNetworkManager, direct, "/etc/NetworkManager/dnsmasq.d/agentvm.conf", "address=/agents.test/127.0.0.1\n"},
+		{DNSNetworkManager, responder, "/etc/NetworkManager/dnsmasq.d/agentvm.conf", "server=/agents.test/127.0.0.1#5353\n"},
+		{DNSDnsmasq, direct, "/etc/dnsmasq.d/agentvm.conf", "address=/agents.test/127.0.0.1\n"},
+		{DNSSystemdResolved, responder, "/etc/systemd/network/50-agentvm.network", "DNS=127.0.0.1:5353\nDomains=~agents.test\nDNSDefaultRoute=false\n"},
+		{DNSMacOS, direct, "/etc/resolver/agents.test", "nameserver 127.0.0.1\nport 53\n"},
+		{DNSMacOS, responder, "/etc/resolver/agents.test", "nameserver 127.0.0.1\nport 5353\n"},
+	}
+	for _, tt := range tests {
+		p, err := DNSProviderByName(tt.provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setup, err := p.Setup("agents.test", tt.target)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.provider, err)
+		}
+		var found bool
+		for _, f := range setup.Files {
+			if f.Path == tt.path {
+				found = true
+				if !strings.Contains(f.Content, tt.want) {
+					t.Errorf("%s: %s = %q, want %q", tt.provider, f.Path, f.Content, tt.want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: no file %s in %+v", tt.provider, tt.path, setup.Files)
+		}
+		if !strings.Contains(setup.Instructions(), "sudo tee "+tt.path) {
+			t.Errorf("%s: instructions missing %s:\n%s", tt.provider, tt.path, setup.Instructions())
+		}
+	}
+
+	if _, err := (resolvedDNS{}).Setup("agents.test", direct); err == nil {
+		t.Error("systemd-resolved without the responder should fail")
+	}
+	if _, err := DNSProviderByName("bind"); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
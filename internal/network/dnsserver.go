@@ -0,0 +1,140 @@
+package network
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+const (
+	dnsTypeA   = 1
+	dnsClassIN = 1
+
+	dnsRcodeFormErr = 1
+	dnsRcodeNotImp  = 4
+	dnsRcodeRefused = 5
+
+	dnsTTL = 60
+)
+
+// DNSResponder is a minimal authoritative DNS server answering A queries for
+// the agents domain and every name under it with one address, so previews
+// resolve without dnsmasq. It speaks UDP only and refuses other domains.
+type DNSResponder struct {
+	domain string
+	ip     net.IP
+}
+
+func NewDNSResponder(domain, address string) (*DNSResponder, error) {
+	ip := net.ParseIP(address).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("DNS responder address %q is not an IPv4 address", address)
+	}
+	return &DNSResponder{domain: strings.ToLower(strings.TrimSuffix(domain, ".")), ip: ip}, nil
+}
+
+// ListenAndServe answers queries on the UDP address until ctx is done.
+func (d *DNSResponder) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("DNS responder: %w", err)
+	}
+	return d.Serve(ctx, conn)
+}
+
+func (d *DNSResponder) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 512)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("DNS responder: %w", err)
+		}
+		resp := d.answer(buf[:n])
+		if resp == nil {
+			continue
+		}
+		if _, err := conn.WriteTo(resp, peer); err != nil {
+			log.Printf("DNS responder: reply to %s: %v", peer, err)
+		}
+	}
+}
+
+// answer builds the response to one query message, or nil to drop it.
+func (d *DNSResponder) answer(msg []byte) []byte {
+	if len(msg) < 12 || msg[2]&0x80 != 0 { // too short, or a response
+		return nil
+	}
+	header := func(rcode byte, qdcount, ancount uint16) []byte {
+		h := make([]byte, 12)
+		copy(h[0:2], msg[0:2])
+		h[2] = 0x84 | msg[2]&0x01 // QR, AA, echo RD
+		h[3] = rcode
+		binary.BigEndian.PutUint16(h[4:6], qdcount)
+		binary.BigEndian.PutUint16(h[6:8], ancount)
+		return h
+	}
+
+	if opcode := (msg[2] >> 3) & 0x0f; opcode != 0 {
+		return header(dnsRcodeNotImp, 0, 0)
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return header(dnsRcodeFormErr, 0, 0)
+	}
+	name, end, ok := parseName(msg, 12)
+	if !ok || end+4 > len(msg) {
+		return header(dnsRcodeFormErr, 0, 0)
+	}
+	qtype := binary.BigEndian.Uint16(msg[end : end+2])
+	qclass := binary.BigEndian.Uint16(msg[end+2 : end+4])
+	question := msg[12 : end+4]
+
+	name = strings.ToLower(name)
+	if name != d.domain && !strings.HasSuffix(name, "."+d.domain) {
+		return append(header(dnsRcodeRefused, 1, 0), question...)
+	}
+	if qclass != dnsClassIN || qtype != dnsTypeA {
+		// The name exists but has no records of this type (NODATA).
+		return append(header(0, 1, 0), question...)
+	}
+
+	resp := append(header(0, 1, 1), question...)
+	resp = append(resp, 0xc0, 12) // pointer to the question name
+	resp = binary.BigEndian.AppendUint16(resp, dnsTypeA)
+	resp = binary.BigEndian.AppendUint16(resp, dnsClassIN)
+	resp = binary.BigEndian.AppendUint32(resp, dnsTTL)
+	resp = binary.BigEndian.AppendUint16(resp, 4)
+	return append(resp, d.ip...)
+}
+
+// parseName reads an uncompressed name starting at off, returning it without
+// the trailing dot and the offset after it.
+func parseName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		n := int(msg[off])
+		off++
+		if n == 0 {
+			return strings.Join(labels, "."), off, true
+		}
+		if n > 63 || off+n > len(msg) { // compression is not used in questions
+			return "", 0, false
+		}
+		labels = append(labels, string(msg[off:off+n]))
+		off += n
+	}
+}
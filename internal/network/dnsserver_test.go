@@ -0,0 +1,63 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startResponder(t *testing.T) *net.Resolver {
+	t.Helper()
+	d, err := NewDNSResponder("agents.test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go d.Serve(ctx, conn)
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+}
+
+func TestDNSResponder_Answers(t *testing.T) {
+	r := startResponder(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, name := range []string{"agents.test", "agent-1.myproject.agents.test", "WEB.Agent-1.MyProject.agents.test"} {
+		addrs, err := r.LookupHost(ctx, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sort.Strings(addrs)
+		if strings.Join(addrs, ",") != "127.0.0.1" {
+			t.Errorf("%s: got %v, want [127.0.0.1]", name, addrs)
+		}
+	}
+
+	_, err := r.LookupHost(ctx, "example.com")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Errorf("expected DNS error for a name outside the domain, got %v", err)
+	}
+}
+
+func TestDNSResponder_InvalidAddress(t *testing.T) {
+	if _, err := NewDNSResponder("agents.test", "::1"); err == nil {
+		t.Error("expected error for non-IPv4 address")
+	}
+}
@@ -0,0 +1,68 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mateo/agentvm/internal/pool"
+	"github.com/mateo/agentvm/internal/project"
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// Expose promotes a port of the slot's running agent to a routed preview at
+// <name>.<agent host> without restarting it. name defaults to "port-<port>".
+// Agents that do not serve anything are registered from the slot first. The
+// registration is returned even when only writing the route failed.
+func Expose(store *registry.Store, router Router, slot *pool.VMSlot, port int, name string) (*registry.AgentRegistration, error) {
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("port %d out of range", port)
+	}
+	if name == "" {
+		name = fmt.Sprintf("port-%d", port)
+	}
+
+	var reg registry.AgentRegistration
+	if prev, ok := store.Get(slot.AgentID); ok {
+		reg = *prev
+	} else {
+		if slot.VMIP == "" {
+			return nil, fmt.Errorf("agent %s has no VM address yet", slot.AgentID)
+		}
+		now := time.Now()
+		reg = registry.AgentRegistration{
+			AgentID:       slot.AgentID,
+			VMName:        slot.Name,
+			VMIP:          slot.VMIP,
+			Project:       slot.Project,
+			Tool:          slot.Tool,
+			Branch:        slot.Branch,
+			State:         string(slot.State),
+			RegisteredAt:  now,
+			LastHeartbeat: now,
+		}
+	}
+
+	named := []project.Service{{Name: name, Port: port}}
+	var routed []registry.Service
+	if reg.Serves() {
+		routed = reg.RoutedServices()
+	}
+	for _, svc := range routed {
+		if svc.Port == port {
+			return nil, fmt.Errorf("port %d is already routed", port)
+		}
+		if svc.Name != "" {
+			named = append(named, project.Service{Name: svc.Name, Port: svc.Port})
+		}
+	}
+	if err := project.ValidateServices(named); err != nil {
+		return nil, err
+	}
+
+	reg.Exposed = append(append([]registry.Service(nil), reg.Exposed...), registry.Service{Name: name, Port: port})
+	store.Register(&reg)
+	if err := router.WriteRoute(&reg); err != nil {
+		return &reg, fmt.Errorf("writing route for %s: %w", reg.AgentID, err)
+	}
+	return &reg, nil
+}
@@ -0,0 +1,65 @@
+package network
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mateo/agentvm/internal/pool"
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+func TestExpose(t *testing.T) {
+	store, err := registry.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProxy("agents.test", true)
+	slot := &pool.VMSlot{Name: "vm-1", AgentID: "agent-1", Project: "p", VMIP: "127.0.0.1", State: pool.SlotActive}
+
+	// Not serving: the exposed port alone is routed, at the agent host too
+	port := backend(t, "dev")
+	reg, err := Expose(store, p, slot, port, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Exposed) != 1 || reg.Exposed[0].Name == "" {
+		t.Fatalf("exposed = %+v", reg.Exposed)
+	}
+	if code, _ := proxyGet(t, p, "agent-1.p.agents.test", "/"); code != http.StatusOK {
+		t.Errorf("agent host: got %d", code)
+	}
+	if code, _ := proxyGet(t, p, reg.Exposed[0].Name+".agent-1.p.agents.test", "/"); code != http.StatusOK {
+		t.Errorf("exposed host: got %d", code)
+	}
+
+	if _, err := Expose(store, p, slot, port, "other"); err == nil {
+		t.Error("expected an error exposing a routed port twice")
+	}
+	if _, err := Expose(store, p, slot, port+1, reg.Exposed[0].Name); err == nil {
+		t.Error("expected an error reusing a service name")
+	}
+	if _, err := Expose(store, p, slot, port+1, "Bad_Name"); err == nil {
+		t.Error("expected an error for an invalid name")
+	}
+	if _, err := Expose(store, p, slot, 70000, ""); err == nil {
+		t.Error("expected an error for an out of range port")
+	}
+
+	// A serving agent keeps its primary service and gains the exposed one
+	served := registry.AgentRegistration{AgentID: "agent-2", Project: "p", VMIP: "127.0.0.1", Ports: []int{backend(t, "web")}}
+	store.Register(&served)
+	p.WriteRoute(&served)
+	reg, err = Expose(store, p, &pool.VMSlot{Name: "vm-2", AgentID: "agent-2", Project: "p"}, backend(t, "docs"), "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reg.RoutedServices(); len(got) != 2 || got[0].Port != served.Ports[0] || got[1].Name != "docs" {
+		t.Errorf("routed services = %+v", got)
+	}
+	if _, body := proxyGet(t, p, "agent-2.p.agents.test", "/"); body[:3] != "web" {
+		t.Errorf("primary host served %q", body)
+	}
+	if _, body := proxyGet(t, p, "docs.agent-2.p.agents.test", "/"); body[:4] != "docs" {
+		t.Errorf("docs host served %q", body)
+	}
+}
@@ -0,0 +1,278 @@
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// Proxy is the Router that serves previews from agentd itself: an
+// in-process reverse proxy routing by Host header, with WebSocket upgrades
+// passed through. Routes change as soon as the registry does, without
+// waiting on a file watcher.
+type Proxy struct {
+	hosts
+	access *AccessStore
+
+	mu      sync.RWMutex
+	routes  map[string][]proxyTarget // host -> targets, longest prefix first
+	byAgent map[string][]string      // agent ID -> hosts it owns
+
+	aliases    aliasClaims
+	aliasHosts []string // hosts routed for aliases
+}
+
+// proxyTarget forwards requests under prefix ("" for all paths) to one
+// agent service.
+type proxyTarget struct {
+	agentID string
+	prefix  string
+	backend string
+	proxy   *httputil.ReverseProxy
+}
+
+func NewProxy(domain string, httpOnly bool) *Proxy {
+	return &Proxy{
+		hosts:   hosts{domain: domain, httpOnly: httpOnly},
+		routes:  make(map[string][]proxyTarget),
+		byAgent: make(map[string][]string),
+	}
+}
+
+// SetAccess makes the proxy enforce the agents' preview credentials.
+func (p *Proxy) SetAccess(access *AccessStore) {
+	p.access = access
+}
+
+// WriteRoute installs or replaces the agent's routes, using the same host
+// and path rules as the Traefik configuration.
+func (p *Proxy) WriteRoute(reg *registry.AgentRegistration) error {
+	routes, err := p.routesFor(reg, p.SubdomainFor(reg.AgentID, reg.Project))
+	if err != nil {
+		return err
+	}
+	p.aliases.set(reg)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeLocked(reg.AgentID)
+	for h, targets := range routes {
+		p.routes[h] = targets
+		p.byAgent[reg.AgentID] = append(p.byAgent[reg.AgentID], h)
+	}
+	p.syncAliasesLocked()
+	return nil
+}
+
+// routesFor maps the hosts under host to the agent's services.
+func (p *Proxy) routesFor(reg *registry.AgentRegistration, host string) (map[string][]proxyTarget, error) {
+	routes := make(map[string][]proxyTarget)
+	for i, svc := range reg.RoutedServices() {
+		backend := fmt.Sprintf("http://%s:%d", reg.VMIP, svc.Port)
+		target, err := url.Parse(backend)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: invalid backend %q: %w", reg.AgentID, backend, err)
+		}
+		t := proxyTarget{agentID: reg.AgentID, backend: backend, proxy: newReverseProxy(target)}
+
+		if svc.Path != "" {
+			t.prefix = svc.Path
+			routes[host] = append(routes[host], t)
+			continue
+		}
+		if i == 0 {
+			routes[host] = append(routes[host], t)
+		}
+		if svc.Name != "" {
+			sub := svc.Name + "." + host
+			routes[sub] = append(routes[sub], t)
+		}
+	}
+
+	for _, targets := range routes {
+		sort.SliceStable(targets, func(i, j int) bool { return len(targets[i].prefix) > len(targets[j].prefix) })
+	}
+	return routes, nil
+}
+
+func (p *Proxy) RemoveRoute(agentID string) error {
+	p.aliases.remove(agentID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeLocked(agentID)
+	p.syncAliasesLocked()
+	return nil
+}
+
+// syncAliasesLocked routes every alias host to its current owner, replacing
+// the previous alias routes under the same lock, so requests see the move at
+// once.
+func (p *Proxy) syncAliasesLocked() {
+	for _, h := range p.aliasHosts {
+		delete(p.routes, h)
+	}
+	p.aliasHosts = nil
+	owners := p.aliases.owners()
+	for _, key := range sortedKeys(owners) {
+		owner := owners[key]
+		routes, err := p.routesFor(owner, p.AliasHost(owner.Alias, owner.Project))
+		if err != nil {
+			log.Printf("Proxy: alias %s: %v", key, err)
+			continue
+		}
+		for h, targets := range routes {
+			p.routes[h] = targets
+			p.aliasHosts = append(p.aliasHosts, h)
+		}
+	}
+}
+
+func (p *Proxy) removeLocked(agentID string) {
+	for _, h := range p.byAgent[agentID] {
+		delete(p.routes, h)
+	}
+	delete(p.byAgent, agentID)
+}
+
+// Watch keeps routes in sync with the registry: existing registrations are
+// routed immediately, then registrations and deregistrations as they happen.
+// It blocks until ctx is done.
+func (p *Proxy) Watch(ctx context.Context, store *registry.Store) {
+	ch := store.Subscribe()
+	defer store.Unsubscribe(ch)
+
+	for _, reg := range store.List() {
+		p.apply(registry.StoreEvent{Type: registry.EventAgentRegistered, AgentID: reg.AgentID, Agent: reg})
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			p.apply(ev)
+		}
+	}
+}
+
+func (p *Proxy) apply(ev registry.StoreEvent) {
+	switch ev.Type {
+	case registry.EventAgentRegistered:
+		if ev.Agent == nil || !ev.Agent.Serves() {
+			return
+		}
+		if err := p.WriteRoute(ev.Agent); err != nil {
+			log.Printf("Proxy: failed to route %s: %v", ev.AgentID, err)
+		}
+	case registry.EventAgentUpdated:
+		// A state change can move an alias between claimants
+		p.mu.Lock()
+		p.syncAliasesLocked()
+		p.mu.Unlock()
+	case registry.EventAgentDeregistered:
+		p.RemoveRoute(ev.AgentID)
+	}
+}
+
+// ServeHTTP routes a request by its Host header to the matching agent.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	p.mu.RLock()
+	targets := p.routes[host]
+	p.mu.RUnlock()
+
+	for _, t := range targets {
+		if strings.HasPrefix(r.URL.Path, t.prefix) {
+			if p.access != nil && !p.access.Authorize(w, r, t.agentID) {
+				return
+			}
+			t.proxy.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("no agent is serving %s", host), http.StatusNotFound)
+}
+
+// newReverseProxy forwards to target, keeping the original Host header as
+// Traefik does so apps can build absolute URLs.
+func newReverseProxy(target *url.URL) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Host = pr.In.Host
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Proxy: %s%s -> %s: %v", r.Host, r.URL.Path, target, err)
+			http.Error(w, "agent unreachable", http.StatusBadGateway)
+		},
+	}
+}
+
+// Serve listens on httpPort, and unless the proxy is HTTP-only also on
+// httpsPort with tlsConfig, redirecting plain HTTP to HTTPS. It blocks until
+// ctx is done or a listener fails.
+func (p *Proxy) Serve(ctx context.Context, httpPort, httpsPort int, tlsConfig *tls.Config) error {
+	var servers []*http.Server
+	errCh := make(chan error, 2)
+
+	if p.httpOnly {
+		srv := &http.Server{Addr: fmt.Sprintf(":%d", httpPort), Handler: p}
+		servers = append(servers, srv)
+		go func() { errCh <- srv.ListenAndServe() }()
+	} else {
+		tlsSrv := &http.Server{Addr: fmt.Sprintf(":%d", httpsPort), Handler: p, TLSConfig: tlsConfig}
+		redirect := &http.Server{Addr: fmt.Sprintf(":%d", httpPort), Handler: httpsRedirect(httpsPort)}
+		servers = append(servers, tlsSrv, redirect)
+		go func() { errCh <- tlsSrv.ListenAndServeTLS("", "") }()
+		go func() { errCh <- redirect.ListenAndServe() }()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(shutdownCtx)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func httpsRedirect(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
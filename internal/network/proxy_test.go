@@ -0,0 +1,200 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// backend starts an app answering with its name and returns its port.
+func backend(t *testing.T, name string) int {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", name, r.Host, r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	port, _ := strconv.Atoi(srv.URL[strings.LastIndex(srv.URL, ":")+1:])
+	return port
+}
+
+func proxyGet(t *testing.T, p *Proxy, host, path string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	req.Host = host
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestProxy_Routes(t *testing.T) {
+	p := NewProxy("agents.test", true)
+	reg := &registry.AgentRegistration{
+		AgentID: "agent-1",
+		Project: "myproject",
+		VMIP:    "127.0.0.1",
+		Ports:   []int{1},
+		Services: []registry.Service{
+			{Name: "web", Port: backend(t, "web")},
+			{Name: "api", Port: backend(t, "api")},
+			{Name: "admin", Port: backend(t, "admin"), Path: "/admin"},
+		},
+	}
+	if err := p.WriteRoute(reg); err != nil {
+		t.Fatalf("WriteRoute failed: %v", err)
+	}
+
+	tests := []struct {
+		host, path, want string
+	}{
+		{"agent-1.myproject.agents.test", "/", "web agent-1.myproject.agents.test /"},
+		{"agent-1.myproject.agents.test:80", "/x", "web agent-1.myproject.agents.test:80 /x"},
+		{"web.agent-1.myproject.agents.test", "/", "web "},
+		{"api.agent-1.myproject.agents.test", "/v1", "api "},
+		{"agent-1.myproject.agents.test", "/admin/users", "admin "},
+	}
+	for _, tt := range tests {
+		code, body := proxyGet(t, p, tt.host, tt.path)
+		if code != http.StatusOK || !strings.HasPrefix(body, tt.want) {
+			t.Errorf("%s%s: got %d %q, want prefix %q", tt.host, tt.path, code, body, tt.want)
+		}
+	}
+
+	if code, _ := proxyGet(t, p, "other.myproject.agents.test", "/"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown host, got %d", code)
+	}
+
+	p.RemoveRoute("agent-1")
+	if code, _ := proxyGet(t, p, "agent-1.myproject.agents.test", "/"); code != http.StatusNotFound {
+		t.Errorf("expected 404 after RemoveRoute, got %d", code)
+	}
+}
+
+func TestProxy_WebSocketUpgrade(t *testing.T) {
+	// A minimal upgrade-then-echo backend.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo " + line)
+		rw.Flush()
+	}))
+	defer srv.Close()
+	port, _ := strconv.Atoi(srv.URL[strings.LastIndex(srv.URL, ":")+1:])
+
+	p := NewProxy("agents.test", true)
+	p.WriteRoute(&registry.AgentRegistration{AgentID: "agent-1", Project: "p", VMIP: "127.0.0.1", Ports: []int{port}})
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: agent-1.p.agents.test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 101, got %d: %s", resp.StatusCode, body)
+	}
+
+	fmt.Fprintf(conn, "hello\n")
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "echo hello\n" {
+		t.Errorf("expected echo over upgraded connection, got %q", line)
+	}
+}
+
+func TestProxy_Watch(t *testing.T) {
+	store, err := registry.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Register(&registry.AgentRegistration{AgentID: "agent-1", Project: "p", VMIP: "127.0.0.1", Ports: []int{backend(t, "one")}})
+
+	p := NewProxy("agents.test", true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Watch(ctx, store)
+
+	waitFor := func(host string, code int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if got, _ := proxyGet(t, p, host, "/"); got == code {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s never returned %d", host, code)
+	}
+
+	waitFor("agent-1.p.agents.test", http.StatusOK)
+
+	store.Register(&registry.AgentRegistration{AgentID: "agent-2", Project: "p", VMIP: "127.0.0.1", Ports: []int{backend(t, "two")}})
+	waitFor("agent-2.p.agents.test", http.StatusOK)
+
+	store.Deregister("agent-1")
+	waitFor("agent-1.p.agents.test", http.StatusNotFound)
+}
+
+func TestProxy_Aliases(t *testing.T) {
+	p := NewProxy("agents.test", true)
+	now := time.Now()
+	p.WriteRoute(&registry.AgentRegistration{AgentID: "agent-1", Project: "p", VMIP: "127.0.0.1", Ports: []int{backend(t, "one")}, Alias: "demo", RegisteredAt: now.Add(-time.Minute)})
+	newer := &registry.AgentRegistration{AgentID: "agent-2", Project: "p", VMIP: "127.0.0.1", Ports: []int{backend(t, "two")}, Alias: "demo", State: "serving", RegisteredAt: now}
+	p.WriteRoute(newer)
+
+	if _, body := proxyGet(t, p, "demo.p.agents.test", "/"); !strings.HasPrefix(body, "two ") {
+		t.Errorf("alias served %q, want the newest agent", body)
+	}
+
+	// The newest agent's app stopping hands the alias back, and serving
+	// again takes it over
+	newer.State = "failed"
+	p.apply(registry.StoreEvent{Type: registry.EventAgentUpdated, AgentID: "agent-2", Agent: newer})
+	if _, body := proxyGet(t, p, "demo.p.agents.test", "/"); !strings.HasPrefix(body, "one ") {
+		t.Errorf("alias served %q after the newest agent failed, want it handed back", body)
+	}
+	newer.State = "serving"
+	p.apply(registry.StoreEvent{Type: registry.EventAgentUpdated, AgentID: "agent-2", Agent: newer})
+	if _, body := proxyGet(t, p, "demo.p.agents.test", "/"); !strings.HasPrefix(body, "two ") {
+		t.Errorf("alias served %q after the newest agent recovered", body)
+	}
+	p.RemoveRoute("agent-2")
+	if _, body := proxyGet(t, p, "demo.p.agents.test", "/"); !strings.HasPrefix(body, "one ") {
+		t.Errorf("alias served %q after removal, want it handed back", body)
+	}
+	p.RemoveRoute("agent-1")
+	if code, _ := proxyGet(t, p, "demo.p.agents.test", "/"); code != http.StatusNotFound {
+		t.Errorf("unclaimed alias: got %d, want 404", code)
+	}
+}
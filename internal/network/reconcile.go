@@ -0,0 +1,197 @@
+package network
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// ReconcileReport lists the drift found by one reconciliation pass.
+type ReconcileReport struct {
+	Orphaned  []string `json:"orphaned,omitempty"`  // route files without a live agent, removed
+	Stale     []string `json:"stale,omitempty"`     // registrations whose VM is no longer claimed, deregistered
+	Rewritten []string `json:"rewritten,omitempty"` // routes that differed from the registry, rewritten
+	Moved     []string `json:"moved,omitempty"`     // agents whose VM IP changed
+	Missing   []string `json:"missing,omitempty"`   // registered agents without a route, written
+	Aliases   []string `json:"aliases,omitempty"`   // alias hosts pointing at the wrong agent or unclaimed, fixed
+}
+
+// Drift reports whether the pass found anything to fix.
+func (r *ReconcileReport) Drift() bool {
+	return len(r.Orphaned)+len(r.Stale)+len(r.Rewritten)+len(r.Moved)+len(r.Missing)+len(r.Aliases) > 0
+}
+
+func (r *ReconcileReport) String() string {
+	var parts []string
+	add := func(label string, ids []string) {
+		if len(ids) > 0 {
+			parts = append(parts, label+" "+strings.Join(ids, ","))
+		}
+	}
+	add("orphaned", r.Orphaned)
+	add("stale", r.Stale)
+	add("moved", r.Moved)
+	add("rewritten", r.Rewritten)
+	add("missing", r.Missing)
+	add("aliases", r.Aliases)
+	if len(parts) == 0 {
+		return "no drift"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Reconciler keeps the Traefik dynamic directory in line with the registry
+// and the pool, so routes don't outlive their agents or point at a VM's old
+// address.
+type Reconciler struct {
+	tw       *TraefikWriter
+	store    *registry.Store
+	agents   func() map[string]string // agent ID -> VM name of claimed slots
+	vmIP     func(ctx context.Context, vmName string) (string, error)
+	interval time.Duration
+	stopCh   chan struct{}
+}
+
+func NewReconciler(tw *TraefikWriter, store *registry.Store, agents func() map[string]string, vmIP func(ctx context.Context, vmName string) (string, error), interval time.Duration) *Reconciler {
+	return &Reconciler{
+		tw:       tw,
+		store:    store,
+		agents:   agents,
+		vmIP:     vmIP,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start runs a pass immediately and then every interval.
+func (r *Reconciler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := r.Reconcile(ctx, false); err != nil {
+				log.Printf("Route reconcile failed: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (r *Reconciler) Stop() {
+	close(r.stopCh)
+}
+
+// Reconcile compares route files with the registry and the pool. Unless
+// dryRun is set it removes orphaned routes, deregisters agents whose VM was
+// released, and rewrites routes that are missing or out of date.
+func (r *Reconciler) Reconcile(ctx context.Context, dryRun bool) (*ReconcileReport, error) {
+	// List files before registrations: a route written after this point
+	// belongs to a registration the store already holds.
+	files, err := r.tw.routeFiles()
+	if err != nil {
+		return nil, err
+	}
+	active := r.agents()
+
+	report := &ReconcileReport{}
+	expected := make(map[string]*registry.AgentRegistration)
+	for _, reg := range r.store.List() {
+		vmName, ok := active[reg.AgentID]
+		if !ok {
+			report.Stale = append(report.Stale, reg.AgentID)
+			if !dryRun {
+				r.store.Deregister(reg.AgentID)
+			}
+			continue
+		}
+
+		want := *reg
+		if ip, err := r.vmIP(ctx, vmName); err != nil {
+			log.Printf("Route reconcile: could not get IP of %s: %v", vmName, err)
+		} else if ip != reg.VMIP {
+			report.Moved = append(report.Moved, reg.AgentID)
+			want.VMIP = ip
+			if !dryRun {
+				r.store.UpdateVMIP(reg.AgentID, ip)
+			}
+		}
+		// Agents are registered at dispatch but routed once they serve
+		if want.Serves() {
+			expected[sanitize(reg.AgentID)] = &want
+		}
+	}
+
+	for name, content := range files {
+		want, ok := expected[name]
+		switch {
+		case !ok:
+			report.Orphaned = append(report.Orphaned, name)
+			if !dryRun {
+				if err := os.Remove(r.tw.routeFile(name)); err != nil && !os.IsNotExist(err) {
+					return report, err
+				}
+			}
+		case content != r.tw.renderRoute(want):
+			report.Rewritten = append(report.Rewritten, want.AgentID)
+			if !dryRun {
+				if err := r.rewrite(want); err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+	for name, want := range expected {
+		if _, ok := files[name]; ok {
+			continue
+		}
+		report.Missing = append(report.Missing, want.AgentID)
+		if !dryRun {
+			if err := r.rewrite(want); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	// Aliases follow the registrations still routed; a dry run works out
+	// the owners on a copy so the writer's claims stay as they are
+	routed := make([]*registry.AgentRegistration, 0, len(expected))
+	for _, want := range expected {
+		routed = append(routed, want)
+	}
+	claims := &r.tw.aliases
+	if dryRun {
+		claims = &aliasClaims{}
+	}
+	claims.replace(routed)
+	if report.Aliases, err = r.tw.syncAliasClaims(claims, dryRun); err != nil {
+		return report, err
+	}
+
+	for _, ids := range [][]string{report.Orphaned, report.Stale, report.Rewritten, report.Moved, report.Missing} {
+		sort.Strings(ids)
+	}
+	if report.Drift() {
+		log.Printf("Route reconcile: %s", report)
+	}
+	return report, nil
+}
+
+// rewrite writes a route unless the agent was deregistered (e.g. killed)
+// while the pass was running.
+func (r *Reconciler) rewrite(reg *registry.AgentRegistration) error {
+	if _, ok := r.store.Get(reg.AgentID); !ok {
+		return nil
+	}
+	return r.tw.WriteRoute(reg)
+}
@@ -0,0 +1,174 @@
+package network
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+func TestReconciler_Reconcile(t *testing.T) {
+	baseDir := t.TempDir()
+	tw := NewTraefikWriter(baseDir, "agents.test")
+	store, err := registry.NewStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ok: route matches; moved: VM got a new IP; missing: no route file;
+	// gone: VM released by the monitor; orphan: route with no registration;
+	// task: dispatched agent that doesn't serve, so isn't routed.
+	for _, reg := range []*registry.AgentRegistration{
+		{AgentID: "ok", Project: "p", VMIP: "10.0.0.1", Ports: []int{3000}},
+		{AgentID: "moved", Project: "p", VMIP: "10.0.0.2", Ports: []int{3000}},
+		{AgentID: "missing", Project: "p", VMIP: "10.0.0.3", Ports: []int{3000}},
+		{AgentID: "gone", Project: "p", VMIP: "10.0.0.4", Ports: []int{3000}},
+		{AgentID: "orphan", Project: "p", VMIP: "10.0.0.5", Ports: []int{3000}},
+		{AgentID: "task", Project: "p", VMIP: "10.0.0.6"},
+	} {
+		if reg.AgentID != "orphan" {
+			store.Register(reg)
+		}
+		if reg.AgentID != "missing" && reg.AgentID != "task" {
+			tw.WriteRoute(reg)
+		}
+	}
+
+	agents := func() map[string]string {
+		return map[string]string{"ok": "vm-1", "moved": "vm-2", "missing": "vm-3", "task": "vm-4"}
+	}
+	ips := map[string]string{"vm-1": "10.0.0.1", "vm-2": "10.0.0.22", "vm-3": "10.0.0.3", "vm-4": "10.0.0.6"}
+	vmIP := func(_ context.Context, name string) (string, error) { return ips[name], nil }
+	rec := NewReconciler(tw, store, agents, vmIP, 0)
+
+	dry, err := rec.Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get("gone"); !ok {
+		t.Error("dry run must not deregister")
+	}
+
+	report, err := rec.Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.String() != report.String() {
+		t.Errorf("dry run %q differs from real pass %q", dry, report)
+	}
+
+	check := func(name string, got []string, want ...string) {
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	check("orphaned", report.Orphaned, "gone", "orphan")
+	check("stale", report.Stale, "gone")
+	check("moved", report.Moved, "moved")
+	check("rewritten", report.Rewritten, "moved")
+	check("missing", report.Missing, "missing")
+
+	dynDir := filepath.Join(baseDir, "traefik", "dynamic")
+	for _, name := range []string{"gone", "orphan", "task"} {
+		if _, err := os.Stat(filepath.Join(dynDir, name+".yaml")); !os.IsNotExist(err) {
+			t.Errorf("%s route should not exist", name)
+		}
+	}
+	if _, ok := store.Get("task"); !ok {
+		t.Error("active agent that doesn't serve must stay registered")
+	}
+	content, _ := os.ReadFile(filepath.Join(dynDir, "moved.yaml"))
+	if !strings.Contains(string(content), "10.0.0.22:3000") {
+		t.Errorf("moved route not rewritten:\n%s", content)
+	}
+	if reg, _ := store.Get("moved"); reg.VMIP != "10.0.0.22" {
+		t.Errorf("expected registry IP updated, got %s", reg.VMIP)
+	}
+	if _, ok := store.Get("gone"); ok {
+		t.Error("stale registration should be removed")
+	}
+
+	again, err := rec.Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Drift() {
+		t.Errorf("expected no drift after reconcile, got %s", again)
+	}
+}
+
+func TestReconciler_Aliases(t *testing.T) {
+	baseDir := t.TempDir()
+	store, err := registry.NewStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := &registry.AgentRegistration{AgentID: "old", Project: "p", VMIP: "10.0.0.1", Ports: []int{3000}, Alias: "demo", RegisteredAt: now.Add(-time.Hour)}
+	gone := &registry.AgentRegistration{AgentID: "gone", Project: "p", VMIP: "10.0.0.2", Ports: []int{3000}, Alias: "demo", RegisteredAt: now}
+	store.Register(old)
+
+	// A previous agentd routed the alias to an agent whose VM was released
+	prev := NewTraefikWriter(baseDir, "agents.test")
+	prev.WriteRoute(old)
+	prev.WriteRoute(gone)
+
+	tw := NewTraefikWriter(baseDir, "agents.test")
+	agents := func() map[string]string { return map[string]string{"old": "vm-1"} }
+	vmIP := func(context.Context, string) (string, error) { return "10.0.0.1", nil }
+	report, err := NewReconciler(tw, store, agents, vmIP, 0).Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(report.Aliases, ",") != "demo.p.agents.test" {
+		t.Errorf("aliases: got %v", report.Aliases)
+	}
+	content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "alias.demo.p.yaml"))
+	if !strings.Contains(string(content), "10.0.0.1:3000") {
+		t.Errorf("alias not handed back to the remaining agent:\n%s", content)
+	}
+
+	report, _ = NewReconciler(tw, store, agents, vmIP, 0).Reconcile(context.Background(), false)
+	if report.Drift() {
+		t.Errorf("expected no drift on a second pass, got %s", report)
+	}
+}
+
+func TestReconciler_DryRunKeepsAliasClaims(t *testing.T) {
+	baseDir := t.TempDir()
+	store, err := registry.NewStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := &registry.AgentRegistration{AgentID: "old", Project: "p", VMIP: "10.0.0.1", Ports: []int{3000}, Alias: "demo", RegisteredAt: now.Add(-time.Hour)}
+	gone := &registry.AgentRegistration{AgentID: "gone", Project: "p", VMIP: "10.0.0.2", Ports: []int{3000}, Alias: "demo", RegisteredAt: now}
+	store.Register(old)
+	store.Register(gone)
+
+	tw := NewTraefikWriter(baseDir, "agents.test")
+	tw.WriteRoute(old)
+	tw.WriteRoute(gone)
+	before := tw.aliases.owners()["demo.p"].AgentID
+
+	// The newer claimant's VM is gone: a real pass would hand the alias back
+	agents := func() map[string]string { return map[string]string{"old": "vm-1"} }
+	vmIP := func(context.Context, string) (string, error) { return "10.0.0.1", nil }
+	report, err := NewReconciler(tw, store, agents, vmIP, 0).Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(report.Aliases, ",") != "demo.p.agents.test" {
+		t.Errorf("aliases: got %v", report.Aliases)
+	}
+	if after := tw.aliases.owners()["demo.p"].AgentID; after != before {
+		t.Errorf("dry run moved the alias claim from %s to %s", before, after)
+	}
+	if len(tw.aliases.regs) != 2 {
+		t.Errorf("dry run changed the claims: %v", tw.aliases.regs)
+	}
+}
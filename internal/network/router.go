@@ -0,0 +1,64 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// Router kinds selectable in NetworkConfig.
+const (
+	RouterTraefik = "traefik" // file-provider YAML for an external Traefik
+	RouterBuiltin = "builtin" // in-process reverse proxy in agentd
+)
+
+// Router publishes served agents at their preview hostnames.
+type Router interface {
+	WriteRoute(reg *registry.AgentRegistration) error
+	RemoveRoute(agentID string) error
+	SubdomainFor(agentID, project string) string
+	URLsFor(agentID, project string, services []registry.Service) []string
+	AliasURL(alias, project string) string
+}
+
+var (
+	_ Router = (*TraefikWriter)(nil)
+	_ Router = (*Proxy)(nil)
+)
+
+// hosts derives preview hostnames and URLs; every Router embeds it so they
+// agree on naming.
+type hosts struct {
+	domain   string
+	httpOnly bool
+}
+
+func (h hosts) SubdomainFor(agentID, project string) string {
+	return fmt.Sprintf("%s.%s.%s", agentID, project, h.domain)
+}
+
+// URLsFor returns the public URLs of an agent's services, primary first.
+// With no services it returns the agent host alone.
+func (h hosts) URLsFor(agentID, project string, services []registry.Service) []string {
+	scheme := "https"
+	if h.httpOnly {
+		scheme = "http"
+	}
+	host := h.SubdomainFor(agentID, project)
+	if len(services) == 0 {
+		return []string{fmt.Sprintf("%s://%s", scheme, host)}
+	}
+
+	var urls []string
+	for i, svc := range services {
+		switch {
+		case svc.Path != "":
+			urls = append(urls, fmt.Sprintf("%s://%s%s", scheme, host, svc.Path))
+		case i == 0:
+			urls = append(urls, fmt.Sprintf("%s://%s", scheme, host))
+		default:
+			urls = append(urls, fmt.Sprintf("%s://%s.%s", scheme, svc.Name, host))
+		}
+	}
+	return urls
+}
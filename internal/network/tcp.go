@@ -0,0 +1,236 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// TCPForwarder gives agents' plain TCP services (databases, caches) a
+// dedicated host port and forwards connections to the agent's VM. TLS
+// services are left to the router's SNI routing when it has one.
+type TCPForwarder struct {
+	store    *registry.Store
+	bind     string
+	min, max int
+	sni      bool
+
+	mu      sync.Mutex
+	agents  map[string]map[string]*tcpListener // agent ID -> service name -> listener
+	claimed map[int]bool
+}
+
+type tcpListener struct {
+	ln       net.Listener
+	hostPort int
+	port     int // service port in the VM
+}
+
+// NewTCPForwarder allocates host ports in [minPort, maxPort] on bind. With
+// sni set, TLS services are routed by SNI instead of forwarded.
+func NewTCPForwarder(store *registry.Store, bind string, minPort, maxPort int, sni bool) *TCPForwarder {
+	return &TCPForwarder{
+		store:   store,
+		bind:    bind,
+		min:     minPort,
+		max:     maxPort,
+		sni:     sni,
+		agents:  make(map[string]map[string]*tcpListener),
+		claimed: make(map[int]bool),
+	}
+}
+
+// Forwarded reports whether svc gets a host port rather than an SNI route.
+func (f *TCPForwarder) Forwarded(svc registry.TCPService) bool {
+	return !svc.TLS || !f.sni
+}
+
+// Addr returns the host address of a forwarded port.
+func (f *TCPForwarder) Addr(hostPort int) string {
+	return net.JoinHostPort(f.bind, strconv.Itoa(hostPort))
+}
+
+// Route opens listeners for the agent's forwarded TCP services, keeping
+// existing ones and preferring ports recorded in reg.TCPRoutes, and closes
+// listeners of services it no longer declares.
+func (f *TCPForwarder) Route(reg *registry.AgentRegistration) ([]registry.TCPRoute, error) {
+	previous := make(map[string]int)
+	for _, r := range reg.TCPRoutes {
+		previous[r.Name] = r.HostPort
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current := f.agents[reg.AgentID]
+	next := make(map[string]*tcpListener)
+	var routes []registry.TCPRoute
+	var firstErr error
+	for _, svc := range reg.TCP {
+		if !f.Forwarded(svc) {
+			continue
+		}
+		l, ok := current[svc.Name]
+		if ok && l.port == svc.Port {
+			delete(current, svc.Name)
+		} else {
+			var err error
+			if l, err = f.listenLocked(reg.AgentID, svc.Port, previous[svc.Name]); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("agent %s: TCP service %s: %w", reg.AgentID, svc.Name, err)
+				}
+				continue
+			}
+		}
+		next[svc.Name] = l
+		routes = append(routes, registry.TCPRoute{Name: svc.Name, HostPort: l.hostPort})
+	}
+	for _, l := range current {
+		f.closeLocked(l)
+	}
+	if len(next) > 0 {
+		f.agents[reg.AgentID] = next
+	} else {
+		delete(f.agents, reg.AgentID)
+	}
+	return routes, firstErr
+}
+
+// Remove closes the agent's listeners and frees their ports.
+func (f *TCPForwarder) Remove(agentID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, l := range f.agents[agentID] {
+		f.closeLocked(l)
+	}
+	delete(f.agents, agentID)
+}
+
+// Watch forwards the TCP services of registered agents, recording their host
+// ports in the registry, and frees them on deregistration. It blocks until
+// ctx is done.
+func (f *TCPForwarder) Watch(ctx context.Context, store *registry.Store) {
+	ch := store.Subscribe()
+	defer store.Unsubscribe(ch)
+
+	for _, reg := range store.List() {
+		f.apply(store, registry.StoreEvent{Type: registry.EventAgentRegistered, AgentID: reg.AgentID, Agent: reg})
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			f.mu.Lock()
+			for agentID := range f.agents {
+				for _, l := range f.agents[agentID] {
+					f.closeLocked(l)
+				}
+			}
+			f.agents = make(map[string]map[string]*tcpListener)
+			f.mu.Unlock()
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			f.apply(store, ev)
+		}
+	}
+}
+
+func (f *TCPForwarder) apply(store *registry.Store, ev registry.StoreEvent) {
+	switch ev.Type {
+	case registry.EventAgentRegistered:
+		if ev.Agent == nil {
+			return
+		}
+		routes, err := f.Route(ev.Agent)
+		if err != nil {
+			log.Printf("TCP forwarder: %v", err)
+		}
+		if len(routes) > 0 || len(ev.Agent.TCPRoutes) > 0 {
+			store.SetTCPRoutes(ev.AgentID, routes)
+		}
+	case registry.EventAgentDeregistered:
+		f.Remove(ev.AgentID)
+	}
+}
+
+// listenLocked binds a free port in the range, trying preferred first.
+func (f *TCPForwarder) listenLocked(agentID string, port, preferred int) (*tcpListener, error) {
+	candidates := make([]int, 0, f.max-f.min+2)
+	if preferred >= f.min && preferred <= f.max {
+		candidates = append(candidates, preferred)
+	}
+	for p := f.min; p <= f.max; p++ {
+		candidates = append(candidates, p)
+	}
+	for _, p := range candidates {
+		if f.claimed[p] {
+			continue
+		}
+		ln, err := net.Listen("tcp", f.Addr(p))
+		if err != nil {
+			continue // taken by something else
+		}
+		l := &tcpListener{ln: ln, hostPort: p, port: port}
+		f.claimed[p] = true
+		go f.serve(agentID, l)
+		log.Printf("TCP forwarder: %s -> agent %s port %d", f.Addr(p), agentID, port)
+		return l, nil
+	}
+	return nil, fmt.Errorf("no free host port in %d-%d", f.min, f.max)
+}
+
+func (f *TCPForwarder) closeLocked(l *tcpListener) {
+	l.ln.Close()
+	delete(f.claimed, l.hostPort)
+}
+
+func (f *TCPForwarder) serve(agentID string, l *tcpListener) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("TCP forwarder: accept on %d: %v", l.hostPort, err)
+			}
+			return
+		}
+		go f.forward(agentID, l.port, conn)
+	}
+}
+
+// forward looks the VM address up per connection so moved VMs keep working.
+func (f *TCPForwarder) forward(agentID string, port int, conn net.Conn) {
+	defer conn.Close()
+	reg, ok := f.store.Get(agentID)
+	if !ok {
+		return
+	}
+	backend, err := net.DialTimeout("tcp", net.JoinHostPort(reg.VMIP, strconv.Itoa(port)), 10*time.Second)
+	if err != nil {
+		log.Printf("TCP forwarder: agent %s port %d: %v", agentID, port, err)
+		return
+	}
+	defer backend.Close()
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		if c, ok := dst.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go pipe(backend, conn)
+	go pipe(conn, backend)
+	<-done
+	<-done
+}
@@ -0,0 +1,156 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+// echoBackend starts a line echo server and returns its port.
+func echoBackend(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				fmt.Fprintf(conn, "echo %s", line)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// freePort returns a port that is free now, used as the start of a range.
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+func dialEcho(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "ping\n")
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	return line
+}
+
+func TestTCPForwarder_Route(t *testing.T) {
+	store, err := registry.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := echoBackend(t)
+	reg := &registry.AgentRegistration{
+		AgentID: "agent-1",
+		Project: "p",
+		VMIP:    "127.0.0.1",
+		TCP: []registry.TCPService{
+			{Name: "postgres", Port: port},
+			{Name: "redis", Port: 6379, TLS: true},
+		},
+	}
+	store.Register(reg)
+
+	min := freePort(t)
+	f := NewTCPForwarder(store, "127.0.0.1", min, min+50, true)
+	routes, err := f.Route(reg)
+	if err != nil {
+		t.Fatalf("Route failed: %v", err)
+	}
+	if len(routes) != 1 || routes[0].Name != "postgres" {
+		t.Fatalf("expected only the plain service forwarded, got %+v", routes)
+	}
+	addr := f.Addr(routes[0].HostPort)
+	if got := dialEcho(t, addr); got != "echo ping\n" {
+		t.Errorf("expected echo through forwarder, got %q", got)
+	}
+
+	again, _ := f.Route(reg)
+	if again[0].HostPort != routes[0].HostPort {
+		t.Errorf("re-routing should keep the host port, got %d then %d", routes[0].HostPort, again[0].HostPort)
+	}
+
+	f.Remove("agent-1")
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Error("expected listener closed after Remove")
+	}
+
+	// Without SNI routing, TLS services get host ports too.
+	noSNI := NewTCPForwarder(store, "127.0.0.1", min, min+50, false)
+	defer noSNI.Remove("agent-1")
+	if routes, _ := noSNI.Route(reg); len(routes) != 2 {
+		t.Errorf("expected both services forwarded without SNI, got %+v", routes)
+	}
+}
+
+func TestTCPForwarder_Watch(t *testing.T) {
+	store, err := registry.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	min := freePort(t)
+	f := NewTCPForwarder(store, "127.0.0.1", min, min+50, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Watch(ctx, store)
+
+	store.Register(&registry.AgentRegistration{
+		AgentID: "agent-1", Project: "p", VMIP: "127.0.0.1",
+		TCP: []registry.TCPService{{Name: "db", Port: echoBackend(t)}},
+	})
+
+	var hostPort int
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && hostPort == 0 {
+		if reg, ok := store.Get("agent-1"); ok && len(reg.TCPRoutes) == 1 {
+			hostPort = reg.TCPRoutes[0].HostPort
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hostPort == 0 {
+		t.Fatal("host port never recorded in the registry")
+	}
+	if got := dialEcho(t, net.JoinHostPort("127.0.0.1", strconv.Itoa(hostPort))); !strings.HasPrefix(got, "echo") {
+		t.Errorf("expected echo, got %q", got)
+	}
+
+	store.Deregister("agent-1")
+	deadline = time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(hostPort)), time.Second)
+		if err != nil {
+			return
+		}
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("host port still open after deregistration")
+}
@@ -1,32 +1,42 @@
 package network
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/mateo/agentvm/internal/certs"
 	"github.com/mateo/agentvm/internal/registry"
 )
 
+// TraefikWriter is the Router that writes one file-provider YAML per agent
+// for an external Traefik watching traefik/dynamic.
 type TraefikWriter struct {
+	hosts
 	dynamicDir string
-	domain     string
-	httpOnly   bool
+
+	access          *AccessStore
+	forwardAuthAddr string
+	ca              *certs.Authority
+
+	aliases aliasClaims
+	aliasMu sync.Mutex // serializes alias file updates
 }
 
 func NewTraefikWriter(baseDir, domain string) *TraefikWriter {
-	return &TraefikWriter{
-		dynamicDir: filepath.Join(baseDir, "traefik", "dynamic"),
-		domain:     domain,
-	}
+	return NewTraefikWriterHTTPOnly(baseDir, domain, false)
 }
 
 func NewTraefikWriterHTTPOnly(baseDir, domain string, httpOnly bool) *TraefikWriter {
 	return &TraefikWriter{
+		hosts:      hosts{domain: domain, httpOnly: httpOnly},
 		dynamicDir: filepath.Join(baseDir, "traefik", "dynamic"),
-		domain:     domain,
-		httpOnly:   httpOnly,
 	}
 }
 
@@ -34,16 +44,144 @@ func (tw *TraefikWriter) WriteRoute(reg *registry.AgentRegistration) error {
 	if err := os.MkdirAll(tw.dynamicDir, 0755); err != nil {
 		return err
 	}
+	if tw.ca != nil && !tw.httpOnly {
+		for _, host := range tw.certHosts(reg) {
+			if _, err := tw.ca.Ensure(host); err != nil {
+				return fmt.Errorf("agent %s: %w", reg.AgentID, err)
+			}
+		}
+	}
+	if err := os.WriteFile(tw.routeFile(reg.AgentID), []byte(tw.renderRoute(reg)), 0644); err != nil {
+		return err
+	}
+	tw.aliases.set(reg)
+	_, err := tw.syncAliases(false)
+	return err
+}
 
-	routerName := sanitize(reg.AgentID)
-	serviceName := sanitize(reg.AgentID) + "-svc"
-	host := fmt.Sprintf("%s.%s.%s", reg.AgentID, reg.Project, tw.domain)
+// SetAccess protects routes of agents granted access credentials: basic
+// auth is checked by Traefik itself, tokens by the forwardAuth endpoint at
+// forwardAuthAddr.
+func (tw *TraefikWriter) SetAccess(access *AccessStore, forwardAuthAddr string) {
+	tw.access = access
+	tw.forwardAuthAddr = forwardAuthAddr
+}
+
+// SetCA serves routes with wildcard certificates from the local CA, issued
+// as projects and agents appear.
+func (tw *TraefikWriter) SetCA(ca *certs.Authority) {
+	tw.ca = ca
+}
 
-	port := 8080
-	if len(reg.Ports) > 0 {
-		port = reg.Ports[0]
+// certHosts returns the hosts whose wildcard certificates cover an agent:
+// the project (for <agent>.<project>) and, with named or TLS TCP services,
+// the agent itself (for <service>.<agent>.<project>).
+func (tw *TraefikWriter) certHosts(reg *registry.AgentRegistration) []string {
+	agentHost := tw.SubdomainFor(reg.AgentID, reg.Project)
+	_, projectHost, _ := strings.Cut(agentHost, ".")
+	hosts := []string{projectHost}
+	for _, svc := range reg.RoutedServices() {
+		if svc.Name != "" && svc.Path == "" {
+			return append(hosts, agentHost)
+		}
 	}
+	for _, svc := range reg.TCP {
+		if svc.TLS {
+			return append(hosts, agentHost)
+		}
+	}
+	return hosts
+}
+
+// CertsRenewed rewrites the routes of regs that use a certificate of the
+// renewed hosts, and any alias routes, so Traefik loads the new files.
+func (tw *TraefikWriter) CertsRenewed(regs []*registry.AgentRegistration, renewed []string) error {
+	for _, reg := range regs {
+		if !reg.Serves() {
+			continue
+		}
+		for _, host := range tw.certHosts(reg) {
+			if slices.Contains(renewed, host) {
+				if err := tw.WriteRoute(reg); err != nil {
+					return fmt.Errorf("agent %s: %w", reg.AgentID, err)
+				}
+				break
+			}
+		}
+	}
+	_, err := tw.syncAliases(false)
+	return err
+}
+
+// tlsBlock lists the certificates for an agent's hosts; Traefik picks one by
+// SNI.
+func (tw *TraefikWriter) tlsBlock(reg *registry.AgentRegistration) string {
+	if tw.ca == nil || tw.httpOnly {
+		return ""
+	}
+	var b strings.Builder
+	for _, host := range tw.certHosts(reg) {
+		certFile, keyFile, ok := tw.ca.Files(host)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "    - certFile: %q\n      keyFile: %q\n", certFile, keyFile)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\ntls:\n  certificates:\n" + b.String()
+}
+
+func (tw *TraefikWriter) routeFile(agentID string) string {
+	return filepath.Join(tw.dynamicDir, sanitize(agentID)+".yaml")
+}
 
+// routeFiles returns the contents of every agent route file in the dynamic
+// directory, keyed by file name without the .yaml extension. Alias files are
+// left out; syncAliases manages them.
+func (tw *TraefikWriter) routeFiles() (map[string]string, error) {
+	entries, err := os.ReadDir(tw.dynamicDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".yaml")
+		if !ok || e.IsDir() || strings.HasPrefix(name, aliasFilePrefix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(tw.dynamicDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[name] = string(data)
+	}
+	return files, nil
+}
+
+// renderRoute builds the file-provider config for an agent's services.
+func (tw *TraefikWriter) renderRoute(reg *registry.AgentRegistration) string {
+	routerName := sanitize(reg.AgentID)
+	host := tw.SubdomainFor(reg.AgentID, reg.Project)
+
+	middleware, middlewareLine := tw.accessMiddleware(reg.AgentID)
+	routers, services := tw.httpRouters(reg, routerName, host, middlewareLine)
+
+	return fmt.Sprintf(`# Auto-generated route for agent %s
+http:
+  routers:
+%s
+  services:
+%s%s%s%s`, reg.AgentID, routers, services, middleware, tw.tcpBlock(reg), tw.tlsBlock(reg))
+}
+
+// httpRouters renders the routers and services publishing the agent's
+// services at host.
+func (tw *TraefikWriter) httpRouters(reg *registry.AgentRegistration, routerName, host, middlewareLine string) (string, string) {
 	entryPoint := "websecure"
 	tlsLine := "\n      tls: {}"
 	if tw.httpOnly {
@@ -51,37 +189,298 @@ func (tw *TraefikWriter) WriteRoute(reg *registry.AgentRegistration) error {
 		tlsLine = ""
 	}
 
-	config := fmt.Sprintf(`# Auto-generated route for agent %s
-http:
-  routers:
-    %s:
-      rule: "Host(%s)"
-      service: %s
+	routed := reg.RoutedServices()
+	hasPaths := false
+	for _, svc := range routed {
+		hasPaths = hasPaths || svc.Path != ""
+	}
+
+	var routers, services strings.Builder
+	for i, svc := range routed {
+		name := routerName
+		if svc.Name != "" {
+			name = routerName + "-" + sanitize(svc.Name)
+		}
+		// Traefik ranks routers by rule length; the primary's bare-host
+		// rule must lose to every path rule on the same host.
+		priorityLine := ""
+		if i == 0 && hasPaths {
+			priorityLine = "\n      priority: 1"
+		}
+		fmt.Fprintf(&routers, `    %s:
+      rule: "%s"%s
+      service: %s-svc
       entryPoints:
-        - %s%s
+        - %s%s%s
+`, name, serviceRule(host, svc, i == 0), priorityLine, name, entryPoint, middlewareLine, tlsLine)
+		fmt.Fprintf(&services, `    %s-svc:
+      loadBalancer:
+%s        servers:
+          - url: "http://%s:%d"
+`, name, healthCheckBlock(reg.Health, i == 0), reg.VMIP, svc.Port)
+	}
+	return routers.String(), services.String()
+}
 
-  services:
-    %s:
+// tcpBlock routes the agent's TLS TCP services by SNI on the websecure
+// entry point, terminating TLS with the agent's certificate. Plain TCP
+// services are forwarded from host ports by TCPForwarder instead.
+func (tw *TraefikWriter) tcpBlock(reg *registry.AgentRegistration) string {
+	if tw.httpOnly {
+		return ""
+	}
+	routerName := sanitize(reg.AgentID)
+	host := tw.SubdomainFor(reg.AgentID, reg.Project)
+
+	var routers, services strings.Builder
+	for _, svc := range reg.TCP {
+		if !svc.TLS {
+			continue
+		}
+		name := routerName + "-tcp-" + sanitize(svc.Name)
+		fmt.Fprintf(&routers, `    %s:
+      rule: "HostSNI(`+"`%s.%s`"+`)"
+      service: %s-svc
+      entryPoints:
+        - websecure
+      tls: {}
+`, name, svc.Name, host, name)
+		fmt.Fprintf(&services, `    %s-svc:
       loadBalancer:
         servers:
-          - url: "http://%s:%d"
-`, reg.AgentID, routerName, "`"+host+"`", serviceName, entryPoint, tlsLine, serviceName, reg.VMIP, port)
+          - address: "%s:%d"
+`, name, reg.VMIP, svc.Port)
+	}
+	if routers.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\ntcp:\n  routers:\n%s  services:\n%s", routers.String(), services.String())
+}
+
+// accessMiddleware returns the middlewares section protecting an agent's
+// routers and the router lines referencing it, or empty strings if the
+// agent's previews are public.
+func (tw *TraefikWriter) accessMiddleware(agentID string) (section, routerLines string) {
+	if tw.access == nil {
+		return "", ""
+	}
+	acc := tw.access.Get(agentID)
+	if acc == nil {
+		return "", ""
+	}
+
+	name := sanitize(agentID) + "-auth"
+	routerLines = fmt.Sprintf("\n      middlewares:\n        - %s", name)
+	switch acc.Mode {
+	case AccessBasic:
+		section = fmt.Sprintf(`
+  middlewares:
+    %s:
+      basicAuth:
+        users:
+          - %q
+`, name, htpasswdSHA(acc.Username, acc.Password))
+	case AccessToken:
+		section = fmt.Sprintf(`
+  middlewares:
+    %s:
+      forwardAuth:
+        address: %q
+`, name, tw.forwardAuthAddr)
+	default:
+		return "", ""
+	}
+	return section, routerLines
+}
 
-	filename := filepath.Join(tw.dynamicDir, fmt.Sprintf("%s.yaml", routerName))
-	return os.WriteFile(filename, []byte(config), 0644)
+// healthCheckBlock renders the loadBalancer healthCheck for the primary
+// service, or nothing when the agent has no health check. Without an
+// expected status Traefik accepts any 2xx or 3xx, as the harness does.
+func healthCheckBlock(hc *registry.HealthCheck, primary bool) string {
+	if hc == nil || !primary {
+		return ""
+	}
+	interval, timeout := hc.Interval, hc.Timeout
+	if interval <= 0 {
+		interval = 10
+	}
+	if timeout <= 0 {
+		timeout = 3
+	}
+	block := fmt.Sprintf(`        healthCheck:
+          path: %q
+          interval: "%ds"
+          timeout: "%ds"
+`, hc.Path, interval, timeout)
+	if hc.Status != 0 {
+		block += fmt.Sprintf("          status: %d\n", hc.Status)
+	}
+	return block
+}
+
+// serviceRule builds the router rule for a service: a path prefix on the
+// agent host, or its own <name>.<host> subdomain. The primary service also
+// answers on the bare agent host, which takes in its Path too.
+func serviceRule(host string, svc registry.Service, primary bool) string {
+	if svc.Path != "" {
+		if primary {
+			return fmt.Sprintf("Host(`%s`)", host)
+		}
+		return fmt.Sprintf("Host(`%s`) && PathPrefix(`%s`)", host, svc.Path)
+	}
+	var hosts []string
+	if primary {
+		hosts = append(hosts, fmt.Sprintf("Host(`%s`)", host))
+	}
+	if svc.Name != "" {
+		hosts = append(hosts, fmt.Sprintf("Host(`%s.%s`)", svc.Name, host))
+	}
+	return strings.Join(hosts, " || ")
 }
 
 func (tw *TraefikWriter) RemoveRoute(agentID string) error {
-	filename := filepath.Join(tw.dynamicDir, fmt.Sprintf("%s.yaml", sanitize(agentID)))
-	err := os.Remove(filename)
-	if os.IsNotExist(err) {
-		return nil
+	if err := os.Remove(tw.routeFile(agentID)); err != nil && !os.IsNotExist(err) {
+		return err
 	}
+	tw.aliases.remove(agentID)
+	_, err := tw.syncAliases(false)
 	return err
 }
 
-func (tw *TraefikWriter) SubdomainFor(agentID, project string) string {
-	return fmt.Sprintf("%s.%s.%s", agentID, project, tw.domain)
+// aliasFilePrefix starts alias route files, alias.<alias>.<project>.yaml;
+// agent route files never contain dots.
+const aliasFilePrefix = "alias."
+
+func (tw *TraefikWriter) aliasFile(key string) string {
+	return filepath.Join(tw.dynamicDir, aliasFilePrefix+key+".yaml")
+}
+
+// renderAlias publishes the owner's services at the alias host, under the
+// owner's access middleware, which its own route file defines.
+func (tw *TraefikWriter) renderAlias(owner *registry.AgentRegistration) string {
+	host := tw.AliasHost(owner.Alias, owner.Project)
+	_, middlewareLine := tw.accessMiddleware(owner.AgentID)
+	routers, services := tw.httpRouters(owner, "alias-"+sanitize(aliasKey(owner)), host, middlewareLine)
+
+	var tlsBlock string
+	if certFile, keyFile, ok := tw.aliasCert(owner); ok {
+		tlsBlock = fmt.Sprintf("\ntls:\n  certificates:\n    - certFile: %q\n      keyFile: %q\n", certFile, keyFile)
+	}
+	return fmt.Sprintf(`# Auto-generated alias %s -> agent %s
+http:
+  routers:
+%s
+  services:
+%s%s`, host, owner.AgentID, routers, services, tlsBlock)
+}
+
+// aliasCert returns the certificate for <service>.<alias host> when the
+// owner has named services; the alias host itself is covered by the
+// project's wildcard.
+func (tw *TraefikWriter) aliasCert(owner *registry.AgentRegistration) (certFile, keyFile string, ok bool) {
+	if tw.ca == nil || tw.httpOnly {
+		return "", "", false
+	}
+	for _, svc := range owner.RoutedServices() {
+		if svc.Name != "" && svc.Path == "" {
+			return tw.ca.Files(tw.AliasHost(owner.Alias, owner.Project))
+		}
+	}
+	return "", "", false
+}
+
+// syncAliases points every alias file at its current owner and removes
+// files of unclaimed aliases. Each file is replaced by a rename, so Traefik
+// moves an alias in one step. It returns the alias hosts changed (or that
+// would change, with dryRun).
+func (tw *TraefikWriter) syncAliases(dryRun bool) ([]string, error) {
+	return tw.syncAliasClaims(&tw.aliases, dryRun)
+}
+
+// WatchAliases re-syncs the alias files whenever an agent's state changes,
+// since an agent that stops serving hands its aliases on. It blocks until
+// ctx is done.
+func (tw *TraefikWriter) WatchAliases(ctx context.Context, store *registry.Store) {
+	ch := store.Subscribe()
+	defer store.Unsubscribe(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if ev.Type != registry.EventAgentUpdated {
+				continue
+			}
+			if _, err := tw.syncAliases(false); err != nil {
+				log.Printf("Failed to sync aliases: %v", err)
+			}
+		}
+	}
+}
+
+// syncAliasClaims syncs alias files to the owners of claims, which a dry
+// run passes without recording them as the writer's own.
+func (tw *TraefikWriter) syncAliasClaims(claims *aliasClaims, dryRun bool) ([]string, error) {
+	tw.aliasMu.Lock()
+	defer tw.aliasMu.Unlock()
+
+	owners := claims.owners()
+	want := make(map[string]string, len(owners))
+	for _, key := range sortedKeys(owners) {
+		owner := owners[key]
+		if tw.ca != nil && !tw.httpOnly && !dryRun {
+			if _, err := tw.ca.Ensure(tw.AliasHost(owner.Alias, owner.Project)); err != nil {
+				return nil, fmt.Errorf("alias %s: %w", key, err)
+			}
+		}
+		want[tw.aliasFile(key)] = tw.renderAlias(owner)
+	}
+
+	existing, err := filepath.Glob(filepath.Join(tw.dynamicDir, aliasFilePrefix+"*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	hostOf := func(file string) string {
+		key := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), aliasFilePrefix), ".yaml")
+		return key + "." + tw.domain
+	}
+	for _, file := range existing {
+		if _, ok := want[file]; ok {
+			continue
+		}
+		changed = append(changed, hostOf(file))
+		if !dryRun {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return changed, err
+			}
+		}
+	}
+	for file, content := range want {
+		if current, err := os.ReadFile(file); err == nil && string(current) == content {
+			continue
+		}
+		changed = append(changed, hostOf(file))
+		if dryRun {
+			continue
+		}
+		if err := os.MkdirAll(tw.dynamicDir, 0755); err != nil {
+			return changed, err
+		}
+		// A dot file without the .yaml extension is ignored by Traefik
+		tmp := filepath.Join(tw.dynamicDir, "."+filepath.Base(file)+".tmp")
+		if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+			return changed, err
+		}
+		if err := os.Rename(tmp, file); err != nil {
+			return changed, err
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
 }
 
 func sanitize(s string) string {
@@ -123,7 +522,6 @@ log:
   level: INFO
 `, httpPort, dynamicDir)
 	} else {
-		certsDir := filepath.Join(baseDir, "certs")
 		config = fmt.Sprintf(`# Traefik static configuration for agentvm
 entryPoints:
   web:
@@ -135,26 +533,21 @@ entryPoints:
           scheme: https
   websecure:
     address: ":%d"
+    http:
+      tls: {} # certificates come from the agentvm CA, listed in each route file
 
 providers:
   file:
     directory: "%s"
     watch: true
 
-tls:
-  stores:
-    default:
-      defaultCertificate:
-        certFile: "%s/agents.test.pem"
-        keyFile: "%s/agents.test-key.pem"
-
 api:
   dashboard: true
   insecure: true
 
 log:
   level: INFO
-`, httpPort, httpsPort, dynamicDir, certsDir, certsDir)
+`, httpPort, httpsPort, dynamicDir)
 	}
 
 	return os.WriteFile(filepath.Join(traefikDir, "traefik.yaml"), []byte(config), 0644)
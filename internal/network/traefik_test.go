@@ -1,11 +1,14 @@
 package network
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/mateo/agentvm/internal/certs"
 	"github.com/mateo/agentvm/internal/registry"
 )
 
@@ -198,3 +201,351 @@ func TestWriteStaticConfigHTTPOnly(t *testing.T) {
 		t.Error("expected HTTP-only comment")
 	}
 }
+
+func TestTraefikWriter_WriteRouteServices(t *testing.T) {
+	baseDir := filepath.Join(t.TempDir(), "agentvm")
+	tw := NewTraefikWriter(baseDir, "agents.test")
+
+	reg := &registry.AgentRegistration{
+		AgentID: "agent-1",
+		Project: "myproject",
+		VMIP:    "192.168.64.5",
+		Services: []registry.Service{
+			{Name: "web", Port: 3000},
+			{Name: "api", Port: 8080},
+			{Name: "admin", Port: 9000, Path: "/admin"},
+		},
+	}
+	if err := tw.WriteRoute(reg); err != nil {
+		t.Fatalf("WriteRoute failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "agent-1.yaml"))
+	s := string(content)
+	for _, want := range []string{
+		"Host(`agent-1.myproject.agents.test`) || Host(`web.agent-1.myproject.agents.test`)",
+		"Host(`api.agent-1.myproject.agents.test`)",
+		"Host(`agent-1.myproject.agents.test`) && PathPrefix(`/admin`)",
+		"agent-1-api-svc:",
+		"192.168.64.5:3000",
+		"192.168.64.5:8080",
+		"192.168.64.5:9000",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in route config:\n%s", want, s)
+		}
+	}
+
+	urls := tw.URLsFor("agent-1", "myproject", reg.Services)
+	want := []string{
+		"https://agent-1.myproject.agents.test",
+		"https://api.agent-1.myproject.agents.test",
+		"https://agent-1.myproject.agents.test/admin",
+	}
+	if strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %v, got %v", want, urls)
+	}
+}
+
+func TestTraefikWriter_WriteRoutePrimaryPath(t *testing.T) {
+	baseDir := filepath.Join(t.TempDir(), "agentvm")
+	tw := NewTraefikWriter(baseDir, "agents.test")
+
+	reg := &registry.AgentRegistration{
+		AgentID: "agent-1",
+		Project: "myproject",
+		VMIP:    "192.168.64.5",
+		Services: []registry.Service{
+			{Name: "app", Port: 3000, Path: "/app"},
+			{Name: "api", Port: 8080, Path: "/api"},
+		},
+	}
+	if err := tw.WriteRoute(reg); err != nil {
+		t.Fatalf("WriteRoute failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "agent-1.yaml"))
+	s := string(content)
+	for _, want := range []string{
+		"rule: \"Host(`agent-1.myproject.agents.test`)\"\n      priority: 1\n      service: agent-1-app-svc",
+		"rule: \"Host(`agent-1.myproject.agents.test`) && PathPrefix(`/api`)\"\n      service: agent-1-api-svc",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in route config:\n%s", want, s)
+		}
+	}
+}
+
+func TestTraefikWriter_WriteRouteHealthCheck(t *testing.T) {
+	baseDir := filepath.Join(t.TempDir(), "agentvm")
+	tw := NewTraefikWriter(baseDir, "agents.test")
+
+	reg := &registry.AgentRegistration{
+		AgentID: "agent-1",
+		Project: "myproject",
+		VMIP:    "192.168.64.5",
+		Ports:   []int{3000},
+		Health:  &registry.HealthCheck{Path: "/healthz", Interval: 5},
+	}
+	if err := tw.WriteRoute(reg); err != nil {
+		t.Fatalf("WriteRoute failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "agent-1.yaml"))
+	s := string(content)
+	for _, want := range []string{"healthCheck:", `path: "/healthz"`, `interval: "5s"`, `timeout: "3s"`} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in route config:\n%s", want, s)
+		}
+	}
+}
+
+func TestTraefikWriter_WriteRouteHealthCheckStatus(t *testing.T) {
+	baseDir := filepath.Join(t.TempDir(), "agentvm")
+	tw := NewTraefikWriter(baseDir, "agents.test")
+
+	reg := &registry.AgentRegistration{
+		AgentID: "agent-1",
+		Project: "myproject",
+		VMIP:    "192.168.64.5",
+		Ports:   []int{3000},
+		Health:  &registry.HealthCheck{Path: "/healthz", Status: 204},
+	}
+	if err := tw.WriteRoute(reg); err != nil {
+		t.Fatalf("WriteRoute failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "agent-1.yaml"))
+	if !strings.Contains(string(content), "status: 204") {
+		t.Errorf("expected status 204 in route config:\n%s", content)
+	}
+
+	// Without an expected status Traefik's 2xx/3xx default applies.
+	reg.Health.Status = 0
+	tw.WriteRoute(reg)
+	content, _ = os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "agent-1.yaml"))
+	if strings.Contains(string(content), "status:") {
+		t.Errorf("expected no status without one configured:\n%s", content)
+	}
+}
+
+func TestTraefikWriter_WriteRouteAccess(t *testing.T) {
+	baseDir := filepath.Join(t.TempDir(), "agentvm")
+	tw := NewTraefikWriter(baseDir, "agents.test")
+	access, err := NewAccessStore(baseDir, "agents.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.SetAccess(access, "http://127.0.0.1:8091/auth/preview")
+
+	basic, _ := access.Grant("agent-1", "myproject", AccessBasic)
+	access.Grant("agent-2", "myproject", AccessToken)
+
+	tests := []struct {
+		agentID string
+		want    []string
+	}{
+		{"agent-1", []string{"- agent-1-auth", "basicAuth:", htpasswdSHA("preview", basic.Password)}},
+		{"agent-2", []string{"- agent-2-auth", "forwardAuth:", `address: "http://127.0.0.1:8091/auth/preview"`}},
+	}
+	for _, tt := range tests {
+		reg := &registry.AgentRegistration{AgentID: tt.agentID, Project: "myproject", VMIP: "192.168.64.5", Ports: []int{3000}}
+		if err := tw.WriteRoute(reg); err != nil {
+			t.Fatalf("WriteRoute failed: %v", err)
+		}
+		content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", tt.agentID+".yaml"))
+		for _, want := range tt.want {
+			if !strings.Contains(string(content), want) {
+				t.Errorf("%s: expected %q in route config:\n%s", tt.agentID, want, content)
+			}
+		}
+	}
+
+	tw.WriteRoute(&registry.AgentRegistration{AgentID: "agent-3", Project: "myproject", VMIP: "192.168.64.5", Ports: []int{3000}})
+	content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "agent-3.yaml"))
+	if strings.Contains(string(content), "middlewares") {
+		t.Errorf("public agent should have no middlewares:\n%s", content)
+	}
+}
+
+func TestTraefikWriter_WriteRouteCertificates(t *testing.T) {
+	baseDir := filepath.Join(t.TempDir(), "agentvm")
+	tw := NewTraefikWriter(baseDir, "agents.test")
+	ca, err := certs.Load(filepath.Join(baseDir, "certs"), "agents.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.SetCA(ca)
+
+	reg := &registry.AgentRegistration{
+		AgentID:  "agent-1",
+		Project:  "myproject",
+		VMIP:     "192.168.64.5",
+		Ports:    []int{3000},
+		Services: []registry.Service{{Name: "web", Port: 3000}, {Name: "api", Port: 8080}},
+	}
+	if err := tw.WriteRoute(reg); err != nil {
+		t.Fatalf("WriteRoute failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "agent-1.yaml"))
+	for _, host := range []string{"myproject.agents.test", "agent-1.myproject.agents.test"} {
+		certFile, keyFile, ok := ca.Files(host)
+		if !ok {
+			t.Fatalf("no certificate issued for %s", host)
+		}
+		for _, want := range []string{`certFile: "` + certFile + `"`, `keyFile: "` + keyFile + `"`} {
+			if !strings.Contains(string(content), want) {
+				t.Errorf("expected %q in route config:\n%s", want, content)
+			}
+		}
+	}
+
+	tw.httpOnly = true
+	if strings.Contains(tw.renderRoute(reg), "certificates:") {
+		t.Error("HTTP-only routes should not list certificates")
+	}
+}
+
+func TestTraefikWriter_WriteRouteTCP(t *testing.T) {
+	baseDir := filepath.Join(t.TempDir(), "agentvm")
+	tw := NewTraefikWriter(baseDir, "agents.test")
+
+	reg := &registry.AgentRegistration{
+		AgentID: "agent-1",
+		Project: "myproject",
+		VMIP:    "192.168.64.5",
+		Ports:   []int{3000},
+		TCP: []registry.TCPService{
+			{Name: "postgres", Port: 5432},
+			{Name: "redis", Port: 6379, TLS: true},
+		},
+	}
+	if err := tw.WriteRoute(reg); err != nil {
+		t.Fatalf("WriteRoute failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(baseDir, "traefik", "dynamic", "agent-1.yaml"))
+	s := string(content)
+	for _, want := range []string{"tcp:", "HostSNI(`redis.agent-1.myproject.agents.test`)", `address: "192.168.64.5:6379"`} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in route config:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "5432") {
+		t.Errorf("plain TCP services are forwarded by agentd, not Traefik:\n%s", s)
+	}
+}
+
+func TestTraefikWriter_Aliases(t *testing.T) {
+	baseDir := t.TempDir()
+	tw := NewTraefikWriter(baseDir, "agents.test")
+	aliasFile := filepath.Join(baseDir, "traefik", "dynamic", "alias.feature-x.myproject.yaml")
+
+	now := time.Now()
+	older := &registry.AgentRegistration{AgentID: "agent-1", Project: "myproject", VMIP: "192.168.64.5", Ports: []int{3000}, Alias: "feature-x", RegisteredAt: now.Add(-time.Hour)}
+	newer := &registry.AgentRegistration{AgentID: "agent-2", Project: "myproject", VMIP: "192.168.64.6", Ports: []int{3000}, Alias: "feature-x", RegisteredAt: now}
+
+	aliasTarget := func() string {
+		t.Helper()
+		content, err := os.ReadFile(aliasFile)
+		if err != nil {
+			return ""
+		}
+		s := string(content)
+		if !strings.Contains(s, "Host(`feature-x.myproject.agents.test`)") {
+			t.Errorf("alias file does not route the alias host:\n%s", s)
+		}
+		for _, ip := range []string{"192.168.64.5", "192.168.64.6"} {
+			if strings.Contains(s, ip) {
+				return ip
+			}
+		}
+		return "?"
+	}
+
+	if err := tw.WriteRoute(older); err != nil {
+		t.Fatal(err)
+	}
+	if got := aliasTarget(); got != "192.168.64.5" {
+		t.Errorf("alias points at %s, want the only claimant", got)
+	}
+
+	// The newest agent takes the alias over, even if an older one rewrites its route later
+	tw.WriteRoute(newer)
+	tw.WriteRoute(older)
+	if got := aliasTarget(); got != "192.168.64.6" {
+		t.Errorf("alias points at %s, want the newest agent", got)
+	}
+
+	// An agent that stops serving hands the alias back
+	newer.State = "completed"
+	tw.syncAliases(false)
+	if got := aliasTarget(); got != "192.168.64.5" {
+		t.Errorf("alias points at %s after the newest agent stopped, want it handed back", got)
+	}
+	newer.State = "serving"
+	tw.syncAliases(false)
+
+	// Alias files are not agent routes
+	files, _ := tw.routeFiles()
+	if len(files) != 2 {
+		t.Errorf("expected 2 agent route files, got %v", files)
+	}
+
+	tw.RemoveRoute("agent-2")
+	if got := aliasTarget(); got != "192.168.64.5" {
+		t.Errorf("alias points at %s after removal, want it handed back", got)
+	}
+	tw.RemoveRoute("agent-1")
+	if _, err := os.Stat(aliasFile); !os.IsNotExist(err) {
+		t.Errorf("expected alias file removed once unclaimed, got %v", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(baseDir, "traefik", "dynamic", ".*")); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
+
+func TestTraefikWriter_WatchAliases(t *testing.T) {
+	baseDir := t.TempDir()
+	store, err := registry.NewStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := NewTraefikWriter(baseDir, "agents.test")
+	aliasFile := filepath.Join(baseDir, "traefik", "dynamic", "alias.demo.p.yaml")
+
+	now := time.Now()
+	for _, reg := range []*registry.AgentRegistration{
+		{AgentID: "agent-1", Project: "p", VMIP: "192.168.64.5", Ports: []int{3000}, Alias: "demo", RegisteredAt: now.Add(-time.Hour)},
+		{AgentID: "agent-2", Project: "p", VMIP: "192.168.64.6", Ports: []int{3000}, Alias: "demo", RegisteredAt: now},
+	} {
+		store.Register(reg)
+		reg, _ := store.Get(reg.AgentID)
+		if err := tw.WriteRoute(reg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tw.WatchAliases(ctx, store)
+
+	if content, _ := os.ReadFile(aliasFile); !strings.Contains(string(content), "192.168.64.6") {
+		t.Fatalf("expected the alias on the newest agent:\n%s", content)
+	}
+
+	// The newest agent failing moves the alias; the update repeats until the
+	// watcher has subscribed
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		store.UpdateState("agent-2", "failed", "", "")
+		if content, _ := os.ReadFile(aliasFile); strings.Contains(string(content), "192.168.64.5") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("alias never moved to the older agent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
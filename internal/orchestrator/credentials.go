@@ -0,0 +1,215 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Credential types.
+const (
+	CredentialToken  = "token"  // HTTPS username/token pair
+	CredentialSSH    = "ssh"    // SSH deploy key
+	CredentialHelper = "helper" // git credential helper available in the VM
+)
+
+// CredentialSource says where agentd finds a git credential on the host.
+// Secrets are read at dispatch and never stored in the task config.
+type CredentialSource struct {
+	Type       string // token (default), ssh or helper
+	Username   string // HTTPS username (default "git")
+	TokenEnv   string // agentd env var holding the token
+	TokenFile  string // file holding the token
+	KeyFile    string // SSH private key
+	KnownHosts string // known_hosts lines for the host; empty accepts on first use
+	Helper     string // credential helper command, run inside the VM
+}
+
+// GitCredential is a resolved credential for one host, injected into the VM
+// as credentials.json next to task.json.
+type GitCredential struct {
+	Host       string `json:"host"`
+	Type       string `json:"type"`
+	Username   string `json:"username,omitempty"`
+	Token      string `json:"token,omitempty"`
+	SSHKey     string `json:"sshKey,omitempty"`
+	KnownHosts string `json:"knownHosts,omitempty"`
+	Helper     string `json:"helper,omitempty"`
+}
+
+// RepoHost returns the host of a git remote URL and whether it uses SSH.
+// Both URL forms (https://, ssh://) and scp-like "user@host:path" are
+// understood. Local paths and file:// URLs return an empty host.
+func RepoHost(repoURL string) (host string, ssh bool, err error) {
+	if strings.Contains(repoURL, "://") {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return "", false, fmt.Errorf("parsing repo URL: %w", err)
+		}
+		switch u.Scheme {
+		case "https", "http":
+			return u.Hostname(), false, nil
+		case "ssh", "git+ssh":
+			return u.Hostname(), true, nil
+		case "file":
+			return "", false, nil
+		default:
+			return "", false, fmt.Errorf("unsupported repo URL scheme %q", u.Scheme)
+		}
+	}
+	// scp-like syntax: [user@]host:path, where host has no slash
+	if before, _, ok := strings.Cut(repoURL, ":"); ok && !strings.Contains(before, "/") {
+		if _, h, ok := strings.Cut(before, "@"); ok {
+			return h, true, nil
+		}
+		return before, true, nil
+	}
+	return "", false, nil
+}
+
+// ResolveCredentials picks and loads the credentials for a dispatch. When
+// the project has its own host mapping it replaces the global one, must cover
+// the repository's host, and all of its entries are sent (for submodules on
+// other hosts); otherwise only the global entry for the repository's host is
+// sent. A credential that doesn't fit the URL's transport is an error.
+func ResolveCredentials(repoURL string, hosts map[string]CredentialSource, projectHosts map[string]CredentialSource) ([]GitCredential, error) {
+	host, ssh, err := RepoHost(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]CredentialSource)
+	if projectHosts != nil {
+		if host != "" {
+			if _, ok := projectHosts[host]; !ok {
+				return nil, fmt.Errorf("project credentials cover %s, not repo host %s",
+					strings.Join(sortedKeys(projectHosts), ", "), host)
+			}
+		}
+		selected = projectHosts
+	} else if src, ok := hosts[host]; ok && host != "" {
+		selected[host] = src
+	}
+
+	var creds []GitCredential
+	for _, h := range sortedKeys(selected) {
+		src := selected[h]
+		if h == host {
+			if err := checkTransport(src, ssh); err != nil {
+				return nil, fmt.Errorf("credential for %s: %w", h, err)
+			}
+		}
+		cred, err := resolveCredential(h, src)
+		if err != nil {
+			return nil, fmt.Errorf("credential for %s: %w", h, err)
+		}
+		creds = append(creds, cred)
+	}
+	return creds, nil
+}
+
+func checkTransport(src CredentialSource, ssh bool) error {
+	if src.Type == CredentialSSH && !ssh {
+		return fmt.Errorf("ssh key configured but repo URL uses HTTPS")
+	}
+	if src.Type != CredentialSSH && ssh {
+		return fmt.Errorf("%s credential configured but repo URL uses SSH", credentialType(src))
+	}
+	return nil
+}
+
+func credentialType(src CredentialSource) string {
+	if src.Type == "" {
+		return CredentialToken
+	}
+	return src.Type
+}
+
+func resolveCredential(host string, src CredentialSource) (GitCredential, error) {
+	cred := GitCredential{Host: host, Type: credentialType(src)}
+	switch cred.Type {
+	case CredentialToken:
+		cred.Username = src.Username
+		if cred.Username == "" {
+			cred.Username = "git"
+		}
+		switch {
+		case src.TokenEnv != "":
+			cred.Token = os.Getenv(src.TokenEnv)
+			if cred.Token == "" {
+				return cred, fmt.Errorf("env var %s is empty", src.TokenEnv)
+			}
+		case src.TokenFile != "":
+			data, err := os.ReadFile(expandHome(src.TokenFile))
+			if err != nil {
+				return cred, fmt.Errorf("reading token file: %w", err)
+			}
+			cred.Token = strings.TrimSpace(string(data))
+		}
+		if cred.Token == "" {
+			return cred, fmt.Errorf("no token (set tokenEnv or tokenFile)")
+		}
+	case CredentialSSH:
+		if src.KeyFile == "" {
+			return cred, fmt.Errorf("keyFile is required")
+		}
+		data, err := os.ReadFile(expandHome(src.KeyFile))
+		if err != nil {
+			return cred, fmt.Errorf("reading SSH key: %w", err)
+		}
+		cred.SSHKey = string(data)
+		cred.KnownHosts = src.KnownHosts
+	case CredentialHelper:
+		if src.Helper == "" {
+			return cred, fmt.Errorf("helper is required")
+		}
+		cred.Helper = src.Helper
+	default:
+		return cred, fmt.Errorf("unknown credential type %q (valid: token, ssh, helper)", src.Type)
+	}
+	return cred, nil
+}
+
+// expandHome resolves a leading "~/" against the agentd user's home.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+func sortedKeys(m map[string]CredentialSource) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteCredentials writes resolved credentials readable only by the owner.
+func WriteCredentials(creds []GitCredential, path string) error {
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling credentials: %w", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func ReadCredentials(path string) ([]GitCredential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials: %w", err)
+	}
+	var creds []GitCredential
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parsing credentials: %w", err)
+	}
+	return creds, nil
+}
@@ -8,8 +8,12 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/mateo/agentvm/internal/history"
 	"github.com/mateo/agentvm/internal/lima"
 	"github.com/mateo/agentvm/internal/pool"
+	"github.com/mateo/agentvm/internal/project"
+	"github.com/mateo/agentvm/internal/registry"
+	"github.com/mateo/agentvm/internal/repocache"
 )
 
 type Orchestrator struct {
@@ -17,6 +21,14 @@ type Orchestrator struct {
 	limaClient lima.Client
 	baseDir    string
 	hostAddr   string // e.g. "host.lima.internal:8090"
+
+	cache         *repocache.Cache
+	cloneSettings map[string]CloneConfig // per-project clone settings
+	history       *history.Store
+	registry      *registry.Store
+
+	credentials        map[string]CredentialSource            // default credential per git host
+	projectCredentials map[string]map[string]CredentialSource // per-project host mapping
 }
 
 func New(pm *pool.Manager, lc lima.Client, baseDir, hostAddr string) *Orchestrator {
@@ -28,39 +40,105 @@ func New(pm *pool.Manager, lc lima.Client, baseDir, hostAddr string) *Orchestrat
 	}
 }
 
+// SetRepoCache enables mirror-assisted clones. settings holds optional
+// per-project shallow/partial clone configuration.
+func (o *Orchestrator) SetRepoCache(cache *repocache.Cache, settings map[string]CloneConfig) {
+	o.cache = cache
+	o.cloneSettings = settings
+}
+
+// SetHistory records each dispatched task in the host-side task history.
+func (o *Orchestrator) SetHistory(h *history.Store) {
+	o.history = h
+}
+
+// SetRegistry registers dispatched agents before their harness starts, so
+// their state reports are recorded whether or not they serve an app.
+func (o *Orchestrator) SetRegistry(store *registry.Store) {
+	o.registry = store
+}
+
+// SetCredentials configures the git credentials sent to VMs. A project's own
+// host mapping replaces the default per-host credentials for that project.
+func (o *Orchestrator) SetCredentials(hosts map[string]CredentialSource, projects map[string]map[string]CredentialSource) {
+	o.credentials = hosts
+	o.projectCredentials = projects
+}
+
 type DispatchResult struct {
 	AgentID string
 	VMName  string
 	VMIP    string
+
+	// ServeCommand and ServeServices are the effective serve settings,
+	// after .agentvm.yaml defaults were merged under the request.
+	ServeCommand  string
+	ServeServices []project.Service
+}
+
+// NewAgentID returns an ID for a new agent, for callers that need it before
+// dispatching.
+func NewAgentID() string {
+	return fmt.Sprintf("agent-%d", time.Now().UnixNano()%100000)
 }
 
 func (o *Orchestrator) Dispatch(ctx context.Context, req DispatchRequest) (*DispatchResult, error) {
-	agentID := fmt.Sprintf("agent-%d", time.Now().UnixNano()%100000)
+	agentID := req.AgentID
+	if agentID == "" {
+		agentID = NewAgentID()
+	}
 
 	task := &TaskConfig{
-		AgentID:      agentID,
-		Project:      req.Project,
-		RepoURL:      req.RepoURL,
-		Issue:        req.Issue,
-		Tool:         req.Tool,
-		Prompt:       req.Prompt,
-		Branch:       req.Branch,
-		MaxTime:      req.MaxTime,
-		MaxTokens:    req.MaxTokens,
-		EnvVars:      req.EnvVars,
-		ServeCommand: req.ServeCommand,
-		ServePort:    req.ServePort,
-		HostAddr:     o.hostAddr,
-		DispatchedAt: time.Now(),
+		AgentID:            agentID,
+		Project:            req.Project,
+		RepoURL:            req.RepoURL,
+		Issue:              req.Issue,
+		Tool:               req.Tool,
+		Prompt:             req.Prompt,
+		Branch:             req.Branch,
+		MaxTime:            req.MaxTime,
+		MaxTokens:          req.MaxTokens,
+		EnvVars:            req.EnvVars,
+		ServeCommand:       req.ServeCommand,
+		ServePort:          req.ServePort,
+		ServeServices:      req.ServeServices,
+		ServeTCP:           req.ServeTCP,
+		ServeHealth:        req.ServeHealth,
+		ServeRestart:       req.ServeRestart,
+		ServeMaxRestarts:   req.ServeMaxRestarts,
+		ServeAlias:         req.ServeAlias,
+		SetupCommands:      req.SetupCommands,
+		SetupTimeout:       req.SetupTimeout,
+		Devcontainer:       req.Devcontainer,
+		ProtectedPaths:     req.ProtectedPaths,
+		ProtectPolicy:      req.ProtectPolicy,
+		SecretScan:         req.SecretScan,
+		CheckpointInterval: req.CheckpointInterval,
+		CommitMessage:      req.CommitMessage,
+		AuthorName:         req.AuthorName,
+		AuthorEmail:        req.AuthorEmail,
+		HostAddr:           o.hostAddr,
+		DispatchedAt:       time.Now(),
+	}
+
+	var fresh bool
+	task.Clone, fresh = o.cloneConfigFor(ctx, req.Project, req.RepoURL)
+	if err := o.applyProjectConfig(ctx, task, fresh); err != nil {
+		return nil, err
 	}
 
 	if err := ValidateTask(task); err != nil {
 		return nil, fmt.Errorf("invalid task: %w", err)
 	}
 
+	creds, err := ResolveCredentials(task.RepoURL, o.credentials, o.projectCredentials[task.Project])
+	if err != nil {
+		return nil, fmt.Errorf("invalid task: credentials: %w", err)
+	}
+
 	// Claim a warm VM
 	slot, err := o.pool.Claim(ctx, agentID, req.Project, pool.ClaimOpts{
-		Tool:   req.Tool,
+		Tool:   task.Tool,
 		Branch: task.Branch,
 		Issue:  req.Issue,
 	})
@@ -135,6 +213,53 @@ func (o *Orchestrator) Dispatch(ctx context.Context, req DispatchRequest) (*Disp
 		return nil, fmt.Errorf("moving env config: %w", err)
 	}
 
+	// Write git credentials into VM; the harness deletes the file once read
+	if len(creds) > 0 {
+		credTmp := filepath.Join(os.TempDir(), fmt.Sprintf("credentials-%s.json", agentID))
+		if err := WriteCredentials(creds, credTmp); err != nil {
+			o.pool.Release(slot.Name)
+			return nil, fmt.Errorf("writing credentials: %w", err)
+		}
+		defer os.Remove(credTmp)
+
+		err = o.limaClient.Copy(ctx, lima.CopyOptions{
+			Instance:  slot.Name,
+			Direction: lima.CopyToVM,
+			LocalPath: credTmp,
+			VMPath:    "/tmp/credentials.json",
+		})
+		if err != nil {
+			o.pool.Release(slot.Name)
+			return nil, fmt.Errorf("injecting credentials: %w", err)
+		}
+		_, err = o.limaClient.Shell(ctx, lima.ShellOptions{
+			Instance: slot.Name,
+			Command:  "sh",
+			Args: []string{"-c", "chmod 600 /tmp/credentials.json && " +
+				"sudo mv /tmp/credentials.json /etc/agent-config/credentials.json"},
+			Timeout: 10 * time.Second,
+		})
+		if err != nil {
+			o.pool.Release(slot.Name)
+			return nil, fmt.Errorf("moving credentials: %w", err)
+		}
+	}
+
+	if o.registry != nil {
+		now := time.Now()
+		o.registry.Register(&registry.AgentRegistration{
+			AgentID:       agentID,
+			VMName:        slot.Name,
+			VMIP:          slot.VMIP,
+			Project:       req.Project,
+			Tool:          task.Tool,
+			Branch:        task.Branch,
+			State:         "dispatched",
+			RegisteredAt:  now,
+			LastHeartbeat: now,
+		})
+	}
+
 	// Restart the harness service
 	_, err = o.limaClient.Shell(ctx, lima.ShellOptions{
 		Instance: slot.Name,
@@ -143,27 +268,151 @@ func (o *Orchestrator) Dispatch(ctx context.Context, req DispatchRequest) (*Disp
 		Timeout:  30 * time.Second,
 	})
 	if err != nil {
+		if o.registry != nil {
+			o.registry.Deregister(agentID)
+		}
 		o.pool.Release(slot.Name)
 		return nil, fmt.Errorf("restarting harness: %w", err)
 	}
 
+	if o.history != nil {
+		if err := o.history.Write(agentID, "task", task.Redacted()); err != nil {
+			log.Printf("Warning: failed to record task history for %s: %v", agentID, err)
+		}
+	}
+
 	return &DispatchResult{
-		AgentID: agentID,
-		VMName:  slot.Name,
-		VMIP:    slot.VMIP,
+		AgentID:       agentID,
+		VMName:        slot.Name,
+		VMIP:          slot.VMIP,
+		ServeCommand:  task.ServeCommand,
+		ServeServices: task.ServeServices,
 	}, nil
 }
 
+// applyProjectConfig validates and merges the repository's .agentvm.yaml when
+// the host's mirror of the repository was fetched for this dispatch (fresh).
+// The file is read at the mirror's HEAD commit, which is pinned in task.Clone
+// so the harness checks out the same one. The harness merges the file again
+// after cloning, so without a fresh mirror nothing is lost except early
+// validation.
+func (o *Orchestrator) applyProjectConfig(ctx context.Context, task *TaskConfig, fresh bool) error {
+	if o.cache == nil || task.Clone == nil || task.Clone.Mirror == "" || !fresh {
+		log.Printf("No up-to-date mirror of %s, %s is applied by the harness after cloning", task.RepoURL, project.FileName)
+		return nil
+	}
+
+	gitDir := o.cache.Path(task.RepoURL)
+	rev, err := project.ResolveRevision(ctx, gitDir, "HEAD")
+	if err != nil {
+		log.Printf("Warning: could not read %s for %s: %v", project.FileName, task.Project, err)
+		return nil
+	}
+	task.Clone.Revision = rev
+
+	data, err := project.ReadRevision(ctx, gitDir, rev)
+	if err != nil {
+		log.Printf("Warning: could not read %s for %s: %v", project.FileName, task.Project, err)
+		return nil
+	}
+	pc, err := project.Parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid project config: %w", err)
+	}
+	if err := ValidateProjectConfig(pc); err != nil {
+		return fmt.Errorf("invalid project config: %w", err)
+	}
+	ApplyProjectConfig(task, pc)
+	return nil
+}
+
+const (
+	// mirrorFetchWait is how long dispatch waits for the fetch into an
+	// existing mirror. It stays well below the API client's 30s timeout.
+	mirrorFetchWait = 10 * time.Second
+	// mirrorFetchTimeout bounds that fetch, which carries on in the
+	// background when dispatch stops waiting.
+	mirrorFetchTimeout = 2 * time.Minute
+)
+
+// cloneConfigFor returns the clone settings for a project, and whether the
+// mirror was fetched just now. An existing mirror is fetched before it is
+// used, so a clone from it is never stale; if the fetch fails or takes longer
+// than mirrorFetchWait the harness clones from the remote with the mirror
+// only as a reference. Otherwise the mirror is created in the background for
+// the next dispatch.
+func (o *Orchestrator) cloneConfigFor(ctx context.Context, project, repoURL string) (*CloneConfig, bool) {
+	cc, ok := o.cloneSettings[project]
+	if o.cache == nil {
+		if !ok {
+			return nil, false
+		}
+		cc.FromMirror = false
+		return &cc, false
+	}
+
+	fresh := false
+	if o.cache.Exists(repoURL) {
+		cc.Mirror = o.cache.VMPath(repoURL)
+		done := make(chan error, 1)
+		go func() {
+			fetchCtx, cancel := context.WithTimeout(context.Background(), mirrorFetchTimeout)
+			defer cancel()
+			_, err := o.cache.Ensure(fetchCtx, repoURL)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("Warning: refreshing mirror of %s failed, cloning from the remote: %v", repoURL, err)
+				cc.FromMirror = false
+			} else {
+				fresh = true
+			}
+		case <-time.After(mirrorFetchWait):
+			log.Printf("Warning: mirror of %s is still fetching, cloning from the remote", repoURL)
+			cc.FromMirror = false
+		case <-ctx.Done():
+			cc.FromMirror = false
+		}
+	} else {
+		cc.FromMirror = false
+		o.cache.EnsureAsync(repoURL)
+	}
+
+	if cc == (CloneConfig{}) {
+		return nil, fresh
+	}
+	return &cc, fresh
+}
+
 type DispatchRequest struct {
-	Project      string
-	RepoURL      string
-	Issue        string
-	Tool         string
-	Prompt       string
-	Branch       string
-	MaxTime      int
-	MaxTokens    int
-	EnvVars      map[string]string
-	ServeCommand string
-	ServePort    int
+	AgentID            string // from NewAgentID, generated when empty
+	Project            string
+	RepoURL            string
+	Issue              string
+	Tool               string
+	Prompt             string
+	Branch             string
+	MaxTime            int
+	MaxTokens          int
+	EnvVars            map[string]string
+	ServeCommand       string
+	ServePort          int
+	ServeServices      []project.Service
+	ServeTCP           []project.TCPService
+	ServeHealth        *project.HealthCheck
+	ServeRestart       string
+	ServeMaxRestarts   int
+	ServeAlias         string
+	SetupCommands      []string
+	SetupTimeout       int
+	Devcontainer       bool
+	ProtectedPaths     []string
+	ProtectPolicy      string
+	SecretScan         string
+	CheckpointInterval int
+	CommitMessage      string
+	AuthorName         string
+	AuthorEmail        string
 }
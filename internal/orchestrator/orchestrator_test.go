@@ -0,0 +1,187 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mateo/agentvm/internal/lima"
+	"github.com/mateo/agentvm/internal/pool"
+	"github.com/mateo/agentvm/internal/project"
+	"github.com/mateo/agentvm/internal/registry"
+	"github.com/mateo/agentvm/internal/repocache"
+)
+
+func TestOrchestrator_DispatchRegistersAgent(t *testing.T) {
+	baseDir := t.TempDir()
+	state := pool.PersistentState{Slots: []pool.VMSlot{{Name: "warm-1", State: pool.SlotIdle}}}
+	data, _ := json.Marshal(state)
+	os.WriteFile(filepath.Join(baseDir, "pool-state.json"), data, 0644)
+
+	mock := lima.NewMockClient()
+	mock.Create(context.Background(), lima.CreateOptions{Name: "warm-1", Start: true})
+	mock.ShellFn = func(ctx context.Context, opts lima.ShellOptions) (string, error) {
+		return "192.168.64.5\n", nil
+	}
+	poolMgr, err := pool.NewManager(pool.PoolConfig{}, mock, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := registry.NewStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := New(poolMgr, mock, baseDir, "host.lima.internal:8090")
+	o.SetRegistry(store)
+	result, err := o.Dispatch(context.Background(), DispatchRequest{
+		AgentID: "agent-42",
+		Project: "shop",
+		RepoURL: "https://example.com/shop.git",
+		Prompt:  "fix the cart",
+		Branch:  "agent/fix-cart",
+
+		ServeCommand:  "npm start",
+		ServeServices: []project.Service{{Name: "web", Port: 3000}},
+	})
+	if err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+
+	if result.AgentID != "agent-42" {
+		t.Errorf("agent ID %q, want the requested one", result.AgentID)
+	}
+	if result.ServeCommand != "npm start" || len(result.ServeServices) != 1 {
+		t.Errorf("effective serve settings missing from result: %+v", result)
+	}
+
+	// State reports are accepted before the agent serves anything
+	reg, ok := store.Get(result.AgentID)
+	if !ok {
+		t.Fatal("dispatched agent is not registered")
+	}
+	if reg.VMName != "warm-1" || reg.Project != "shop" || reg.Branch != "agent/fix-cart" || reg.Serves() {
+		t.Errorf("unexpected registration %+v", reg)
+	}
+	if err := store.UpdateState(result.AgentID, "completed", "Exit code: 0", ""); err != nil {
+		t.Errorf("state report rejected: %v", err)
+	}
+}
+
+func TestOrchestrator_ApplyProjectConfigFromMirror(t *testing.T) {
+	remote := t.TempDir()
+	os.WriteFile(filepath.Join(remote, ".agentvm.yaml"), []byte("tool: opencode\nmaxTime: 60\n"), 0644)
+	gitRun(t, remote, "init", "-q")
+	head := commitAll(t, remote, "init")
+
+	cache := repocache.New(t.TempDir(), "/mnt/agentvm/repos", 0)
+	if _, err := cache.Ensure(context.Background(), remote); err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	o := New(nil, lima.NewMockClient(), t.TempDir(), "host.lima.internal:8090")
+	o.SetRepoCache(cache, nil)
+
+	task := &TaskConfig{AgentID: "agent-1", Project: "shop", RepoURL: remote, Prompt: "fix the cart",
+		Clone: &CloneConfig{Mirror: cache.VMPath(remote)}}
+	if err := o.applyProjectConfig(context.Background(), task, true); err != nil {
+		t.Fatalf("applyProjectConfig failed: %v", err)
+	}
+	if task.Tool != "opencode" || task.MaxTime != 60 {
+		t.Errorf("project config not applied: tool=%q maxTime=%d", task.Tool, task.MaxTime)
+	}
+	// The harness checks out the commit the config was read from
+	if task.Clone.Revision != head {
+		t.Errorf("revision = %q, want %s", task.Clone.Revision, head)
+	}
+
+	// Without a mirror the harness applies the file after cloning
+	task = &TaskConfig{AgentID: "agent-2", Project: "shop", RepoURL: remote, Prompt: "fix the cart", Clone: &CloneConfig{}}
+	if err := o.applyProjectConfig(context.Background(), task, false); err != nil {
+		t.Fatalf("applyProjectConfig failed: %v", err)
+	}
+	if task.Tool != "" || task.Clone.Revision != "" {
+		t.Errorf("config applied without a mirror: tool=%q revision=%q", task.Tool, task.Clone.Revision)
+	}
+}
+
+func TestOrchestrator_ApplyProjectConfigAfterFailedFetch(t *testing.T) {
+	remote := t.TempDir()
+	os.WriteFile(filepath.Join(remote, ".agentvm.yaml"), []byte("tool: opencode\n"), 0644)
+	gitRun(t, remote, "init", "-q")
+	commitAll(t, remote, "init")
+
+	cache := repocache.New(t.TempDir(), "/mnt/agentvm/repos", 0)
+	if _, err := cache.Ensure(context.Background(), remote); err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	o := New(nil, lima.NewMockClient(), t.TempDir(), "host.lima.internal:8090")
+	o.SetRepoCache(cache, map[string]CloneConfig{"shop": {FromMirror: true}})
+
+	// The remote can't be fetched: the mirror may be stale, so neither its
+	// commit nor its config is used
+	os.RemoveAll(remote)
+	task := &TaskConfig{AgentID: "agent-1", Project: "shop", RepoURL: remote, Prompt: "fix the cart"}
+	var fresh bool
+	task.Clone, fresh = o.cloneConfigFor(context.Background(), "shop", remote)
+	if fresh || task.Clone == nil || task.Clone.FromMirror {
+		t.Fatalf("unexpected clone config %+v (fresh %v)", task.Clone, fresh)
+	}
+	if err := o.applyProjectConfig(context.Background(), task, fresh); err != nil {
+		t.Fatalf("applyProjectConfig failed: %v", err)
+	}
+	if task.Tool != "" || task.Clone.Revision != "" {
+		t.Errorf("stale mirror used: tool=%q revision=%q", task.Tool, task.Clone.Revision)
+	}
+}
+
+func TestOrchestrator_CloneConfigFetchesMirror(t *testing.T) {
+	remote := t.TempDir()
+	gitRun(t, remote, "init", "-q")
+	commitAll(t, remote, "init")
+	cache := repocache.New(t.TempDir(), "/mnt/agentvm/repos", 0)
+	if _, err := cache.Ensure(context.Background(), remote); err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	o := New(nil, lima.NewMockClient(), t.TempDir(), "host.lima.internal:8090")
+	o.SetRepoCache(cache, map[string]CloneConfig{"shop": {FromMirror: true}})
+
+	// The remote moves on after the mirror was created
+	head := commitAll(t, remote, "second")
+	cc, fresh := o.cloneConfigFor(context.Background(), "shop", remote)
+	if cc == nil || !fresh || !cc.FromMirror || cc.Mirror != cache.VMPath(remote) {
+		t.Fatalf("unexpected clone config %+v", cc)
+	}
+	if got := gitRun(t, cache.Path(remote), "rev-parse", "HEAD"); got != head {
+		t.Errorf("mirror at %s, want %s", got, head)
+	}
+
+	// A mirror that can't be refreshed is only used as a reference
+	os.RemoveAll(remote)
+	cc, fresh = o.cloneConfigFor(context.Background(), "shop", remote)
+	if cc == nil || fresh || cc.FromMirror || cc.Mirror != cache.VMPath(remote) {
+		t.Errorf("unexpected clone config %+v", cc)
+	}
+}
+
+func gitRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitAll commits everything in dir, returning the new commit.
+func commitAll(t *testing.T, dir, message string) string {
+	t.Helper()
+	gitRun(t, dir, "add", "-A")
+	gitRun(t, dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", message)
+	return gitRun(t, dir, "rev-parse", "HEAD")
+}
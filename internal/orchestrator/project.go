@@ -0,0 +1,116 @@
+package orchestrator
+
+import (
+	"fmt"
+
+	"github.com/mateo/agentvm/internal/project"
+)
+
+// ValidateProjectConfig checks a repository's .agentvm.yaml, including values
+// that depend on orchestrator knowledge such as the supported tools.
+func ValidateProjectConfig(pc *project.Config) error {
+	if err := pc.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", project.FileName, err)
+	}
+	if pc.Tool != "" && !validTools[pc.Tool] {
+		return fmt.Errorf("%s: invalid tool %q", project.FileName, pc.Tool)
+	}
+	return nil
+}
+
+// ApplyProjectConfig merges project defaults under the task: a project value
+// is used only where the task field is unset or holds a built-in default.
+func ApplyProjectConfig(tc *TaskConfig, pc *project.Config) {
+	if pc.Tool != "" && (tc.Tool == "" || tc.IsDefault("tool")) {
+		tc.Tool = pc.Tool
+		tc.clearDefault("tool")
+	}
+	if pc.MaxTime > 0 && (tc.MaxTime <= 0 || tc.IsDefault("maxTime")) {
+		tc.MaxTime = pc.MaxTime
+		tc.clearDefault("maxTime")
+	}
+
+	if tc.ServeCommand == "" && pc.Serve.Command != "" {
+		tc.ServeCommand = pc.Serve.Command
+		if tc.ServePort <= 0 || tc.IsDefault("servePort") {
+			tc.ServePort = pc.Serve.Port
+			tc.clearDefault("servePort")
+		}
+	} else if pc.Serve.Port > 0 && (tc.ServePort <= 0 || tc.IsDefault("servePort")) {
+		tc.ServePort = pc.Serve.Port
+		tc.clearDefault("servePort")
+	}
+	// Project services replace a defaulted port, never an explicit one.
+	if len(tc.ServeServices) == 0 && len(pc.Serve.Services) > 0 && (tc.ServePort <= 0 || tc.IsDefault("servePort")) {
+		tc.ServeServices = pc.Serve.Services
+		tc.ServePort = 0
+		tc.clearDefault("servePort")
+	}
+	if len(tc.ServeTCP) == 0 {
+		tc.ServeTCP = pc.Serve.TCP
+	}
+	if tc.ServeHealth == nil {
+		tc.ServeHealth = pc.Serve.Health
+	}
+	if tc.ServeRestart == "" {
+		tc.ServeRestart = pc.Serve.Restart
+	}
+	if tc.ServeMaxRestarts <= 0 {
+		tc.ServeMaxRestarts = pc.Serve.MaxRestarts
+	}
+	if tc.ServeAlias == "" {
+		tc.ServeAlias = pc.Serve.Alias
+	}
+
+	for k, v := range pc.Env {
+		if _, ok := tc.EnvVars[k]; ok {
+			continue
+		}
+		if tc.EnvVars == nil {
+			tc.EnvVars = make(map[string]string)
+		}
+		tc.EnvVars[k] = v
+	}
+
+	if len(tc.SetupCommands) == 0 {
+		tc.SetupCommands = pc.Setup.Commands
+	}
+	if tc.SetupTimeout <= 0 {
+		tc.SetupTimeout = pc.Setup.Timeout
+	}
+	if pc.Setup.Devcontainer {
+		tc.Devcontainer = true
+	}
+	// Protected paths accumulate: a task can add protection but never
+	// lift what the repository declares.
+	for _, p := range pc.ProtectedPaths {
+		if !contains(tc.ProtectedPaths, p) {
+			tc.ProtectedPaths = append(tc.ProtectedPaths, p)
+		}
+	}
+	if tc.ProtectPolicy == "" {
+		tc.ProtectPolicy = pc.ProtectPolicy
+	}
+	if tc.CheckpointInterval <= 0 {
+		tc.CheckpointInterval = pc.CheckpointInterval
+	}
+	if tc.CommitMessage == "" {
+		tc.CommitMessage = pc.Commit.Message
+	}
+	if tc.AuthorName == "" && tc.AuthorEmail == "" {
+		tc.AuthorName = pc.Commit.AuthorName
+		tc.AuthorEmail = pc.Commit.AuthorEmail
+	}
+	if tc.SecretScan == "" {
+		tc.SecretScan = pc.SecretScan
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package orchestrator
+
+import (
+	"testing"
+
+	"github.com/mateo/agentvm/internal/project"
+)
+
+func TestApplyProjectConfig_FillsDefaults(t *testing.T) {
+	tc := &TaskConfig{
+		AgentID: "agent-1",
+		Project: "myproject",
+		RepoURL: "https://github.com/user/repo",
+		Prompt:  "Fix bug",
+	}
+	if err := ValidateTask(tc); err != nil {
+		t.Fatalf("ValidateTask failed: %v", err)
+	}
+
+	ApplyProjectConfig(tc, &project.Config{
+		Tool:    "opencode",
+		MaxTime: 60,
+		Env:     map[string]string{"NODE_ENV": "test"},
+		Serve:   project.ServeConfig{Command: "npm start", Port: 3000},
+		Setup:   project.SetupConfig{Commands: []string{"npm ci"}},
+	})
+
+	if tc.Tool != "opencode" {
+		t.Errorf("expected project tool to replace default, got %s", tc.Tool)
+	}
+	if tc.MaxTime != 60 {
+		t.Errorf("expected project maxTime to replace default, got %d", tc.MaxTime)
+	}
+	if tc.ServeCommand != "npm start" || tc.ServePort != 3000 {
+		t.Errorf("unexpected serve config: %s:%d", tc.ServeCommand, tc.ServePort)
+	}
+	if tc.EnvVars["NODE_ENV"] != "test" {
+		t.Errorf("expected project env var, got %v", tc.EnvVars)
+	}
+	if len(tc.SetupCommands) != 1 {
+		t.Errorf("expected project setup commands, got %v", tc.SetupCommands)
+	}
+	if len(tc.Defaults) != 0 {
+		t.Errorf("expected no remaining defaults, got %v", tc.Defaults)
+	}
+}
+
+func TestApplyProjectConfig_ExplicitWins(t *testing.T) {
+	tc := &TaskConfig{
+		AgentID:        "agent-1",
+		Project:        "myproject",
+		RepoURL:        "https://github.com/user/repo",
+		Tool:           "amp",
+		Prompt:         "Fix bug",
+		MaxTime:        10,
+		EnvVars:        map[string]string{"NODE_ENV": "production"},
+		ServeCommand:   "make serve",
+		ServePort:      9000,
+		ProtectedPaths: []string{"migrations/**"},
+	}
+	if err := ValidateTask(tc); err != nil {
+		t.Fatalf("ValidateTask failed: %v", err)
+	}
+
+	ApplyProjectConfig(tc, &project.Config{
+		Tool:           "opencode",
+		MaxTime:        60,
+		Env:            map[string]string{"NODE_ENV": "test"},
+		Serve:          project.ServeConfig{Command: "npm start", Port: 3000},
+		ProtectedPaths: []string{".github/workflows/**", "migrations/**"},
+	})
+
+	if tc.Tool != "amp" || tc.MaxTime != 10 {
+		t.Errorf("explicit tool/maxTime overridden: %s/%d", tc.Tool, tc.MaxTime)
+	}
+	if tc.ServeCommand != "make serve" || tc.ServePort != 9000 {
+		t.Errorf("explicit serve overridden: %s:%d", tc.ServeCommand, tc.ServePort)
+	}
+	if tc.EnvVars["NODE_ENV"] != "production" {
+		t.Errorf("explicit env var overridden: %v", tc.EnvVars)
+	}
+	if len(tc.ProtectedPaths) != 2 {
+		t.Errorf("expected protected paths to be merged, got %v", tc.ProtectedPaths)
+	}
+}
+
+func TestValidateProjectConfig_InvalidTool(t *testing.T) {
+	if err := ValidateProjectConfig(&project.Config{Tool: "vim"}); err == nil {
+		t.Fatal("expected error for invalid tool")
+	}
+	if err := ValidateProjectConfig(&project.Config{Tool: "amp"}); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+}
+
+func TestApplyProjectConfig_ProtectPolicy(t *testing.T) {
+	tc := &TaskConfig{}
+	ApplyProjectConfig(tc, &project.Config{ProtectPolicy: project.PolicyFail})
+	if tc.ProtectPolicy != project.PolicyFail {
+		t.Errorf("expected project policy, got %q", tc.ProtectPolicy)
+	}
+
+	tc = &TaskConfig{ProtectPolicy: project.PolicyRevert}
+	ApplyProjectConfig(tc, &project.Config{ProtectPolicy: project.PolicyFail})
+	if tc.ProtectPolicy != project.PolicyRevert {
+		t.Errorf("explicit policy overridden: %q", tc.ProtectPolicy)
+	}
+}
+
+func TestApplyProjectConfig_ServeServices(t *testing.T) {
+	pc := &project.Config{Serve: project.ServeConfig{
+		Command:  "npm run dev",
+		Services: []project.Service{{Name: "web", Port: 3000}, {Name: "api", Port: 4000}},
+	}}
+
+	tc := &TaskConfig{ServeCommand: "npm start", ServePort: 8080, Defaults: []string{"servePort"}}
+	ApplyProjectConfig(tc, pc)
+	if len(tc.ServeServices) != 2 || tc.ServePort != 0 {
+		t.Errorf("expected project services to replace default port, got %d services, port %d", len(tc.ServeServices), tc.ServePort)
+	}
+
+	tc = &TaskConfig{ServeCommand: "npm start", ServePort: 5000}
+	ApplyProjectConfig(tc, pc)
+	if len(tc.ServeServices) != 0 || tc.ServePort != 5000 {
+		t.Errorf("explicit port overridden: %d services, port %d", len(tc.ServeServices), tc.ServePort)
+	}
+}
+
+func TestApplyProjectConfig_Commit(t *testing.T) {
+	tc := &TaskConfig{}
+	ApplyProjectConfig(tc, &project.Config{Commit: project.CommitConfig{
+		Message:     "feat: {{.Summary}}",
+		AuthorName:  "Bot",
+		AuthorEmail: "bot@example.com",
+	}})
+	if tc.CommitMessage != "feat: {{.Summary}}" || tc.AuthorName != "Bot" || tc.AuthorEmail != "bot@example.com" {
+		t.Errorf("expected project commit settings, got %q %q %q", tc.CommitMessage, tc.AuthorName, tc.AuthorEmail)
+	}
+
+	tc = &TaskConfig{AuthorName: "Me", AuthorEmail: "me@example.com"}
+	ApplyProjectConfig(tc, &project.Config{Commit: project.CommitConfig{AuthorName: "Bot", AuthorEmail: "bot@example.com"}})
+	if tc.AuthorName != "Me" || tc.AuthorEmail != "me@example.com" {
+		t.Errorf("explicit author overridden: %s <%s>", tc.AuthorName, tc.AuthorEmail)
+	}
+}
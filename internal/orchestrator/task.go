@@ -5,23 +5,62 @@ import (
 	"fmt"
 	"os"
 	"time"
+
+	"github.com/mateo/agentvm/internal/project"
 )
 
 type TaskConfig struct {
-	AgentID      string            `json:"agentID"`
-	Project      string            `json:"project"`
-	RepoURL      string            `json:"repoURL"`
-	Issue        string            `json:"issue,omitempty"`
-	Tool         string            `json:"tool"`
-	Prompt       string            `json:"prompt"`
-	Branch       string            `json:"branch"`
-	MaxTime      int               `json:"maxTime"` // minutes
-	MaxTokens    int               `json:"maxTokens,omitempty"`
-	EnvVars      map[string]string `json:"envVars,omitempty"`
-	ServeCommand string            `json:"serveCommand,omitempty"`
-	ServePort    int               `json:"servePort,omitempty"`
-	HostAddr     string            `json:"hostAddr"` // e.g. "host.lima.internal:8090"
-	DispatchedAt time.Time         `json:"dispatchedAt"`
+	AgentID          string               `json:"agentID"`
+	Project          string               `json:"project"`
+	RepoURL          string               `json:"repoURL"`
+	Issue            string               `json:"issue,omitempty"`
+	Tool             string               `json:"tool"`
+	Prompt           string               `json:"prompt"`
+	Branch           string               `json:"branch"`
+	MaxTime          int                  `json:"maxTime"` // minutes
+	MaxTokens        int                  `json:"maxTokens,omitempty"`
+	EnvVars          map[string]string    `json:"envVars,omitempty"`
+	ServeCommand     string               `json:"serveCommand,omitempty"`
+	ServePort        int                  `json:"servePort,omitempty"`
+	ServeServices    []project.Service    `json:"serveServices,omitempty"`
+	ServeTCP         []project.TCPService `json:"serveTCP,omitempty"`
+	ServeHealth      *project.HealthCheck `json:"serveHealth,omitempty"`
+	ServeRestart     string               `json:"serveRestart,omitempty"` // never (default), on-failure or always
+	ServeMaxRestarts int                  `json:"serveMaxRestarts,omitempty"`
+	// ServeAlias is the stable preview name; project.AliasBranch (from
+	// .agentvm.yaml) is resolved against Branch when the app registers.
+	ServeAlias     string   `json:"serveAlias,omitempty"`
+	SetupCommands  []string `json:"setupCommands,omitempty"`
+	SetupTimeout   int      `json:"setupTimeout,omitempty"` // minutes
+	Devcontainer   bool     `json:"devcontainer,omitempty"`
+	ProtectedPaths []string `json:"protectedPaths,omitempty"`
+	ProtectPolicy  string   `json:"protectPolicy,omitempty"` // revert (default) or fail
+	SecretScan     string   `json:"secretScan,omitempty"`    // block (default), warn or off
+	// CheckpointInterval is how often work in progress is snapshotted while
+	// the tool runs, in minutes (default 10).
+	CheckpointInterval int `json:"checkpointInterval,omitempty"`
+	// CommitMessage is a text/template for the harness's commit (see
+	// project.CommitData); AuthorName/AuthorEmail override the VM identity.
+	CommitMessage string `json:"commitMessage,omitempty"`
+	AuthorName    string `json:"authorName,omitempty"`
+	AuthorEmail   string `json:"authorEmail,omitempty"`
+	// Defaults lists fields that ValidateTask filled with built-in defaults
+	// rather than explicit request values; .agentvm.yaml may override them.
+	Defaults     []string     `json:"defaults,omitempty"`
+	HostAddr     string       `json:"hostAddr"` // e.g. "host.lima.internal:8090"
+	Clone        *CloneConfig `json:"clone,omitempty"`
+	DispatchedAt time.Time    `json:"dispatchedAt"`
+}
+
+// CloneConfig tells the harness how to clone the repository.
+type CloneConfig struct {
+	Mirror     string `json:"mirror,omitempty"` // VM path to a bare mirror of RepoURL
+	FromMirror bool   `json:"fromMirror,omitempty"`
+	Depth      int    `json:"depth,omitempty"`
+	Filter     string `json:"filter,omitempty"`
+	// Revision is the commit agentd read .agentvm.yaml from; the harness
+	// checks it out so both see the same config.
+	Revision string `json:"revision,omitempty"`
 }
 
 var validTools = map[string]bool{
@@ -41,21 +80,123 @@ func ValidateTask(tc *TaskConfig) error {
 	if tc.Prompt == "" {
 		return fmt.Errorf("prompt is required")
 	}
+	if tc.Tool == "" {
+		tc.Tool = "claude-code"
+		tc.markDefault("tool")
+	}
 	if !validTools[tc.Tool] {
 		return fmt.Errorf("invalid tool %q (valid: claude-code, opencode, amp, cline)", tc.Tool)
 	}
 	if tc.MaxTime <= 0 {
 		tc.MaxTime = 30
+		tc.markDefault("maxTime")
+	}
+	if !project.ValidPolicy(tc.ProtectPolicy) {
+		return fmt.Errorf("invalid protect policy %q (valid: revert, fail)", tc.ProtectPolicy)
+	}
+	if tc.CheckpointInterval < 0 {
+		return fmt.Errorf("checkpoint interval must not be negative")
+	}
+	if tc.CommitMessage != "" {
+		if err := project.ValidateCommitTemplate(tc.CommitMessage); err != nil {
+			return err
+		}
+	}
+	if (tc.AuthorName == "") != (tc.AuthorEmail == "") {
+		return fmt.Errorf("author name and email must be set together")
+	}
+	if !project.ValidSecretScan(tc.SecretScan) {
+		return fmt.Errorf("invalid secret scan mode %q (valid: block, warn, off)", tc.SecretScan)
 	}
 	if tc.Branch == "" {
 		tc.Branch = fmt.Sprintf("agent/%s/%s", tc.Project, tc.AgentID)
 	}
-	if tc.ServeCommand != "" && tc.ServePort <= 0 {
+	if len(tc.ServeServices) > 0 {
+		if tc.ServeCommand == "" {
+			return fmt.Errorf("serve services require a serve command")
+		}
+		if err := project.ValidateServices(tc.ServeServices); err != nil {
+			return err
+		}
+	}
+	if len(tc.ServeTCP) > 0 {
+		if tc.ServeCommand == "" {
+			return fmt.Errorf("TCP services require a serve command")
+		}
+		if err := project.ValidateTCPServices(tc.ServeTCP, tc.ServeServices); err != nil {
+			return err
+		}
+	}
+	if tc.ServeAlias != "" {
+		if tc.ServeCommand == "" {
+			return fmt.Errorf("alias requires a serve command")
+		}
+		alias, err := project.ResolveAlias(tc.ServeAlias, tc.Branch)
+		if err != nil {
+			return err
+		}
+		tc.ServeAlias = alias
+	}
+	if !project.ValidRestartPolicy(tc.ServeRestart) {
+		return fmt.Errorf("invalid serve restart policy %q (valid: never, on-failure, always)", tc.ServeRestart)
+	}
+	if tc.ServeMaxRestarts < 0 {
+		return fmt.Errorf("serve max restarts must not be negative")
+	}
+	if tc.ServeHealth != nil {
+		if tc.ServeCommand == "" {
+			return fmt.Errorf("health check requires a serve command")
+		}
+		if err := tc.ServeHealth.Validate(); err != nil {
+			return err
+		}
+	}
+	if tc.ServeCommand != "" && tc.ServePort <= 0 && len(tc.ServeServices) == 0 {
 		tc.ServePort = 8080
+		tc.markDefault("servePort")
 	}
 	return nil
 }
 
+func (tc *TaskConfig) markDefault(field string) {
+	if !tc.IsDefault(field) {
+		tc.Defaults = append(tc.Defaults, field)
+	}
+}
+
+// IsDefault reports whether field holds a built-in default rather than an
+// explicitly requested value.
+func (tc *TaskConfig) IsDefault(field string) bool {
+	for _, f := range tc.Defaults {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *TaskConfig) clearDefault(field string) {
+	for i, f := range tc.Defaults {
+		if f == field {
+			tc.Defaults = append(tc.Defaults[:i], tc.Defaults[i+1:]...)
+			return
+		}
+	}
+}
+
+// Redacted returns a copy safe to persist on the host: env var values are
+// replaced so secrets passed via --env don't end up in history files.
+func (tc *TaskConfig) Redacted() *TaskConfig {
+	c := *tc
+	if len(tc.EnvVars) > 0 {
+		c.EnvVars = make(map[string]string, len(tc.EnvVars))
+		for k := range tc.EnvVars {
+			c.EnvVars[k] = "[redacted]"
+		}
+	}
+	return &c
+}
+
 func WriteTaskConfig(tc *TaskConfig, path string) error {
 	data, err := json.MarshalIndent(tc, "", "  ")
 	if err != nil {
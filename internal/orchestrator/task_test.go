@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/mateo/agentvm/internal/project"
 )
 
 func TestValidateTask_Valid(t *testing.T) {
@@ -25,6 +27,38 @@ func TestValidateTask_Valid(t *testing.T) {
 	if tc.Branch == "" {
 		t.Error("expected branch to be auto-generated")
 	}
+	if !tc.IsDefault("maxTime") {
+		t.Error("expected maxTime to be marked as defaulted")
+	}
+	if tc.IsDefault("tool") {
+		t.Error("explicit tool should not be marked as defaulted")
+	}
+}
+
+func TestValidateTask_DefaultTool(t *testing.T) {
+	tc := &TaskConfig{
+		AgentID: "agent-1",
+		Project: "myproject",
+		RepoURL: "https://github.com/user/repo",
+		Prompt:  "Fix bug",
+	}
+	if err := ValidateTask(tc); err != nil {
+		t.Fatalf("expected valid, got error: %v", err)
+	}
+	if tc.Tool != "claude-code" || !tc.IsDefault("tool") {
+		t.Errorf("expected defaulted claude-code, got %s (defaults %v)", tc.Tool, tc.Defaults)
+	}
+}
+
+func TestTaskConfig_Redacted(t *testing.T) {
+	tc := &TaskConfig{EnvVars: map[string]string{"API_KEY": "secret"}}
+	r := tc.Redacted()
+	if r.EnvVars["API_KEY"] == "secret" {
+		t.Error("expected env var value to be redacted")
+	}
+	if tc.EnvVars["API_KEY"] != "secret" {
+		t.Error("Redacted must not modify the original")
+	}
 }
 
 func TestValidateTask_MissingProject(t *testing.T) {
@@ -72,6 +106,18 @@ func TestValidateTask_InvalidTool(t *testing.T) {
 	}
 }
 
+func TestValidateTask_InvalidProtectPolicy(t *testing.T) {
+	tc := &TaskConfig{
+		Project:       "myproject",
+		RepoURL:       "https://github.com/user/repo",
+		Prompt:        "Fix bug",
+		ProtectPolicy: "ignore",
+	}
+	if err := ValidateTask(tc); err == nil {
+		t.Fatal("expected error for invalid protect policy")
+	}
+}
+
 func TestValidateTask_AllTools(t *testing.T) {
 	tools := []string{"claude-code", "opencode", "amp", "cline"}
 	for _, tool := range tools {
@@ -125,6 +171,115 @@ func TestValidateTask_ServePortExplicit(t *testing.T) {
 	}
 }
 
+func TestValidateTask_ServeServices(t *testing.T) {
+	tc := &TaskConfig{
+		AgentID:       "agent-1",
+		Project:       "myproject",
+		RepoURL:       "https://github.com/user/repo",
+		Prompt:        "Fix bug",
+		ServeCommand:  "docker compose up",
+		ServeServices: []project.Service{{Name: "web", Port: 3000}, {Name: "api", Port: 8080, Path: "/api"}},
+	}
+	if err := ValidateTask(tc); err != nil {
+		t.Fatalf("expected valid, got error: %v", err)
+	}
+	if tc.ServePort != 0 {
+		t.Errorf("servePort should not default alongside services, got %d", tc.ServePort)
+	}
+
+	tc.ServeServices = append(tc.ServeServices, project.Service{Name: "API", Port: 9000})
+	if err := ValidateTask(tc); err == nil {
+		t.Error("expected error for invalid service name")
+	}
+
+	tc.ServeServices = tc.ServeServices[:1]
+	tc.ServeCommand = ""
+	if err := ValidateTask(tc); err == nil {
+		t.Error("expected error for services without serve command")
+	}
+}
+
+func TestValidateTask_ServeTCP(t *testing.T) {
+	tc := &TaskConfig{
+		AgentID:       "agent-1",
+		Project:       "myproject",
+		RepoURL:       "https://github.com/user/repo",
+		Prompt:        "Fix bug",
+		ServeCommand:  "docker compose up",
+		ServeServices: []project.Service{{Name: "web", Port: 3000}},
+		ServeTCP:      []project.TCPService{{Name: "postgres", Port: 5432}},
+	}
+	if err := ValidateTask(tc); err != nil {
+		t.Fatalf("expected valid, got error: %v", err)
+	}
+
+	tc.ServeTCP = append(tc.ServeTCP, project.TCPService{Name: "web", Port: 6379})
+	if err := ValidateTask(tc); err == nil {
+		t.Error("expected error for TCP service named like an HTTP service")
+	}
+
+	tc.ServeTCP = tc.ServeTCP[:1]
+	tc.ServeCommand = ""
+	tc.ServeServices = nil
+	if err := ValidateTask(tc); err == nil {
+		t.Error("expected error for TCP services without serve command")
+	}
+}
+
+func TestValidateTask_ServeAlias(t *testing.T) {
+	tc := &TaskConfig{
+		AgentID:      "agent-1",
+		Project:      "myproject",
+		RepoURL:      "https://github.com/user/repo",
+		Prompt:       "Fix bug",
+		Branch:       "feature/Login",
+		ServeCommand: "npm run dev",
+		ServeAlias:   project.AliasBranch,
+	}
+	if err := ValidateTask(tc); err != nil {
+		t.Fatalf("expected valid, got error: %v", err)
+	}
+	if tc.ServeAlias != "feature-login" {
+		t.Errorf("expected alias feature-login, got %q", tc.ServeAlias)
+	}
+
+	tc.ServeAlias = "agent-7"
+	if err := ValidateTask(tc); err == nil {
+		t.Error("expected error for an alias shadowing agent hosts")
+	}
+
+	tc.ServeAlias = "demo"
+	tc.ServeCommand = ""
+	if err := ValidateTask(tc); err == nil {
+		t.Error("expected error for alias without serve command")
+	}
+}
+
+func TestValidateTask_ServeHealth(t *testing.T) {
+	tc := &TaskConfig{
+		AgentID:      "agent-1",
+		Project:      "myproject",
+		RepoURL:      "https://github.com/user/repo",
+		Prompt:       "Fix bug",
+		ServeCommand: "docker compose up",
+		ServeHealth:  &project.HealthCheck{Path: "/healthz"},
+	}
+	if err := ValidateTask(tc); err != nil {
+		t.Fatalf("expected valid, got error: %v", err)
+	}
+
+	tc.ServeHealth.Status = 999
+	if err := ValidateTask(tc); err == nil {
+		t.Error("expected error for invalid status")
+	}
+
+	tc.ServeHealth.Status = 0
+	tc.ServeCommand = ""
+	if err := ValidateTask(tc); err == nil {
+		t.Error("expected error for health check without serve command")
+	}
+}
+
 func TestValidateTask_NoServeNoPort(t *testing.T) {
 	tc := &TaskConfig{
 		AgentID: "agent-1",
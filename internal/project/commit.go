@@ -0,0 +1,61 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultCommitTemplate reproduces the historical "agent/<id>: <prompt>"
+// subject and adds trailers identifying the agent.
+const DefaultCommitTemplate = `agent/{{.AgentID}}: {{.Summary}}
+
+Agent-ID: {{.AgentID}}
+Agent-Tool: {{.Tool}}{{if .Model}}
+Agent-Model: {{.Model}}{{end}}`
+
+// CommitData is the data available to commit message templates.
+type CommitData struct {
+	AgentID string
+	Project string
+	Issue   string
+	Tool    string
+	Model   string
+	Branch  string
+	Prompt  string
+	Summary string // first line of the prompt, truncated
+}
+
+// RenderCommitMessage executes a commit message template (text/template
+// syntax). An empty template uses DefaultCommitTemplate.
+func RenderCommitMessage(tmpl string, data CommitData) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultCommitTemplate
+	}
+	t, err := template.New("commit").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parsing commit template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("rendering commit template: %w", err)
+	}
+	msg := strings.TrimSpace(buf.String())
+	if msg == "" {
+		return "", fmt.Errorf("commit template rendered an empty message")
+	}
+	return msg + "\n", nil
+}
+
+// ValidateCommitTemplate checks that tmpl parses and only references known
+// fields.
+func ValidateCommitTemplate(tmpl string) error {
+	_, err := RenderCommitMessage(tmpl, CommitData{
+		AgentID: "agent-0",
+		Tool:    "claude-code",
+		Prompt:  "prompt",
+		Summary: "prompt",
+	})
+	return err
+}
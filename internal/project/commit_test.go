@@ -0,0 +1,49 @@
+package project
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderCommitMessage_Default(t *testing.T) {
+	msg, err := RenderCommitMessage("", CommitData{
+		AgentID: "agent-1",
+		Tool:    "claude-code",
+		Model:   "claude-sonnet-4",
+		Summary: "Fix the login bug",
+	})
+	if err != nil {
+		t.Fatalf("RenderCommitMessage failed: %v", err)
+	}
+	want := "agent/agent-1: Fix the login bug\n\nAgent-ID: agent-1\nAgent-Tool: claude-code\nAgent-Model: claude-sonnet-4\n"
+	if msg != want {
+		t.Errorf("expected %q, got %q", want, msg)
+	}
+
+	msg, _ = RenderCommitMessage("", CommitData{AgentID: "agent-1", Tool: "amp", Summary: "x"})
+	if strings.Contains(msg, "Agent-Model") {
+		t.Errorf("expected no model trailer, got %q", msg)
+	}
+}
+
+func TestRenderCommitMessage_Custom(t *testing.T) {
+	tmpl := "fix{{if .Issue}}({{.Issue}}){{end}}: {{.Summary}}\n\nRefs: {{.Issue}}"
+	msg, err := RenderCommitMessage(tmpl, CommitData{Issue: "PROJ-123", Summary: "handle nil user"})
+	if err != nil {
+		t.Fatalf("RenderCommitMessage failed: %v", err)
+	}
+	if msg != "fix(PROJ-123): handle nil user\n\nRefs: PROJ-123\n" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestValidateCommitTemplate(t *testing.T) {
+	if err := ValidateCommitTemplate("feat: {{.Summary}}"); err != nil {
+		t.Errorf("expected valid template, got %v", err)
+	}
+	for _, tmpl := range []string{"{{.Nope}}", "{{.Summary", "{{if .Issue}}{{end}}"} {
+		if err := ValidateCommitTemplate(tmpl); err == nil {
+			t.Errorf("expected error for %q", tmpl)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package project
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the per-repository configuration file read from the repo root.
+const FileName = ".agentvm.yaml"
+
+// Config is the optional per-repository project configuration. Every field is
+// a default: explicit values in a dispatch request take precedence.
+type Config struct {
+	Tool           string            `yaml:"tool,omitempty" json:"tool,omitempty"`
+	MaxTime        int               `yaml:"maxTime,omitempty" json:"maxTime,omitempty"` // minutes
+	Env            map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Setup          SetupConfig       `yaml:"setup,omitempty" json:"setup,omitempty"`
+	Verify         VerifyConfig      `yaml:"verify,omitempty" json:"verify,omitempty"`
+	Serve          ServeConfig       `yaml:"serve,omitempty" json:"serve,omitempty"`
+	ProtectedPaths []string          `yaml:"protectedPaths,omitempty" json:"protectedPaths,omitempty"`
+	ProtectPolicy  string            `yaml:"protectPolicy,omitempty" json:"protectPolicy,omitempty"` // revert (default) or fail
+	SecretScan     string            `yaml:"secretScan,omitempty" json:"secretScan,omitempty"`       // block (default), warn or off
+	// CheckpointInterval is how often work in progress is snapshotted, in
+	// minutes.
+	CheckpointInterval int          `yaml:"checkpointInterval,omitempty" json:"checkpointInterval,omitempty"`
+	Artifacts          []string     `yaml:"artifacts,omitempty" json:"artifacts,omitempty"` // recorded with the task, not collected
+	Commit             CommitConfig `yaml:"commit,omitempty" json:"commit,omitempty"`
+}
+
+// Protected path policies: what the harness does when the coding tool
+// modifies a file matching ProtectedPaths.
+const (
+	PolicyRevert = "revert" // restore the original files and continue
+	PolicyFail   = "fail"   // fail the task without pushing
+)
+
+// ValidPolicy reports whether p is a known protected path policy. An empty
+// policy means PolicyRevert.
+func ValidPolicy(p string) bool {
+	return p == "" || p == PolicyRevert || p == PolicyFail
+}
+
+// Serve restart policies: what the harness does when the serve command exits.
+const (
+	RestartNever     = "never"      // report completed or failed and stop
+	RestartOnFailure = "on-failure" // restart after a non-zero exit, with backoff
+	RestartAlways    = "always"     // restart after any exit, with backoff
+)
+
+// ValidRestartPolicy reports whether p is a known restart policy. An empty
+// policy means RestartNever.
+func ValidRestartPolicy(p string) bool {
+	return p == "" || p == RestartNever || p == RestartOnFailure || p == RestartAlways
+}
+
+// Secret scan modes: what the harness does when the agent's diff contains
+// potential secrets.
+const (
+	SecretScanBlock = "block" // fail the task without pushing
+	SecretScanWarn  = "warn"  // push, but report the findings
+	SecretScanOff   = "off"   // don't scan
+)
+
+// ValidSecretScan reports whether m is a known secret scan mode. An empty
+// mode means SecretScanBlock.
+func ValidSecretScan(m string) bool {
+	return m == "" || m == SecretScanBlock || m == SecretScanWarn || m == SecretScanOff
+}
+
+// SetupConfig describes commands run after the branch is created and before
+// the coding tool starts.
+type SetupConfig struct {
+	Commands     []string `yaml:"commands,omitempty" json:"commands,omitempty"`
+	Timeout      int      `yaml:"timeout,omitempty" json:"timeout,omitempty"`           // minutes
+	Devcontainer bool     `yaml:"devcontainer,omitempty" json:"devcontainer,omitempty"` // honor .devcontainer/devcontainer.json
+}
+
+// VerifyConfig declares commands that check the agent's work, such as tests
+// or linters. They are recorded with the project in the task's effective
+// config; the harness does not run them.
+type VerifyConfig struct {
+	Commands []string `yaml:"commands,omitempty" json:"commands,omitempty"`
+	Timeout  int      `yaml:"timeout,omitempty" json:"timeout,omitempty"` // minutes
+}
+
+// CommitConfig describes how the harness commits the agent's changes.
+type CommitConfig struct {
+	Message     string `yaml:"message,omitempty" json:"message,omitempty"` // text/template, see CommitData
+	AuthorName  string `yaml:"authorName,omitempty" json:"authorName,omitempty"`
+	AuthorEmail string `yaml:"authorEmail,omitempty" json:"authorEmail,omitempty"`
+}
+
+// ServeConfig describes how to serve the app after the branch is pushed.
+type ServeConfig struct {
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	Port    int    `yaml:"port,omitempty" json:"port,omitempty"`
+	// Services exposes several named ports instead of Port.
+	Services []Service `yaml:"services,omitempty" json:"services,omitempty"`
+	// TCP lists non-HTTP ports (databases, caches) to route as well.
+	TCP     []TCPService `yaml:"tcp,omitempty" json:"tcp,omitempty"`
+	Health  *HealthCheck `yaml:"health,omitempty" json:"health,omitempty"`
+	Restart string       `yaml:"restart,omitempty" json:"restart,omitempty"` // never (default), on-failure or always
+	// MaxRestarts caps automatic restarts; 0 means unlimited.
+	MaxRestarts int `yaml:"maxRestarts,omitempty" json:"maxRestarts,omitempty"`
+	// Alias is a stable preview name, <alias>.<project>.<domain>, that
+	// follows the newest agent claiming it; "branch" derives it from the
+	// task's branch.
+	Alias string `yaml:"alias,omitempty" json:"alias,omitempty"`
+}
+
+// Load reads FileName from dir. A missing file yields an empty config.
+func Load(dir string) (*Config, error) {
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", FileName, err)
+	}
+	return Parse(data)
+}
+
+// ResolveRevision returns the commit rev points to in a (possibly bare) git
+// repository.
+func ResolveRevision(ctx context.Context, gitDir, rev string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", "--git-dir", gitDir, "rev-parse", "--verify", "--quiet", rev+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("resolving %s in %s: %w", rev, gitDir, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ReadRevision returns the raw contents of FileName at rev in a (possibly
+// bare) git repository, such as a host-side mirror. A missing file yields nil.
+func ReadRevision(ctx context.Context, gitDir, rev string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", "--git-dir", gitDir, "show", rev+":"+FileName)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := stderr.String()
+		if strings.Contains(msg, "does not exist") || strings.Contains(msg, "invalid object name") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s from %s: %w\n%s", FileName, gitDir, err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Parse decodes a project config from YAML. Unknown keys are rejected so
+// typos don't silently fall back to defaults.
+func Parse(data []byte) (*Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing %s: %w", FileName, err)
+	}
+	return &cfg, nil
+}
+
+// Validate checks values that can be verified without the task context.
+func (c *Config) Validate() error {
+	if c.MaxTime < 0 {
+		return fmt.Errorf("maxTime must not be negative")
+	}
+	if c.CheckpointInterval < 0 {
+		return fmt.Errorf("checkpointInterval must not be negative")
+	}
+	if c.Setup.Timeout < 0 || c.Verify.Timeout < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	if c.Serve.Port < 0 || c.Serve.Port > 65535 {
+		return fmt.Errorf("serve.port %d out of range", c.Serve.Port)
+	}
+	if c.Serve.Port > 0 && c.Serve.Command == "" {
+		return fmt.Errorf("serve.port set without serve.command")
+	}
+	if len(c.Serve.Services) > 0 {
+		if c.Serve.Command == "" {
+			return fmt.Errorf("serve.services set without serve.command")
+		}
+		if c.Serve.Port > 0 {
+			return fmt.Errorf("serve.port and serve.services are mutually exclusive")
+		}
+		if err := ValidateServices(c.Serve.Services); err != nil {
+			return fmt.Errorf("serve.services: %w", err)
+		}
+	}
+	if len(c.Serve.TCP) > 0 {
+		if c.Serve.Command == "" {
+			return fmt.Errorf("serve.tcp set without serve.command")
+		}
+		if err := ValidateTCPServices(c.Serve.TCP, c.Serve.Services); err != nil {
+			return fmt.Errorf("serve.tcp: %w", err)
+		}
+	}
+	if c.Serve.Alias != "" {
+		if c.Serve.Command == "" {
+			return fmt.Errorf("serve.alias set without serve.command")
+		}
+		if c.Serve.Alias != AliasBranch {
+			if err := ValidateAlias(c.Serve.Alias); err != nil {
+				return fmt.Errorf("serve.alias: %w", err)
+			}
+		}
+	}
+	if !ValidRestartPolicy(c.Serve.Restart) {
+		return fmt.Errorf("invalid serve.restart %q (valid: never, on-failure, always)", c.Serve.Restart)
+	}
+	if c.Serve.MaxRestarts < 0 {
+		return fmt.Errorf("serve.maxRestarts must not be negative")
+	}
+	if c.Serve.Health != nil {
+		if c.Serve.Command == "" {
+			return fmt.Errorf("serve.health set without serve.command")
+		}
+		if err := c.Serve.Health.Validate(); err != nil {
+			return fmt.Errorf("serve.health: %w", err)
+		}
+	}
+	for _, cmds := range [][]string{c.Setup.Commands, c.Verify.Commands} {
+		for _, cmd := range cmds {
+			if strings.TrimSpace(cmd) == "" {
+				return fmt.Errorf("empty command")
+			}
+		}
+	}
+	if !ValidPolicy(c.ProtectPolicy) {
+		return fmt.Errorf("invalid protectPolicy %q (valid: revert, fail)", c.ProtectPolicy)
+	}
+	if !ValidSecretScan(c.SecretScan) {
+		return fmt.Errorf("invalid secretScan %q (valid: block, warn, off)", c.SecretScan)
+	}
+	for _, globs := range [][]string{c.ProtectedPaths, c.Artifacts} {
+		for _, g := range globs {
+			if _, err := path.Match(g, ""); err != nil {
+				return fmt.Errorf("invalid glob %q: %w", g, err)
+			}
+		}
+	}
+	if c.Commit.Message != "" {
+		if err := ValidateCommitTemplate(c.Commit.Message); err != nil {
+			return fmt.Errorf("commit.message: %w", err)
+		}
+	}
+	if (c.Commit.AuthorName == "") != (c.Commit.AuthorEmail == "") {
+		return fmt.Errorf("commit.authorName and commit.authorEmail must be set together")
+	}
+	for k := range c.Env {
+		if k == "" || strings.ContainsAny(k, "= \t\n") {
+			return fmt.Errorf("invalid env var name %q", k)
+		}
+	}
+	return nil
+}
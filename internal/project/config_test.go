@@ -0,0 +1,136 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad_Missing(t *testing.T) {
+	cfg, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.Setup.Commands) != 0 {
+		t.Errorf("expected empty config, got %+v", cfg)
+	}
+}
+
+func TestLoad_Setup(t *testing.T) {
+	dir := t.TempDir()
+	content := `setup:
+  commands:
+    - npm ci
+    - cp .env.example .env
+  timeout: 15
+  devcontainer: true
+`
+	os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0644)
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.Setup.Commands) != 2 || cfg.Setup.Commands[0] != "npm ci" {
+		t.Errorf("unexpected setup commands: %v", cfg.Setup.Commands)
+	}
+	if cfg.Setup.Timeout != 15 {
+		t.Errorf("expected timeout 15, got %d", cfg.Setup.Timeout)
+	}
+	if !cfg.Setup.Devcontainer {
+		t.Error("expected devcontainer enabled")
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, FileName), []byte("setup: [not a map"), 0644)
+
+	if _, err := Load(dir); err == nil {
+		t.Fatal("expected error for invalid YAML")
+	}
+}
+
+func TestParse_Full(t *testing.T) {
+	content := `tool: opencode
+maxTime: 45
+env:
+  NODE_ENV: development
+verify:
+  commands: [npm test]
+serve:
+  command: docker compose up
+  port: 3000
+protectedPaths:
+  - .github/workflows/*
+  - package-lock.json
+artifacts:
+  - coverage/*
+`
+	cfg, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if cfg.Tool != "opencode" || cfg.MaxTime != 45 {
+		t.Errorf("unexpected tool/maxTime: %s/%d", cfg.Tool, cfg.MaxTime)
+	}
+	if cfg.Env["NODE_ENV"] != "development" {
+		t.Errorf("unexpected env: %v", cfg.Env)
+	}
+	if cfg.Serve.Command != "docker compose up" || cfg.Serve.Port != 3000 {
+		t.Errorf("unexpected serve: %+v", cfg.Serve)
+	}
+	if len(cfg.ProtectedPaths) != 2 || len(cfg.Artifacts) != 1 || len(cfg.Verify.Commands) != 1 {
+		t.Errorf("unexpected lists: %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+}
+
+func TestParse_UnknownField(t *testing.T) {
+	if _, err := Parse([]byte("tol: amp\n")); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestParse_Empty(t *testing.T) {
+	cfg, err := Parse(nil)
+	if err != nil {
+		t.Fatalf("Parse of empty file failed: %v", err)
+	}
+	if cfg.Tool != "" {
+		t.Errorf("expected empty config, got %+v", cfg)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"negative maxTime", Config{MaxTime: -1}},
+		{"port out of range", Config{Serve: ServeConfig{Command: "x", Port: 70000}}},
+		{"port without command", Config{Serve: ServeConfig{Port: 3000}}},
+		{"services without command", Config{Serve: ServeConfig{Services: []Service{{Name: "web", Port: 3000}}}}},
+		{"port and services", Config{Serve: ServeConfig{Command: "x", Port: 3000, Services: []Service{{Name: "web", Port: 3000}}}}},
+		{"bad restart policy", Config{Serve: ServeConfig{Command: "x", Restart: "sometimes"}}},
+		{"health without command", Config{Serve: ServeConfig{Health: &HealthCheck{Path: "/healthz"}}}},
+		{"relative health path", Config{Serve: ServeConfig{Command: "x", Health: &HealthCheck{Path: "healthz"}}}},
+		{"duplicate service", Config{Serve: ServeConfig{Command: "x", Services: []Service{{Name: "web", Port: 3000}, {Name: "web", Port: 3001}}}}},
+		{"empty setup command", Config{Setup: SetupConfig{Commands: []string{" "}}}},
+		{"bad glob", Config{ProtectedPaths: []string{"[abc"}}},
+		{"bad protect policy", Config{ProtectPolicy: "ignore"}},
+		{"bad secret scan mode", Config{SecretScan: "maybe"}},
+		{"bad commit template", Config{Commit: CommitConfig{Message: "{{.Nope}}"}}},
+		{"author name without email", Config{Commit: CommitConfig{AuthorName: "Bot"}}},
+		{"bad env name", Config{Env: map[string]string{"A B": "x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+}
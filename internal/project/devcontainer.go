@@ -0,0 +1,189 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Devcontainer holds the parts of .devcontainer/devcontainer.json that the
+// harness can honor inside an agent VM.
+type Devcontainer struct {
+	OnCreateCommand      []string
+	UpdateContentCommand []string
+	PostCreateCommand    []string
+	Features             []string // feature IDs; not installed by the harness
+}
+
+// Commands returns the lifecycle commands in the order the devcontainer spec
+// runs them.
+func (d *Devcontainer) Commands() []string {
+	var cmds []string
+	cmds = append(cmds, d.OnCreateCommand...)
+	cmds = append(cmds, d.UpdateContentCommand...)
+	cmds = append(cmds, d.PostCreateCommand...)
+	return cmds
+}
+
+// LoadDevcontainer reads .devcontainer/devcontainer.json (or
+// .devcontainer.json) from dir. It returns nil if neither exists.
+func LoadDevcontainer(dir string) (*Devcontainer, error) {
+	var data []byte
+	var err error
+	for _, p := range []string{
+		filepath.Join(dir, ".devcontainer", "devcontainer.json"),
+		filepath.Join(dir, ".devcontainer.json"),
+	} {
+		data, err = os.ReadFile(p)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading devcontainer.json: %w", err)
+		}
+	}
+	if data == nil {
+		return nil, nil
+	}
+	return ParseDevcontainer(data)
+}
+
+// ParseDevcontainer decodes devcontainer.json, which may contain comments and
+// trailing commas.
+func ParseDevcontainer(data []byte) (*Devcontainer, error) {
+	var raw struct {
+		OnCreateCommand      json.RawMessage            `json:"onCreateCommand"`
+		UpdateContentCommand json.RawMessage            `json:"updateContentCommand"`
+		PostCreateCommand    json.RawMessage            `json:"postCreateCommand"`
+		Features             map[string]json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(stripJSONC(data), &raw); err != nil {
+		return nil, fmt.Errorf("parsing devcontainer.json: %w", err)
+	}
+
+	dc := &Devcontainer{}
+	var err error
+	if dc.OnCreateCommand, err = lifecycleCommands(raw.OnCreateCommand); err != nil {
+		return nil, fmt.Errorf("onCreateCommand: %w", err)
+	}
+	if dc.UpdateContentCommand, err = lifecycleCommands(raw.UpdateContentCommand); err != nil {
+		return nil, fmt.Errorf("updateContentCommand: %w", err)
+	}
+	if dc.PostCreateCommand, err = lifecycleCommands(raw.PostCreateCommand); err != nil {
+		return nil, fmt.Errorf("postCreateCommand: %w", err)
+	}
+	for id := range raw.Features {
+		dc.Features = append(dc.Features, id)
+	}
+	sort.Strings(dc.Features)
+	return dc, nil
+}
+
+// lifecycleCommands converts a devcontainer lifecycle command to shell
+// command lines. The spec allows a string (run by a shell), an array (exec
+// form) or an object of named commands (run in parallel by the spec; run in
+// name order here).
+func lifecycleCommands(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		if str == "" {
+			return nil, nil
+		}
+		return []string{str}, nil
+	}
+
+	var argv []string
+	if err := json.Unmarshal(raw, &argv); err == nil {
+		if len(argv) == 0 {
+			return nil, nil
+		}
+		return []string{shellJoin(argv)}, nil
+	}
+
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, fmt.Errorf("unsupported command format")
+	}
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var cmds []string
+	for _, name := range names {
+		sub, err := lifecycleCommands(named[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		cmds = append(cmds, sub...)
+	}
+	return cmds, nil
+}
+
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// stripJSONC removes // and /* */ comments and trailing commas outside of
+// strings so the result can be decoded with encoding/json.
+func stripJSONC(data []byte) []byte {
+	var out []byte
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i+1 < len(data) && !(data[i] == '*' && data[i+1] == '/') {
+				i++
+			}
+			i++
+		case c == ']' || c == '}':
+			// Drop a trailing comma before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && (out[j] == ' ' || out[j] == '\t' || out[j] == '\n' || out[j] == '\r') {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDevcontainer_Forms(t *testing.T) {
+	data := []byte(`{
+  // Comments and trailing commas are allowed
+  "name": "app",
+  "onCreateCommand": "make deps",
+  "postCreateCommand": ["npm", "run", "it's ready"],
+  "updateContentCommand": {
+    "b": "echo b",
+    "a": "echo a",
+  },
+  /* features are reported but not installed */
+  "features": {
+    "ghcr.io/devcontainers/features/go:1": {},
+  },
+}`)
+
+	dc, err := ParseDevcontainer(data)
+	if err != nil {
+		t.Fatalf("ParseDevcontainer failed: %v", err)
+	}
+
+	want := []string{"make deps", "echo a", "echo b", `'npm' 'run' 'it'\''s ready'`}
+	got := dc.Commands()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("command[%d]: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+	if len(dc.Features) != 1 || dc.Features[0] != "ghcr.io/devcontainers/features/go:1" {
+		t.Errorf("unexpected features: %v", dc.Features)
+	}
+}
+
+func TestParseDevcontainer_URLInString(t *testing.T) {
+	dc, err := ParseDevcontainer([]byte(`{"postCreateCommand": "curl https://example.com/x"}`))
+	if err != nil {
+		t.Fatalf("ParseDevcontainer failed: %v", err)
+	}
+	if dc.PostCreateCommand[0] != "curl https://example.com/x" {
+		t.Errorf("comment stripping should not touch strings, got %q", dc.PostCreateCommand[0])
+	}
+}
+
+func TestLoadDevcontainer(t *testing.T) {
+	dir := t.TempDir()
+	dc, err := LoadDevcontainer(dir)
+	if err != nil || dc != nil {
+		t.Fatalf("expected nil for missing devcontainer, got %v, %v", dc, err)
+	}
+
+	os.MkdirAll(filepath.Join(dir, ".devcontainer"), 0755)
+	os.WriteFile(filepath.Join(dir, ".devcontainer", "devcontainer.json"),
+		[]byte(`{"postCreateCommand": "npm ci"}`), 0644)
+
+	dc, err = LoadDevcontainer(dir)
+	if err != nil {
+		t.Fatalf("LoadDevcontainer failed: %v", err)
+	}
+	if len(dc.PostCreateCommand) != 1 || dc.PostCreateCommand[0] != "npm ci" {
+		t.Errorf("unexpected postCreateCommand: %v", dc.PostCreateCommand)
+	}
+}
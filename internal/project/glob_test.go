@@ -0,0 +1,30 @@
+package project
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"package-lock.json", "package-lock.json", true},
+		{"package-lock.json", "web/package-lock.json", true},
+		{"/package-lock.json", "web/package-lock.json", false},
+		{"*.lock", "Cargo.lock", true},
+		{".github/workflows/*", ".github/workflows/ci.yml", true},
+		{".github/workflows/*", ".github/workflows/sub/ci.yml", false},
+		{".github/workflows/**", ".github/workflows/sub/ci.yml", true},
+		{".github/", ".github/dependabot.yml", true},
+		{"migrations/**/*.sql", "migrations/001.sql", true},
+		{"migrations/**/*.sql", "migrations/2024/001.sql", true},
+		{"migrations/**/*.sql", "db/migrations/001.sql", false},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "main.go", false},
+	}
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HealthCheck configures HTTP probes against the served app's primary port.
+// The same probe gates readiness after the port opens and then runs
+// periodically as a liveness check.
+type HealthCheck struct {
+	Path             string `yaml:"path" json:"path"`
+	Status           int    `yaml:"status,omitempty" json:"status,omitempty"`                     // expected status; 0 accepts any 2xx or 3xx
+	Interval         int    `yaml:"interval,omitempty" json:"interval,omitempty"`                 // seconds between probes (default 10)
+	Timeout          int    `yaml:"timeout,omitempty" json:"timeout,omitempty"`                   // seconds per probe (default 3)
+	FailureThreshold int    `yaml:"failureThreshold,omitempty" json:"failureThreshold,omitempty"` // consecutive failures before unhealthy (default 3)
+	StartTimeout     int    `yaml:"startTimeout,omitempty" json:"startTimeout,omitempty"`         // seconds to wait for readiness (default 300)
+}
+
+// Validate checks the probe settings.
+func (h *HealthCheck) Validate() error {
+	if !strings.HasPrefix(h.Path, "/") {
+		return fmt.Errorf("health path %q must start with /", h.Path)
+	}
+	if h.Status != 0 && (h.Status < 100 || h.Status > 599) {
+		return fmt.Errorf("health status %d is not an HTTP status", h.Status)
+	}
+	if h.Interval < 0 || h.Timeout < 0 || h.FailureThreshold < 0 || h.StartTimeout < 0 {
+		return fmt.Errorf("health check settings must not be negative")
+	}
+	return nil
+}
+
+// WithDefaults returns a copy with unset fields filled in.
+func (h HealthCheck) WithDefaults() HealthCheck {
+	if h.Interval == 0 {
+		h.Interval = 10
+	}
+	if h.Timeout == 0 {
+		h.Timeout = 3
+	}
+	if h.FailureThreshold == 0 {
+		h.FailureThreshold = 3
+	}
+	if h.StartTimeout == 0 {
+		h.StartTimeout = 300
+	}
+	return h
+}
+
+// Healthy reports whether an HTTP status code passes the check.
+func (h *HealthCheck) Healthy(code int) bool {
+	if h.Status != 0 {
+		return code == h.Status
+	}
+	return code >= 200 && code < 400
+}
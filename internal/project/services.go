@@ -0,0 +1,163 @@
+package project
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Service is one named port exposed by the serve command. The first service
+// is primary: it answers on the agent's bare hostname and receives $PORT.
+type Service struct {
+	Name string `yaml:"name" json:"name"`
+	Port int    `yaml:"port" json:"port"`
+	Path string `yaml:"path,omitempty" json:"path,omitempty"` // route on the primary host under this prefix instead of a subdomain
+}
+
+var serviceName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// ParseService parses the command-line form "name:port[/path]",
+// e.g. "web:3000" or "api:8080/api".
+func ParseService(spec string) (Service, error) {
+	name, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return Service{}, fmt.Errorf("invalid service %q (want name:port[/path])", spec)
+	}
+	portStr, path, hasPath := strings.Cut(rest, "/")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return Service{}, fmt.Errorf("invalid service %q: bad port %q", spec, portStr)
+	}
+	svc := Service{Name: name, Port: port}
+	if hasPath {
+		svc.Path = "/" + path
+	}
+	return svc, nil
+}
+
+// ValidateServices checks that service names are unique DNS labels, ports are
+// in range and paths are absolute.
+func ValidateServices(services []Service) error {
+	names := make(map[string]bool, len(services))
+	for _, svc := range services {
+		if !serviceName.MatchString(svc.Name) {
+			return fmt.Errorf("invalid service name %q (lowercase letters, digits and dashes)", svc.Name)
+		}
+		if names[svc.Name] {
+			return fmt.Errorf("duplicate service name %q", svc.Name)
+		}
+		names[svc.Name] = true
+		if svc.Port <= 0 || svc.Port > 65535 {
+			return fmt.Errorf("service %s: port %d out of range", svc.Name, svc.Port)
+		}
+		if svc.Path != "" && (!strings.HasPrefix(svc.Path, "/") || svc.Path == "/") {
+			return fmt.Errorf("service %s: path %q must start with / and not be the root", svc.Name, svc.Path)
+		}
+	}
+	return nil
+}
+
+// TCPService is a non-HTTP port of the served stack, such as a database.
+// Plain ports are forwarded from a dedicated host port; TLS ports are routed
+// by SNI at <name>.<agent host>, with the router terminating TLS.
+type TCPService struct {
+	Name string `yaml:"name" json:"name"`
+	Port int    `yaml:"port" json:"port"`
+	TLS  bool   `yaml:"tls,omitempty" json:"tls,omitempty"`
+}
+
+// ParseTCPService parses the command-line form "name:port[/tls]",
+// e.g. "postgres:5432" or "redis:6379/tls".
+func ParseTCPService(spec string) (TCPService, error) {
+	name, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return TCPService{}, fmt.Errorf("invalid TCP service %q (want name:port[/tls])", spec)
+	}
+	portStr, mode, hasMode := strings.Cut(rest, "/")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return TCPService{}, fmt.Errorf("invalid TCP service %q: bad port %q", spec, portStr)
+	}
+	if hasMode && mode != "tls" {
+		return TCPService{}, fmt.Errorf("invalid TCP service %q: unknown mode %q (want tls)", spec, mode)
+	}
+	return TCPService{Name: name, Port: port, TLS: hasMode}, nil
+}
+
+// ValidateTCPServices checks TCP services like ValidateServices; their names
+// must also differ from the HTTP services' since both become subdomains.
+func ValidateTCPServices(tcp []TCPService, services []Service) error {
+	names := make(map[string]bool, len(tcp)+len(services))
+	for _, svc := range services {
+		names[svc.Name] = true
+	}
+	for _, svc := range tcp {
+		if !serviceName.MatchString(svc.Name) {
+			return fmt.Errorf("invalid TCP service name %q (lowercase letters, digits and dashes)", svc.Name)
+		}
+		if names[svc.Name] {
+			return fmt.Errorf("duplicate service name %q", svc.Name)
+		}
+		names[svc.Name] = true
+		if svc.Port <= 0 || svc.Port > 65535 {
+			return fmt.Errorf("TCP service %s: port %d out of range", svc.Name, svc.Port)
+		}
+	}
+	return nil
+}
+
+// AliasBranch as a serve alias names the preview after the task's branch.
+const AliasBranch = "branch"
+
+var aliasLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidateAlias checks that alias is a DNS label that cannot be mistaken for
+// an agent's own host.
+func ValidateAlias(alias string) error {
+	if !aliasLabel.MatchString(alias) {
+		return fmt.Errorf("invalid alias %q (lowercase letters, digits and dashes, at most 63)", alias)
+	}
+	if strings.HasPrefix(alias, "agent-") {
+		return fmt.Errorf("alias %q is reserved: agent-* names are agent hosts", alias)
+	}
+	return nil
+}
+
+// ResolveAlias turns a serve alias setting into the label used in
+// <alias>.<project>.<domain>: AliasBranch derives it from branch, any other
+// value must be a valid alias itself.
+func ResolveAlias(alias, branch string) (string, error) {
+	if alias == AliasBranch {
+		alias = BranchAlias(branch)
+		if alias == "" {
+			return "", fmt.Errorf("branch %q yields no alias", branch)
+		}
+	}
+	if err := ValidateAlias(alias); err != nil {
+		return "", err
+	}
+	return alias, nil
+}
+
+// BranchAlias sanitizes a branch name into a DNS label: lowercased, with
+// every run of other characters turned into a dash, at most 63 long.
+// "feature/Login_Page" becomes "feature-login-page".
+func BranchAlias(branch string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(branch) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	alias := b.String()
+	if len(alias) > 63 {
+		alias = alias[:63]
+	}
+	return strings.TrimRight(alias, "-")
+}
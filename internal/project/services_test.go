@@ -0,0 +1,128 @@
+package project
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseService(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Service
+	}{
+		{"web:3000", Service{Name: "web", Port: 3000}},
+		{"api:8080/api", Service{Name: "api", Port: 8080, Path: "/api"}},
+		{"docs:4000/v1/docs", Service{Name: "docs", Port: 4000, Path: "/v1/docs"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseService(tt.spec)
+		if err != nil {
+			t.Errorf("ParseService(%q): %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseService(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"web", "web:abc", "web:"} {
+		if _, err := ParseService(spec); err == nil {
+			t.Errorf("ParseService(%q): expected error", spec)
+		}
+	}
+}
+
+func TestValidateServices(t *testing.T) {
+	valid := []Service{{Name: "web", Port: 3000}, {Name: "api-v2", Port: 8080, Path: "/api"}}
+	if err := ValidateServices(valid); err != nil {
+		t.Fatalf("expected valid, got %v", err)
+	}
+
+	invalid := map[string][]Service{
+		"bad name":      {{Name: "Web_UI", Port: 3000}},
+		"empty name":    {{Port: 3000}},
+		"duplicate":     {{Name: "web", Port: 3000}, {Name: "web", Port: 3001}},
+		"port range":    {{Name: "web", Port: 70000}},
+		"relative path": {{Name: "api", Port: 8080, Path: "api"}},
+		"root path":     {{Name: "api", Port: 8080, Path: "/"}},
+	}
+	for name, services := range invalid {
+		if err := ValidateServices(services); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseTCPService(t *testing.T) {
+	tests := []struct {
+		spec string
+		want TCPService
+	}{
+		{"postgres:5432", TCPService{Name: "postgres", Port: 5432}},
+		{"redis:6379/tls", TCPService{Name: "redis", Port: 6379, TLS: true}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTCPService(tt.spec)
+		if err != nil {
+			t.Errorf("ParseTCPService(%q): %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTCPService(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"postgres", "db:x", "db:5432/udp"} {
+		if _, err := ParseTCPService(spec); err == nil {
+			t.Errorf("ParseTCPService(%q): expected error", spec)
+		}
+	}
+}
+
+func TestValidateTCPServices(t *testing.T) {
+	web := []Service{{Name: "web", Port: 3000}}
+	if err := ValidateTCPServices([]TCPService{{Name: "postgres", Port: 5432}, {Name: "redis", Port: 6379, TLS: true}}, web); err != nil {
+		t.Fatalf("expected valid, got %v", err)
+	}
+	invalid := map[string][]TCPService{
+		"clashes with http": {{Name: "web", Port: 5432}},
+		"duplicate":         {{Name: "db", Port: 5432}, {Name: "db", Port: 5433}},
+		"bad port":          {{Name: "db", Port: 70000}},
+		"bad name":          {{Name: "DB", Port: 5432}},
+	}
+	for name, tcp := range invalid {
+		if err := ValidateTCPServices(tcp, web); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResolveAlias(t *testing.T) {
+	tests := []struct {
+		alias, branch, want string
+		wantErr             bool
+	}{
+		{alias: "branch", branch: "feature/Login_Page", want: "feature-login-page"},
+		{alias: "branch", branch: "--fix//typo--", want: "fix-typo"},
+		{alias: "demo", branch: "main", want: "demo"},
+		{alias: "branch", branch: "///", wantErr: true},
+		{alias: "branch", branch: "agent/p/agent-1", wantErr: true},
+		{alias: "agent-42", wantErr: true},
+		{alias: "Demo", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveAlias(tt.alias, tt.branch)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveAlias(%q, %q) error = %v, wantErr %v", tt.alias, tt.branch, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ResolveAlias(%q, %q) = %q, want %q", tt.alias, tt.branch, got, tt.want)
+		}
+	}
+
+	long := BranchAlias(strings.Repeat("a", 62) + "/b")
+	if len(long) > 63 || strings.HasSuffix(long, "-") {
+		t.Errorf("BranchAlias of a long branch = %q", long)
+	}
+}
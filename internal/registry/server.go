@@ -6,12 +6,15 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/mateo/agentvm/internal/history"
 )
 
 type Server struct {
-	store    *Store
+	store      *Store
 	onRegister func(reg *AgentRegistration) // callback for traefik config
-	mux      *http.ServeMux
+	history    *history.Store
+	mux        *http.ServeMux
 }
 
 func NewServer(store *Store, onRegister func(reg *AgentRegistration)) *Server {
@@ -23,11 +26,19 @@ func NewServer(store *Store, onRegister func(reg *AgentRegistration)) *Server {
 	s.mux.HandleFunc("POST /register", s.handleRegister)
 	s.mux.HandleFunc("POST /deregister", s.handleDeregister)
 	s.mux.HandleFunc("POST /status", s.handleStatus)
+	s.mux.HandleFunc("POST /history", s.handleHistory)
+	s.mux.HandleFunc("POST /ports", s.handlePorts)
 	s.mux.HandleFunc("GET /agents", s.handleListAgents)
 	s.mux.HandleFunc("GET /health", s.handleHealth)
 	return s
 }
 
+// SetHistory enables POST /history, which lets harnesses record what
+// actually ran (effective config, reports) in the host-side task history.
+func (s *Server) SetHistory(h *history.Store) {
+	s.history = h
+}
+
 func (s *Server) Handler() http.Handler {
 	return s.mux
 }
@@ -46,11 +57,21 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		Project:       req.Project,
 		Tool:          req.Tool,
 		Ports:         req.Ports,
+		Services:      req.Services,
+		TCP:           req.TCP,
+		Health:        req.Health,
+		Alias:         req.Alias,
 		State:         "registered",
 		RegisteredAt:  time.Now(),
 		LastHeartbeat: time.Now(),
 	}
 
+	// Agents are registered at dispatch; keep what was recorded since
+	if prev, ok := s.store.Get(req.AgentID); ok {
+		reg.Exposed = prev.Exposed
+		reg.Branch = prev.Branch
+	}
+
 	s.store.Register(reg)
 	log.Printf("Agent %s registered from %s (project: %s, tool: %s)", req.AgentID, req.VMIP, req.Project, req.Tool)
 
@@ -75,16 +96,23 @@ func (s *Server) handleDeregister(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	var report struct {
-		AgentID string `json:"agentID"`
-		State   string `json:"state"`
-		Message string `json:"message,omitempty"`
-		Branch  string `json:"branch,omitempty"`
+		AgentID  string `json:"agentID"`
+		State    string `json:"state"`
+		Message  string `json:"message,omitempty"`
+		Branch   string `json:"branch,omitempty"`
+		Restarts int    `json:"restarts,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
+	if report.Restarts > 0 {
+		if err := s.store.SetRestarts(report.AgentID, report.Restarts); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+	}
 	if err := s.store.UpdateState(report.AgentID, report.State, report.Message, report.Branch); err != nil {
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
 		return
@@ -94,6 +122,40 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"ok": "true"})
 }
 
+func (s *Server) handlePorts(w http.ResponseWriter, r *http.Request) {
+	var req PortsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.AgentID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "agentID is required"})
+		return
+	}
+
+	s.store.SetListening(req.AgentID, req.Ports)
+	writeJSON(w, http.StatusOK, map[string]string{"ok": "true"})
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "history not enabled"})
+		return
+	}
+
+	var req HistoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := s.history.Write(req.AgentID, req.Name, req.Data); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"ok": "true"})
+}
+
 func (s *Server) handleListAgents(w http.ResponseWriter, r *http.Request) {
 	agents := s.store.List()
 	writeJSON(w, http.StatusOK, agents)
@@ -5,7 +5,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/mateo/agentvm/internal/history"
 )
 
 func setupTestServer(t *testing.T) (*Server, *Store) {
@@ -149,3 +153,132 @@ func TestServer_StatusUpdate(t *testing.T) {
 		t.Errorf("expected running, got %s", reg.State)
 	}
 }
+
+func TestServer_StatusRestarts(t *testing.T) {
+	srv, store := setupTestServer(t)
+
+	store.Register(&AgentRegistration{AgentID: "agent-1", State: "serving"})
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"agentID":  "agent-1",
+		"state":    "restarting",
+		"restarts": 2,
+	})
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/status", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A plain status update keeps the count.
+	body, _ = json.Marshal(map[string]string{"agentID": "agent-1", "state": "serving"})
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/status", bytes.NewReader(body)))
+
+	reg, _ := store.Get("agent-1")
+	if reg.State != "serving" || reg.Restarts != 2 {
+		t.Errorf("expected serving with 2 restarts, got %s with %d", reg.State, reg.Restarts)
+	}
+}
+
+func TestStore_SetRestartsPersistsAndNotifies(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	store.Register(&AgentRegistration{AgentID: "agent-1", State: "serving"})
+
+	events := store.Subscribe()
+	if err := store.SetRestarts("agent-1", 3); err != nil {
+		t.Fatalf("SetRestarts failed: %v", err)
+	}
+	select {
+	case ev := <-events:
+		if ev.Type != EventAgentUpdated || ev.Agent.Restarts != 3 {
+			t.Errorf("expected update with 3 restarts, got %s with %d", ev.Type, ev.Agent.Restarts)
+		}
+	default:
+		t.Error("expected an update event")
+	}
+
+	reloaded, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	reg, ok := reloaded.Get("agent-1")
+	if !ok || reg.Restarts != 3 {
+		t.Errorf("expected 3 restarts after reload, got %+v", reg)
+	}
+
+	if err := store.SetRestarts("missing", 1); err == nil {
+		t.Error("expected error for unregistered agent")
+	}
+}
+
+func TestServer_History(t *testing.T) {
+	srv, _ := setupTestServer(t)
+
+	body, _ := json.Marshal(HistoryRequest{
+		AgentID: "agent-1",
+		Name:    "effective",
+		Data:    json.RawMessage(`{"tool":"amp"}`),
+	})
+
+	// Disabled until a history store is attached
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/history", bytes.NewReader(body)))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without history store, got %d", w.Code)
+	}
+
+	base := t.TempDir()
+	srv.SetHistory(history.NewStore(base))
+
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/history", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(base, "history", "agent-1", "effective.json")); err != nil {
+		t.Errorf("expected effective record to be stored: %v", err)
+	}
+}
+
+func TestServer_Ports(t *testing.T) {
+	srv, store := setupTestServer(t)
+
+	// Agents that do not serve report ports too
+	body, _ := json.Marshal(PortsRequest{AgentID: "agent-1", Ports: []ListeningPort{
+		{Port: 3000, Process: "node"},
+		{Port: 5432, Process: "postgres", Loopback: true},
+	}})
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/ports", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := store.Listening("agent-1"); len(got) != 2 {
+		t.Fatalf("expected 2 listening ports, got %+v", got)
+	}
+
+	// Exposed ports are no longer listed, and survive the app registering
+	store.Register(&AgentRegistration{AgentID: "agent-1", Exposed: []Service{{Name: "port-3000", Port: 3000}}})
+	body, _ = json.Marshal(RegisterRequest{AgentID: "agent-1", Ports: []int{8080}})
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/register", bytes.NewReader(body)))
+
+	reg, _ := store.Get("agent-1")
+	if len(reg.Exposed) != 1 || reg.Exposed[0].Port != 3000 {
+		t.Errorf("exposed ports lost on register: %+v", reg.Exposed)
+	}
+	if got := store.Listening("agent-1"); len(got) != 1 || got[0].Port != 5432 {
+		t.Errorf("expected only 5432 listed, got %+v", got)
+	}
+
+	store.Deregister("agent-1")
+	if got := store.Listening("agent-1"); len(got) != 0 {
+		t.Errorf("expected no ports after deregister, got %+v", got)
+	}
+}
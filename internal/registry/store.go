@@ -13,14 +13,16 @@ type Store struct {
 	mu          sync.RWMutex
 	agents      map[string]*AgentRegistration
 	path        string
+	listening   map[string][]ListeningPort // agent ID -> discovered ports, kept in memory only
 	subscribers []chan StoreEvent
 	subMu       sync.Mutex
 }
 
 func NewStore(baseDir string) (*Store, error) {
 	s := &Store{
-		agents: make(map[string]*AgentRegistration),
-		path:   filepath.Join(baseDir, "registry.json"),
+		agents:    make(map[string]*AgentRegistration),
+		listening: make(map[string][]ListeningPort),
+		path:      filepath.Join(baseDir, "registry.json"),
 	}
 
 	if err := s.load(); err != nil {
@@ -84,6 +86,7 @@ func (s *Store) Deregister(agentID string) {
 	s.mu.Lock()
 	agent := s.agents[agentID]
 	delete(s.agents, agentID)
+	delete(s.listening, agentID)
 	s.persist()
 	s.mu.Unlock()
 
@@ -137,6 +140,114 @@ func (s *Store) UpdateState(agentID, state, message, branch string) error {
 	return nil
 }
 
+// UpdateVMIP records a new address for the agent's VM, e.g. after it was
+// restarted.
+func (s *Store) UpdateVMIP(agentID, vmIP string) error {
+	s.mu.Lock()
+	reg, ok := s.agents[agentID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("agent %q not registered", agentID)
+	}
+	reg.VMIP = vmIP
+	s.persist()
+	s.mu.Unlock()
+
+	s.notify(StoreEvent{
+		Type:    EventAgentUpdated,
+		AgentID: agentID,
+		Agent:   reg,
+	})
+	return nil
+}
+
+// SetTCPRoutes records the host ports allocated to the agent's TCP
+// services.
+func (s *Store) SetTCPRoutes(agentID string, routes []TCPRoute) error {
+	s.mu.Lock()
+	reg, ok := s.agents[agentID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("agent %q not registered", agentID)
+	}
+	reg.TCPRoutes = routes
+	s.persist()
+	s.mu.Unlock()
+
+	s.notify(StoreEvent{
+		Type:    EventAgentUpdated,
+		AgentID: agentID,
+		Agent:   reg,
+	})
+	return nil
+}
+
+// SetRestarts records how many times the agent's serve process has
+// restarted.
+func (s *Store) SetRestarts(agentID string, restarts int) error {
+	s.mu.Lock()
+	reg, ok := s.agents[agentID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("agent %q not registered", agentID)
+	}
+	reg.Restarts = restarts
+	s.persist()
+	s.mu.Unlock()
+
+	s.notify(StoreEvent{
+		Type:    EventAgentUpdated,
+		AgentID: agentID,
+		Agent:   reg,
+	})
+	return nil
+}
+
+// SetListening records the ports the agent's VM listens on. They are kept
+// apart from registrations because agents that do not serve anything report
+// them too, and registrations are what gets routed. Subscribers are notified
+// only when the agent is registered.
+func (s *Store) SetListening(agentID string, ports []ListeningPort) {
+	s.mu.Lock()
+	if len(ports) > 0 {
+		s.listening[agentID] = ports
+	} else {
+		delete(s.listening, agentID)
+	}
+	reg, ok := s.agents[agentID]
+	s.mu.Unlock()
+
+	if ok {
+		s.notify(StoreEvent{
+			Type:    EventAgentUpdated,
+			AgentID: agentID,
+			Agent:   reg,
+		})
+	}
+}
+
+// Listening returns the ports last reported for the agent that are not
+// routed yet.
+func (s *Store) Listening(agentID string) []ListeningPort {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reg, ok := s.agents[agentID]
+	if !ok || !reg.Serves() {
+		return s.listening[agentID]
+	}
+	routed := make(map[int]bool)
+	for _, svc := range reg.RoutedServices() {
+		routed[svc.Port] = true
+	}
+	var ports []ListeningPort
+	for _, p := range s.listening[agentID] {
+		if !routed[p.Port] {
+			ports = append(ports, p)
+		}
+	}
+	return ports
+}
+
 func (s *Store) load() error {
 	data, err := os.ReadFile(s.path)
 	if err != nil {
@@ -1,19 +1,92 @@
 package registry
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type AgentRegistration struct {
-	AgentID       string    `json:"agentID"`
-	VMName        string    `json:"vmName"`
-	VMIP          string    `json:"vmIP"`
-	Project       string    `json:"project"`
-	Tool          string    `json:"tool"`
-	Branch        string    `json:"branch,omitempty"`
-	Message       string    `json:"message,omitempty"`
-	Ports         []int     `json:"ports,omitempty"`
-	State         string    `json:"state"` // registered, running, completed, failed
-	RegisteredAt  time.Time `json:"registeredAt"`
-	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	AgentID       string       `json:"agentID"`
+	VMName        string       `json:"vmName"`
+	VMIP          string       `json:"vmIP"`
+	Project       string       `json:"project"`
+	Tool          string       `json:"tool"`
+	Branch        string       `json:"branch,omitempty"`
+	Message       string       `json:"message,omitempty"`
+	Ports         []int        `json:"ports,omitempty"`
+	Services      []Service    `json:"services,omitempty"`  // named ports; when empty Ports[0] is served
+	TCP           []TCPService `json:"tcp,omitempty"`       // non-HTTP ports
+	TCPRoutes     []TCPRoute   `json:"tcpRoutes,omitempty"` // host ports allocated to TCP services
+	Exposed       []Service    `json:"exposed,omitempty"`   // discovered ports promoted to previews
+	Alias         string       `json:"alias,omitempty"`     // stable preview name claimed, <alias>.<project>
+	Health        *HealthCheck `json:"health,omitempty"`
+	Restarts      int          `json:"restarts,omitempty"` // serve process restarts
+	State         string       `json:"state"`              // dispatched, registered, then as reported by the harness
+	RegisteredAt  time.Time    `json:"registeredAt"`
+	LastHeartbeat time.Time    `json:"lastHeartbeat"`
+}
+
+// Service is a named port of a served app. The first service is routed at
+// the agent's own host; every service is also reachable at
+// <name>.<agent host>, or under a path prefix of the agent host when Path is
+// set.
+type Service struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	Path string `json:"path,omitempty"` // e.g. "/api"
+}
+
+// RoutedServices returns the services the router publishes: the named
+// services, or a single unnamed service on the first registered port (8080
+// if none) for harnesses that only report ports, followed by exposed ports.
+// An agent that only has exposed ports is published through those alone.
+func (r *AgentRegistration) RoutedServices() []Service {
+	var services []Service
+	switch {
+	case len(r.Services) > 0:
+		services = append(services, r.Services...)
+	case len(r.Ports) > 0:
+		services = append(services, Service{Port: r.Ports[0]})
+	case len(r.Exposed) == 0:
+		services = append(services, Service{Port: 8080})
+	}
+	return append(services, r.Exposed...)
+}
+
+// Serves reports whether the agent has anything to route.
+func (r *AgentRegistration) Serves() bool {
+	return len(r.Ports) > 0 || len(r.Services) > 0 || len(r.Exposed) > 0
+}
+
+// ListeningPort is a TCP port an agent's VM listens on, as discovered by the
+// harness.
+type ListeningPort struct {
+	Port     int    `json:"port"`
+	Process  string `json:"process,omitempty"`
+	Loopback bool   `json:"loopback,omitempty"` // bound to localhost only, so not reachable from the host
+}
+
+// TCPService is a non-HTTP port of a served app. TLS services are routed by
+// SNI at <name>.<agent host>; the others get a dedicated host port.
+type TCPService struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	TLS  bool   `json:"tls,omitempty"`
+}
+
+// TCPRoute records the host port forwarding to a TCP service.
+type TCPRoute struct {
+	Name     string `json:"name"`
+	HostPort int    `json:"hostPort"`
+}
+
+// HealthCheck is the HTTP probe the router runs against the primary service
+// so traffic stops when the app stops answering.
+type HealthCheck struct {
+	Path     string `json:"path"`
+	Status   int    `json:"status,omitempty"`   // expected status; 0 accepts any 2xx or 3xx
+	Interval int    `json:"interval,omitempty"` // seconds
+	Timeout  int    `json:"timeout,omitempty"`  // seconds
 }
 
 // StoreEventType identifies the kind of store change.
@@ -33,12 +106,16 @@ type StoreEvent struct {
 }
 
 type RegisterRequest struct {
-	AgentID string `json:"agentID"`
-	VMName  string `json:"vmName"`
-	VMIP    string `json:"vmIP"`
-	Project string `json:"project"`
-	Tool    string `json:"tool"`
-	Ports   []int  `json:"ports,omitempty"`
+	AgentID  string       `json:"agentID"`
+	VMName   string       `json:"vmName"`
+	VMIP     string       `json:"vmIP"`
+	Project  string       `json:"project"`
+	Tool     string       `json:"tool"`
+	Ports    []int        `json:"ports,omitempty"`
+	Services []Service    `json:"services,omitempty"`
+	TCP      []TCPService `json:"tcp,omitempty"`
+	Health   *HealthCheck `json:"health,omitempty"`
+	Alias    string       `json:"alias,omitempty"`
 }
 
 type RegisterResponse struct {
@@ -49,3 +126,16 @@ type RegisterResponse struct {
 type DeregisterRequest struct {
 	AgentID string `json:"agentID"`
 }
+
+// PortsRequest reports the ports an agent's VM currently listens on.
+type PortsRequest struct {
+	AgentID string          `json:"agentID"`
+	Ports   []ListeningPort `json:"ports"`
+}
+
+// HistoryRequest records a named JSON document in an agent's task history.
+type HistoryRequest struct {
+	AgentID string          `json:"agentID"`
+	Name    string          `json:"name"` // e.g. "effective", "report"
+	Data    json.RawMessage `json:"data"`
+}
@@ -0,0 +1,228 @@
+package repocache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache maintains bare mirror clones of task repositories under the shared
+// directory, which is mounted read-only into every VM. The harness clones
+// with --reference against these mirrors so only missing objects are fetched
+// from the remote.
+type Cache struct {
+	dir      string // host path, e.g. ~/.agentvm/shared/repos
+	vmDir    string // same directory as seen from inside a VM
+	interval time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // keyed by mirror name
+
+	stopCh chan struct{}
+}
+
+func New(dir, vmDir string, interval time.Duration) *Cache {
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	return &Cache{
+		dir:      dir,
+		vmDir:    vmDir,
+		interval: interval,
+		locks:    make(map[string]*sync.Mutex),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start refreshes all existing mirrors on the configured interval.
+func (c *Cache) Start(ctx context.Context) {
+	go c.loop(ctx)
+}
+
+func (c *Cache) Stop() {
+	close(c.stopCh)
+}
+
+// Name returns the mirror directory name for a repository URL. It keeps the
+// last path component for readability and appends a short hash of the full
+// URL so different hosts or owners never collide.
+func Name(repoURL string) string {
+	sum := sha256.Sum256([]byte(normalizeURL(repoURL)))
+	base := strings.TrimSuffix(filepath.Base(strings.TrimRight(repoURL, "/")), ".git")
+	base = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, base)
+	if base == "" || base == "." {
+		base = "repo"
+	}
+	return fmt.Sprintf("%s-%s.git", base, hex.EncodeToString(sum[:])[:12])
+}
+
+// Path returns the host path of the mirror for repoURL.
+func (c *Cache) Path(repoURL string) string {
+	return filepath.Join(c.dir, Name(repoURL))
+}
+
+// VMPath returns the path of the mirror for repoURL as seen inside a VM.
+func (c *Cache) VMPath(repoURL string) string {
+	return c.vmDir + "/" + Name(repoURL)
+}
+
+// Exists reports whether a mirror has already been created for repoURL.
+func (c *Cache) Exists(repoURL string) bool {
+	_, err := os.Stat(filepath.Join(c.Path(repoURL), "HEAD"))
+	return err == nil
+}
+
+// Ensure creates the mirror for repoURL if it does not exist yet, or fetches
+// the latest refs into it otherwise. It returns the host path of the mirror.
+func (c *Cache) Ensure(ctx context.Context, repoURL string) (string, error) {
+	name := Name(repoURL)
+	lock := c.lockFor(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := filepath.Join(c.dir, name)
+	if _, err := os.Stat(filepath.Join(path, "HEAD")); err == nil {
+		if err := fetch(ctx, path); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", fmt.Errorf("creating cache dir: %w", err)
+	}
+
+	// Clone into a temp dir and rename so VMs never see a half-written mirror
+	tmp := path + ".tmp"
+	os.RemoveAll(tmp)
+	log.Printf("RepoCache: creating mirror for %s", repoURL)
+	if _, err := git(ctx, "", "clone", "--mirror", repoURL, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	// Objects are shared with VM clones via alternates; never let auto gc
+	// prune them out from under a running clone.
+	if _, err := git(ctx, tmp, "config", "gc.auto", "0"); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("installing mirror: %w", err)
+	}
+	return path, nil
+}
+
+// EnsureAsync runs Ensure in the background, logging failures.
+func (c *Cache) EnsureAsync(repoURL string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+		if _, err := c.Ensure(ctx, repoURL); err != nil {
+			log.Printf("RepoCache: refreshing %s failed: %v", repoURL, err)
+		}
+	}()
+}
+
+// Refresh fetches into every mirror in the cache directory.
+func (c *Cache) Refresh(ctx context.Context) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("RepoCache: reading %s: %v", c.dir, err)
+		}
+		return
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasSuffix(e.Name(), ".git") {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		default:
+		}
+
+		lock := c.lockFor(e.Name())
+		lock.Lock()
+		if err := fetch(ctx, filepath.Join(c.dir, e.Name())); err != nil {
+			log.Printf("RepoCache: refreshing %s failed: %v", e.Name(), err)
+		}
+		lock.Unlock()
+	}
+}
+
+func (c *Cache) loop(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			c.Refresh(ctx)
+		}
+	}
+}
+
+func (c *Cache) lockFor(name string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.locks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[name] = l
+	}
+	return l
+}
+
+func fetch(ctx context.Context, path string) error {
+	_, err := git(ctx, path, "remote", "update", "--prune")
+	return err
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	if dir != "" {
+		cmd.Dir = dir
+	}
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// normalizeURL makes trivially different spellings of the same remote map to
+// the same mirror.
+func normalizeURL(u string) string {
+	u = strings.TrimSpace(u)
+	u = strings.TrimRight(u, "/")
+	u = strings.TrimSuffix(u, ".git")
+	return strings.ToLower(u)
+}
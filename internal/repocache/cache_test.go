@@ -0,0 +1,100 @@
+package repocache
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupRemote creates a bare repository with a single commit to act as the
+// upstream remote.
+func setupRemote(t *testing.T) string {
+	t.Helper()
+	tmpDir := t.TempDir()
+	work := filepath.Join(tmpDir, "work")
+	remote := filepath.Join(tmpDir, "remote.git")
+
+	run := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	os.MkdirAll(work, 0755)
+	run(work, "init", "-q")
+	os.WriteFile(filepath.Join(work, "README.md"), []byte("hello\n"), 0644)
+	run(work, "add", "-A")
+	run(work, "commit", "-q", "-m", "initial")
+	run(tmpDir, "clone", "-q", "--bare", work, remote)
+	return remote
+}
+
+func TestName(t *testing.T) {
+	a := Name("https://github.com/user/repo.git")
+	b := Name("https://github.com/user/repo")
+	c := Name("https://gitlab.com/user/repo")
+
+	if a != b {
+		t.Errorf("expected .git suffix to be ignored: %s vs %s", a, b)
+	}
+	if a == c {
+		t.Error("expected different hosts to map to different mirrors")
+	}
+	if !strings.HasPrefix(a, "repo-") || !strings.HasSuffix(a, ".git") {
+		t.Errorf("unexpected mirror name %s", a)
+	}
+}
+
+func TestCache_VMPath(t *testing.T) {
+	c := New("/host/shared/repos", "/mnt/host-shared/repos", 0)
+	url := "https://github.com/user/repo"
+	want := "/mnt/host-shared/repos/" + Name(url)
+	if got := c.VMPath(url); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestCache_EnsureCreatesAndRefreshes(t *testing.T) {
+	remote := setupRemote(t)
+	c := New(filepath.Join(t.TempDir(), "repos"), "/mnt/host-shared/repos", 0)
+	ctx := context.Background()
+
+	if c.Exists(remote) {
+		t.Fatal("mirror should not exist before Ensure")
+	}
+
+	path, err := c.Ensure(ctx, remote)
+	if err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	if !c.Exists(remote) {
+		t.Fatal("expected mirror to exist after Ensure")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("expected temp clone dir to be cleaned up")
+	}
+
+	out, err := exec.Command("git", "--git-dir", path, "config", "gc.auto").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "0" {
+		t.Errorf("expected gc.auto=0 in mirror, got %q (%v)", out, err)
+	}
+
+	// Second call fetches into the existing mirror
+	if _, err := c.Ensure(ctx, remote); err != nil {
+		t.Fatalf("Ensure (refresh) failed: %v", err)
+	}
+}
+
+func TestCache_RefreshMissingDir(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "missing"), "/mnt/host-shared/repos", 0)
+	// Should not panic or error on a missing directory
+	c.Refresh(context.Background())
+}
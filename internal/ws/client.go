@@ -8,13 +8,14 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/mateo/agentvm/internal/auth"
 )
 
 const (
 	writeWait      = 10 * time.Second
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 4096
+	maxMessageSize = 64 * 1024 // room for pasting into a terminal
 )
 
 // Client is a WebSocket client managed by the Hub.
@@ -22,6 +23,9 @@ type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+	term chan []byte // binary terminal frames
+
+	caller auth.Token // API token the client connected with, if any
 
 	subMu         sync.RWMutex
 	subscriptions map[string]bool
@@ -33,6 +37,7 @@ func NewClient(hub *Hub, conn *websocket.Conn) *Client {
 		hub:           hub,
 		conn:          conn,
 		send:          make(chan []byte, 256),
+		term:          make(chan []byte, 64),
 		subscriptions: make(map[string]bool),
 	}
 }
@@ -89,7 +94,7 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		msgType, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
 				log.Printf("WebSocket read error: %v", err)
@@ -97,6 +102,13 @@ func (c *Client) readPump() {
 			return
 		}
 
+		if msgType == websocket.BinaryMessage {
+			if agentID, data, ok := parseTermFrame(message); ok {
+				c.hub.TerminalManager().Input(agentID, c, data)
+			}
+			continue
+		}
+
 		var env Envelope
 		if err := json.Unmarshal(message, &env); err != nil {
 			log.Printf("WebSocket: invalid message: %v", err)
@@ -141,6 +153,12 @@ func (c *Client) writePump() {
 				return
 			}
 
+		case frame := <-c.term:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+				return
+			}
+
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -150,6 +168,14 @@ func (c *Client) writePump() {
 	}
 }
 
+// RemoteAddr returns the client's network address.
+func (c *Client) RemoteAddr() string {
+	if c.conn == nil {
+		return ""
+	}
+	return c.conn.RemoteAddr().String()
+}
+
 // Send queues a message for sending to the client.
 func (c *Client) Send(msg []byte) {
 	select {
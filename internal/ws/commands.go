@@ -17,7 +17,7 @@ type CommandHandler struct {
 	orch    *orchestrator.Orchestrator
 	poolMgr *pool.Manager
 	store   *registry.Store
-	traefik *network.TraefikWriter
+	router  network.Router
 	sshfs   *SSHFSManager
 }
 
@@ -26,14 +26,14 @@ func NewCommandHandler(
 	orch *orchestrator.Orchestrator,
 	poolMgr *pool.Manager,
 	store *registry.Store,
-	traefik *network.TraefikWriter,
+	router network.Router,
 	sshfs *SSHFSManager,
 ) *CommandHandler {
 	return &CommandHandler{
 		orch:    orch,
 		poolMgr: poolMgr,
 		store:   store,
-		traefik: traefik,
+		router:  router,
 		sshfs:   sshfs,
 	}
 }
@@ -54,6 +54,8 @@ func (ch *CommandHandler) Handle(client *Client, cmd CommandPayload) {
 		result = ch.handleUnmount(cmd)
 	case "shell":
 		result = ch.handleShell(cmd)
+	case "expose":
+		result = ch.handleExpose(cmd)
 	default:
 		result.Error = "unknown action: " + cmd.Action
 	}
@@ -82,12 +84,36 @@ func (ch *CommandHandler) handleKill(cmd CommandPayload) CommandResultPayload {
 	if err := ch.poolMgr.Release(slot.Name); err != nil {
 		return CommandResultPayload{ID: cmd.ID, Error: err.Error()}
 	}
-	ch.traefik.RemoveRoute(args.AgentID)
+	ch.router.RemoveRoute(args.AgentID)
 	ch.store.Deregister(args.AgentID)
 
 	return CommandResultPayload{ID: cmd.ID, Success: true, Message: "agent killed"}
 }
 
+// handleExpose routes a discovered port of a running agent.
+func (ch *CommandHandler) handleExpose(cmd CommandPayload) CommandResultPayload {
+	var args struct {
+		AgentID string `json:"agentID"`
+		Port    int    `json:"port"`
+		Name    string `json:"name"`
+	}
+	if err := json.Unmarshal(cmd.Args, &args); err != nil {
+		return CommandResultPayload{ID: cmd.ID, Error: "invalid args: " + err.Error()}
+	}
+
+	slot, ok := ch.poolMgr.GetSlot(args.AgentID)
+	if !ok {
+		return CommandResultPayload{ID: cmd.ID, Error: "agent not found"}
+	}
+
+	reg, err := network.Expose(ch.store, ch.router, slot, args.Port, args.Name)
+	if err != nil {
+		return CommandResultPayload{ID: cmd.ID, Error: err.Error()}
+	}
+	urls := ch.router.URLsFor(reg.AgentID, reg.Project, reg.RoutedServices())
+	return CommandResultPayload{ID: cmd.ID, Success: true, Message: "exposed at " + urls[len(urls)-1]}
+}
+
 func (ch *CommandHandler) handleDispatch(cmd CommandPayload) CommandResultPayload {
 	var args struct {
 		Project  string            `json:"project"`
@@ -177,8 +203,8 @@ func (ch *CommandHandler) handleShell(cmd CommandPayload) CommandResultPayload {
 		return CommandResultPayload{ID: cmd.ID, Error: "agent not found"}
 	}
 
-	// Shell is launched client-side via `open -a Terminal limactl shell <vm>`
-	// We just return the VM name for the client to use
+	// For clients running limactl on the agentd host; the term:<agentId>
+	// channel gives a terminal from anywhere. We just return the VM name
 	return CommandResultPayload{
 		ID:      cmd.ID,
 		Success: true,
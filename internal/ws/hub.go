@@ -3,10 +3,15 @@ package ws
 import (
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/mateo/agentvm/internal/auth"
+	"github.com/mateo/agentvm/internal/history"
+	"github.com/mateo/agentvm/internal/lima"
 	"github.com/mateo/agentvm/internal/pool"
 	"github.com/mateo/agentvm/internal/registry"
 )
@@ -14,12 +19,27 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 4096,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	CheckOrigin:     checkOrigin,
+}
+
+// checkOrigin accepts clients that aren't browsers, which send no Origin, and
+// pages served from agentd's own host, so other sites can't open connections
+// from the operator's browser.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
 }
 
 // SubdomainFunc computes the public subdomain for an agent.
 type SubdomainFunc func(agentID, project string) string
 
+// URLsFunc computes the public URLs of an agent's served services.
+type URLsFunc func(agentID, project string, services []registry.Service) []string
+
 // Hub manages all WebSocket clients and broadcasts.
 type Hub struct {
 	mu      sync.RWMutex
@@ -32,14 +52,17 @@ type Hub struct {
 	store       *registry.Store
 	poolMgr     *pool.Manager
 	logMgr      *LogStreamManager
+	termMgr     *TerminalManager
 	cmdHandler  *CommandHandler
 	subdomainFn SubdomainFunc
+	urlsFn      URLsFunc
+	authz       *auth.Authorizer
 
 	stopCh chan struct{}
 }
 
 // NewHub creates a new WebSocket hub.
-func NewHub(store *registry.Store, poolMgr *pool.Manager, cmdHandler *CommandHandler, subdomainFn SubdomainFunc) *Hub {
+func NewHub(store *registry.Store, poolMgr *pool.Manager, limaClient lima.Client, cmdHandler *CommandHandler, subdomainFn SubdomainFunc, urlsFn URLsFunc) *Hub {
 	h := &Hub{
 		clients:     make(map[*Client]bool),
 		register:    make(chan *Client),
@@ -49,9 +72,11 @@ func NewHub(store *registry.Store, poolMgr *pool.Manager, cmdHandler *CommandHan
 		poolMgr:     poolMgr,
 		cmdHandler:  cmdHandler,
 		subdomainFn: subdomainFn,
+		urlsFn:      urlsFn,
 		stopCh:      make(chan struct{}),
 	}
-	h.logMgr = NewLogStreamManager(h)
+	h.logMgr = NewLogStreamManager(h, limaClient)
+	h.termMgr = NewTerminalManager(h, limaClient)
 	return h
 }
 
@@ -81,8 +106,9 @@ func (h *Hub) Run() {
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.send)
-				// Clean up any log subscriptions
+				// Clean up any log subscriptions and terminals
 				h.logMgr.UnsubscribeAll(client)
+				h.termMgr.CloseAll(client)
 			}
 			h.mu.Unlock()
 			log.Printf("WebSocket client disconnected (total: %d)", len(h.clients))
@@ -111,6 +137,7 @@ func (h *Hub) Run() {
 func (h *Hub) Stop() {
 	close(h.stopCh)
 	h.logMgr.StopAll()
+	h.termMgr.StopAll()
 }
 
 // ServeWS handles the WebSocket upgrade and creates a client.
@@ -122,6 +149,9 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := NewClient(h, conn)
+	if h.authz != nil {
+		client.caller, _ = h.authz.Caller(r)
+	}
 	h.register <- client
 
 	go client.writePump()
@@ -136,6 +166,15 @@ func (h *Hub) HandleClientMessage(client *Client, env Envelope) {
 		if err := unmarshalPayload(env.Payload, &payload); err != nil {
 			return
 		}
+		if agentID := parseTermChannel(payload.Channel); agentID != "" {
+			if !h.operator(client) {
+				h.termMgr.sendExit(client, agentID, -1, "operator scope required")
+				return
+			}
+			client.Subscribe(payload.Channel)
+			h.termMgr.Open(agentID, client, payload.Rows, payload.Cols)
+			return
+		}
 		client.Subscribe(payload.Channel)
 
 		// If subscribing to status, send initial snapshot
@@ -156,12 +195,28 @@ func (h *Hub) HandleClientMessage(client *Client, env Envelope) {
 		if agentID := parseLogChannel(payload.Channel); agentID != "" {
 			h.logMgr.Unsubscribe(agentID, client)
 		}
+		if agentID := parseTermChannel(payload.Channel); agentID != "" {
+			h.termMgr.Close(agentID, client)
+		}
+
+	case TypeTermResize:
+		var payload TermResizePayload
+		if err := unmarshalPayload(env.Payload, &payload); err != nil {
+			return
+		}
+		go h.termMgr.Resize(payload.AgentID, client, payload.Rows, payload.Cols)
 
 	case TypeCommand:
 		var payload CommandPayload
 		if err := unmarshalPayload(env.Payload, &payload); err != nil {
 			return
 		}
+		if !h.operator(client) {
+			if msg, err := MakeEnvelope(TypeCommandResult, CommandResultPayload{ID: payload.ID, Error: "operator scope required"}); err == nil {
+				client.Send(msg)
+			}
+			return
+		}
 		go h.cmdHandler.Handle(client, payload)
 	}
 }
@@ -190,6 +245,28 @@ func (h *Hub) SendToLogSubscribers(agentID, line string) {
 	}
 }
 
+// SetAuthorizer checks the token each client connected with before opening
+// terminals or running commands, which reach into agents' VMs: they need
+// operator scope.
+func (h *Hub) SetAuthorizer(authz *auth.Authorizer) {
+	h.authz = authz
+}
+
+// operator reports whether client may open terminals and run commands.
+func (h *Hub) operator(client *Client) bool {
+	return h.authz == nil || client.caller.HasScope(auth.ScopeOperator)
+}
+
+// TerminalManager returns the hub's terminal session manager.
+func (h *Hub) TerminalManager() *TerminalManager {
+	return h.termMgr
+}
+
+// SetHistory records terminal session transcripts in hist.
+func (h *Hub) SetHistory(hist *history.Store) {
+	h.termMgr.SetHistory(hist)
+}
+
 // LogManager returns the hub's log stream manager.
 func (h *Hub) LogManager() *LogStreamManager {
 	return h.logMgr
@@ -279,11 +356,18 @@ func (h *Hub) buildStatusSnapshot() []byte {
 			State:     string(slot.State),
 			StartedAt: slot.ClaimedAt,
 			Elapsed:   time.Since(slot.ClaimedAt).Truncate(time.Second).String(),
+			Listening: h.store.Listening(slot.AgentID),
 		}
 		// Enrich with registry data
+		var services []registry.Service
 		if reg, ok := regMap[slot.AgentID]; ok {
+			services = reg.Services
+			if reg.Serves() {
+				services = reg.RoutedServices()
+			}
 			snap.State = reg.State
 			snap.Message = reg.Message
+			snap.Restarts = reg.Restarts
 			if reg.Branch != "" {
 				snap.Branch = reg.Branch
 			}
@@ -295,6 +379,9 @@ func (h *Hub) buildStatusSnapshot() []byte {
 		if h.subdomainFn != nil {
 			snap.Subdomain = h.subdomainFn(slot.AgentID, slot.Project)
 		}
+		if h.urlsFn != nil {
+			snap.URLs = h.urlsFn(slot.AgentID, slot.Project, services)
+		}
 		agents = append(agents, snap)
 	}
 
@@ -327,9 +414,14 @@ func (h *Hub) agentRegToSnapshot(reg *registry.AgentRegistration) *AgentSnapshot
 		Message:   reg.Message,
 		StartedAt: reg.RegisteredAt,
 		Elapsed:   time.Since(reg.RegisteredAt).Truncate(time.Second).String(),
+		Restarts:  reg.Restarts,
 	}
 	if h.subdomainFn != nil {
 		snap.Subdomain = h.subdomainFn(reg.AgentID, reg.Project)
 	}
+	if h.urlsFn != nil {
+		snap.URLs = h.urlsFn(reg.AgentID, reg.Project, reg.RoutedServices())
+	}
+	snap.Listening = h.store.Listening(reg.AgentID)
 	return snap
 }
@@ -0,0 +1,146 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/mateo/agentvm/internal/auth"
+	"github.com/mateo/agentvm/internal/lima"
+	"github.com/mateo/agentvm/internal/pool"
+	"github.com/mateo/agentvm/internal/registry"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true}, // agentctl, the monitor
+		{"http://127.0.0.1:8091", true},
+		{"http://evil.example", false},
+		{"http://127.0.0.1:8091.evil.example", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://127.0.0.1:8091/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := checkOrigin(r); got != tt.want {
+			t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestHub_TerminalAndCommandsNeedOperator(t *testing.T) {
+	baseDir := t.TempDir()
+	mock := lima.NewMockClient()
+	mock.ExecFn = func(ctx context.Context, opts lima.ExecOptions) error {
+		t.Error("terminal opened without operator scope")
+		return nil
+	}
+	poolMgr, err := pool.NewManager(pool.PoolConfig{}, mock, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz, err := auth.New(filepath.Join(baseDir, "operator-token"), []auth.Token{{Name: "viewer", Value: "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub(nil, poolMgr, mock, nil, nil, nil)
+	hub.SetAuthorizer(authz)
+	client := &Client{
+		send:          make(chan []byte, 8),
+		term:          make(chan []byte, 8),
+		subscriptions: make(map[string]bool),
+		caller:        auth.Token{Name: "viewer", Value: "v"},
+	}
+
+	sub, _ := json.Marshal(SubscribePayload{Channel: "term:agent-1"})
+	hub.HandleClientMessage(client, Envelope{Type: TypeSubscribe, Payload: sub})
+	var env Envelope
+	var exit TermExitPayload
+	json.Unmarshal(<-client.send, &env)
+	json.Unmarshal(env.Payload, &exit)
+	if env.Type != TypeTermExit || exit.Error != "operator scope required" || client.IsSubscribed("term:agent-1") {
+		t.Errorf("terminal not refused: %s %+v", env.Type, exit)
+	}
+
+	cmd, _ := json.Marshal(CommandPayload{ID: "c1", Action: "kill"})
+	hub.HandleClientMessage(client, Envelope{Type: TypeCommand, Payload: cmd})
+	var result CommandResultPayload
+	json.Unmarshal(<-client.send, &env)
+	json.Unmarshal(env.Payload, &result)
+	if env.Type != TypeCommandResult || result.ID != "c1" || result.Error != "operator scope required" {
+		t.Errorf("command not refused: %s %+v", env.Type, result)
+	}
+}
+
+func TestServeWS_StatusWithoutToken(t *testing.T) {
+	baseDir := t.TempDir()
+	mock := lima.NewMockClient()
+	poolMgr, err := pool.NewManager(pool.PoolConfig{}, mock, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz, err := auth.New(filepath.Join(baseDir, "operator-token"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := registry.NewStore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hub := NewHub(store, poolMgr, mock, nil, nil, nil)
+	hub.SetAuthorizer(authz)
+	go hub.Run()
+	defer hub.Stop()
+	// As agentd mounts it
+	srv := httptest.NewServer(http.HandlerFunc(hub.ServeWS))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial without a token failed: %v", err)
+	}
+	defer conn.Close()
+
+	read := func() Envelope {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var env Envelope
+		if err := conn.ReadJSON(&env); err != nil {
+			t.Fatal(err)
+		}
+		return env
+	}
+	subscribe := func(channel string) {
+		t.Helper()
+		sub, _ := MakeEnvelope(TypeSubscribe, SubscribePayload{Channel: channel})
+		if err := conn.WriteMessage(websocket.TextMessage, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subscribe(ChannelStatus)
+	if env := read(); env.Type != TypeStatusSnapshot {
+		t.Errorf("expected a status snapshot, got %s", env.Type)
+	}
+
+	subscribe("term:agent-1")
+	env := read()
+	var exit TermExitPayload
+	json.Unmarshal(env.Payload, &exit)
+	if env.Type != TypeTermExit || exit.Error != "operator scope required" {
+		t.Errorf("terminal not refused: %s %+v", env.Type, exit)
+	}
+}
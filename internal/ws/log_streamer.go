@@ -3,9 +3,11 @@ package ws
 import (
 	"bufio"
 	"context"
+	"io"
 	"log"
-	"os/exec"
 	"sync"
+
+	"github.com/mateo/agentvm/internal/lima"
 )
 
 // logStream tracks a single journalctl -f process and its subscribers.
@@ -20,14 +22,16 @@ type logStream struct {
 // LogStreamManager manages per-agent log streaming processes.
 type LogStreamManager struct {
 	hub     *Hub
+	client  lima.Client
 	mu      sync.Mutex
 	streams map[string]*logStream // keyed by agentID
 }
 
 // NewLogStreamManager creates a new manager.
-func NewLogStreamManager(hub *Hub) *LogStreamManager {
+func NewLogStreamManager(hub *Hub, client lima.Client) *LogStreamManager {
 	return &LogStreamManager{
 		hub:     hub,
+		client:  client,
 		streams: make(map[string]*logStream),
 	}
 }
@@ -114,34 +118,32 @@ func (m *LogStreamManager) StopAll() {
 	m.streams = make(map[string]*logStream)
 }
 
-// runStream executes journalctl -f via limactl shell and fans out lines.
+// runStream follows the harness journal in the VM and fans out lines.
 func (m *LogStreamManager) runStream(ctx context.Context, stream *logStream) {
 	log.Printf("LogStream: starting for agent %s (VM: %s)", stream.agentID, stream.vmName)
 
-	cmd := exec.CommandContext(ctx, "limactl", "shell", stream.vmName,
-		"sudo", "journalctl", "-u", "agent-harness.service", "-f", "--no-pager", "-n", "100")
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Printf("LogStream: pipe error for %s: %v", stream.agentID, err)
-		return
-	}
-
-	if err := cmd.Start(); err != nil {
-		log.Printf("LogStream: start error for %s: %v", stream.agentID, err)
-		return
-	}
-
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		m.hub.SendToLogSubscribers(stream.agentID, line)
-	}
-
-	if err := cmd.Wait(); err != nil {
-		if ctx.Err() == nil {
-			log.Printf("LogStream: process exited for %s: %v", stream.agentID, err)
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			m.hub.SendToLogSubscribers(stream.agentID, scanner.Text())
 		}
+		// Keep draining so an overlong line doesn't block the command
+		io.Copy(io.Discard, pr)
+	}()
+
+	err := m.client.Exec(ctx, lima.ExecOptions{
+		Instance: stream.vmName,
+		Command:  "sudo",
+		Args:     []string{"journalctl", "-u", "agent-harness.service", "-f", "--no-pager", "-n", "100"},
+		Stdout:   pw,
+	})
+	pw.Close()
+	<-done
+	if err != nil && ctx.Err() == nil {
+		log.Printf("LogStream: process exited for %s: %v", stream.agentID, err)
 	}
 
 	log.Printf("LogStream: ended for agent %s", stream.agentID)
@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/mateo/agentvm/internal/lima"
+)
+
+func TestLogStreamManager_RunStream(t *testing.T) {
+	mock := lima.NewMockClient()
+	var got lima.ExecOptions
+	mock.ExecFn = func(ctx context.Context, opts lima.ExecOptions) error {
+		got = opts
+		io.WriteString(opts.Stdout, "starting\nserving on :8080\n")
+		return &lima.ExitError{Code: 1}
+	}
+
+	hub := NewHub(nil, nil, mock, nil, nil, nil)
+	client := &Client{
+		send:          make(chan []byte, 8),
+		subscriptions: map[string]bool{"logs:agent-1": true},
+	}
+	hub.clients[client] = true
+
+	hub.logMgr.runStream(context.Background(), &logStream{agentID: "agent-1", vmName: "vm-1"})
+
+	if got.Instance != "vm-1" || got.Command != "sudo" {
+		t.Errorf("unexpected exec: %+v", got)
+	}
+	var lines []string
+	for len(client.send) > 0 {
+		var env Envelope
+		var payload LogDataPayload
+		if err := json.Unmarshal(<-client.send, &env); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(env.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if env.Type != TypeLogsData || payload.AgentID != "agent-1" {
+			t.Errorf("unexpected message %s %+v", env.Type, payload)
+		}
+		lines = append(lines, payload.Line)
+	}
+	if want := []string{"starting", "serving on :8080"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("got lines %q, want %q", lines, want)
+	}
+}
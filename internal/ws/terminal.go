@@ -0,0 +1,285 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateo/agentvm/internal/history"
+	"github.com/mateo/agentvm/internal/lima"
+)
+
+// terminal is one PTY session in an agent's VM, owned by a single client.
+type terminal struct {
+	id      string
+	agentID string
+	vmName  string
+	stdin   *io.PipeWriter
+	cancel  context.CancelFunc
+}
+
+// ttyFile is where the session's shell records its pty in the VM, so it
+// can be resized from outside.
+func (t *terminal) ttyFile() string {
+	return "/tmp/agentvm-term-" + t.id
+}
+
+// TerminalManager runs interactive terminal sessions for "term:<agentId>"
+// subscribers. Unlike log streams, every subscriber gets its own session.
+type TerminalManager struct {
+	hub      *Hub
+	client   lima.Client
+	hist     *history.Store
+	mu       sync.Mutex
+	sessions map[*Client]map[string]*terminal // client -> agentID -> session
+}
+
+// NewTerminalManager creates a new manager.
+func NewTerminalManager(hub *Hub, client lima.Client) *TerminalManager {
+	return &TerminalManager{
+		hub:      hub,
+		client:   client,
+		sessions: make(map[*Client]map[string]*terminal),
+	}
+}
+
+// SetHistory records session transcripts in hist for audit.
+func (m *TerminalManager) SetHistory(hist *history.Store) {
+	m.hist = hist
+}
+
+// Open starts a shell in the agent's workspace for client.
+func (m *TerminalManager) Open(agentID string, client *Client, rows, cols int) {
+	slot, found := m.hub.poolMgr.GetSlot(agentID)
+	if !found {
+		m.sendExit(client, agentID, -1, "agent not found")
+		return
+	}
+	id, err := sessionID()
+	if err != nil {
+		m.sendExit(client, agentID, -1, err.Error())
+		return
+	}
+
+	m.mu.Lock()
+	if m.sessions[client] == nil {
+		m.sessions[client] = make(map[string]*terminal)
+	}
+	if _, ok := m.sessions[client][agentID]; ok {
+		m.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	term := &terminal{id: id, agentID: agentID, vmName: slot.Name, stdin: pw, cancel: cancel}
+	m.sessions[client][agentID] = term
+	m.mu.Unlock()
+
+	log.Printf("Terminal: %s opened session %s on %s", client.RemoteAddr(), id, agentID)
+	go m.run(ctx, client, term, pr, slot.Project, rows, cols)
+}
+
+func (m *TerminalManager) run(ctx context.Context, client *Client, term *terminal, stdin *io.PipeReader, project string, rows, cols int) {
+	// Input arriving after the shell exits fails instead of blocking
+	defer stdin.Close()
+
+	out := io.Writer(&terminalWriter{ctx: ctx, client: client, agentID: term.agentID})
+	transcript := m.openTranscript(client, term)
+	if transcript != nil {
+		defer transcript.Close()
+		out = io.MultiWriter(out, transcript)
+	}
+
+	script := fmt.Sprintf(`tty > %s; cd ~/workspace/"$1" 2>/dev/null; exec "${SHELL:-bash}" -l`, term.ttyFile())
+	err := m.client.Exec(ctx, lima.ExecOptions{
+		Instance: term.vmName,
+		Command:  "bash",
+		Args:     []string{"-c", script, "bash", project},
+		Stdin:    stdin,
+		Stdout:   out,
+		Stderr:   out,
+		TTY:      true,
+		Rows:     rows,
+		Cols:     cols,
+	})
+
+	code, msg := 0, ""
+	var exitErr *lima.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.Code
+	case err != nil && ctx.Err() == nil:
+		code, msg = -1, err.Error()
+	}
+	if transcript != nil {
+		fmt.Fprintf(transcript, "\n# session closed at %s (exit %d)\n", time.Now().Format(time.RFC3339), code)
+	}
+	log.Printf("Terminal: session %s on %s ended (exit %d)", term.id, term.agentID, code)
+
+	m.mu.Lock()
+	current := m.sessions[client][term.agentID] == term
+	if current {
+		delete(m.sessions[client], term.agentID)
+	}
+	m.mu.Unlock()
+	if current {
+		m.sendExit(client, term.agentID, code, msg)
+	}
+}
+
+// openTranscript creates the session's audit transcript: the terminal output,
+// which includes echoed input but not what the shell hides, like passwords.
+func (m *TerminalManager) openTranscript(client *Client, term *terminal) *os.File {
+	if m.hist == nil {
+		return nil
+	}
+	name := fmt.Sprintf("terminal-%s-%s.log", time.Now().Format("20060102-150405"), term.id)
+	f, err := m.hist.Create(term.agentID, name)
+	if err != nil {
+		log.Printf("Terminal: recording session %s: %v", term.id, err)
+		return nil
+	}
+	fmt.Fprintf(f, "# session %s opened by %s at %s\n", term.id, client.RemoteAddr(), time.Now().Format(time.RFC3339))
+	return f
+}
+
+// Input writes client keystrokes to its session on agentID.
+func (m *TerminalManager) Input(agentID string, client *Client, data []byte) {
+	m.mu.Lock()
+	term, ok := m.sessions[client][agentID]
+	m.mu.Unlock()
+	if ok {
+		term.stdin.Write(data)
+	}
+}
+
+// Resize sets the size of client's session on agentID. The kernel signals
+// SIGWINCH to the foreground process when the pty size changes.
+func (m *TerminalManager) Resize(agentID string, client *Client, rows, cols int) {
+	m.mu.Lock()
+	term, ok := m.sessions[client][agentID]
+	m.mu.Unlock()
+	if !ok || rows <= 0 || cols <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var stderr bytes.Buffer
+	err := m.client.Exec(ctx, lima.ExecOptions{
+		Instance: term.vmName,
+		Command:  "sh",
+		Args: []string{"-c", `stty -F "$(cat "$1")" rows "$2" cols "$3"`, "sh",
+			term.ttyFile(), strconv.Itoa(rows), strconv.Itoa(cols)},
+		Stderr: &stderr,
+	})
+	if err != nil {
+		log.Printf("Terminal: resizing session %s: %v %s", term.id, err, stderr.String())
+	}
+}
+
+// Close ends client's session on agentID.
+func (m *TerminalManager) Close(agentID string, client *Client) {
+	m.mu.Lock()
+	term, ok := m.sessions[client][agentID]
+	delete(m.sessions[client], agentID)
+	m.mu.Unlock()
+	if ok {
+		term.stdin.Close()
+		term.cancel()
+	}
+}
+
+// CloseAll ends all of client's sessions.
+func (m *TerminalManager) CloseAll(client *Client) {
+	m.mu.Lock()
+	sessions := m.sessions[client]
+	delete(m.sessions, client)
+	m.mu.Unlock()
+	for _, term := range sessions {
+		term.stdin.Close()
+		term.cancel()
+	}
+}
+
+// StopAll ends every session.
+func (m *TerminalManager) StopAll() {
+	m.mu.Lock()
+	all := m.sessions
+	m.sessions = make(map[*Client]map[string]*terminal)
+	m.mu.Unlock()
+	for _, sessions := range all {
+		for _, term := range sessions {
+			term.stdin.Close()
+			term.cancel()
+		}
+	}
+}
+
+func (m *TerminalManager) sendExit(client *Client, agentID string, code int, errMsg string) {
+	msg, err := MakeEnvelope(TypeTermExit, TermExitPayload{AgentID: agentID, Code: code, Error: errMsg})
+	if err == nil {
+		client.Send(msg)
+	}
+}
+
+// terminalWriter forwards session output to the client as binary frames,
+// waiting for room rather than dropping output like other messages do.
+type terminalWriter struct {
+	ctx     context.Context
+	client  *Client
+	agentID string
+}
+
+func (w *terminalWriter) Write(p []byte) (int, error) {
+	select {
+	case w.client.term <- termFrame(w.agentID, p):
+		return len(p), nil
+	case <-w.ctx.Done():
+		return 0, w.ctx.Err()
+	}
+}
+
+// termFrame builds a binary terminal frame: the agent ID, a NUL byte and
+// the data.
+func termFrame(agentID string, data []byte) []byte {
+	frame := make([]byte, 0, len(agentID)+1+len(data))
+	frame = append(frame, agentID...)
+	frame = append(frame, 0)
+	return append(frame, data...)
+}
+
+// parseTermFrame splits a binary terminal frame.
+func parseTermFrame(frame []byte) (string, []byte, bool) {
+	agentID, data, ok := bytes.Cut(frame, []byte{0})
+	if !ok || len(agentID) == 0 {
+		return "", nil, false
+	}
+	return string(agentID), data, true
+}
+
+// parseTermChannel extracts agentID from "term:<agentID>" channel names.
+func parseTermChannel(channel string) string {
+	if agentID, ok := strings.CutPrefix(channel, "term:"); ok {
+		return agentID
+	}
+	return ""
+}
+
+func sessionID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating session ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateo/agentvm/internal/history"
+	"github.com/mateo/agentvm/internal/lima"
+	"github.com/mateo/agentvm/internal/pool"
+)
+
+func TestTermFrame(t *testing.T) {
+	agentID, data, ok := parseTermFrame(termFrame("agent-1", []byte("ls\x00\r")))
+	if !ok || agentID != "agent-1" || string(data) != "ls\x00\r" {
+		t.Errorf("got %q %q %v", agentID, data, ok)
+	}
+	if _, _, ok := parseTermFrame([]byte("no separator")); ok {
+		t.Error("expected frame without NUL to be rejected")
+	}
+}
+
+func TestTerminalManager_Session(t *testing.T) {
+	baseDir := t.TempDir()
+	state := pool.PersistentState{Slots: []pool.VMSlot{
+		{Name: "vm-1", State: pool.SlotActive, AgentID: "agent-1", Project: "shop"},
+	}}
+	data, _ := json.Marshal(state)
+	os.WriteFile(filepath.Join(baseDir, "pool-state.json"), data, 0644)
+	mock := lima.NewMockClient()
+	poolMgr, err := pool.NewManager(pool.PoolConfig{}, mock, baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan lima.ExecOptions, 1)
+	mock.ExecFn = func(ctx context.Context, opts lima.ExecOptions) error {
+		if !opts.TTY {
+			return nil // resize
+		}
+		started <- opts
+		// Echo a line of input, then run until the client closes the session
+		buf := make([]byte, 64)
+		n, _ := opts.Stdin.Read(buf)
+		opts.Stdout.Write(buf[:n])
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	hub := NewHub(nil, poolMgr, mock, nil, nil, nil)
+	hist := history.NewStore(baseDir)
+	hub.SetHistory(hist)
+	client := &Client{
+		send:          make(chan []byte, 8),
+		term:          make(chan []byte, 8),
+		subscriptions: make(map[string]bool),
+	}
+
+	hub.termMgr.Open("agent-1", client, 40, 120)
+	opts := <-started
+	if opts.Instance != "vm-1" || opts.Rows != 40 || opts.Cols != 120 {
+		t.Errorf("unexpected exec: %+v", opts)
+	}
+	if args := strings.Join(opts.Args, " "); !strings.Contains(args, "~/workspace/") || !strings.HasSuffix(args, " shop") {
+		t.Errorf("session not scoped to the workspace: %q", args)
+	}
+
+	hub.termMgr.Input("agent-1", client, []byte("echo hi\r"))
+	agentID, out, _ := parseTermFrame(<-client.term)
+	if agentID != "agent-1" || string(out) != "echo hi\r" {
+		t.Errorf("got output %q for %q", out, agentID)
+	}
+	hub.termMgr.Close("agent-1", client)
+
+	// The session was closed by the client, so no exit message is sent
+	time.Sleep(50 * time.Millisecond)
+	if len(client.send) != 0 {
+		t.Errorf("unexpected message %s", <-client.send)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(baseDir, "history", "agent-1", "terminal-*.log"))
+	if len(files) != 1 {
+		t.Fatalf("expected one transcript, got %v", files)
+	}
+	transcript, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(transcript), "echo hi") || !strings.Contains(string(transcript), "session closed") {
+		t.Errorf("unexpected transcript:\n%s", transcript)
+	}
+}
+
+func TestTerminalManager_UnknownAgent(t *testing.T) {
+	mock := lima.NewMockClient()
+	poolMgr, err := pool.NewManager(pool.PoolConfig{}, mock, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := NewHub(nil, poolMgr, mock, nil, nil, nil)
+	client := &Client{send: make(chan []byte, 8), term: make(chan []byte, 8)}
+
+	hub.termMgr.Open("agent-9", client, 0, 0)
+
+	var env Envelope
+	var payload TermExitPayload
+	json.Unmarshal(<-client.send, &env)
+	json.Unmarshal(env.Payload, &payload)
+	if env.Type != TypeTermExit || payload.AgentID != "agent-9" || payload.Error == "" {
+		t.Errorf("got %s %+v", env.Type, payload)
+	}
+}
@@ -3,6 +3,8 @@ package ws
 import (
 	"encoding/json"
 	"time"
+
+	"github.com/mateo/agentvm/internal/registry"
 )
 
 // Envelope is the top-level WebSocket message format.
@@ -15,7 +17,9 @@ type Envelope struct {
 
 // SubscribePayload requests subscription to a channel.
 type SubscribePayload struct {
-	Channel string `json:"channel"` // "status", "logs:<agentId>"
+	Channel string `json:"channel"`        // "status", "logs:<agentId>", "term:<agentId>"
+	Rows    int    `json:"rows,omitempty"` // initial size of a terminal
+	Cols    int    `json:"cols,omitempty"`
 }
 
 // UnsubscribePayload cancels a subscription.
@@ -23,6 +27,13 @@ type UnsubscribePayload struct {
 	Channel string `json:"channel"`
 }
 
+// TermResizePayload resizes the client's terminal on an agent.
+type TermResizePayload struct {
+	AgentID string `json:"agentID"`
+	Rows    int    `json:"rows"`
+	Cols    int    `json:"cols"`
+}
+
 // CommandPayload sends a command to the server.
 type CommandPayload struct {
 	ID     string          `json:"id"`     // client-generated correlation ID
@@ -34,18 +45,21 @@ type CommandPayload struct {
 
 // AgentSnapshot represents the full state of a single agent.
 type AgentSnapshot struct {
-	AgentID   string    `json:"agentID"`
-	VMName    string    `json:"vmName"`
-	VMIP      string    `json:"vmIP"`
-	Project   string    `json:"project"`
-	Tool      string    `json:"tool"`
-	Branch    string    `json:"branch,omitempty"`
-	Issue     string    `json:"issue,omitempty"`
-	State     string    `json:"state"`
-	Message   string    `json:"message,omitempty"`
-	StartedAt time.Time `json:"startedAt"`
-	Elapsed   string    `json:"elapsed"`
-	Subdomain string    `json:"subdomain,omitempty"`
+	AgentID   string                   `json:"agentID"`
+	VMName    string                   `json:"vmName"`
+	VMIP      string                   `json:"vmIP"`
+	Project   string                   `json:"project"`
+	Tool      string                   `json:"tool"`
+	Branch    string                   `json:"branch,omitempty"`
+	Issue     string                   `json:"issue,omitempty"`
+	State     string                   `json:"state"`
+	Message   string                   `json:"message,omitempty"`
+	StartedAt time.Time                `json:"startedAt"`
+	Elapsed   string                   `json:"elapsed"`
+	Subdomain string                   `json:"subdomain,omitempty"`
+	URLs      []string                 `json:"urls,omitempty"` // one per served service, primary first
+	Restarts  int                      `json:"restarts,omitempty"`
+	Listening []registry.ListeningPort `json:"listening,omitempty"` // discovered ports that can be exposed
 }
 
 // StatusSnapshotPayload is the full state sent on subscribe and periodically.
@@ -81,6 +95,14 @@ type LogDataPayload struct {
 	Line    string `json:"line"`
 }
 
+// TermExitPayload reports that a terminal session ended. Error is set when
+// the session could not be started or broke off.
+type TermExitPayload struct {
+	AgentID string `json:"agentID"`
+	Code    int    `json:"code"`
+	Error   string `json:"error,omitempty"`
+}
+
 // CommandResultPayload is the response to a command.
 type CommandResultPayload struct {
 	ID      string `json:"id"`
@@ -95,6 +117,7 @@ const (
 	TypeSubscribe   = "subscribe"
 	TypeUnsubscribe = "unsubscribe"
 	TypeCommand     = "command"
+	TypeTermResize  = "term.resize"
 
 	// Server -> Client
 	TypeStatusSnapshot    = "status.snapshot"
@@ -103,12 +126,15 @@ const (
 	TypeAgentDeregistered = "agent.deregistered"
 	TypeLogsData          = "logs.data"
 	TypeCommandResult     = "command.result"
+	TypeTermExit          = "term.exit"
 )
 
 // Channel name constants.
 const (
 	ChannelStatus = "status"
 	// logs channels are "logs:<agentId>"
+	// term channels are "term:<agentId>"; terminal input and output travel
+	// as binary frames of the agent ID, a NUL byte and the data
 )
 
 // MakeEnvelope creates an Envelope with the given type and payload.
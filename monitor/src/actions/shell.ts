@@ -1,9 +1,9 @@
-import { spawn } from "child_process";
 import type blessed from "blessed";
 import type { Store } from "../state/store.js";
 import type { WSClient } from "../connection/ws-client.js";
 
-// Yield the terminal to `limactl shell <vm>`, then restore the TUI on exit.
+// Yield the terminal to a shell in the agent's workspace, relayed by agentd
+// over the WebSocket, then restore the TUI when the shell exits.
 export async function openShell(
   store: Store,
   wsClient: WSClient,
@@ -11,15 +11,9 @@ export async function openShell(
 ): Promise<string | null> {
   const agent = store.selectedAgent;
   if (!agent) return "No agent selected";
+  if (!wsClient.connected) return "Not connected to agentd";
 
-  const result = await wsClient.command("shell", { agentID: agent.agentID });
-  if (!result.success) {
-    return result.error || "Failed to get VM info";
-  }
-
-  const vmName = result.message;
-  if (!vmName) return "No VM name returned";
-
+  const agentID = agent.agentID;
   const prog = (screen as any).program;
   const input: any = prog.input;
 
@@ -29,10 +23,12 @@ export async function openShell(
     const savedRender = screen.render;
     screen.render = () => {};
 
-    // 2. Stop blessed from reading/intercepting stdin
+    // 2. Take stdin from blessed: keystrokes go to the shell as-is
     const wasRaw = input.isRaw;
-    input.setRawMode(false);
-    input.pause();
+    const blessedListeners = input.listeners("data");
+    input.removeAllListeners("data");
+    input.setRawMode(true);
+    input.resume();
 
     // 3. Exit alternate screen buffer, show cursor
     prog.normalBuffer();
@@ -41,33 +37,34 @@ export async function openShell(
     // 4. Clear the screen so the shell starts clean
     process.stdout.write("\x1b[2J\x1b[H");
 
-    const child = spawn("limactl", ["shell", vmName], {
-      stdio: [0, 1, 2],
-    });
+    const onInput = (data: Buffer) => wsClient.terminalInput(agentID, data);
+    const onResize = () =>
+      wsClient.resizeTerminal(agentID, process.stdout.rows, process.stdout.columns);
+    input.on("data", onInput);
+    process.stdout.on("resize", onResize);
 
     function restore() {
+      input.removeListener("data", onInput);
+      process.stdout.removeListener("resize", onResize);
+      for (const l of blessedListeners) input.on("data", l);
+      input.setRawMode(wasRaw);
+
       // Re-enter blessed's alternate screen
       prog.alternateBuffer();
       prog.hideCursor();
 
-      // Resume blessed's input handling
-      if (wasRaw) input.setRawMode(true);
-      input.resume();
-
       // Restore screen.render and force full redraw
       screen.render = savedRender;
       (screen as any).alloc();
       screen.render();
     }
 
-    child.on("exit", () => {
-      restore();
-      resolve(null);
-    });
-
-    child.on("error", (err) => {
-      restore();
-      resolve(`Failed to spawn shell: ${err.message}`);
+    wsClient.openTerminal(agentID, process.stdout.rows, process.stdout.columns, {
+      onData: (data) => process.stdout.write(data),
+      onExit: (exit) => {
+        restore();
+        resolve(exit.error ? `Shell failed: ${exit.error}` : null);
+      },
     });
   });
 }
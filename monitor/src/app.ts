@@ -15,6 +15,7 @@ import { killAgent } from "./actions/kill.js";
 
 export interface AppConfig {
   wsUrl: string;
+  token?: string;
 }
 
 export function createApp(config: AppConfig) {
@@ -46,6 +47,7 @@ export function createApp(config: AppConfig) {
   // WebSocket client
   const wsClient = new WSClient({
     url: config.wsUrl,
+    token: config.token,
     onMessage: (env: Envelope) => store.handleMessage(env),
     onConnect: () => {
       store.setConnected(true);
@@ -97,6 +97,17 @@ export function createAgentDetail(
       `  {bold}Subdomain:{/bold}   ${agent.subdomain || "-"}`,
     ];
 
+    if (agent.restarts) {
+      lines.push(`  {bold}Restarts:{/bold}    ${agent.restarts}`);
+    }
+    for (const url of agent.urls ?? []) {
+      lines.push(`  {bold}URL:{/bold}         ${url}`);
+    }
+    for (const p of agent.listening ?? []) {
+      const note = p.loopback ? " (localhost only)" : "";
+      lines.push(`  {bold}Listening:{/bold}   ${p.port} ${p.process ?? ""}${note}`);
+    }
+
     if (agent.message) {
       lines.push(``);
       lines.push(`  {bold}Message:{/bold}`);
@@ -1,6 +1,30 @@
 import blessed from "blessed";
 import type { Store } from "../state/store.js";
 
+interface FileEntry {
+  name: string;
+  type: string;
+  size: number;
+  mode: string;
+  modTime: string;
+  target?: string;
+}
+
+function formatSize(n: number): string {
+  if (n < 1024) return `${n}`;
+  if (n < 1024 * 1024) return `${(n / 1024).toFixed(1)}K`;
+  return `${(n / (1024 * 1024)).toFixed(1)}M`;
+}
+
+// formatEntry renders one listing line: mode, size, mtime and name.
+function formatEntry(e: FileEntry): string {
+  const when = new Date(e.modTime).toISOString().slice(0, 16).replace("T", " ");
+  let name = blessed.escape(e.name);
+  if (e.type === "directory") name = `{blue-fg}${name}/{/blue-fg}`;
+  if (e.type === "symlink") name = `{cyan-fg}${name}{/cyan-fg} -> ${blessed.escape(e.target ?? "")}`;
+  return `  ${e.mode}  ${formatSize(e.size).padStart(7)}  ${when}  ${name}`;
+}
+
 // FileBrowser fetches directory listings and file contents via the agentd REST API.
 export class FileBrowser {
   private store: Store;
@@ -43,20 +67,22 @@ export class FileBrowser {
       const data = (await resp.json()) as {
         type: string;
         path: string;
-        content: string;
+        entries?: FileEntry[];
+        content?: string;
+        truncated?: boolean;
       };
 
       if (data.type === "directory") {
         this.currentPath = path;
-        const lines = [
-          `{bold}  Directory: ${data.path}{/bold}`,
-          "",
-          data.content,
-        ];
+        const entries = data.entries ?? [];
+        const lines = [`{bold}  Directory: ${data.path}{/bold}`, ""];
+        if (entries.length === 0) lines.push("  (empty)");
+        for (const e of entries) lines.push(formatEntry(e));
         this.fileBox.setContent(lines.join("\n"));
       } else {
+        const more = data.truncated ? "\n\n  … truncated (agentctl cp to fetch it all)" : "";
         this.fileBox.setContent(
-          `{bold}  File: ${data.path}{/bold}\n\n${blessed.escape(data.content)}`
+          `{bold}  File: ${data.path}{/bold}\n\n${blessed.escape(data.content ?? "")}${more}`
         );
       }
       this.screen.render();
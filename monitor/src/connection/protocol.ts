@@ -7,6 +7,14 @@ export interface Envelope {
 // Client -> Server
 export interface SubscribePayload {
   channel: string;
+  rows?: number; // initial size of a terminal
+  cols?: number;
+}
+
+export interface TermResizePayload {
+  agentID: string;
+  rows: number;
+  cols: number;
 }
 
 export interface UnsubscribePayload {
@@ -33,6 +41,16 @@ export interface AgentSnapshot {
   startedAt: string;
   elapsed: string;
   subdomain?: string;
+  urls?: string[];
+  restarts?: number;
+  listening?: ListeningPort[];
+}
+
+// A port discovered in the agent's VM that can be exposed
+export interface ListeningPort {
+  port: number;
+  process?: string;
+  loopback?: boolean;
 }
 
 export interface PoolSnapshot {
@@ -70,15 +88,24 @@ export interface CommandResultPayload {
   error?: string;
 }
 
+// A terminal session ended; error is set if it failed or broke off
+export interface TermExitPayload {
+  agentID: string;
+  code: number;
+  error?: string;
+}
+
 // Message types
 export const MSG = {
   SUBSCRIBE: "subscribe",
   UNSUBSCRIBE: "unsubscribe",
   COMMAND: "command",
+  TERM_RESIZE: "term.resize",
   STATUS_SNAPSHOT: "status.snapshot",
   STATUS_UPDATE: "status.update",
   AGENT_REGISTERED: "agent.registered",
   AGENT_DEREGISTERED: "agent.deregistered",
   LOGS_DATA: "logs.data",
   COMMAND_RESULT: "command.result",
+  TERM_EXIT: "term.exit",
 } as const;
@@ -2,13 +2,20 @@ import WebSocket from "ws";
 import type {
   Envelope,
   CommandResultPayload,
+  TermExitPayload,
 } from "./protocol.js";
 import { MSG } from "./protocol.js";
 
 type MessageHandler = (env: Envelope) => void;
 
+export interface TerminalHandlers {
+  onData: (data: Buffer) => void;
+  onExit: (exit: TermExitPayload) => void;
+}
+
 export interface WSClientOptions {
   url: string;
+  token?: string;
   onMessage: MessageHandler;
   onConnect?: () => void;
   onDisconnect?: () => void;
@@ -17,6 +24,7 @@ export interface WSClientOptions {
 export class WSClient {
   private ws: WebSocket | null = null;
   private url: string;
+  private token?: string;
   private onMessage: MessageHandler;
   private onConnect?: () => void;
   private onDisconnect?: () => void;
@@ -29,9 +37,11 @@ export class WSClient {
     (result: CommandResultPayload) => void
   >();
   private cmdCounter = 0;
+  private terminals = new Map<string, TerminalHandlers>();
 
   constructor(opts: WSClientOptions) {
     this.url = opts.url;
+    this.token = opts.token;
     this.onMessage = opts.onMessage;
     this.onConnect = opts.onConnect;
     this.onDisconnect = opts.onDisconnect;
@@ -41,14 +51,20 @@ export class WSClient {
     if (this.ws) return;
 
     try {
-      this.ws = new WebSocket(this.url);
+      const headers: Record<string, string> = {};
+      if (this.token) headers.Authorization = `Bearer ${this.token}`;
+      this.ws = new WebSocket(this.url, { headers });
 
       this.ws.on("open", () => {
         this.reconnectDelay = 1000;
         this.onConnect?.();
       });
 
-      this.ws.on("message", (data: WebSocket.Data) => {
+      this.ws.on("message", (data: WebSocket.Data, isBinary: boolean) => {
+        if (isBinary) {
+          this.handleTerminalFrame(data as Buffer);
+          return;
+        }
         try {
           // Handle batched messages (newline-separated)
           const raw = data.toString();
@@ -66,6 +82,16 @@ export class WSClient {
               }
             }
 
+            if (env.type === MSG.TERM_EXIT) {
+              const exit = env.payload as TermExitPayload;
+              const term = this.terminals.get(exit.agentID);
+              if (term) {
+                this.terminals.delete(exit.agentID);
+                this.unsubscribe(`term:${exit.agentID}`);
+                term.onExit(exit);
+              }
+            }
+
             this.onMessage(env);
           }
         } catch {
@@ -75,6 +101,10 @@ export class WSClient {
 
       this.ws.on("close", () => {
         this.ws = null;
+        for (const [agentID, term] of this.terminals) {
+          term.onExit({ agentID, code: -1, error: "connection lost" });
+        }
+        this.terminals.clear();
         this.onDisconnect?.();
         this.scheduleReconnect();
       });
@@ -109,6 +139,43 @@ export class WSClient {
     this.send({ type: MSG.UNSUBSCRIBE, payload: { channel } });
   }
 
+  // Open a shell in the agent's workspace; input and output are binary
+  // frames of the agent ID, a NUL byte and the data
+  openTerminal(
+    agentID: string,
+    rows: number,
+    cols: number,
+    handlers: TerminalHandlers
+  ): void {
+    this.terminals.set(agentID, handlers);
+    this.send({
+      type: MSG.SUBSCRIBE,
+      payload: { channel: `term:${agentID}`, rows, cols },
+    });
+  }
+
+  terminalInput(agentID: string, data: Buffer): void {
+    if (this.ws?.readyState === WebSocket.OPEN) {
+      this.ws.send(Buffer.concat([Buffer.from(agentID + "\0"), data]));
+    }
+  }
+
+  resizeTerminal(agentID: string, rows: number, cols: number): void {
+    this.send({ type: MSG.TERM_RESIZE, payload: { agentID, rows, cols } });
+  }
+
+  closeTerminal(agentID: string): void {
+    this.terminals.delete(agentID);
+    this.unsubscribe(`term:${agentID}`);
+  }
+
+  private handleTerminalFrame(frame: Buffer): void {
+    const sep = frame.indexOf(0);
+    if (sep <= 0) return;
+    const term = this.terminals.get(frame.subarray(0, sep).toString());
+    term?.onData(frame.subarray(sep + 1));
+  }
+
   command(
     action: string,
     args: Record<string, unknown>
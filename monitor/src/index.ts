@@ -1,11 +1,26 @@
+import { readFileSync } from "node:fs";
+import { homedir } from "node:os";
+import { join } from "node:path";
 import { createApp } from "./app.js";
 
 const WS_URL = process.env.AGENTVM_WS_URL || "ws://127.0.0.1:8091/ws";
 
+// Terminals and commands over agentd's WebSocket need an operator token:
+// $AGENTVM_TOKEN, or else the local operator token agentd keeps in
+// ~/.agentvm/operator-token. Status and logs work without one.
+function loadToken(): string {
+  if (process.env.AGENTVM_TOKEN) return process.env.AGENTVM_TOKEN;
+  try {
+    return readFileSync(join(homedir(), ".agentvm", "operator-token"), "utf8").trim();
+  } catch {
+    return "";
+  }
+}
+
 console.log("agentvm-monitor starting...");
 console.log(`Connecting to ${WS_URL}`);
 
-const { screen } = createApp({ wsUrl: WS_URL });
+const { screen } = createApp({ wsUrl: WS_URL, token: loadToken() });
 
 // Handle uncaught errors gracefully
 process.on("uncaughtException", (err) => {
@@ -10,11 +10,16 @@ export const colors = {
   stateColors: {
     starting: "yellow",
     cloning: "yellow",
+    setup: "yellow",
     executing: "green",
     pushing: "blue",
     serving: "magenta",
+    unhealthy: "yellow",
+    restarting: "yellow",
     completed: "cyan",
+    no_changes: "white",
     failed: "red",
+    policy_violation: "red",
     killed: "red",
     registered: "white",
     running: "green",
@@ -51,11 +56,16 @@ export function stateIcon(state: string): string {
   const icons: Record<string, string> = {
     starting: "...",
     cloning: ">>>",
+    setup: "+++",
     executing: "***",
     pushing: "^^^",
     serving: "~~~",
+    unhealthy: "~!~",
+    restarting: "~>~",
     completed: "[+]",
+    no_changes: "[-]",
     failed: "[X]",
+    policy_violation: "[!]",
     killed: "[X]",
     registered: "[ ]",
     running: "[>]",
@@ -1,29 +1,12 @@
 #!/bin/bash
 set -euo pipefail
 
-DOMAIN="agents.test"
-CERTS_DIR="$HOME/.agentvm/certs"
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+AGENTCTL="$SCRIPT_DIR/../bin/agentctl"
 
-echo "Setting up TLS certificates for *.$DOMAIN..."
+# agentd issues per-project wildcard certificates from its own local CA
+# (~/.agentvm/certs); the CA root only needs to be trusted once.
+echo "Installing the agentvm local CA (may require password)..."
+"$AGENTCTL" certs trust
 
-# Install mkcert if not present
-if ! command -v mkcert &>/dev/null; then
-    echo "Installing mkcert..."
-    brew install mkcert
-fi
-
-# Install the local CA
-echo "Installing local CA (may require password)..."
-mkcert -install
-
-# Generate wildcard certificate
-mkdir -p "$CERTS_DIR"
-cd "$CERTS_DIR"
-
-echo "Generating wildcard certificate..."
-mkcert -cert-file "$DOMAIN.pem" -key-file "$DOMAIN-key.pem" \
-    "*.$DOMAIN" "*.*.${DOMAIN}" "$DOMAIN"
-
-echo "Certificates written to $CERTS_DIR/"
-echo "  $DOMAIN.pem"
-echo "  $DOMAIN-key.pem"
+echo "CA root: $HOME/.agentvm/certs/ca.pem"
@@ -36,7 +36,6 @@ echo "Installing optional dependencies..."
 if command -v brew &>/dev/null; then
     brew list traefik &>/dev/null || brew install traefik
     brew list dnsmasq &>/dev/null || brew install dnsmasq
-    brew list mkcert &>/dev/null || brew install mkcert
 fi
 
 # 3. Create directory structure